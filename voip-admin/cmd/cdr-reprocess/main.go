@@ -0,0 +1,91 @@
+// Lệnh cdr-reprocess tính lại cột CDR từ raw_json ngay trong tiến trình,
+// dùng chung bảng job/checkpoint với API /api/cdr/reprocess. Job do lệnh tạo ở
+// trạng thái held nên worker của service không nhận.
+//
+//  cdr-reprocess -from 2025-01-01T00:00:00Z -to 2025-02-01T00:00:00Z -dry-run
+//  cdr-reprocess -resume 42
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "log"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    "voip-admin/internal/config"
+    "voip-admin/internal/db"
+    "voip-admin/internal/reprocess"
+)
+
+func main() {
+    cfgPath := flag.String("config", "/etc/voipadmind.yaml", "config file path")
+    fromStr := flag.String("from", "", "start of range (RFC3339)")
+    toStr := flag.String("to", "", "end of range (RFC3339)")
+    dryRun := flag.Bool("dry-run", false, "report differences without updating CDRs")
+    resume := flag.Int64("resume", 0, "resume job id from its checkpoint")
+    batch := flag.Int("batch", 0, "batch size (default from config)")
+    flag.Parse()
+
+    cfg, err := config.Load(*cfgPath)
+    if err != nil {
+        log.Fatalf("load config: %v", err)
+    }
+
+    pool, err := db.NewPool(cfg.DBDSN)
+    if err != nil {
+        log.Fatalf("db connect: %v", err)
+    }
+    defer pool.Close()
+
+    // Ctrl-C dừng sau batch hiện tại; job quay về held để chạy tiếp bằng -resume.
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+
+    if err := db.Migrate(ctx, pool); err != nil {
+        log.Fatalf("db migrate: %v", err)
+    }
+
+    id := *resume
+    if id == 0 {
+        from, err := time.Parse(time.RFC3339, *fromStr)
+        if err != nil {
+            log.Fatalf("invalid -from: expected RFC3339")
+        }
+        to, err := time.Parse(time.RFC3339, *toStr)
+        if err != nil || to.Before(from) {
+            log.Fatalf("invalid -to: expected RFC3339 not before -from")
+        }
+        job, err := reprocess.CreateHeld(ctx, pool, from, to, *dryRun)
+        if err != nil {
+            log.Fatalf("create job: %v", err)
+        }
+        id = job.ID
+        log.Printf("created reprocess job %d", id)
+    }
+
+    runner := &reprocess.Runner{
+        Pool:           pool,
+        BatchSize:      cfg.Reprocess.BatchSize,
+        MaxDiffSamples: cfg.Reprocess.MaxDiffSamples,
+        Hold:           true,
+    }
+    if *batch > 0 {
+        runner.BatchSize = *batch
+    }
+
+    job, err := runner.Start(ctx, id)
+    if err != nil {
+        log.Fatalf("start job %d: %v", id, err)
+    }
+    if err := runner.Execute(ctx, job); err != nil {
+        log.Fatalf("job %d: %v", id, err)
+    }
+
+    enc := json.NewEncoder(os.Stdout)
+    enc.SetIndent("", "  ")
+    _ = enc.Encode(job)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
     "context"
+    "encoding/json"
     "flag"
     "log"
     "net/http"
@@ -10,13 +11,27 @@ import (
     "syscall"
     "time"
 
+    "voip-admin/internal/callcenter"
+    "voip-admin/internal/calls"
+    "voip-admin/internal/cdr"
     "voip-admin/internal/config"
     "voip-admin/internal/db"
+    "voip-admin/internal/deadletter"
+    "voip-admin/internal/dispatcher"
+    "voip-admin/internal/esl"
     "voip-admin/internal/httpapi"
+    "voip-admin/internal/kamailio"
+    "voip-admin/internal/originate"
+    "voip-admin/internal/partition"
+    "voip-admin/internal/privacy"
+    "voip-admin/internal/reprocess"
+    "voip-admin/internal/stats"
+    "voip-admin/internal/webhook"
 )
 
 func main() {
     cfgPath := flag.String("config", "/etc/voipadmind.yaml", "config file path")
+    migrateOnly := flag.Bool("migrate-only", false, "apply database migrations and exit")
     flag.Parse()
 
     cfg, err := config.Load(*cfgPath)
@@ -30,7 +45,85 @@ func main() {
     }
     defer pool.Close()
 
-    router := httpapi.NewRouter(cfg, pool)
+    // Không đặt timeout: migration 9 (chuyển voip.cdr sang partition) quét toàn
+    // bảng CDR và có thể chạy lâu; với bảng lớn nên chạy -migrate-only trong
+    // cửa sổ bảo trì trước khi khởi động service.
+    if err := db.Migrate(context.Background(), pool); err != nil {
+        log.Fatalf("db migrate: %v", err)
+    }
+    if *migrateOnly {
+        return
+    }
+
+    kam, err := kamailio.Open(cfg.Kamailio, pool)
+    if err != nil {
+        log.Fatalf("kamailio db connect: %v", err)
+    }
+    defer kam.Close()
+
+    // Context cho các job nền, hủy khi service dừng.
+    bgCtx, bgCancel := context.WithCancel(context.Background())
+    defer bgCancel()
+
+    partitions := &partition.Manager{
+        Pool:                 pool,
+        MonthsAhead:          cfg.CDRPartitions.MonthsAhead,
+        DefaultRetentionDays: cfg.CDRPartitions.RetentionDays,
+        ArchiveDir:           cfg.CDRPartitions.ArchiveDir,
+    }
+    go partitions.Run(bgCtx, cfg.CDRPartitions.Interval)
+
+    roller := &stats.Roller{Pool: pool, Lookback: cfg.Stats.RollupLookback}
+    go roller.Run(bgCtx, cfg.Stats.RollupInterval)
+
+    webhookDispatcher := &webhook.Dispatcher{
+        Pool:        pool,
+        Client:      webhook.NewClient(cfg.Webhooks.Timeout),
+        MaxAttempts: cfg.Webhooks.MaxAttempts,
+        BaseBackoff: cfg.Webhooks.BaseBackoff,
+        MaxBackoff:  cfg.Webhooks.MaxBackoff,
+        // Payload webhook chịu cùng data policy như API của consumer.
+        Redact: func(consumer, event string, data json.RawMessage) (json.RawMessage, error) {
+            return privacy.RedactPayload(cfg.ConsumerPolicy(consumer), data)
+        },
+    }
+    go webhookDispatcher.Run(bgCtx, cfg.Webhooks.PollInterval)
+
+    dlMonitor := &deadletter.Monitor{Pool: pool, Threshold: cfg.DeadLetters.AlertThreshold}
+    go dlMonitor.Run(bgCtx, cfg.DeadLetters.CheckInterval)
+
+    reprocessor := &reprocess.Runner{
+        Pool:           pool,
+        BatchSize:      cfg.Reprocess.BatchSize,
+        MaxDiffSamples: cfg.Reprocess.MaxDiffSamples,
+    }
+    go reprocessor.Run(bgCtx, cfg.Reprocess.PollInterval)
+
+    // Index cho biến tìm kiếm được tạo nền vì có thể lâu trên bảng CDR lớn.
+    go func() {
+        if err := cdr.SyncVariableIndexes(bgCtx, pool, cfg.CDRSearch.Variables); err != nil && bgCtx.Err() == nil {
+            log.Printf("cdr search indexes: %v", err)
+        }
+    }()
+
+    if syncer := kamailio.NewSyncer(cfg.Kamailio, pool, kam); syncer != nil {
+        go syncer.Run(bgCtx, cfg.Kamailio.SyncInterval)
+    }
+
+    // Kết nối event socket tới các node FreeSWITCH (nếu được cấu hình).
+    fs := esl.NewManager(cfg.ESL)
+    live := calls.NewTable(fs)
+    orig := originate.NewService(pool, fs)
+    agents := callcenter.NewAgents(pool, fs)
+    disp := dispatcher.NewManager(cfg.Kamailio, pool, kam, fs)
+    go live.Run(bgCtx)
+    go orig.Run(bgCtx)
+    go fs.Run(bgCtx)
+    if disp != nil {
+        go disp.Run(bgCtx, cfg.Kamailio.Dispatcher.HealthInterval)
+    }
+
+    router := httpapi.NewRouter(cfg, pool, fs, live, orig, agents, kam, disp)
 
     srv := &http.Server{
         Addr:         cfg.ListenAddr,
@@ -50,6 +143,7 @@ func main() {
     sigCh := make(chan os.Signal, 1)
     signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
     <-sigCh
+    bgCancel()
 
     ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
     defer cancel()
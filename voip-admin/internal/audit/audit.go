@@ -0,0 +1,60 @@
+package audit
+
+import (
+    "context"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Entry là một thao tác nhạy cảm đã thực hiện qua API.
+type Entry struct {
+    ID        int64                  `json:"id"`
+    Actor     string                 `json:"actor"`
+    Action    string                 `json:"action"`
+    Target    string                 `json:"target"`
+    Details   map[string]interface{} `json:"details,omitempty"`
+    CreatedAt time.Time              `json:"created_at"`
+}
+
+// Execer là phần chung của *pgxpool.Pool và pgx.Tx.
+type Execer interface {
+    Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// Record ghi một dòng audit; db có thể là transaction của thao tác để audit
+// chỉ tồn tại khi thao tác thành công.
+func Record(ctx context.Context, db Execer, actor, action, target string, details interface{}) error {
+    _, err := db.Exec(ctx, `
+        INSERT INTO voip.audit_log (actor, action, target, details)
+        VALUES ($1, $2, $3, $4)
+    `, actor, action, target, details)
+    return err
+}
+
+// List trả audit mới nhất trước, tùy chọn lọc theo action (khớp tiền tố, ví dụ "call.").
+func List(ctx context.Context, pool *pgxpool.Pool, action string, beforeID int64, limit int) ([]Entry, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT id, actor, action, target, details, created_at
+        FROM voip.audit_log
+        WHERE ($1::text = '' OR starts_with(action, $1))
+          AND ($2::bigint = 0 OR id < $2)
+        ORDER BY id DESC
+        LIMIT $3
+    `, action, beforeID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Entry
+    for rows.Next() {
+        var e Entry
+        if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &e.Details, &e.CreatedAt); err != nil {
+            return nil, err
+        }
+        out = append(out, e)
+    }
+    return out, rows.Err()
+}
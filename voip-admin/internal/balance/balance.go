@@ -0,0 +1,298 @@
+package balance
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/rating"
+)
+
+// ErrNoAccount: điều chỉnh âm cho domain chưa có account số dư.
+var ErrNoAccount = errors.New("balance account not found")
+
+// Account là số dư của một account (domain).
+type Account struct {
+    DomainID  int64        `json:"domain_id"`
+    Prepaid   bool         `json:"prepaid"`
+    Balance   rating.Money `json:"balance"`
+    Currency  string       `json:"currency"`
+    UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// Authorization là kết quả kiểm tra số dư trước khi cho phép gọi ra.
+type Authorization struct {
+    Prepaid bool
+    Allowed bool
+    // MaxSeconds là thời lượng tối đa cho phép sau khi trả lời, 0 là không giới hạn.
+    MaxSeconds int
+    Reason     string
+}
+
+// reservationGrace cộng thêm vào thời lượng tối đa khi đặt hạn giữ chỗ, cho thời gian
+// đổ chuông và độ trễ gửi CDR.
+const reservationGrace = 10 * time.Minute
+
+// Authorize kiểm tra account prepaid của domain trước khi gọi tới number.
+// Account postpaid (hoặc chưa có bản ghi số dư) luôn được phép, không giới hạn thời lượng.
+// Cuộc gọi được phép sẽ giữ chỗ phần cước tối đa của nó theo callUUID (Debit bỏ giữ chỗ
+// khi CDR được rate), nên các cuộc gọi đồng thời chỉ chia nhau số dư còn lại.
+func Authorize(ctx context.Context, pool *pgxpool.Pool, domainID int64, callUUID, number string, maxCallSeconds int) (*Authorization, error) {
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    var (
+        prepaid bool
+        bal     int64
+    )
+    // FOR UPDATE để các lần cấp phép đồng thời của cùng domain chạy tuần tự.
+    err = tx.QueryRow(ctx, `
+        SELECT prepaid, (balance * 1000000)::bigint
+        FROM voip.account_balances
+        WHERE domain_id=$1
+        FOR UPDATE
+    `, domainID).Scan(&prepaid, &bal)
+    if errors.Is(err, pgx.ErrNoRows) || (err == nil && !prepaid) {
+        return &Authorization{Allowed: true}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if _, err := tx.Exec(ctx, `
+        DELETE FROM voip.balance_reservations WHERE domain_id=$1 AND expires_at <= now()
+    `, domainID); err != nil {
+        return nil, err
+    }
+    // Giữ chỗ cũ của chính cuộc gọi này (dialplan được hỏi lại khi transfer) không tính.
+    var reserved, reservedFree int64
+    err = tx.QueryRow(ctx, `
+        SELECT COALESCE(SUM((amount * 1000000)::bigint), 0), COALESCE(SUM(free_seconds), 0)
+        FROM voip.balance_reservations
+        WHERE domain_id=$1 AND call_uuid <> $2
+    `, domainID, callUUID).Scan(&reserved, &reservedFree)
+    if err != nil {
+        return nil, err
+    }
+
+    auth := &Authorization{Prepaid: true}
+
+    rate, err := rating.LookupRate(ctx, tx, domainID, number)
+    if err != nil && !errors.Is(err, rating.ErrNoTariff) {
+        return nil, err
+    }
+    if rate == nil {
+        auth.Reason = "no rate for destination"
+        return auth, nil
+    }
+
+    freeLeft := 0
+    if rate.BundleEligible && rate.FreeSeconds > 0 {
+        var used int64
+        err := tx.QueryRow(ctx, `
+            SELECT COALESCE(SUM(used_seconds), 0)
+            FROM voip.bundle_usage
+            WHERE domain_id=$1 AND period=date_trunc('month', now())::date
+        `, domainID).Scan(&used)
+        if err != nil {
+            return nil, err
+        }
+        if left := int64(rate.FreeSeconds) - used - reservedFree; left > 0 {
+            freeLeft = int(left)
+        }
+    }
+
+    available := rating.Money(bal-reserved) - rate.ConnectionFee
+    if available <= 0 && freeLeft == 0 {
+        auth.Reason = "insufficient balance"
+        return auth, nil
+    }
+
+    seconds := maxCallSeconds
+    if rate.RatePerMinute > 0 {
+        paid := int64(0)
+        if available > 0 {
+            paid = int64(available) * 60 / int64(rate.RatePerMinute)
+        }
+        seconds = maxBillable(int(paid)+freeLeft, rate.InitialIncrement, rate.Increment)
+        if seconds <= 0 {
+            auth.Reason = "insufficient balance"
+            return auth, nil
+        }
+        if maxCallSeconds > 0 && seconds > maxCallSeconds {
+            seconds = maxCallSeconds
+        }
+    }
+
+    free := freeLeft
+    if seconds > 0 && free > seconds {
+        free = seconds
+    }
+    exposure := rate.ConnectionFee
+    if seconds > 0 {
+        exposure = rating.Price(*rate, seconds, free)
+    }
+    if err := reserve(ctx, tx, domainID, callUUID, exposure, free, seconds); err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+
+    auth.Allowed = true
+    auth.MaxSeconds = seconds
+    return auth, nil
+}
+
+// reserve ghi (hoặc thay) giữ chỗ của cuộc gọi, hết hạn sau thời lượng tối đa cộng
+// reservationGrace. Không có callUUID thì giữ chỗ chỉ được bỏ khi hết hạn.
+func reserve(ctx context.Context, tx pgx.Tx, domainID int64, callUUID string, amount rating.Money, freeSeconds, seconds int) error {
+    ttl := time.Duration(seconds)*time.Second + reservationGrace
+    _, err := tx.Exec(ctx, `
+        INSERT INTO voip.balance_reservations (call_uuid, domain_id, amount, free_seconds, expires_at)
+        VALUES (COALESCE(NULLIF($1, ''), gen_random_uuid()::text), $2, $3::numeric / 1000000, $4,
+                now() + make_interval(secs => $5))
+        ON CONFLICT (call_uuid) DO UPDATE
+        SET domain_id = EXCLUDED.domain_id,
+            amount = EXCLUDED.amount,
+            free_seconds = EXCLUDED.free_seconds,
+            created_at = now(),
+            expires_at = EXCLUDED.expires_at
+    `, callUUID, domainID, int64(amount), freeSeconds, ttl.Seconds())
+    return err
+}
+
+// maxBillable là số giây lớn nhất nằm trên lưới làm tròn cước mà không vượt quá budget,
+// để cuộc gọi không bị tính vượt số dư.
+func maxBillable(budget, initial, increment int) int {
+    if initial <= 0 {
+        initial = 1
+    }
+    if increment <= 0 {
+        increment = 1
+    }
+    if budget < initial {
+        return 0
+    }
+    return initial + (budget-initial)/increment*increment
+}
+
+// Debit trừ phần chênh lệch cước của CDR khỏi số dư prepaid trong transaction rating,
+// dùng làm rating.Rater.AfterRate, rồi bỏ giữ chỗ Authorize đã đặt cho cuộc gọi.
+// Account postpaid không bị ảnh hưởng; CDR B-leg không bao giờ tới đây vì RateCDRTx
+// bỏ qua chúng.
+func Debit(ctx context.Context, tx pgx.Tx, res *rating.Result) error {
+    if delta := res.Cost - res.PreviousCost; delta != 0 {
+        if err := debit(ctx, tx, res, delta); err != nil {
+            return err
+        }
+    }
+    // Bỏ giữ chỗ sau khi khóa số dư, cùng thứ tự khóa với Authorize.
+    _, err := tx.Exec(ctx, `
+        DELETE FROM voip.balance_reservations WHERE call_uuid=$1
+    `, res.CallUUID)
+    return err
+}
+
+func debit(ctx context.Context, tx pgx.Tx, res *rating.Result, delta rating.Money) error {
+    var after int64
+    err := tx.QueryRow(ctx, `
+        UPDATE voip.account_balances
+        SET balance = balance - $2::numeric / 1000000,
+            updated_at = now()
+        WHERE domain_id=$1 AND prepaid
+        RETURNING (balance * 1000000)::bigint
+    `, res.DomainID, int64(delta)).Scan(&after)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    reason := "call"
+    if res.PreviousCost != 0 {
+        reason = "rerate"
+    }
+    _, err = tx.Exec(ctx, `
+        INSERT INTO voip.balance_transactions (domain_id, cdr_id, amount, balance_after, reason)
+        VALUES ($1, $2, $3::numeric / 1000000, $4::numeric / 1000000, $5)
+    `, res.DomainID, res.CDRID, -int64(delta), after, reason)
+    return err
+}
+
+// Get trả số dư của domain, nil nếu domain chưa có account.
+func Get(ctx context.Context, pool *pgxpool.Pool, domainID int64) (*Account, error) {
+    var a Account
+    err := pool.QueryRow(ctx, `
+        SELECT domain_id, prepaid, (balance * 1000000)::bigint, currency, updated_at
+        FROM voip.account_balances
+        WHERE domain_id=$1
+    `, domainID).Scan(&a.DomainID, &a.Prepaid, &a.Balance, &a.Currency, &a.UpdatedAt)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &a, nil
+}
+
+// TopUp cộng amount vào số dư và ghi giao dịch. Nạp tiền (amount > 0) tạo account
+// prepaid nếu chưa có; điều chỉnh âm chỉ áp dụng cho account đã tồn tại (ErrNoAccount),
+// để domain postpaid không bị chuyển thành prepaid với số dư âm.
+func TopUp(ctx context.Context, pool *pgxpool.Pool, domainID int64, amount rating.Money, reason string) (*Account, error) {
+    if reason == "" {
+        reason = "topup"
+    }
+
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    var a Account
+    if amount > 0 {
+        err = tx.QueryRow(ctx, `
+            INSERT INTO voip.account_balances (domain_id, balance)
+            VALUES ($1, $2::numeric / 1000000)
+            ON CONFLICT (domain_id) DO UPDATE
+            SET balance = voip.account_balances.balance + EXCLUDED.balance,
+                updated_at = now()
+            RETURNING domain_id, prepaid, (balance * 1000000)::bigint, currency, updated_at
+        `, domainID, int64(amount)).Scan(&a.DomainID, &a.Prepaid, &a.Balance, &a.Currency, &a.UpdatedAt)
+    } else {
+        err = tx.QueryRow(ctx, `
+            UPDATE voip.account_balances
+            SET balance = balance + $2::numeric / 1000000,
+                updated_at = now()
+            WHERE domain_id=$1
+            RETURNING domain_id, prepaid, (balance * 1000000)::bigint, currency, updated_at
+        `, domainID, int64(amount)).Scan(&a.DomainID, &a.Prepaid, &a.Balance, &a.Currency, &a.UpdatedAt)
+        if errors.Is(err, pgx.ErrNoRows) {
+            return nil, ErrNoAccount
+        }
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = tx.Exec(ctx, `
+        INSERT INTO voip.balance_transactions (domain_id, amount, balance_after, reason)
+        VALUES ($1, $2::numeric / 1000000, $3::numeric / 1000000, $4)
+    `, domainID, int64(amount), int64(a.Balance), reason)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+    return &a, nil
+}
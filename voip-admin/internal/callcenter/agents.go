@@ -0,0 +1,469 @@
+package callcenter
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/esl"
+)
+
+// Trạng thái agent của mod_callcenter có thể đặt qua API.
+const (
+    StatusAvailable         = "Available"
+    StatusAvailableOnDemand = "Available (On Demand)"
+    StatusOnBreak           = "On Break"
+    StatusLoggedOut         = "Logged Out"
+)
+
+var validStatuses = map[string]bool{
+    StatusAvailable:         true,
+    StatusAvailableOnDemand: true,
+    StatusOnBreak:           true,
+    StatusLoggedOut:         true,
+}
+
+var (
+    // ErrNotFound được trả về khi agent (hoặc user tương ứng) không tồn tại.
+    ErrNotFound = errors.New("agent not found")
+    // ErrInvalidStatus được trả về khi trạng thái không được hỗ trợ.
+    ErrInvalidStatus = errors.New("invalid status: expected Available, Available (On Demand), On Break or Logged Out")
+    // ErrInvalidName được trả về khi tên agent hoặc queue sai định dạng.
+    ErrInvalidName = errors.New("invalid agent or queue name: expected name@domain")
+    // ErrInvalidTier được trả về khi level hoặc position âm.
+    ErrInvalidTier = errors.New("invalid tier: level and position must not be negative")
+)
+
+// namePattern giới hạn tên agent/queue ("name@domain") để đưa an toàn vào lệnh callcenter_config.
+var namePattern = regexp.MustCompile(`^[0-9A-Za-z._-]+@[0-9A-Za-z.-]+$`)
+
+// Agent là trạng thái mong muốn của một agent, lưu trong DB và áp lên mọi node.
+type Agent struct {
+    Name      string    `json:"name"`
+    UserID    *int64    `json:"user_id,omitempty"`
+    Contact   string    `json:"contact"`
+    Status    string    `json:"status"`
+    UpdatedBy *string   `json:"updated_by,omitempty"`
+    UpdatedAt time.Time `json:"updated_at"`
+    Tiers     []Tier    `json:"tiers"`
+}
+
+// Tier là thành viên của agent trong một queue.
+type Tier struct {
+    Queue    string `json:"queue"`
+    Level    int    `json:"level"`
+    Position int    `json:"position"`
+}
+
+// NodeState là trạng thái thực tế của agent trên một node.
+type NodeState struct {
+    Node   string `json:"node"`
+    Status string `json:"status,omitempty"`
+    State  string `json:"state,omitempty"`
+    Error  string `json:"error,omitempty"`
+}
+
+// NodeResult là kết quả áp thay đổi lên một node; node lỗi hoặc chưa kết nối
+// sẽ được áp lại khi kết nối lại.
+type NodeResult struct {
+    Node  string `json:"node"`
+    Error string `json:"error,omitempty"`
+}
+
+// Agents quản lý trạng thái agent/tier của mod_callcenter qua callcenter_config.
+// DB là nguồn gốc: mỗi thay đổi được lưu trước rồi áp lên các node đang kết nối,
+// và toàn bộ trạng thái được áp lại khi một node kết nối (lại), nên agent giữ
+// nguyên trạng thái khi cuộc gọi chuyển sang node còn lại.
+type Agents struct {
+    Pool *pgxpool.Pool
+    FS   *esl.Manager
+}
+
+// NewAgents đăng ký hook kết nối của fs; gọi trước fs.Run.
+func NewAgents(pool *pgxpool.Pool, fs *esl.Manager) *Agents {
+    a := &Agents{Pool: pool, FS: fs}
+    fs.OnConnect(func(ctx context.Context, node string) {
+        if err := a.Restore(ctx, node); err != nil {
+            log.Printf("callcenter: restore agents on %s: %v", node, err)
+        }
+    })
+    return a
+}
+
+// ensureAgent tạo dòng agent từ user "username@domain" nếu chưa có.
+func ensureAgent(ctx context.Context, tx pgx.Tx, name, actor string) error {
+    if _, err := tx.Exec(ctx, `
+        INSERT INTO voip.cc_agents (name, user_id, contact, updated_by)
+        SELECT $1, u.id, 'user/' || $1, $2
+        FROM voip.users u
+        JOIN voip.domains d ON d.id = u.domain_id
+        WHERE u.username || '@' || d.name = $1
+        ON CONFLICT (name) DO NOTHING
+    `, name, actor); err != nil {
+        return err
+    }
+    var exists bool
+    if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM voip.cc_agents WHERE name = $1)`, name).Scan(&exists); err != nil {
+        return err
+    }
+    if !exists {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// update chạy fn trong transaction sau khi chắc chắn agent tồn tại và trả
+// agent sau thay đổi.
+func (a *Agents) update(ctx context.Context, name, actor string, fn func(tx pgx.Tx) error) (*Agent, error) {
+    if !namePattern.MatchString(name) {
+        return nil, ErrInvalidName
+    }
+    tx, err := a.Pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    if err := ensureAgent(ctx, tx, name, actor); err != nil {
+        return nil, err
+    }
+    if err := fn(tx); err != nil {
+        return nil, err
+    }
+    if _, err := tx.Exec(ctx, `
+        UPDATE voip.cc_agents SET updated_by = $2, updated_at = now() WHERE name = $1
+    `, name, actor); err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+
+    return a.Get(ctx, name)
+}
+
+// SetStatus đặt trạng thái agent.
+func (a *Agents) SetStatus(ctx context.Context, name, status, actor string) (*Agent, []NodeResult, error) {
+    if !validStatuses[status] {
+        return nil, nil, ErrInvalidStatus
+    }
+    agent, err := a.update(ctx, name, actor, func(tx pgx.Tx) error {
+        _, err := tx.Exec(ctx, `UPDATE voip.cc_agents SET status = $2 WHERE name = $1`, name, status)
+        return err
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+    return agent, a.apply(ctx, func(node string) error {
+        if err := a.addAgent(ctx, node, agent); err != nil {
+            return err
+        }
+        return a.command(ctx, node, "agent set status "+agent.Name+" '"+status+"'")
+    }), nil
+}
+
+// AddTier thêm (hoặc cập nhật level/position) agent vào queue.
+func (a *Agents) AddTier(ctx context.Context, name string, t Tier, actor string) (*Agent, []NodeResult, error) {
+    if !namePattern.MatchString(t.Queue) {
+        return nil, nil, ErrInvalidName
+    }
+    if t.Level < 0 || t.Position < 0 {
+        return nil, nil, ErrInvalidTier
+    }
+    agent, err := a.update(ctx, name, actor, func(tx pgx.Tx) error {
+        _, err := tx.Exec(ctx, `
+            INSERT INTO voip.cc_tiers (agent, queue, level, position)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT (agent, queue) DO UPDATE
+            SET level = EXCLUDED.level, position = EXCLUDED.position, updated_at = now()
+        `, name, t.Queue, t.Level, t.Position)
+        return err
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+    return agent, a.apply(ctx, func(node string) error {
+        if err := a.addAgent(ctx, node, agent); err != nil {
+            return err
+        }
+        return a.addTier(ctx, node, agent.Name, t)
+    }), nil
+}
+
+// RemoveTier xóa agent khỏi queue.
+func (a *Agents) RemoveTier(ctx context.Context, name, queue, actor string) (*Agent, []NodeResult, error) {
+    if !namePattern.MatchString(queue) {
+        return nil, nil, ErrInvalidName
+    }
+    agent, err := a.update(ctx, name, actor, func(tx pgx.Tx) error {
+        tag, err := tx.Exec(ctx, `DELETE FROM voip.cc_tiers WHERE agent = $1 AND queue = $2`, name, queue)
+        if err == nil && tag.RowsAffected() == 0 {
+            return ErrNotFound
+        }
+        return err
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+    return agent, a.apply(ctx, func(node string) error {
+        err := a.command(ctx, node, "tier del "+queue+" "+agent.Name)
+        if ignoreReply(err, "not found") {
+            return nil
+        }
+        return err
+    }), nil
+}
+
+// Get đọc agent kèm tier.
+func (a *Agents) Get(ctx context.Context, name string) (*Agent, error) {
+    items, err := a.list(ctx, name)
+    if err != nil {
+        return nil, err
+    }
+    if len(items) == 0 {
+        return nil, ErrNotFound
+    }
+    return &items[0], nil
+}
+
+// List trả mọi agent đã quản lý qua API, theo tên.
+func (a *Agents) List(ctx context.Context) ([]Agent, error) {
+    return a.list(ctx, "")
+}
+
+func (a *Agents) list(ctx context.Context, name string) ([]Agent, error) {
+    rows, err := a.Pool.Query(ctx, `
+        SELECT a.name, a.user_id, a.contact, a.status, a.updated_by, a.updated_at,
+               COALESCE(array_agg(t.queue ORDER BY t.queue) FILTER (WHERE t.queue IS NOT NULL), '{}'),
+               COALESCE(array_agg(t.level ORDER BY t.queue) FILTER (WHERE t.queue IS NOT NULL), '{}'),
+               COALESCE(array_agg(t.position ORDER BY t.queue) FILTER (WHERE t.queue IS NOT NULL), '{}')
+        FROM voip.cc_agents a
+        LEFT JOIN voip.cc_tiers t ON t.agent = a.name
+        WHERE $1 = '' OR a.name = $1
+        GROUP BY a.name
+        ORDER BY a.name
+    `, name)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Agent
+    for rows.Next() {
+        var (
+            ag               Agent
+            queues           []string
+            levels, position []int32
+        )
+        if err := rows.Scan(&ag.Name, &ag.UserID, &ag.Contact, &ag.Status, &ag.UpdatedBy, &ag.UpdatedAt,
+            &queues, &levels, &position); err != nil {
+            return nil, err
+        }
+        ag.Tiers = make([]Tier, len(queues))
+        for i := range queues {
+            ag.Tiers[i] = Tier{Queue: queues[i], Level: int(levels[i]), Position: int(position[i])}
+        }
+        out = append(out, ag)
+    }
+    return out, rows.Err()
+}
+
+// LiveState đọc status/state thực tế của agent trên từng node đang kết nối.
+func (a *Agents) LiveState(ctx context.Context, name string) []NodeState {
+    var out []NodeState
+    for _, n := range a.FS.Nodes() {
+        if !n.Connected {
+            continue
+        }
+        s := NodeState{Node: n.Node}
+        status, err := a.FS.API(ctx, n.Node, "callcenter_config agent get status "+name)
+        if err == nil {
+            var state string
+            state, err = a.FS.API(ctx, n.Node, "callcenter_config agent get state "+name)
+            s.State = strings.TrimSpace(state)
+        }
+        s.Status = strings.TrimSpace(status)
+        if err != nil {
+            s.Error = err.Error()
+        }
+        out = append(out, s)
+    }
+    return out
+}
+
+// Restore áp toàn bộ agent và tier trong DB lên node, rồi xóa tier trên node
+// không còn trong voip.cc_tiers (ví dụ bị xóa qua API khi node mất kết nối).
+func (a *Agents) Restore(ctx context.Context, node string) error {
+    agents, err := a.List(ctx)
+    if err != nil {
+        return err
+    }
+    if err := a.removeStaleTiers(ctx, node, agents); err != nil {
+        log.Printf("callcenter: reconcile tiers on %s: %v", node, err)
+    }
+    var failed int
+    for i := range agents {
+        ag := &agents[i]
+        err := a.addAgent(ctx, node, ag)
+        if err == nil {
+            err = a.command(ctx, node, "agent set status "+ag.Name+" '"+ag.Status+"'")
+        }
+        for _, t := range ag.Tiers {
+            if err != nil {
+                break
+            }
+            err = a.addTier(ctx, node, ag.Name, t)
+        }
+        if err != nil {
+            failed++
+            log.Printf("callcenter: restore agent %s on %s: %v", ag.Name, node, err)
+        }
+    }
+    if failed > 0 {
+        return fmt.Errorf("%d of %d agents failed", failed, len(agents))
+    }
+    return nil
+}
+
+// removeStaleTiers xóa trên node các tier (theo "tier list") không có trong agents.
+func (a *Agents) removeStaleTiers(ctx context.Context, node string, agents []Agent) error {
+    reply, err := a.FS.API(ctx, node, "callcenter_config tier list")
+    if err != nil {
+        return err
+    }
+    want := map[tierKey]bool{}
+    for _, ag := range agents {
+        for _, t := range ag.Tiers {
+            want[tierKey{Queue: t.Queue, Agent: ag.Name}] = true
+        }
+    }
+    for _, k := range parseTierList(reply) {
+        if want[k] {
+            continue
+        }
+        // Tên lạ (không khớp namePattern) không được đưa vào lệnh.
+        if !namePattern.MatchString(k.Queue) || !namePattern.MatchString(k.Agent) {
+            log.Printf("callcenter: skip stale tier %s/%s on %s: unexpected name", k.Queue, k.Agent, node)
+            continue
+        }
+        if err := a.command(ctx, node, "tier del "+k.Queue+" "+k.Agent); err != nil && !ignoreReply(err, "not found") {
+            return fmt.Errorf("tier del %s %s: %w", k.Queue, k.Agent, err)
+        }
+        log.Printf("callcenter: removed stale tier %s/%s on %s", k.Queue, k.Agent, node)
+    }
+    return nil
+}
+
+// tierKey là một tier theo (queue, agent).
+type tierKey struct {
+    Queue string
+    Agent string
+}
+
+// parseTierList đọc bảng "queue|agent|state|level|position" của
+// "callcenter_config tier list" (dòng cuối "+OK").
+func parseTierList(reply string) []tierKey {
+    var (
+        out        []tierKey
+        queueCol   = -1
+        agentCol   = -1
+        headerSeen bool
+    )
+    for _, line := range strings.Split(reply, "\n") {
+        line = strings.TrimSpace(line)
+        if line == "" || strings.HasPrefix(line, "+OK") {
+            continue
+        }
+        fields := strings.Split(line, "|")
+        if !headerSeen {
+            for i, f := range fields {
+                switch f {
+                case "queue":
+                    queueCol = i
+                case "agent":
+                    agentCol = i
+                }
+            }
+            if queueCol < 0 || agentCol < 0 {
+                return nil
+            }
+            headerSeen = true
+            continue
+        }
+        if queueCol >= len(fields) || agentCol >= len(fields) {
+            continue
+        }
+        out = append(out, tierKey{Queue: fields[queueCol], Agent: fields[agentCol]})
+    }
+    return out
+}
+
+// apply chạy fn trên mọi node đang kết nối; node chưa kết nối được ghi nhận
+// và sẽ nhận trạng thái qua Restore khi kết nối lại.
+func (a *Agents) apply(ctx context.Context, fn func(node string) error) []NodeResult {
+    var out []NodeResult
+    for _, n := range a.FS.Nodes() {
+        r := NodeResult{Node: n.Node}
+        if !n.Connected {
+            r.Error = esl.ErrNotConnected.Error()
+        } else if err := fn(n.Node); err != nil {
+            r.Error = err.Error()
+        }
+        out = append(out, r)
+    }
+    return out
+}
+
+// addAgent tạo agent callback trên node (nếu chưa có) và đặt contact.
+func (a *Agents) addAgent(ctx context.Context, node string, ag *Agent) error {
+    err := a.command(ctx, node, "agent add "+ag.Name+" callback")
+    if err != nil && !ignoreReply(err, "exist") {
+        return err
+    }
+    return a.command(ctx, node, "agent set contact "+ag.Name+" "+ag.Contact)
+}
+
+func (a *Agents) addTier(ctx context.Context, node, agent string, t Tier) error {
+    level, position := strconv.Itoa(t.Level), strconv.Itoa(t.Position)
+    err := a.command(ctx, node, "tier add "+t.Queue+" "+agent+" "+level+" "+position)
+    if !ignoreReply(err, "exist") {
+        return err
+    }
+    // Tier đã có: cập nhật level/position.
+    if err := a.command(ctx, node, "tier set level "+t.Queue+" "+agent+" "+level); err != nil {
+        return err
+    }
+    return a.command(ctx, node, "tier set position "+t.Queue+" "+agent+" "+position)
+}
+
+func (a *Agents) command(ctx context.Context, node, args string) error {
+    _, err := a.FS.API(ctx, node, "callcenter_config "+args)
+    return err
+}
+
+// ignoreReply cho biết err là "-ERR" của FreeSWITCH có chứa substr (không phân biệt hoa thường).
+func ignoreReply(err error, substr string) bool {
+    var cmdErr *esl.CommandError
+    return errors.As(err, &cmdErr) && strings.Contains(strings.ToLower(cmdErr.Reply), substr)
+}
+
+// SyncStatus cập nhật trạng thái đã lưu khi agent đổi trạng thái ngoài API
+// (ví dụ bằng feature code), để lần áp lại sau không ghi đè thay đổi đó.
+func SyncStatus(ctx context.Context, pool *pgxpool.Pool, ev *AgentEvent) error {
+    if ev.Action != "agent-status-change" || !validStatuses[ev.Status] {
+        return nil
+    }
+    _, err := pool.Exec(ctx, `
+        UPDATE voip.cc_agents
+        SET status = $2, updated_by = 'freeswitch', updated_at = $3
+        WHERE name = $1 AND status <> $2 AND updated_at < $3
+    `, ev.Agent, ev.Status, ev.OccurredAt)
+    return err
+}
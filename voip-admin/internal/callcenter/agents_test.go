@@ -0,0 +1,46 @@
+package callcenter
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestParseTierList(t *testing.T) {
+    tests := []struct {
+        name  string
+        reply string
+        want  []tierKey
+    }{
+        {
+            name: "tiers",
+            reply: "queue|agent|state|level|position\n" +
+                "support@bsv.local|1001@bsv.local|Ready|1|1\n" +
+                "sales@bsv.local|1002@bsv.local|Standby|2|1\n" +
+                "+OK\n",
+            want: []tierKey{
+                {Queue: "support@bsv.local", Agent: "1001@bsv.local"},
+                {Queue: "sales@bsv.local", Agent: "1002@bsv.local"},
+            },
+        },
+        {
+            name:  "empty",
+            reply: "queue|agent|state|level|position\n+OK\n",
+        },
+        {
+            name:  "columns reordered",
+            reply: "agent|queue|state|level|position\n1001@bsv.local|support@bsv.local|Ready|1|1\n+OK",
+            want:  []tierKey{{Queue: "support@bsv.local", Agent: "1001@bsv.local"}},
+        },
+        {
+            name:  "unexpected reply",
+            reply: "-ERR Unknown command\n",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := parseTierList(tt.reply); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("parseTierList() = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,82 @@
+package callcenter
+
+import (
+    "context"
+    "strconv"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AgentEvent là sự kiện CUSTOM callcenter::info liên quan tới agent.
+type AgentEvent struct {
+    Agent      string
+    Action     string
+    Queue      string
+    Status     string
+    State      string
+    MemberUUID string
+    Node       string
+    OccurredAt time.Time
+}
+
+// Các CC-Action được lưu lại cho báo cáo agent.
+var agentActions = map[string]bool{
+    "agent-status-change": true,
+    "agent-state-change":  true,
+    "agent-offering":      true,
+    "bridge-agent-start":  true,
+    "bridge-agent-end":    true,
+    "bridge-agent-fail":   true,
+}
+
+// EventFromHeaders dựng AgentEvent từ header của event FreeSWITCH
+// (dạng JSON hoặc plain của event socket). ok=false nếu không phải
+// sự kiện agent cần lưu.
+func EventFromHeaders(h map[string]string) (*AgentEvent, bool) {
+    if h["Event-Subclass"] != "" && h["Event-Subclass"] != "callcenter::info" {
+        return nil, false
+    }
+    action := h["CC-Action"]
+    agent := h["CC-Agent"]
+    if !agentActions[action] || agent == "" {
+        return nil, false
+    }
+
+    ev := &AgentEvent{
+        Agent:      agent,
+        Action:     action,
+        Queue:      h["CC-Queue"],
+        Status:     h["CC-Agent-Status"],
+        State:      h["CC-Agent-State"],
+        MemberUUID: h["CC-Member-UUID"],
+        Node:       h["FreeSWITCH-Hostname"],
+        OccurredAt: time.Now(),
+    }
+    // Event-Date-Timestamp tính theo micro giây.
+    if us, err := strconv.ParseInt(h["Event-Date-Timestamp"], 10, 64); err == nil && us > 0 {
+        ev.OccurredAt = time.UnixMicro(us)
+    }
+    return ev, true
+}
+
+func nullIfEmpty(s string) *string {
+    if s == "" {
+        return nil
+    }
+    return &s
+}
+
+// RecordEvent lưu sự kiện agent vào voip.cc_agent_events.
+func RecordEvent(ctx context.Context, pool *pgxpool.Pool, ev *AgentEvent) error {
+    _, err := pool.Exec(ctx, `
+        INSERT INTO voip.cc_agent_events (agent, event, queue, status, state, member_uuid, node, occurred_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `,
+        ev.Agent, ev.Action,
+        nullIfEmpty(ev.Queue), nullIfEmpty(ev.Status), nullIfEmpty(ev.State),
+        nullIfEmpty(ev.MemberUUID), nullIfEmpty(ev.Node),
+        ev.OccurredAt,
+    )
+    return err
+}
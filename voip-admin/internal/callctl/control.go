@@ -0,0 +1,214 @@
+package callctl
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/calls"
+    "voip-admin/internal/esl"
+)
+
+var (
+    // ErrCallNotFound được trả về khi call UUID không có trong bảng cuộc gọi đang diễn ra.
+    ErrCallNotFound = errors.New("call not found")
+    // ErrNotBridged được trả về khi thao tác cần cuộc gọi đã nối với bên kia.
+    ErrNotBridged = errors.New("call is not bridged")
+    // ErrInvalidDestination được trả về khi số đích chuyển tiếp không hợp lệ.
+    ErrInvalidDestination = errors.New("invalid destination")
+    // ErrInvalidCause được trả về khi hangup cause không hợp lệ.
+    ErrInvalidCause = errors.New("invalid hangup cause")
+    // ErrInvalidLeg được trả về khi leg chuyển tiếp không phải self, peer hoặc both.
+    ErrInvalidLeg = errors.New("invalid leg: expected self, peer or both")
+    // ErrUserNotFound được trả về khi supervisor không tồn tại hoặc bị khóa.
+    ErrUserNotFound = errors.New("supervisor user not found or inactive")
+)
+
+var (
+    destinationPattern = regexp.MustCompile(`^\+?[0-9*#]{2,32}$`)
+    causePattern       = regexp.MustCompile(`^[A-Z_]{1,64}$`)
+)
+
+// Chế độ giám sát cuộc gọi của supervisor.
+const (
+    // MonitorEavesdrop chỉ nghe.
+    MonitorEavesdrop = "eavesdrop"
+    // MonitorWhisper nói với channel được giám sát (thường là agent), bên kia không nghe thấy.
+    MonitorWhisper = "whisper"
+    // MonitorBarge nói với cả hai bên.
+    MonitorBarge = "barge"
+)
+
+// Controller điều khiển cuộc gọi đang diễn ra qua event socket của node đang
+// giữ channel.
+type Controller struct {
+    Pool *pgxpool.Pool
+    FS   *esl.Manager
+    Live *calls.Table
+}
+
+// call trả channel đang hoạt động theo UUID.
+func (c *Controller) call(uuid string) (calls.Call, error) {
+    call, ok := c.Live.Get(uuid)
+    if !ok {
+        return calls.Call{}, ErrCallNotFound
+    }
+    return call, nil
+}
+
+// Hangup kết thúc channel với cause cho trước (mặc định NORMAL_CLEARING).
+func (c *Controller) Hangup(ctx context.Context, uuid, cause string) (calls.Call, error) {
+    call, err := c.call(uuid)
+    if err != nil {
+        return call, err
+    }
+    if cause == "" {
+        cause = "NORMAL_CLEARING"
+    }
+    if !causePattern.MatchString(cause) {
+        return call, ErrInvalidCause
+    }
+    _, err = c.FS.API(ctx, call.Node, "uuid_kill "+call.UUID+" "+cause)
+    return call, err
+}
+
+// TransferParams là tham số chuyển tiếp cuộc gọi.
+type TransferParams struct {
+    Destination string
+    // Attended: bên đang nối với channel (thường là agent) gọi tham vấn tới
+    // Destination trước, channel được chuyển khi agent gác máy.
+    Attended bool
+    // Leg của chuyển tiếp trực tiếp: self (mặc định), peer hoặc both.
+    Leg string
+}
+
+// Transfer chuyển channel tới Destination qua dialplan context default.
+func (c *Controller) Transfer(ctx context.Context, uuid string, p TransferParams) (calls.Call, error) {
+    call, err := c.call(uuid)
+    if err != nil {
+        return call, err
+    }
+    if !destinationPattern.MatchString(p.Destination) {
+        return call, ErrInvalidDestination
+    }
+
+    if p.Attended {
+        if call.BridgedTo == "" {
+            return call, ErrNotBridged
+        }
+        // att_xfer chạy trên leg đang nối với channel; channel được giữ máy
+        // trong lúc tham vấn và không bị ngắt khi bridge cũ kết thúc.
+        cmd := fmt.Sprintf("uuid_transfer %s -bleg 'set:hangup_after_bridge=false,att_xfer:loopback/%s/default' inline",
+            call.UUID, p.Destination)
+        _, err = c.FS.API(ctx, call.Node, cmd)
+        return call, err
+    }
+
+    var flag string
+    switch p.Leg {
+    case "", "self":
+    case "peer":
+        if call.BridgedTo == "" {
+            return call, ErrNotBridged
+        }
+        flag = " -bleg"
+    case "both":
+        flag = " -both"
+    default:
+        return call, ErrInvalidLeg
+    }
+    _, err = c.FS.API(ctx, call.Node, "uuid_transfer "+call.UUID+flag+" "+p.Destination+" XML default")
+    return call, err
+}
+
+// Hold giữ máy (on=true) hoặc bỏ giữ máy channel.
+func (c *Controller) Hold(ctx context.Context, uuid string, on bool) (calls.Call, error) {
+    call, err := c.call(uuid)
+    if err != nil {
+        return call, err
+    }
+    cmd := "uuid_hold " + call.UUID
+    if !on {
+        cmd = "uuid_hold off " + call.UUID
+    }
+    _, err = c.FS.API(ctx, call.Node, cmd)
+    return call, err
+}
+
+// Supervisor xác định user của supervisor bằng UserID hoặc Extension + Domain.
+type Supervisor struct {
+    UserID    int64
+    Extension string
+    Domain    string
+}
+
+// Monitor gọi tới máy của supervisor trên cùng node với channel; khi supervisor
+// nhấc máy sẽ nghe (và với whisper/barge, nói vào) cuộc gọi. Trả UUID leg của
+// supervisor.
+func (c *Controller) Monitor(ctx context.Context, uuid, mode string, sup Supervisor) (calls.Call, string, error) {
+    call, err := c.call(uuid)
+    if err != nil {
+        return call, "", err
+    }
+
+    var ext, domain string
+    err = c.Pool.QueryRow(ctx, `
+        SELECT u.username, d.name
+        FROM voip.users u
+        JOIN voip.domains d ON d.id = u.domain_id
+        WHERE u.is_active
+          AND (($1::bigint > 0 AND u.id = $1)
+            OR ($1::bigint = 0 AND u.username = $2 AND d.name = $3))
+    `, sup.UserID, sup.Extension, sup.Domain).Scan(&ext, &domain)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return call, "", ErrUserNotFound
+    }
+    if err != nil {
+        return call, "", err
+    }
+
+    legUUID, err := esl.NewUUID()
+    if err != nil {
+        return call, "", err
+    }
+    vars := []string{
+        "origination_uuid=" + legUUID,
+        "origination_caller_id_name=" + mode,
+        "origination_caller_id_number=" + callerNumber(call),
+        "domain_name=" + domain,
+        "eavesdrop_enable_dtmf=false",
+    }
+    switch mode {
+    case MonitorEavesdrop:
+    case MonitorWhisper:
+        vars = append(vars, "eavesdrop_whisper_aleg=true")
+    case MonitorBarge:
+        vars = append(vars, "eavesdrop_whisper_aleg=true", "eavesdrop_whisper_bleg=true")
+    default:
+        return call, "", fmt.Errorf("unsupported monitor mode %q", mode)
+    }
+    cmd := fmt.Sprintf("originate {%s}user/%s@%s &eavesdrop(%s)", strings.Join(vars, ","), ext, domain, call.UUID)
+    // Chạy nền: originate chỉ trả về khi supervisor nhấc máy hoặc hết thời gian chờ.
+    go func() {
+        bg, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+        defer cancel()
+        if _, err := c.FS.BgAPI(bg, call.Node, cmd); err != nil {
+            log.Printf("%s %s by %s@%s: %v", mode, call.UUID, ext, domain, err)
+        }
+    }()
+    return call, legUUID, nil
+}
+
+// callerNumber là số hiển thị trên máy supervisor để nhận biết cuộc gọi được giám sát.
+func callerNumber(call calls.Call) string {
+    if destinationPattern.MatchString(call.Caller) {
+        return call.Caller
+    }
+    return "0000"
+}
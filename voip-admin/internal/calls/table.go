@@ -0,0 +1,392 @@
+package calls
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+
+    "voip-admin/internal/esl"
+)
+
+// Các loại Update gửi tới subscriber.
+const (
+    UpdateCall      = "call.updated"
+    UpdateCallEnded = "call.ended"
+    UpdateCDR       = "cdr.completed"
+)
+
+// Call là một channel đang hoạt động trên một node FreeSWITCH.
+type Call struct {
+    UUID       string     `json:"uuid"`
+    Node       string     `json:"node"`
+    Leg        string     `json:"leg"`
+    Direction  string     `json:"direction"`
+    Caller     string     `json:"caller"`
+    CallerName string     `json:"caller_name,omitempty"`
+    Callee     string     `json:"callee"`
+    State      string     `json:"state"`
+    Domain     string     `json:"domain,omitempty"`
+    Queue      string     `json:"queue,omitempty"`
+    BridgedTo  string     `json:"bridged_to,omitempty"`
+    CreatedAt  time.Time  `json:"created_at"`
+    AnsweredAt *time.Time `json:"answered_at,omitempty"`
+    // Duration (giây) tính tới thời điểm đọc.
+    Duration int `json:"duration"`
+}
+
+// Update là một thay đổi của bảng cuộc gọi, hoặc CDR vừa ingest.
+type Update struct {
+    Type string      `json:"type"`
+    Call *Call       `json:"call,omitempty"`
+    CDR  interface{} `json:"cdr,omitempty"`
+}
+
+// Table là bảng cuộc gọi đang diễn ra, dựng từ channel event của các node và
+// đồng bộ lại bằng "show channels" mỗi khi một node kết nối lại.
+type Table struct {
+    fs     *esl.Manager
+    events <-chan esl.Event
+
+    mu    sync.RWMutex
+    calls map[string]*Call
+    // syncs theo node: event nhận được trong lúc Sync chờ "show channels".
+    syncs map[string]*syncState
+
+    subMu  sync.Mutex
+    subs   map[int]chan Update
+    nextID int
+}
+
+// NewTable đăng ký nhận event và hook kết nối của fs; gọi trước fs.Run để
+// không bỏ lỡ lần kết nối đầu tiên.
+func NewTable(fs *esl.Manager) *Table {
+    t := &Table{
+        fs:    fs,
+        calls: make(map[string]*Call),
+        syncs: make(map[string]*syncState),
+        subs:  make(map[int]chan Update),
+    }
+    t.events, _ = fs.Subscribe(1024)
+    fs.OnConnect(func(ctx context.Context, node string) {
+        if err := t.Sync(ctx, node); err != nil {
+            log.Printf("active calls: sync %s: %v", node, err)
+        }
+    })
+    return t
+}
+
+// Run áp dụng event vào bảng tới khi ctx bị hủy.
+func (t *Table) Run(ctx context.Context) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case e := <-t.events:
+            t.Apply(e)
+        }
+    }
+}
+
+// Apply cập nhật bảng theo một channel event.
+func (t *Table) Apply(e esl.Event) {
+    uuid := e.Get("Unique-ID")
+    if uuid == "" {
+        return
+    }
+
+    t.mu.Lock()
+    if st := t.syncs[e.Node]; st != nil {
+        if e.Name() == "CHANNEL_HANGUP_COMPLETE" {
+            st.ended[uuid] = true
+        } else {
+            st.seen[uuid] = true
+        }
+    }
+    c, ok := t.calls[uuid]
+    switch e.Name() {
+    case "CHANNEL_HANGUP_COMPLETE":
+        if !ok {
+            t.mu.Unlock()
+            return
+        }
+        delete(t.calls, uuid)
+        snap := t.snapshot(c)
+        t.mu.Unlock()
+        t.Publish(Update{Type: UpdateCallEnded, Call: &snap})
+        return
+    case "CHANNEL_CREATE":
+        if !ok {
+            c = &Call{UUID: uuid, Node: e.Node, State: "DOWN"}
+            t.calls[uuid] = c
+        }
+    default:
+        // Event của channel chưa biết (ví dụ tạo trước khi kết nối) vẫn được thêm vào.
+        if !ok {
+            c = &Call{UUID: uuid, Node: e.Node}
+            t.calls[uuid] = c
+        }
+    }
+
+    applyHeaders(c, e)
+    switch e.Name() {
+    case "CHANNEL_ANSWER":
+        c.State = "ACTIVE"
+    case "CHANNEL_BRIDGE":
+        c.BridgedTo = e.Get("Other-Leg-Unique-ID")
+    case "CHANNEL_UNBRIDGE":
+        c.BridgedTo = ""
+    case "CHANNEL_HOLD":
+        c.State = "HELD"
+    case "CHANNEL_UNHOLD":
+        c.State = "ACTIVE"
+    }
+    snap := t.snapshot(c)
+    t.mu.Unlock()
+    t.Publish(Update{Type: UpdateCall, Call: &snap})
+}
+
+func applyHeaders(c *Call, e esl.Event) {
+    set := func(dst *string, header string) {
+        if v := e.Get(header); v != "" {
+            *dst = v
+        }
+    }
+    set(&c.Direction, "Call-Direction")
+    set(&c.Caller, "Caller-Caller-ID-Number")
+    set(&c.CallerName, "Caller-Caller-ID-Name")
+    set(&c.Callee, "Caller-Destination-Number")
+    set(&c.State, "Channel-Call-State")
+    set(&c.Domain, "variable_domain_name")
+    set(&c.Queue, "variable_cc_queue")
+    if e.Get("variable_originating_leg_uuid") != "" {
+        c.Leg = "B"
+    } else if c.Leg == "" {
+        c.Leg = "A"
+    }
+    if t := microTime(e.Get("Caller-Channel-Created-Time")); t != nil {
+        c.CreatedAt = *t
+    } else if c.CreatedAt.IsZero() {
+        c.CreatedAt = time.Now().UTC()
+    }
+    if t := microTime(e.Get("Caller-Channel-Answered-Time")); t != nil {
+        c.AnsweredAt = t
+    }
+}
+
+// microTime đọc timestamp micro giây của FreeSWITCH; "0" là chưa xảy ra.
+func microTime(s string) *time.Time {
+    n, err := strconv.ParseInt(s, 10, 64)
+    if err != nil || n <= 0 {
+        return nil
+    }
+    t := time.UnixMicro(n).UTC()
+    return &t
+}
+
+// snapshot trả bản sao của c kèm thời lượng hiện tại; gọi khi đang giữ t.mu.
+func (t *Table) snapshot(c *Call) Call {
+    s := *c
+    if !s.CreatedAt.IsZero() {
+        s.Duration = int(time.Since(s.CreatedAt).Seconds())
+    }
+    return s
+}
+
+// Filter lọc danh sách cuộc gọi; trường rỗng là không lọc.
+type Filter struct {
+    Node   string
+    Domain string
+    Queue  string
+    Leg    string
+}
+
+// Match cho biết c có khớp f không.
+func (f Filter) Match(c Call) bool {
+    return (f.Node == "" || c.Node == f.Node) &&
+        (f.Domain == "" || c.Domain == f.Domain) &&
+        (f.Queue == "" || c.Queue == f.Queue) &&
+        (f.Leg == "" || c.Leg == f.Leg)
+}
+
+// List trả các cuộc gọi khớp f, cũ nhất trước.
+func (t *Table) List(f Filter) []Call {
+    t.mu.RLock()
+    out := make([]Call, 0, len(t.calls))
+    for _, c := range t.calls {
+        if !f.Match(*c) {
+            continue
+        }
+        out = append(out, t.snapshot(c))
+    }
+    t.mu.RUnlock()
+    sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+    return out
+}
+
+// Get trả cuộc gọi theo UUID.
+func (t *Table) Get(uuid string) (Call, bool) {
+    t.mu.RLock()
+    defer t.mu.RUnlock()
+    c, ok := t.calls[uuid]
+    if !ok {
+        return Call{}, false
+    }
+    return t.snapshot(c), true
+}
+
+// showChannels là kết quả của "show channels as json".
+type showChannels struct {
+    Rows []channelRow `json:"rows"`
+}
+
+type channelRow struct {
+    UUID         string `json:"uuid"`
+    Direction    string `json:"direction"`
+    CreatedEpoch string `json:"created_epoch"`
+    CIDName      string `json:"cid_name"`
+    CIDNum       string `json:"cid_num"`
+    Dest         string `json:"dest"`
+    CallState    string `json:"callstate"`
+    PresenceID   string `json:"presence_id"`
+    // CallUUID là uuid của A-leg (khác UUID ở B-leg); BUUID là leg đã bridge.
+    CallUUID string `json:"call_uuid"`
+    BUUID    string `json:"b_uuid"`
+}
+
+// syncState ghi các channel có event (seen) hoặc đã kết thúc (ended) trong lúc
+// Sync chờ kết quả "show channels", vì kết quả đó có thể cũ hơn các event này.
+type syncState struct {
+    seen  map[string]bool
+    ended map[string]bool
+}
+
+// Sync thay các cuộc gọi của node bằng danh sách channel hiện tại của node đó,
+// để bỏ cuộc gọi đã kết thúc trong lúc mất kết nối.
+func (t *Table) Sync(ctx context.Context, node string) error {
+    st := &syncState{seen: map[string]bool{}, ended: map[string]bool{}}
+    t.mu.Lock()
+    t.syncs[node] = st
+    t.mu.Unlock()
+    defer func() {
+        t.mu.Lock()
+        if t.syncs[node] == st {
+            delete(t.syncs, node)
+        }
+        t.mu.Unlock()
+    }()
+
+    out, err := t.fs.API(ctx, node, "show channels as json")
+    if err != nil {
+        return err
+    }
+    var res showChannels
+    // Node không có channel trả {"row_count":0} không có rows.
+    if err := json.Unmarshal([]byte(out), &res); err != nil {
+        return err
+    }
+    t.applySync(node, st, res.Rows)
+    return nil
+}
+
+// applySync áp danh sách channel của node lên bảng: channel đã kết thúc trong lúc
+// Sync không được thêm lại, channel có event trong lúc Sync không bị xóa.
+func (t *Table) applySync(node string, st *syncState, rows []channelRow) {
+    bLegs := make(map[string]bool)
+    for _, r := range rows {
+        if r.BUUID != "" {
+            bLegs[r.BUUID] = true
+        }
+    }
+
+    var ended []Call
+    t.mu.Lock()
+    fresh := make(map[string]*Call, len(rows))
+    for _, r := range rows {
+        if st.ended[r.UUID] {
+            continue
+        }
+        c := &Call{
+            UUID:       r.UUID,
+            Node:       node,
+            Leg:        "A",
+            Direction:  r.Direction,
+            Caller:     r.CIDNum,
+            CallerName: r.CIDName,
+            Callee:     r.Dest,
+            State:      r.CallState,
+            BridgedTo:  r.BUUID,
+        }
+        if (r.CallUUID != "" && r.CallUUID != r.UUID) || bLegs[r.UUID] {
+            c.Leg = "B"
+        }
+        if n, err := strconv.ParseInt(r.CreatedEpoch, 10, 64); err == nil {
+            c.CreatedAt = time.Unix(n, 0).UTC()
+        }
+        fresh[r.UUID] = c
+    }
+
+    for uuid, c := range t.calls {
+        if c.Node != node {
+            continue
+        }
+        if f, ok := fresh[uuid]; ok {
+            // Giữ thông tin đã có từ event (queue, domain, leg...).
+            f.Leg, f.Domain, f.Queue, f.AnsweredAt = c.Leg, c.Domain, c.Queue, c.AnsweredAt
+            if c.BridgedTo != "" || st.seen[uuid] {
+                f.BridgedTo = c.BridgedTo
+            }
+            continue
+        }
+        if st.seen[uuid] {
+            // Channel tạo sau khi "show channels" chạy.
+            continue
+        }
+        delete(t.calls, uuid)
+        ended = append(ended, t.snapshot(c))
+    }
+    for uuid, c := range fresh {
+        t.calls[uuid] = c
+    }
+    t.mu.Unlock()
+
+    for i := range ended {
+        t.Publish(Update{Type: UpdateCallEnded, Call: &ended[i]})
+    }
+}
+
+// Subscribe đăng ký nhận Update; update bị bỏ khi kênh đầy để subscriber chậm
+// (client SSE) không chặn việc xử lý event.
+func (t *Table) Subscribe(buffer int) (<-chan Update, func()) {
+    ch := make(chan Update, buffer)
+    t.subMu.Lock()
+    id := t.nextID
+    t.nextID++
+    t.subs[id] = ch
+    t.subMu.Unlock()
+
+    var once sync.Once
+    return ch, func() {
+        once.Do(func() {
+            t.subMu.Lock()
+            delete(t.subs, id)
+            t.subMu.Unlock()
+            close(ch)
+        })
+    }
+}
+
+// Publish gửi u tới mọi subscriber.
+func (t *Table) Publish(u Update) {
+    t.subMu.Lock()
+    defer t.subMu.Unlock()
+    for _, ch := range t.subs {
+        select {
+        case ch <- u:
+        default:
+        }
+    }
+}
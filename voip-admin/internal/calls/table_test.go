@@ -0,0 +1,107 @@
+package calls
+
+import (
+    "testing"
+
+    "voip-admin/internal/esl"
+)
+
+func newTestTable() *Table {
+    return &Table{
+        calls: make(map[string]*Call),
+        syncs: make(map[string]*syncState),
+        subs:  make(map[int]chan Update),
+    }
+}
+
+func event(node, name, uuid string) esl.Event {
+    return esl.Event{Node: node, Headers: map[string]string{"Event-Name": name, "Unique-ID": uuid}}
+}
+
+// beginSync đăng ký syncState như Sync làm trước khi gửi "show channels".
+func beginSync(t *Table, node string) *syncState {
+    st := &syncState{seen: map[string]bool{}, ended: map[string]bool{}}
+    t.syncs[node] = st
+    return st
+}
+
+func TestApplySync(t *testing.T) {
+    tests := []struct {
+        name string
+        // before là event áp trước khi Sync bắt đầu, during là event trong lúc chờ.
+        before []esl.Event
+        during []esl.Event
+        rows   []channelRow
+        want   map[string]string // uuid -> leg
+        ended  int
+    }{
+        {
+            name:   "hangup during sync leaves no zombie",
+            before: []esl.Event{event("fs1", "CHANNEL_CREATE", "a")},
+            during: []esl.Event{event("fs1", "CHANNEL_HANGUP_COMPLETE", "a")},
+            rows:   []channelRow{{UUID: "a", CallUUID: "a"}},
+            want:   map[string]string{},
+        },
+        {
+            name:   "channel created during sync is kept",
+            during: []esl.Event{event("fs1", "CHANNEL_CREATE", "b")},
+            want:   map[string]string{"b": "A"},
+        },
+        {
+            name:   "channel gone while disconnected is ended",
+            before: []esl.Event{event("fs1", "CHANNEL_CREATE", "c"), event("fs2", "CHANNEL_CREATE", "x")},
+            want:   map[string]string{"x": "A"},
+            ended:  1,
+        },
+        {
+            name: "leg from call_uuid and b_uuid",
+            rows: []channelRow{
+                {UUID: "a1", CallUUID: "a1", BUUID: "b1"},
+                {UUID: "b1"},
+                {UUID: "b2", CallUUID: "a2"},
+                {UUID: "a3"},
+            },
+            want: map[string]string{"a1": "A", "b1": "B", "b2": "B", "a3": "A"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tbl := newTestTable()
+            updates, cancel := tbl.Subscribe(16)
+            defer cancel()
+            for _, e := range tt.before {
+                tbl.Apply(e)
+            }
+            st := beginSync(tbl, "fs1")
+            for _, e := range tt.during {
+                tbl.Apply(e)
+            }
+            for len(updates) > 0 {
+                <-updates
+            }
+            tbl.applySync("fs1", st, tt.rows)
+
+            got := map[string]string{}
+            for _, c := range tbl.List(Filter{}) {
+                got[c.UUID] = c.Leg
+            }
+            if len(got) != len(tt.want) {
+                t.Fatalf("calls = %v, want %v", got, tt.want)
+            }
+            for uuid, leg := range tt.want {
+                if got[uuid] != leg {
+                    t.Errorf("call %s leg = %q, want %q", uuid, got[uuid], leg)
+                }
+            }
+            ended := 0
+            for len(updates) > 0 {
+                if u := <-updates; u.Type == UpdateCallEnded {
+                    ended++
+                }
+            }
+            if ended != tt.ended {
+                t.Errorf("%d call.ended updates, want %d", ended, tt.ended)
+            }
+        })
+    }
+}
@@ -0,0 +1,121 @@
+package cdr
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "time"
+)
+
+// Columns là giá trị các cột voip.cdr suy ra từ payload FreeSWITCH.
+// Ingest và reprocess dùng chung để cùng một raw_json luôn cho cùng kết quả.
+// DomainName và CCAgent được tra thành domain_id/agent_user_id trong database.
+type Columns struct {
+    Direction         string
+    CallerIDNumber    string
+    DestinationNumber string
+    StartTime         time.Time
+    AnswerTime        time.Time
+    EndTime           time.Time
+    Duration          int
+    BillSec           int
+    HangupCause       string
+    DomainName        string
+
+    // Leg là "A" hoặc "B"; B-leg nhận biết qua originating_leg_uuid.
+    Leg                string
+    OriginatingLegUUID string
+
+    // ClickToCallUserID là user đã yêu cầu cuộc gọi click-to-call, nil nếu không có.
+    ClickToCallUserID *int64
+
+    CCQueue             *string
+    CCSide              *string
+    CCAgent             *string
+    CCCause             *string
+    CCCancelReason      *string
+    CCQueueJoinedAt     *time.Time
+    CCQueueAnsweredAt   *time.Time
+    CCQueueTerminatedAt *time.Time
+    CCQueueCanceledAt   *time.Time
+}
+
+// Giá trị của cột leg.
+const (
+    LegA = "A"
+    LegB = "B"
+)
+
+const stampLayout = "2006-01-02 15:04:05"
+
+// Derive tính các cột từ biến của CDR.
+func Derive(fs *FreeSwitchCDR) Columns {
+    v := &fs.Variables
+    start, _ := time.ParseInLocation(stampLayout, v.StartStamp, time.Local)
+    end, _ := time.ParseInLocation(stampLayout, v.EndStamp, time.Local)
+    answer, _ := time.ParseInLocation(stampLayout, v.AnswerStamp, time.Local)
+
+    c := Columns{
+        Direction:         v.Direction,
+        CallerIDNumber:    v.CallerIDNumber,
+        DestinationNumber: v.DestinationNumber,
+        StartTime:         start,
+        AnswerTime:        answer,
+        EndTime:           end,
+        Duration:          atoiSafe(v.Duration),
+        BillSec:           atoiSafe(v.BillSec),
+        HangupCause:       v.HangupCause,
+        DomainName:        v.DomainName,
+
+        Leg:                LegA,
+        OriginatingLegUUID: v.OriginatingLegUUID,
+
+        CCQueue:             nullIfEmpty(v.CCQueue),
+        CCSide:              nullIfEmpty(v.CCSide),
+        CCAgent:             nullIfEmpty(v.CCAgent),
+        CCCause:             nullIfEmpty(v.CCCause),
+        CCCancelReason:      nullIfEmpty(v.CCCancelReason),
+        CCQueueJoinedAt:     epochTime(v.CCQueueJoinedEpoch),
+        CCQueueAnsweredAt:   epochTime(v.CCQueueAnsweredEpoch),
+        CCQueueTerminatedAt: epochTime(v.CCQueueTerminatedEpoch),
+        CCQueueCanceledAt:   epochTime(v.CCQueueCanceledEpoch),
+    }
+    if v.OriginatingLegUUID != "" {
+        c.Leg = LegB
+    }
+    if id, err := strconv.ParseInt(v.ClickToCallUserID, 10, 64); err == nil && id > 0 {
+        c.ClickToCallUserID = &id
+    }
+    return c
+}
+
+// Completeness là số biến có giá trị trong raw_json, dùng để chọn bản đầy đủ
+// hơn khi cùng một leg được gửi nhiều lần.
+func Completeness(rawJSON []byte) int {
+    var doc struct {
+        Variables map[string]interface{} `json:"variables"`
+    }
+    if err := json.Unmarshal(rawJSON, &doc); err != nil {
+        return 0
+    }
+    n := 0
+    for _, v := range doc.Variables {
+        if s, ok := v.(string); ok && s == "" {
+            continue
+        }
+        if v != nil {
+            n++
+        }
+    }
+    return n
+}
+
+// ParseRawJSON đọc lại raw_json đã lưu (JSON gốc của mod_json_cdr hoặc
+// JSON chuẩn hóa từ mod_xml_cdr).
+func ParseRawJSON(raw []byte) (*FreeSwitchCDR, error) {
+    var fs FreeSwitchCDR
+    if err := json.Unmarshal(raw, &fs); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+    }
+    return &fs, nil
+}
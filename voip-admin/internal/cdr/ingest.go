@@ -2,10 +2,11 @@ package cdr
 
 import (
     "context"
-    "encoding/json"
+    "errors"
     "fmt"
     "time"
 
+    "github.com/jackc/pgx/v5"
     "github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -15,6 +16,7 @@ type FreeSwitchCDR struct {
         Direction         string `json:"direction"`
         CallerIDNumber    string `json:"caller_id_number"`
         DestinationNumber string `json:"destination_number"`
+        DomainName        string `json:"domain_name"`
         StartStamp        string `json:"start_stamp"`
         AnswerStamp       string `json:"answer_stamp"`
         EndStamp          string `json:"end_stamp"`
@@ -24,66 +26,276 @@ type FreeSwitchCDR struct {
         QueueName         string `json:"queue_name"`
         AgentID           string `json:"agent_id"`
         RecordingFile     string `json:"recording_file"`
+
+        // Biến của mod_callcenter (chỉ có với cuộc gọi qua queue).
+        CCQueue                string `json:"cc_queue"`
+        CCSide                 string `json:"cc_side"`
+        CCAgent                string `json:"cc_agent"`
+        CCCause                string `json:"cc_cause"`
+        CCCancelReason         string `json:"cc_cancel_reason"`
+        CCQueueJoinedEpoch     string `json:"cc_queue_joined_epoch"`
+        CCQueueAnsweredEpoch   string `json:"cc_queue_answered_epoch"`
+        CCQueueTerminatedEpoch string `json:"cc_queue_terminated_epoch"`
+        CCQueueCanceledEpoch   string `json:"cc_queue_canceled_epoch"`
+
+        // Có ở CDR của B-leg: uuid của A-leg đã tạo ra leg này.
+        OriginatingLegUUID string `json:"originating_leg_uuid"`
+
+        // Id user đã yêu cầu cuộc gọi click-to-call (đặt khi originate).
+        ClickToCallUserID string `json:"click_to_call_user_id"`
     } `json:"variables"`
 }
 
+// ErrInvalidPayload bọc lỗi parse payload CDR (JSON/XML sai định dạng),
+// phân biệt với lỗi ghi database.
+var ErrInvalidPayload = errors.New("invalid cdr payload")
+
+// AfterInsert chạy trong transaction insert sau khi CDR được ghi mới hoặc thay
+// bản cũ (ví dụ rate và ghi webhook outbox); lỗi làm hủy cả lần insert.
+type AfterInsert func(ctx context.Context, tx pgx.Tx, cdrID int64) error
+
 // InsertCDR nhận raw JSON từ FreeSWITCH và insert vào bảng voip.cdr, voip.recordings.
-func InsertCDR(ctx context.Context, pool *pgxpool.Pool, raw []byte) error {
-    var fs FreeSwitchCDR
-    if err := json.Unmarshal(raw, &fs); err != nil {
-        return err
+// Trả về id CDR, hoặc 0 nếu là bản trùng không đầy đủ hơn bản đang lưu (xem insertCDR).
+// after có thể nil.
+func InsertCDR(ctx context.Context, pool *pgxpool.Pool, raw []byte, after AfterInsert) (int64, error) {
+    fs, err := ParseRawJSON(raw)
+    if err != nil {
+        return 0, err
+    }
+    return insertCDR(ctx, pool, fs, raw, nil, after)
+}
+
+// InsertXMLCDR nhận document XML từ mod_xml_cdr. raw_json lưu bản JSON chuẩn hóa,
+// payload XML gốc được giữ nguyên trong raw_xml để đối soát.
+func InsertXMLCDR(ctx context.Context, pool *pgxpool.Pool, raw []byte, after AfterInsert) (int64, error) {
+    fs, normalized, err := ParseXML(raw)
+    if err != nil {
+        return 0, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
     }
+    rawXML := string(raw)
+    return insertCDR(ctx, pool, fs, normalized, &rawXML, after)
+}
 
-    layout := "2006-01-02 15:04:05"
-    start, _ := time.ParseInLocation(layout, fs.Variables.StartStamp, time.Local)
-    end, _ := time.ParseInLocation(layout, fs.Variables.EndStamp, time.Local)
-    answer, _ := time.ParseInLocation(layout, fs.Variables.AnswerStamp, time.Local)
+// Merge policy khi nhận lại CDR cùng call_uuid và leg (FreeSWITCH gửi lại,
+// hoặc bản đã sửa): giữ bản đầy đủ hơn theo thứ tự completeness, billsec,
+// end_time; bằng nhau thì giữ bản đang lưu. Bản bị loại được ghi vào
+// voip.cdr_duplicates. Recording và CDR được ghi trong cùng transaction,
+// khóa theo (call_uuid, leg) để các lần gửi đồng thời được xử lý tuần tự.
+//
+// Trả về id CDR khi insert mới hoặc thay bản cũ, 0 khi giữ bản đang lưu.
+func insertCDR(ctx context.Context, pool *pgxpool.Pool, fs *FreeSwitchCDR, rawJSON []byte, rawXML *string, after AfterInsert) (int64, error) {
+    c := Derive(fs)
+    score := Completeness(rawJSON)
+    callUUID := fs.Variables.UUID
 
-    dur := atoiSafe(fs.Variables.Duration)
-    bill := atoiSafe(fs.Variables.BillSec)
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1::text || '/' || $2::text))`, callUUID, c.Leg); err != nil {
+        return 0, err
+    }
 
     var recordingID *int64
     if fs.Variables.RecordingFile != "" {
         var id int64
-        err := pool.QueryRow(ctx, `
+        err := tx.QueryRow(ctx, `
             INSERT INTO voip.recordings (call_uuid, path, backend)
             VALUES ($1, $2, 'local')
             ON CONFLICT (call_uuid, path) DO UPDATE
             SET path = EXCLUDED.path
             RETURNING id
-        `, fs.Variables.UUID, fs.Variables.RecordingFile).Scan(&id)
-        if err == nil {
-            recordingID = &id
+        `, callUUID, fs.Variables.RecordingFile).Scan(&id)
+        if err != nil {
+            return 0, fmt.Errorf("insert recording: %w", err)
+        }
+        recordingID = &id
+    }
+
+    var (
+        existingID    int64
+        existingStart time.Time
+        existingScore int
+        existingBill  int
+        existingEnd   *time.Time
+        existingRaw   *string
+        existingRec   *int64
+    )
+    err = tx.QueryRow(ctx, `
+        SELECT id, start_time, completeness, COALESCE(billsec, 0), end_time, raw_json::text, recording_id
+        FROM voip.cdr
+        WHERE call_uuid = $1 AND leg = $2
+        ORDER BY completeness DESC, id
+        LIMIT 1
+        FOR UPDATE
+    `, callUUID, c.Leg).Scan(&existingID, &existingStart, &existingScore, &existingBill, &existingEnd, &existingRaw, &existingRec)
+    if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+        return 0, err
+    }
+
+    var cdrID int64
+
+    switch {
+    case errors.Is(err, pgx.ErrNoRows):
+        values := cdrValues(c, recordingID, rawJSON, rawXML, score)
+        err = tx.QueryRow(ctx, insertCDRSQL, append([]interface{}{callUUID}, values...)...).Scan(&cdrID)
+        if err != nil {
+            return 0, err
+        }
+
+    case betterCDR(score, c.BillSec, c.EndTime, existingScore, existingBill, existingEnd):
+        // Giữ id (và các cột rating) của bản cũ để rerate tính theo chênh lệch.
+        // Bản mới không có bản ghi âm thì giữ recording_id của bản cũ.
+        if recordingID == nil {
+            recordingID = existingRec
+        }
+        values := cdrValues(c, recordingID, rawJSON, rawXML, score)
+        _, err = tx.Exec(ctx, replaceCDRSQL, append(append([]interface{}{existingID}, values...), existingStart)...)
+        if err != nil {
+            return 0, err
+        }
+        if err := recordDuplicate(ctx, tx, callUUID, c.Leg, existingID, "replaced", score, existingScore, existingRaw); err != nil {
+            return 0, err
+        }
+        cdrID = existingID
+
+    default:
+        if recordingID != nil {
+            if _, err := tx.Exec(ctx, `
+                UPDATE voip.cdr SET recording_id = $3
+                WHERE id = $1 AND start_time = $2 AND recording_id IS NULL
+            `, existingID, existingStart, *recordingID); err != nil {
+                return 0, err
+            }
+        }
+        raw := string(rawJSON)
+        if err := recordDuplicate(ctx, tx, callUUID, c.Leg, existingID, "kept_existing", existingScore, score, &raw); err != nil {
+            return 0, err
+        }
+    }
+
+    if cdrID != 0 && after != nil {
+        if err := after(ctx, tx, cdrID); err != nil {
+            return 0, err
         }
     }
+    if err := tx.Commit(ctx); err != nil {
+        return 0, err
+    }
+    return cdrID, nil
+}
+
+// cdrColumns/cdrPlaceholders là các cột ghi từ payload, tham số bắt đầu từ $2
+// theo thứ tự của cdrValues. domain_id và agent_user_id được tra theo tên,
+// click_to_call_user_id chỉ giữ khi user còn tồn tại.
+const (
+    cdrColumns = `direction,
+        caller_id_number, destination_number,
+        start_time, answer_time, end_time,
+        duration, billsec, hangup_cause, recording_id, raw_json, raw_xml,
+        domain_id, agent_user_id,
+        cc_queue, cc_side, cc_agent, cc_cause, cc_cancel_reason,
+        cc_queue_joined_at, cc_queue_answered_at, cc_queue_terminated_at, cc_queue_canceled_at,
+        leg, originating_leg_uuid, completeness,
+        click_to_call_user_id`
+    cdrPlaceholders = `$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,
+        (SELECT id FROM voip.domains WHERE name=$14),
+        (
+            SELECT u.id
+            FROM voip.users u
+            JOIN voip.domains d ON d.id = u.domain_id
+            WHERE u.username = split_part($17, '@', 1)
+              AND d.name = split_part($17, '@', 2)
+        ),
+        $15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,
+        (SELECT id FROM voip.users WHERE id=$27)`
 
-    _, err := pool.Exec(ctx, `
+    insertCDRSQL = `
         INSERT INTO voip.cdr (
-            call_uuid, direction,
-            caller_id_number, destination_number,
-            start_time, answer_time, end_time,
-            duration, billsec, hangup_cause, recording_id, raw_json
+            call_uuid, ` + cdrColumns + `
         ) VALUES (
-            $1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12
+            $1, ` + cdrPlaceholders + `
         )
-        ON CONFLICT (call_uuid) DO NOTHING
-    `,
-        fs.Variables.UUID,
-        fs.Variables.Direction,
-        fs.Variables.CallerIDNumber,
-        fs.Variables.DestinationNumber,
-        start,
-        answer,
-        end,
-        dur,
-        bill,
-        fs.Variables.HangupCause,
+        RETURNING id
+    `
+    // replaceCDRSQL ghi đè bản đang lưu ($1 là id, $28 là start_time để chọn partition).
+    replaceCDRSQL = `
+        UPDATE voip.cdr
+        SET (` + cdrColumns + `, merged_at) = (` + cdrPlaceholders + `, now())
+        WHERE id = $1 AND start_time = $28
+    `
+)
+
+func cdrValues(c Columns, recordingID *int64, rawJSON []byte, rawXML *string, score int) []interface{} {
+    return []interface{}{
+        c.Direction,
+        c.CallerIDNumber,
+        c.DestinationNumber,
+        c.StartTime,
+        c.AnswerTime,
+        c.EndTime,
+        c.Duration,
+        c.BillSec,
+        c.HangupCause,
         recordingID,
-        raw,
-    )
+        rawJSON,
+        rawXML,
+        c.DomainName,
+        c.CCQueue,
+        c.CCSide,
+        c.CCAgent,
+        c.CCCause,
+        c.CCCancelReason,
+        c.CCQueueJoinedAt,
+        c.CCQueueAnsweredAt,
+        c.CCQueueTerminatedAt,
+        c.CCQueueCanceledAt,
+        c.Leg,
+        nullIfEmpty(c.OriginatingLegUUID),
+        score,
+        c.ClickToCallUserID,
+    }
+}
+
+// betterCDR cho biết bản mới có đầy đủ hơn bản đang lưu không.
+func betterCDR(score, billsec int, end time.Time, oldScore, oldBillsec int, oldEnd *time.Time) bool {
+    if score != oldScore {
+        return score > oldScore
+    }
+    if billsec != oldBillsec {
+        return billsec > oldBillsec
+    }
+    return oldEnd != nil && end.After(*oldEnd)
+}
+
+func recordDuplicate(ctx context.Context, tx pgx.Tx, callUUID, leg string, cdrID int64, action string, keptScore, discardedScore int, discardedRaw *string) error {
+    _, err := tx.Exec(ctx, `
+        INSERT INTO voip.cdr_duplicates (
+            call_uuid, leg, cdr_id, action, kept_completeness, discarded_completeness, discarded_raw_json
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `, callUUID, leg, cdrID, action, keptScore, discardedScore, discardedRaw)
     return err
 }
 
+// epochTime đổi epoch giây của FreeSWITCH sang thời gian; rỗng hoặc 0 là NULL.
+func epochTime(s string) *time.Time {
+    n := atoiSafe(s)
+    if n <= 0 {
+        return nil
+    }
+    t := time.Unix(int64(n), 0)
+    return &t
+}
+
+func nullIfEmpty(s string) *string {
+    if s == "" {
+        return nil
+    }
+    return &s
+}
+
 func atoiSafe(s string) int {
     var n int
     _, _ = fmt.Sscanf(s, "%d", &n)
@@ -0,0 +1,188 @@
+package cdr
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/db"
+    "voip-admin/internal/partition"
+)
+
+// TestReplaceCDRSQL kiểm tra câu UPDATE của nhánh thay bản cũ: mỗi cột chỉ được
+// gán một lần (Postgres từ chối gán trùng) và số cột khớp số giá trị.
+func TestReplaceCDRSQL(t *testing.T) {
+    m := regexp.MustCompile(`(?s)SET \((.*)\) = \((.*)\)\s+WHERE`).FindStringSubmatch(replaceCDRSQL)
+    if m == nil {
+        t.Fatalf("unexpected replace sql: %s", replaceCDRSQL)
+    }
+    seen := map[string]bool{}
+    cols := strings.Split(m[1], ",")
+    for _, c := range cols {
+        c = strings.TrimSpace(c)
+        if seen[c] {
+            t.Errorf("column %q assigned twice", c)
+        }
+        seen[c] = true
+    }
+    if !seen["recording_id"] {
+        t.Error("recording_id not assigned")
+    }
+
+    // Một phép gán thêm sau danh sách (ví dụ ", recording_id = ...") làm lệch số giá trị.
+    if n := len(splitTopLevel(m[2])); n != len(cols) {
+        t.Errorf("%d columns, %d values", len(cols), n)
+    }
+    if n := len(cdrValues(Columns{}, nil, nil, nil, 0)); n != 26 {
+        t.Errorf("cdrValues returns %d values, placeholders expect $2..$27", n)
+    }
+}
+
+// splitTopLevel tách danh sách biểu thức theo dấu phẩy không nằm trong ngoặc.
+func splitTopLevel(s string) []string {
+    var (
+        out   []string
+        depth int
+        start int
+    )
+    for i, r := range s {
+        switch r {
+        case '(':
+            depth++
+        case ')':
+            depth--
+        case ',':
+            if depth == 0 {
+                out = append(out, s[start:i])
+                start = i + 1
+            }
+        }
+    }
+    return append(out, s[start:])
+}
+
+// testPool mở database thử nghiệm từ VOIPADMIN_TEST_DSN; không có thì bỏ qua test.
+func testPool(t *testing.T) *pgxpool.Pool {
+    t.Helper()
+    dsn := os.Getenv("VOIPADMIN_TEST_DSN")
+    if dsn == "" {
+        t.Skip("VOIPADMIN_TEST_DSN not set")
+    }
+    pool, err := db.NewPool(dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(pool.Close)
+
+    ctx := context.Background()
+    if err := db.Migrate(ctx, pool); err != nil {
+        t.Fatal(err)
+    }
+    pm := &partition.Manager{Pool: pool}
+    if err := pm.EnsurePartitions(ctx, time.Now()); err != nil {
+        t.Fatal(err)
+    }
+    return pool
+}
+
+func cdrJSON(uuid string, billsec int, extra map[string]string) []byte {
+    now := time.Now()
+    vars := map[string]string{
+        "uuid":               uuid,
+        "direction":          "outbound",
+        "caller_id_number":   "1001",
+        "destination_number": "0901234567",
+        "start_stamp":        now.Add(-time.Minute).Format(stampLayout),
+        "end_stamp":          now.Format(stampLayout),
+        "duration":           "60",
+        "billsec":            fmt.Sprint(billsec),
+    }
+    for k, v := range extra {
+        vars[k] = v
+    }
+    var b strings.Builder
+    b.WriteString(`{"variables":{`)
+    first := true
+    for k, v := range vars {
+        if !first {
+            b.WriteString(",")
+        }
+        first = false
+        fmt.Fprintf(&b, "%q:%q", k, v)
+    }
+    b.WriteString("}}")
+    return []byte(b.String())
+}
+
+func TestInsertCDRReplace(t *testing.T) {
+    pool := testPool(t)
+    ctx := context.Background()
+    uuid := fmt.Sprintf("test-replace-%d", time.Now().UnixNano())
+
+    firstID, err := InsertCDR(ctx, pool, cdrJSON(uuid, 10, map[string]string{
+        "recording_file": "/recordings/" + uuid + ".wav",
+    }), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if firstID == 0 {
+        t.Fatal("first insert returned 0")
+    }
+
+    // Bản gửi lại đầy đủ hơn nhưng không có recording_file: thay bản cũ,
+    // giữ id và recording_id.
+    secondID, err := InsertCDR(ctx, pool, cdrJSON(uuid, 20, map[string]string{
+        "hangup_cause": "NORMAL_CLEARING",
+        "answer_stamp": time.Now().Add(-20 * time.Second).Format(stampLayout),
+    }), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if secondID != firstID {
+        t.Fatalf("replace returned id %d, want %d", secondID, firstID)
+    }
+
+    var (
+        rows     int
+        billsec  int
+        recordID *int64
+        merged   bool
+    )
+    err = pool.QueryRow(ctx, `
+        SELECT COUNT(*) OVER (), billsec, recording_id, merged_at IS NOT NULL
+        FROM voip.cdr WHERE call_uuid = $1
+    `, uuid).Scan(&rows, &billsec, &recordID, &merged)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rows != 1 || billsec != 20 || !merged {
+        t.Errorf("rows=%d billsec=%d merged=%v, want 1 20 true", rows, billsec, merged)
+    }
+    if recordID == nil {
+        t.Error("recording_id lost on replace")
+    }
+
+    var action string
+    if err := pool.QueryRow(ctx, `
+        SELECT action FROM voip.cdr_duplicates WHERE call_uuid = $1
+    `, uuid).Scan(&action); err != nil {
+        t.Fatal(err)
+    }
+    if action != "replaced" {
+        t.Errorf("duplicate action %q, want replaced", action)
+    }
+
+    // Bản kém đầy đủ hơn gửi sau được giữ trong cdr_duplicates, không thay bản đang lưu.
+    id, err := InsertCDR(ctx, pool, cdrJSON(uuid, 5, nil), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if id != 0 {
+        t.Errorf("less complete duplicate returned id %d, want 0", id)
+    }
+}
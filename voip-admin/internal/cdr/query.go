@@ -0,0 +1,84 @@
+package cdr
+
+import (
+    "context"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/models"
+)
+
+// Querier là phần chung của *pgxpool.Pool và pgx.Tx dùng cho truy vấn một dòng.
+type Querier interface {
+    QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// Get đọc một CDR theo id.
+func Get(ctx context.Context, q Querier, id int64) (*models.CDR, error) {
+    var c models.CDR
+    err := q.QueryRow(ctx, `
+        SELECT id, call_uuid, direction, caller_id_number, destination_number,
+               start_time, answer_time, end_time, duration, billsec, hangup_cause,
+               queue_id, agent_user_id, trunk_id, recording_id,
+               domain_id, cost::text, currency, leg, originating_leg_uuid, created_at
+        FROM voip.cdr
+        WHERE id=$1
+    `, id).Scan(
+        &c.ID, &c.CallUUID, &c.Direction,
+        &c.CallerIDNumber, &c.DestinationNumber,
+        &c.StartTime, &c.AnswerTime, &c.EndTime,
+        &c.Duration, &c.BillSec, &c.HangupCause,
+        &c.QueueID, &c.AgentUserID, &c.TrunkID, &c.RecordingID,
+        &c.DomainID, &c.Cost, &c.Currency,
+        &c.Leg, &c.OriginatingLeg,
+        &c.CreatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &c, nil
+}
+
+// Duplicate là một lần nhận trùng CDR và quyết định merge tương ứng.
+type Duplicate struct {
+    ID                    int64     `json:"id"`
+    CallUUID              string    `json:"call_uuid"`
+    Leg                   string    `json:"leg"`
+    CDRID                 int64     `json:"cdr_id"`
+    Action                string    `json:"action"`
+    KeptCompleteness      int       `json:"kept_completeness"`
+    DiscardedCompleteness int       `json:"discarded_completeness"`
+    DiscardedRawJSON      *string   `json:"discarded_raw_json,omitempty"`
+    ReceivedAt            time.Time `json:"received_at"`
+}
+
+// ListDuplicates trả các lần nhận trùng gần nhất, tùy chọn theo call_uuid.
+// Payload bị loại chỉ được trả về khi lọc theo call_uuid.
+func ListDuplicates(ctx context.Context, pool *pgxpool.Pool, callUUID string, limit int) ([]Duplicate, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT id, call_uuid, leg, cdr_id, action, kept_completeness, discarded_completeness,
+               CASE WHEN $1::text <> '' THEN discarded_raw_json END, received_at
+        FROM voip.cdr_duplicates
+        WHERE $1::text = '' OR call_uuid = $1
+        ORDER BY id DESC
+        LIMIT $2
+    `, callUUID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Duplicate
+    for rows.Next() {
+        var d Duplicate
+        if err := rows.Scan(
+            &d.ID, &d.CallUUID, &d.Leg, &d.CDRID, &d.Action,
+            &d.KeptCompleteness, &d.DiscardedCompleteness, &d.DiscardedRawJSON, &d.ReceivedAt,
+        ); err != nil {
+            return nil, err
+        }
+        out = append(out, d)
+    }
+    return out, rows.Err()
+}
@@ -0,0 +1,151 @@
+package cdr
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Các toán tử tìm kiếm trên biến kênh trong raw_json.
+const (
+    OpEq       = "eq"
+    OpNe       = "ne"
+    OpPrefix   = "prefix"
+    OpContains = "contains"
+    OpIn       = "in"
+    OpExists   = "exists"
+)
+
+// variablesExpr là biểu thức object "variables" của raw_json. Index GIN (migration)
+// và điều kiện tìm kiếm phải dùng đúng biểu thức này để planner dùng được index.
+const variablesExpr = `(raw_json::jsonb -> 'variables')`
+
+// VarCondition là một điều kiện trên biến kênh. Name phải thuộc allow-list đã
+// kiểm tra trước khi gọi SQL vì được đưa thẳng vào câu lệnh (để khớp index).
+type VarCondition struct {
+    Name   string
+    Op     string
+    Values []string
+}
+
+// ParseVarCondition đọc điều kiện dạng "name:op:value" ("name:exists" với exists,
+// value phân tách bởi dấu phẩy với in) và kiểm tra name thuộc allowed.
+func ParseVarCondition(s string, allowed map[string]bool) (VarCondition, error) {
+    parts := strings.SplitN(s, ":", 3)
+    if len(parts) < 2 {
+        return VarCondition{}, fmt.Errorf("invalid var %q: expected name:op:value", s)
+    }
+    c := VarCondition{Name: parts[0], Op: parts[1]}
+    if !allowed[c.Name] {
+        return VarCondition{}, fmt.Errorf("variable %q is not searchable", c.Name)
+    }
+
+    switch c.Op {
+    case OpExists:
+        if len(parts) == 3 {
+            return VarCondition{}, fmt.Errorf("invalid var %q: exists takes no value", s)
+        }
+        return c, nil
+    case OpEq, OpNe, OpPrefix, OpContains, OpIn:
+    default:
+        return VarCondition{}, fmt.Errorf("invalid operator %q: expected eq, ne, prefix, contains, in or exists", c.Op)
+    }
+    if len(parts) < 3 || parts[2] == "" {
+        return VarCondition{}, fmt.Errorf("invalid var %q: value is required", s)
+    }
+    if c.Op == OpIn {
+        c.Values = strings.Split(parts[2], ",")
+    } else {
+        c.Values = []string{parts[2]}
+    }
+    return c, nil
+}
+
+// SQL trả điều kiện WHERE với "?" cho từng tham số theo thứ tự trong args.
+func (c VarCondition) SQL() (string, []interface{}) {
+    value := variableExpr(c.Name)
+    switch c.Op {
+    case OpEq:
+        // @> dùng index GIN chung cho mọi biến, kể cả biến chưa có index riêng.
+        return variablesExpr + " @> jsonb_build_object('" + c.Name + "', ?::text)", []interface{}{c.Values[0]}
+    case OpNe:
+        return value + " <> ?", []interface{}{c.Values[0]}
+    case OpPrefix:
+        return value + " LIKE ?", []interface{}{escapeLike(c.Values[0]) + "%"}
+    case OpContains:
+        return value + " ILIKE ?", []interface{}{"%" + escapeLike(c.Values[0]) + "%"}
+    case OpIn:
+        return value + " = ANY(?)", []interface{}{c.Values}
+    default:
+        // Toán tử jsonb "?" không có tham số nên không bị cdrFilter thay thành placeholder.
+        return variablesExpr + " ? '" + c.Name + "'", nil
+    }
+}
+
+// variableExpr là biểu thức giá trị text của một biến, cũng là biểu thức của index btree.
+func variableExpr(name string) string {
+    return "(" + variablesExpr + " ->> '" + name + "')"
+}
+
+func escapeLike(s string) string {
+    return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+const varIndexPrefix = "cdr_var_"
+
+// SyncVariableIndexes tạo index btree cho từng biến trong allow-list và xóa index
+// của biến không còn trong danh sách. Index tạo trên bảng partitioned nên áp dụng
+// cho mọi partition; việc tạo có thể lâu với bảng lớn.
+func SyncVariableIndexes(ctx context.Context, pool *pgxpool.Pool, variables []string) error {
+    rows, err := pool.Query(ctx, `
+        SELECT c.relname
+        FROM pg_index x
+        JOIN pg_class c ON c.oid = x.indexrelid
+        JOIN pg_class t ON t.oid = x.indrelid
+        JOIN pg_namespace n ON n.oid = t.relnamespace
+        WHERE n.nspname = 'voip' AND t.relname = 'cdr' AND c.relname LIKE $1
+    `, strings.ReplaceAll(varIndexPrefix, "_", `\_`)+"%")
+    if err != nil {
+        return err
+    }
+    existing := make(map[string]bool)
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            rows.Close()
+            return err
+        }
+        existing[name] = true
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    wanted := make(map[string]bool)
+    for _, v := range variables {
+        name := varIndexPrefix + v + "_idx"
+        wanted[name] = true
+        if existing[name] {
+            continue
+        }
+        // text_pattern_ops phục vụ cả "=" lẫn LIKE 'abc%'.
+        if _, err := pool.Exec(ctx, `CREATE INDEX IF NOT EXISTS `+name+` ON voip.cdr (`+variableExpr(v)+` text_pattern_ops)`); err != nil {
+            return fmt.Errorf("create index %s: %w", name, err)
+        }
+        log.Printf("cdr search: created index %s", name)
+    }
+    for name := range existing {
+        if wanted[name] {
+            continue
+        }
+        if _, err := pool.Exec(ctx, `DROP INDEX IF EXISTS voip.`+name); err != nil {
+            return fmt.Errorf("drop index %s: %w", name, err)
+        }
+        log.Printf("cdr search: dropped index %s", name)
+    }
+    return nil
+}
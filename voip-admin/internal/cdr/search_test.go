@@ -0,0 +1,46 @@
+package cdr
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestParseVarCondition(t *testing.T) {
+    allowed := map[string]bool{"sip_user_agent": true, "cc_queue": true}
+    tests := []struct {
+        in      string
+        want    VarCondition
+        wantErr bool
+    }{
+        {in: "sip_user_agent:eq:Yealink", want: VarCondition{Name: "sip_user_agent", Op: OpEq, Values: []string{"Yealink"}}},
+        {in: "sip_user_agent:ne:Zoiper", want: VarCondition{Name: "sip_user_agent", Op: OpNe, Values: []string{"Zoiper"}}},
+        {in: "cc_queue:prefix:sales", want: VarCondition{Name: "cc_queue", Op: OpPrefix, Values: []string{"sales"}}},
+        {in: "sip_user_agent:contains:T4", want: VarCondition{Name: "sip_user_agent", Op: OpContains, Values: []string{"T4"}}},
+        {in: "cc_queue:in:sales@bsv.local,support@bsv.local", want: VarCondition{Name: "cc_queue", Op: OpIn, Values: []string{"sales@bsv.local", "support@bsv.local"}}},
+        {in: "cc_queue:exists", want: VarCondition{Name: "cc_queue", Op: OpExists}},
+        // Giá trị được giữ nguyên kể cả khi chứa ":".
+        {in: "sip_user_agent:eq:a:b", want: VarCondition{Name: "sip_user_agent", Op: OpEq, Values: []string{"a:b"}}},
+        {in: "sip_user_agent", wantErr: true},
+        {in: "uuid:eq:x", wantErr: true},
+        {in: "cc_queue:like:x", wantErr: true},
+        {in: "cc_queue:exists:x", wantErr: true},
+        {in: "cc_queue:eq", wantErr: true},
+        {in: "cc_queue:eq:", wantErr: true},
+    }
+    for _, tt := range tests {
+        got, err := ParseVarCondition(tt.in, allowed)
+        if tt.wantErr {
+            if err == nil {
+                t.Errorf("ParseVarCondition(%q) = %+v, want error", tt.in, got)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("ParseVarCondition(%q): %v", tt.in, err)
+            continue
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("ParseVarCondition(%q) = %+v, want %+v", tt.in, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,84 @@
+package cdr
+
+import (
+    "bytes"
+    "encoding/json"
+    "encoding/xml"
+    "errors"
+    "io"
+    "net/url"
+)
+
+// ParseXML đọc document <cdr> của mod_xml_cdr và map phần <variables>
+// sang cùng cấu trúc FreeSwitchCDR như mod_json_cdr. Giá trị trả về thứ hai
+// là JSON chuẩn hóa {"variables": {...}} để lưu vào raw_json.
+func ParseXML(raw []byte) (*FreeSwitchCDR, []byte, error) {
+    vars, err := xmlVariables(raw)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    normalized, err := json.Marshal(map[string]interface{}{"variables": vars})
+    if err != nil {
+        return nil, nil, err
+    }
+
+    var fs FreeSwitchCDR
+    if err := json.Unmarshal(normalized, &fs); err != nil {
+        return nil, nil, err
+    }
+    return &fs, normalized, nil
+}
+
+// xmlVariables lấy các phần tử con trực tiếp của /cdr/variables.
+// mod_xml_cdr url-encode giá trị biến nên cần decode lại; dùng PathUnescape
+// vì một số giá trị để nguyên dấu '+' (số E.164) thay vì %2B.
+func xmlVariables(raw []byte) (map[string]string, error) {
+    dec := xml.NewDecoder(bytes.NewReader(raw))
+
+    var (
+        path  []string
+        vars  map[string]string
+        value bytes.Buffer
+    )
+
+    for {
+        tok, err := dec.Token()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        switch t := tok.(type) {
+        case xml.StartElement:
+            path = append(path, t.Name.Local)
+            if len(path) == 1 && t.Name.Local != "cdr" {
+                return nil, errors.New("xml cdr: root element must be <cdr>")
+            }
+            if len(path) == 2 && t.Name.Local == "variables" && vars == nil {
+                vars = make(map[string]string)
+            }
+            value.Reset()
+        case xml.CharData:
+            if len(path) == 3 && path[1] == "variables" {
+                value.Write(t)
+            }
+        case xml.EndElement:
+            if len(path) == 3 && path[1] == "variables" {
+                v := value.String()
+                if decoded, err := url.PathUnescape(v); err == nil {
+                    v = decoded
+                }
+                vars[path[2]] = v
+            }
+            path = path[:len(path)-1]
+        }
+    }
+
+    if vars == nil {
+        return nil, errors.New("xml cdr: missing <variables>")
+    }
+    return vars, nil
+}
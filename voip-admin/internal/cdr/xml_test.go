@@ -0,0 +1,72 @@
+package cdr
+
+import (
+    "encoding/json"
+    "testing"
+)
+
+func TestParseXML(t *testing.T) {
+    raw := []byte(`<?xml version="1.0"?>
+<cdr core-uuid="c1">
+  <variables>
+    <uuid>3f1c2a</uuid>
+    <direction>inbound</direction>
+    <caller_id_number>%2B84901234567</caller_id_number>
+    <destination_number>1001</destination_number>
+    <domain_name>bsv.local</domain_name>
+    <billsec>42</billsec>
+    <sip_user_agent>Yealink%20T46</sip_user_agent>
+    <sip_from_user>+84281234567</sip_from_user>
+  </variables>
+  <callflow>
+    <caller_profile>
+      <uuid>ignored</uuid>
+    </caller_profile>
+  </callflow>
+</cdr>`)
+
+    fs, normalized, err := ParseXML(raw)
+    if err != nil {
+        t.Fatal(err)
+    }
+    v := fs.Variables
+    if v.UUID != "3f1c2a" || v.Direction != "inbound" || v.CallerIDNumber != "+84901234567" ||
+        v.DestinationNumber != "1001" || v.DomainName != "bsv.local" || v.BillSec != "42" {
+        t.Errorf("variables = %+v", v)
+    }
+
+    var doc struct {
+        Variables map[string]string `json:"variables"`
+    }
+    if err := json.Unmarshal(normalized, &doc); err != nil {
+        t.Fatal(err)
+    }
+    if doc.Variables["sip_user_agent"] != "Yealink T46" {
+        t.Errorf("sip_user_agent = %q, want decoded value", doc.Variables["sip_user_agent"])
+    }
+    if doc.Variables["sip_from_user"] != "+84281234567" {
+        t.Errorf("sip_from_user = %q, want raw '+' kept", doc.Variables["sip_from_user"])
+    }
+    if len(doc.Variables) != 8 {
+        t.Errorf("normalized has %d variables, want 8 (callflow excluded)", len(doc.Variables))
+    }
+}
+
+func TestParseXMLInvalid(t *testing.T) {
+    tests := []struct {
+        name string
+        raw  string
+    }{
+        {"wrong root", `<call><variables><uuid>x</uuid></variables></call>`},
+        {"missing variables", `<cdr><callflow/></cdr>`},
+        {"malformed", `<cdr><variables><uuid>x</variables></cdr>`},
+        {"empty", ``},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, _, err := ParseXML([]byte(tt.raw)); err == nil {
+                t.Error("want error")
+            }
+        })
+    }
+}
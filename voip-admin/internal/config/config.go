@@ -3,8 +3,11 @@ package config
 import (
 	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -19,14 +22,281 @@ type RecordingConfig struct {
 	BasePath string `yaml:"base_path"`
 }
 
+type PrepaidConfig struct {
+	MaxCallSeconds int `yaml:"max_call_seconds"`
+}
+
+type StatsConfig struct {
+	RollupInterval time.Duration `yaml:"rollup_interval"`
+	RollupLookback time.Duration `yaml:"rollup_lookback"`
+}
+
+type WebhookConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"`
+	Timeout      time.Duration `yaml:"timeout"`
+	MaxAttempts  int           `yaml:"max_attempts"`
+	BaseBackoff  time.Duration `yaml:"base_backoff"`
+	MaxBackoff   time.Duration `yaml:"max_backoff"`
+}
+
+type PartitionConfig struct {
+	Interval      time.Duration `yaml:"interval"`
+	MonthsAhead   int           `yaml:"months_ahead"`
+	RetentionDays int           `yaml:"retention_days"`
+	ArchiveDir    string        `yaml:"archive_dir"`
+}
+
+type DeadLetterConfig struct {
+	AlertThreshold int64         `yaml:"alert_threshold"`
+	CheckInterval  time.Duration `yaml:"check_interval"`
+}
+
+type ReprocessConfig struct {
+	PollInterval   time.Duration `yaml:"poll_interval"`
+	BatchSize      int           `yaml:"batch_size"`
+	MaxDiffSamples int           `yaml:"max_diff_samples"`
+}
+
+// Các loại rule phát hiện gian lận cước.
+const (
+	FraudDestinationSpike = "destination_spike"
+	FraudUnusualHours     = "unusual_hours"
+	FraudConcurrentCalls  = "concurrent_calls"
+	FraudCostVelocity     = "cost_velocity"
+)
+
+type FraudRule struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Prefixes giới hạn rule theo đầu số đích (ví dụ "00" quốc tế, "1900" premium); rỗng là mọi số.
+	Prefixes  []string      `yaml:"prefixes"`
+	Window    time.Duration `yaml:"window"`
+	Threshold int           `yaml:"threshold"`
+	// MaxCost là ngưỡng tổng cước trong Window của rule cost_velocity.
+	MaxCost float64 `yaml:"max_cost"`
+	// HourFrom/HourTo là khung giờ bất thường [from, to) theo TZ, có thể qua nửa đêm (22 -> 6).
+	HourFrom int    `yaml:"hour_from"`
+	HourTo   int    `yaml:"hour_to"`
+	TZ       string `yaml:"tz"`
+	// Block khóa user (users.is_active = false) khi rule kích hoạt.
+	Block bool `yaml:"block"`
+}
+
+type FraudConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Cooldown là khoảng tối thiểu giữa hai cảnh báo cùng rule cho cùng account.
+	Cooldown time.Duration `yaml:"cooldown"`
+	Rules    []FraudRule   `yaml:"rules"`
+}
+
+type CDRSearchConfig struct {
+	// Variables là allow-list biến kênh trong raw_json được phép tìm kiếm;
+	// service tạo index cho từng biến và xóa index của biến bị bỏ khỏi danh sách.
+	Variables []string `yaml:"variables"`
+	// MaxRange giới hạn khoảng from/to của một lần tìm.
+	MaxRange time.Duration `yaml:"max_range"`
+}
+
+type ESLNode struct {
+	Name     string `yaml:"name"`
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+}
+
+// ESLConfig là cấu hình kết nối event socket (mod_event_socket) tới các node FreeSWITCH.
+type ESLConfig struct {
+	Nodes        []ESLNode     `yaml:"nodes"`
+	Events       []string      `yaml:"events"`
+	DialTimeout  time.Duration `yaml:"dial_timeout"`
+	ReconnectMin time.Duration `yaml:"reconnect_min"`
+	ReconnectMax time.Duration `yaml:"reconnect_max"`
+	// SofiaProfiles là các profile sofia đọc đăng ký (mặc định internal).
+	SofiaProfiles []string `yaml:"sofia_profiles"`
+}
+
+// KamailioConfig là cấu hình truy cập bảng của Kamailio (proxy biên) trên cùng cụm Postgres.
+type KamailioConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// DBDSN rỗng thì dùng chung kết nối db_dsn.
+	DBDSN string `yaml:"db_dsn"`
+	// Schema chứa các bảng Kamailio (location, subscriber, address, dispatcher).
+	Schema string `yaml:"schema"`
+	// SyncInterval là chu kỳ đồng bộ subscriber/domain/address; 0 dùng mặc định 5 phút.
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	// AddressGroup là grp của các dòng address do service quản lý (IP trunk).
+	AddressGroup int `yaml:"address_group"`
+	// StorePasswords ghi cả mật khẩu rõ vào subscriber.password; mặc định chỉ ghi ha1/ha1b.
+	StorePasswords bool             `yaml:"store_passwords"`
+	Dispatcher     DispatcherConfig `yaml:"dispatcher"`
+}
+
+// DispatcherConfig là cấu hình quản lý dispatcher của Kamailio và health check node FreeSWITCH.
+type DispatcherConfig struct {
+	// RPCURLs là endpoint JSON-RPC (jsonrpcs qua xhttp) của từng instance Kamailio.
+	RPCURLs    []string      `yaml:"rpc_urls"`
+	RPCTimeout time.Duration `yaml:"rpc_timeout"`
+	// HealthInterval là chu kỳ kiểm tra node qua event socket.
+	HealthInterval time.Duration `yaml:"health_interval"`
+	// FailThreshold là số lần kiểm tra lỗi liên tiếp trước khi đưa node ra khỏi vòng.
+	FailThreshold int `yaml:"fail_threshold"`
+	// RecoverThreshold là số lần kiểm tra thành công liên tiếp để đưa node trở lại.
+	RecoverThreshold int `yaml:"recover_threshold"`
+}
+
+// DataPolicy giới hạn dữ liệu cá nhân mà API key của một role được thấy.
+// Role không có policy nhận defaultDataPolicy (che số); role cần thấy đầy đủ
+// phải được khai báo, ví dụ "ops: {}".
+type DataPolicy struct {
+	// MaskNumbers che số gọi/bị gọi, chỉ giữ lại KeepDigits chữ số cuối.
+	MaskNumbers bool `yaml:"mask_numbers"`
+	KeepDigits  int  `yaml:"keep_digits"`
+	// HideRawJSON ẩn payload gốc của CDR (raw_json, payload dead letter, bản trùng).
+	HideRawJSON bool `yaml:"hide_raw_json"`
+	// DenyRecordings chặn tải file ghi âm.
+	DenyRecordings bool `yaml:"deny_recordings"`
+	// AllowErasure cho phép yêu cầu xóa dữ liệu theo số điện thoại.
+	AllowErasure bool `yaml:"allow_erasure"`
+}
+
+// Quyền của API key, gán cho role qua role_permissions.
+const (
+	PermCallHangup    = "call.hangup"
+	PermCallTransfer  = "call.transfer"
+	PermCallHold      = "call.hold"
+	PermCallEavesdrop = "call.eavesdrop"
+	PermCallWhisper   = "call.whisper"
+	PermCallBarge     = "call.barge"
+	// PermCallOriginate cho phép tạo cuộc gọi click-to-call.
+	PermCallOriginate = "call.originate"
+	// PermRatingRerate cho phép tính lại cước một khoảng thời gian.
+	PermRatingRerate = "rating.rerate"
+	// PermBalanceTopUp cho phép nạp tiền vào số dư prepaid.
+	PermBalanceTopUp = "balance.topup"
+	// PermCDRDeadLetters cho phép sửa, replay và bỏ dead letter.
+	PermCDRDeadLetters = "cdr.dead_letters"
+	// PermCDRRetention cho phép đặt và xóa retention policy.
+	PermCDRRetention = "cdr.retention"
+	// PermCDRReprocess cho phép tạo và hủy job tính lại CDR.
+	PermCDRReprocess = "cdr.reprocess"
+	// PermCallcenterManage cho phép đổi trạng thái và tier của agent.
+	PermCallcenterManage = "callcenter.manage"
+	// PermWebhookManage cho phép tạo và xóa webhook subscription.
+	PermWebhookManage = "webhook.manage"
+	// PermAuditRead cho phép đọc audit log.
+	PermAuditRead = "audit.read"
+	// PermDispatcherManage cho phép sửa dispatcher và drain node.
+	PermDispatcherManage = "dispatcher.manage"
+	// PermKamailioSync cho phép ghi đồng bộ subscriber/domain/address sang Kamailio.
+	PermKamailioSync = "kamailio.sync"
+	// PermFraudManage cho phép xác nhận cảnh báo fraud và mở khóa user bị chặn.
+	PermFraudManage = "fraud.manage"
+)
+
+var knownPermissions = map[string]bool{
+	PermCallHangup:    true,
+	PermCallTransfer:  true,
+	PermCallHold:      true,
+	PermCallEavesdrop: true,
+	PermCallWhisper:   true,
+	PermCallBarge:     true,
+	PermCallOriginate: true,
+
+	PermRatingRerate:     true,
+	PermBalanceTopUp:     true,
+	PermCDRDeadLetters:   true,
+	PermCDRRetention:     true,
+	PermCDRReprocess:     true,
+	PermCallcenterManage: true,
+	PermWebhookManage:    true,
+	PermAuditRead:        true,
+	PermDispatcherManage: true,
+	PermKamailioSync:     true,
+	PermFraudManage:      true,
+}
+
+// identifierPattern giới hạn tên schema dùng trực tiếp trong SQL.
+var identifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// profilePattern giới hạn tên profile sofia dùng trong lệnh FreeSWITCH.
+var profilePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// searchVariablePattern giới hạn tên biến để dùng an toàn trong SQL và tên index.
+var searchVariablePattern = regexp.MustCompile(`^[a-z0-9_]{1,48}$`)
+
 type Config struct {
-	ListenAddr       string          `yaml:"listen_addr"`
-	DBDSN            string          `yaml:"db_dsn"`
-	XMLCurlUser      string          `yaml:"xmlcurl_basic_user"`
-	XMLCurlPass      string          `yaml:"xmlcurl_basic_pass"`
-	CDRAuthorization string          `yaml:"cdr_auth_token"`
-	APIKeys          []APIKey        `yaml:"api_keys"`
-	Recordings       RecordingConfig `yaml:"recordings"`
+	ListenAddr       string           `yaml:"listen_addr"`
+	DBDSN            string           `yaml:"db_dsn"`
+	XMLCurlUser      string           `yaml:"xmlcurl_basic_user"`
+	XMLCurlPass      string           `yaml:"xmlcurl_basic_pass"`
+	CDRAuthorization string           `yaml:"cdr_auth_token"`
+	APIKeys          []APIKey         `yaml:"api_keys"`
+	Recordings       RecordingConfig  `yaml:"recordings"`
+	Prepaid          PrepaidConfig    `yaml:"prepaid"`
+	Stats            StatsConfig      `yaml:"stats"`
+	Webhooks         WebhookConfig    `yaml:"webhooks"`
+	CDRPartitions    PartitionConfig  `yaml:"cdr_partitions"`
+	DeadLetters      DeadLetterConfig `yaml:"dead_letters"`
+	Reprocess        ReprocessConfig  `yaml:"reprocess"`
+	Fraud            FraudConfig      `yaml:"fraud"`
+	CDRSearch        CDRSearchConfig  `yaml:"cdr_search"`
+	ESL              ESLConfig        `yaml:"esl"`
+	Kamailio         KamailioConfig   `yaml:"kamailio"`
+	// DataPolicies theo role của API key.
+	DataPolicies map[string]DataPolicy `yaml:"data_policies"`
+	// RolePermissions liệt kê quyền của từng role; "call.*" cấp mọi quyền call.,
+	// "*" cấp mọi quyền. Role không được liệt kê không có quyền nào.
+	RolePermissions map[string][]string `yaml:"role_permissions"`
+}
+
+// defaultDataPolicy áp dụng cho role không có trong data_policies.
+var defaultDataPolicy = DataPolicy{MaskNumbers: true, KeepDigits: 3, HideRawJSON: true, DenyRecordings: true}
+
+// DataPolicy trả policy của role; role không cấu hình nhận defaultDataPolicy.
+func (c *Config) DataPolicy(role string) DataPolicy {
+	if p, ok := c.DataPolicies[role]; ok {
+		return p
+	}
+	return defaultDataPolicy
+}
+
+// ConsumerPolicy trả policy theo tên API key (consumer của webhook subscription);
+// consumer không còn trong api_keys nhận defaultDataPolicy.
+func (c *Config) ConsumerPolicy(name string) DataPolicy {
+	for _, k := range c.APIKeys {
+		if k.Name == name {
+			return c.DataPolicy(k.Role)
+		}
+	}
+	return defaultDataPolicy
+}
+
+// Permitted cho biết role có quyền perm không.
+func (c *Config) Permitted(role, perm string) bool {
+	for _, p := range c.RolePermissions[role] {
+		if p == "*" || p == perm {
+			return true
+		}
+		if strings.HasSuffix(p, ".*") && strings.HasPrefix(perm, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func validPermission(p string) bool {
+	if p == "*" || knownPermissions[p] {
+		return true
+	}
+	if !strings.HasSuffix(p, ".*") {
+		return false
+	}
+	prefix := strings.TrimSuffix(p, "*")
+	for k := range knownPermissions {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 func Load(path string) (*Config, error) {
@@ -48,10 +318,104 @@ func Load(path string) (*Config, error) {
 	applyStringEnvOverride("VOIPADMIND_XMLCURL_BASIC_PASS", &cfg.XMLCurlPass)
 	applyStringEnvOverride("VOIPADMIND_CDR_AUTH_TOKEN", &cfg.CDRAuthorization)
 	applyStringEnvOverride("VOIPADMIND_RECORDINGS_BASE_PATH", &cfg.Recordings.BasePath)
+	applyStringEnvOverride("VOIPADMIND_CDR_ARCHIVE_DIR", &cfg.CDRPartitions.ArchiveDir)
 
 	if cfg.ListenAddr == "" {
 		cfg.ListenAddr = ":8080"
 	}
+	if cfg.Prepaid.MaxCallSeconds == 0 {
+		cfg.Prepaid.MaxCallSeconds = 4 * 3600
+	}
+	if cfg.Stats.RollupInterval == 0 {
+		cfg.Stats.RollupInterval = 5 * time.Minute
+	}
+	if cfg.Stats.RollupLookback == 0 {
+		cfg.Stats.RollupLookback = 2 * time.Hour
+	}
+	if cfg.Webhooks.PollInterval == 0 {
+		cfg.Webhooks.PollInterval = 5 * time.Second
+	}
+	if cfg.Webhooks.Timeout == 0 {
+		cfg.Webhooks.Timeout = 10 * time.Second
+	}
+	if cfg.Webhooks.MaxAttempts == 0 {
+		cfg.Webhooks.MaxAttempts = 10
+	}
+	if cfg.Webhooks.BaseBackoff == 0 {
+		cfg.Webhooks.BaseBackoff = 30 * time.Second
+	}
+	if cfg.Webhooks.MaxBackoff == 0 {
+		cfg.Webhooks.MaxBackoff = time.Hour
+	}
+	if cfg.CDRPartitions.Interval == 0 {
+		cfg.CDRPartitions.Interval = time.Hour
+	}
+	if cfg.CDRPartitions.MonthsAhead == 0 {
+		cfg.CDRPartitions.MonthsAhead = 2
+	}
+	if cfg.DeadLetters.AlertThreshold == 0 {
+		cfg.DeadLetters.AlertThreshold = 100
+	}
+	if cfg.DeadLetters.CheckInterval == 0 {
+		cfg.DeadLetters.CheckInterval = time.Minute
+	}
+	if cfg.Reprocess.PollInterval == 0 {
+		cfg.Reprocess.PollInterval = 10 * time.Second
+	}
+	if cfg.Reprocess.BatchSize == 0 {
+		cfg.Reprocess.BatchSize = 500
+	}
+	if cfg.Reprocess.MaxDiffSamples == 0 {
+		cfg.Reprocess.MaxDiffSamples = 1000
+	}
+	if cfg.Fraud.Cooldown == 0 {
+		cfg.Fraud.Cooldown = time.Hour
+	}
+	if cfg.CDRSearch.Variables == nil {
+		cfg.CDRSearch.Variables = []string{"sip_call_id", "sip_user_agent", "sip_from_user", "sip_to_user", "sip_network_ip"}
+	}
+	if cfg.CDRSearch.MaxRange == 0 {
+		cfg.CDRSearch.MaxRange = 31 * 24 * time.Hour
+	}
+	if cfg.ESL.Events == nil {
+		cfg.ESL.Events = []string{
+			"CHANNEL_CREATE", "CHANNEL_ANSWER", "CHANNEL_BRIDGE", "CHANNEL_UNBRIDGE",
+			"CHANNEL_HOLD", "CHANNEL_UNHOLD", "CHANNEL_CALLSTATE", "CHANNEL_HANGUP_COMPLETE",
+		}
+	}
+	if cfg.ESL.DialTimeout == 0 {
+		cfg.ESL.DialTimeout = 5 * time.Second
+	}
+	if cfg.ESL.ReconnectMin == 0 {
+		cfg.ESL.ReconnectMin = time.Second
+	}
+	if cfg.ESL.ReconnectMax == 0 {
+		cfg.ESL.ReconnectMax = 30 * time.Second
+	}
+	if cfg.ESL.SofiaProfiles == nil {
+		cfg.ESL.SofiaProfiles = []string{"internal"}
+	}
+	if cfg.Kamailio.Schema == "" {
+		cfg.Kamailio.Schema = "kamailio"
+	}
+	if cfg.Kamailio.SyncInterval == 0 {
+		cfg.Kamailio.SyncInterval = 5 * time.Minute
+	}
+	if cfg.Kamailio.AddressGroup == 0 {
+		cfg.Kamailio.AddressGroup = 1
+	}
+	if cfg.Kamailio.Dispatcher.RPCTimeout == 0 {
+		cfg.Kamailio.Dispatcher.RPCTimeout = 5 * time.Second
+	}
+	if cfg.Kamailio.Dispatcher.HealthInterval == 0 {
+		cfg.Kamailio.Dispatcher.HealthInterval = 10 * time.Second
+	}
+	if cfg.Kamailio.Dispatcher.FailThreshold == 0 {
+		cfg.Kamailio.Dispatcher.FailThreshold = 3
+	}
+	if cfg.Kamailio.Dispatcher.RecoverThreshold == 0 {
+		cfg.Kamailio.Dispatcher.RecoverThreshold = 2
+	}
 
 	if err := cfg.Validate(); err != nil {
 		slog.Warn("invalid configuration", "error", err)
@@ -85,6 +449,91 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("config validation failed: missing %s", strings.Join(missing, ", "))
 	}
 
+	nodes := make(map[string]bool, len(c.ESL.Nodes))
+	for i, n := range c.ESL.Nodes {
+		if n.Name == "" || n.Addr == "" {
+			return fmt.Errorf("config validation failed: esl.nodes[%d]: name and addr are required", i)
+		}
+		if nodes[n.Name] {
+			return fmt.Errorf("config validation failed: esl.nodes: duplicate name %q", n.Name)
+		}
+		nodes[n.Name] = true
+	}
+
+	for _, p := range c.ESL.SofiaProfiles {
+		if !profilePattern.MatchString(p) {
+			return fmt.Errorf("config validation failed: esl.sofia_profiles: invalid profile %q", p)
+		}
+	}
+	if !identifierPattern.MatchString(c.Kamailio.Schema) {
+		return fmt.Errorf("config validation failed: kamailio.schema: invalid name %q", c.Kamailio.Schema)
+	}
+	for _, s := range c.Kamailio.Dispatcher.RPCURLs {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("config validation failed: kamailio.dispatcher.rpc_urls: invalid url %q", s)
+		}
+	}
+
+	for role, p := range c.DataPolicies {
+		if p.KeepDigits < 0 {
+			return fmt.Errorf("config validation failed: data_policies.%s.keep_digits must not be negative", role)
+		}
+	}
+
+	for role, perms := range c.RolePermissions {
+		for _, p := range perms {
+			if !validPermission(p) {
+				return fmt.Errorf("config validation failed: role_permissions.%s: unknown permission %q", role, p)
+			}
+		}
+	}
+
+	for _, v := range c.CDRSearch.Variables {
+		if !searchVariablePattern.MatchString(v) {
+			return fmt.Errorf("config validation failed: cdr_search.variables: invalid name %q (allowed: a-z, 0-9, _)", v)
+		}
+	}
+
+	for i, r := range c.Fraud.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("config validation failed: fraud.rules[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (r FraudRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch r.Type {
+	case FraudDestinationSpike, FraudUnusualHours:
+		if r.Window <= 0 || r.Threshold <= 0 {
+			return fmt.Errorf("%s: window and threshold must be positive", r.Name)
+		}
+	case FraudConcurrentCalls:
+		if r.Threshold <= 0 {
+			return fmt.Errorf("%s: threshold must be positive", r.Name)
+		}
+	case FraudCostVelocity:
+		if r.Window <= 0 || r.MaxCost <= 0 {
+			return fmt.Errorf("%s: window and max_cost must be positive", r.Name)
+		}
+	default:
+		return fmt.Errorf("%s: unknown type %q", r.Name, r.Type)
+	}
+	if r.Type == FraudUnusualHours {
+		if r.HourFrom < 0 || r.HourFrom > 23 || r.HourTo < 0 || r.HourTo > 23 || r.HourFrom == r.HourTo {
+			return fmt.Errorf("%s: hour_from/hour_to must be distinct hours 0-23", r.Name)
+		}
+		if r.TZ != "" {
+			if _, err := time.LoadLocation(r.TZ); err != nil {
+				return fmt.Errorf("%s: invalid tz: %w", r.Name, err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,733 @@
+package db
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migration là một bước thay đổi schema do service tự quản lý.
+// Các bước chạy theo thứ tự Version và chỉ chạy một lần.
+type Migration struct {
+    Version int
+    Name    string
+    SQL     string
+}
+
+var migrations = []Migration{
+    {
+        Version: 1,
+        Name:    "cdr_raw_xml",
+        SQL: `
+            ALTER TABLE voip.cdr ADD COLUMN IF NOT EXISTS raw_xml TEXT;
+        `,
+    },
+    {
+        Version: 2,
+        Name:    "rating",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.tariffs (
+                id                BIGSERIAL PRIMARY KEY,
+                domain_id         BIGINT NOT NULL UNIQUE REFERENCES voip.domains(id),
+                name              TEXT NOT NULL,
+                currency          TEXT NOT NULL DEFAULT 'VND',
+                connection_fee    NUMERIC(14,6) NOT NULL DEFAULT 0,
+                initial_increment INT NOT NULL DEFAULT 60,
+                increment         INT NOT NULL DEFAULT 60,
+                free_seconds      INT NOT NULL DEFAULT 0,
+                created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+                updated_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE TABLE IF NOT EXISTS voip.tariff_rates (
+                id                BIGSERIAL PRIMARY KEY,
+                tariff_id         BIGINT NOT NULL REFERENCES voip.tariffs(id) ON DELETE CASCADE,
+                prefix            TEXT NOT NULL,
+                description       TEXT,
+                rate_per_minute   NUMERIC(14,6) NOT NULL,
+                connection_fee    NUMERIC(14,6),
+                initial_increment INT,
+                increment         INT,
+                bundle_eligible   BOOLEAN NOT NULL DEFAULT TRUE,
+                UNIQUE (tariff_id, prefix)
+            );
+
+            CREATE TABLE IF NOT EXISTS voip.bundle_usage (
+                domain_id    BIGINT NOT NULL REFERENCES voip.domains(id),
+                period       DATE NOT NULL,
+                used_seconds BIGINT NOT NULL DEFAULT 0,
+                PRIMARY KEY (domain_id, period)
+            );
+
+            ALTER TABLE voip.cdr
+                ADD COLUMN IF NOT EXISTS domain_id     BIGINT,
+                ADD COLUMN IF NOT EXISTS cost          NUMERIC(14,6),
+                ADD COLUMN IF NOT EXISTS currency      TEXT,
+                ADD COLUMN IF NOT EXISTS rated_seconds INT,
+                ADD COLUMN IF NOT EXISTS free_seconds  INT NOT NULL DEFAULT 0,
+                ADD COLUMN IF NOT EXISTS rated_at      TIMESTAMPTZ;
+
+            CREATE INDEX IF NOT EXISTS cdr_domain_start_idx ON voip.cdr (domain_id, start_time);
+        `,
+    },
+    {
+        Version: 3,
+        Name:    "prepaid_balances",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.account_balances (
+                domain_id  BIGINT PRIMARY KEY REFERENCES voip.domains(id),
+                prepaid    BOOLEAN NOT NULL DEFAULT TRUE,
+                balance    NUMERIC(14,6) NOT NULL DEFAULT 0,
+                currency   TEXT NOT NULL DEFAULT 'VND',
+                updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE TABLE IF NOT EXISTS voip.balance_transactions (
+                id            BIGSERIAL PRIMARY KEY,
+                domain_id     BIGINT NOT NULL REFERENCES voip.domains(id),
+                cdr_id        BIGINT,
+                amount        NUMERIC(14,6) NOT NULL,
+                balance_after NUMERIC(14,6) NOT NULL,
+                reason        TEXT NOT NULL,
+                created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE INDEX IF NOT EXISTS balance_transactions_domain_idx
+                ON voip.balance_transactions (domain_id, created_at);
+        `,
+    },
+    {
+        Version: 4,
+        Name:    "cdr_keyset_index",
+        SQL: `
+            CREATE INDEX IF NOT EXISTS cdr_start_time_id_idx ON voip.cdr (start_time, id);
+        `,
+    },
+    {
+        Version: 5,
+        Name:    "cdr_rollups",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.cdr_rollup_hourly (
+                bucket       TIMESTAMPTZ NOT NULL,
+                domain_id    BIGINT NOT NULL DEFAULT 0,
+                trunk_id     BIGINT NOT NULL DEFAULT 0,
+                direction    TEXT NOT NULL DEFAULT '',
+                hangup_cause TEXT NOT NULL DEFAULT '',
+                calls        BIGINT NOT NULL,
+                answered     BIGINT NOT NULL,
+                duration_sum BIGINT NOT NULL,
+                billsec_sum  BIGINT NOT NULL,
+                PRIMARY KEY (bucket, domain_id, trunk_id, direction, hangup_cause)
+            );
+
+            CREATE TABLE IF NOT EXISTS voip.cdr_concurrency_hourly (
+                bucket TIMESTAMPTZ PRIMARY KEY,
+                peak   INT NOT NULL
+            );
+
+            CREATE TABLE IF NOT EXISTS voip.cdr_rollup_state (
+                id           INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+                rolled_until TIMESTAMPTZ NOT NULL
+            );
+        `,
+    },
+    {
+        Version: 6,
+        Name:    "cdr_callcenter",
+        SQL: `
+            ALTER TABLE voip.cdr
+                ADD COLUMN IF NOT EXISTS cc_queue               TEXT,
+                ADD COLUMN IF NOT EXISTS cc_side                TEXT,
+                ADD COLUMN IF NOT EXISTS cc_agent               TEXT,
+                ADD COLUMN IF NOT EXISTS cc_cause               TEXT,
+                ADD COLUMN IF NOT EXISTS cc_cancel_reason       TEXT,
+                ADD COLUMN IF NOT EXISTS cc_queue_joined_at     TIMESTAMPTZ,
+                ADD COLUMN IF NOT EXISTS cc_queue_answered_at   TIMESTAMPTZ,
+                ADD COLUMN IF NOT EXISTS cc_queue_terminated_at TIMESTAMPTZ,
+                ADD COLUMN IF NOT EXISTS cc_queue_canceled_at   TIMESTAMPTZ;
+
+            CREATE INDEX IF NOT EXISTS cdr_cc_queue_joined_idx
+                ON voip.cdr (cc_queue, cc_queue_joined_at)
+                WHERE cc_queue IS NOT NULL;
+        `,
+    },
+    {
+        Version: 7,
+        Name:    "callcenter_agent_events",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.cc_agent_events (
+                id          BIGSERIAL PRIMARY KEY,
+                agent       TEXT NOT NULL,
+                event       TEXT NOT NULL,
+                queue       TEXT,
+                status      TEXT,
+                state       TEXT,
+                member_uuid TEXT,
+                node        TEXT,
+                occurred_at TIMESTAMPTZ NOT NULL,
+                received_at TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE INDEX IF NOT EXISTS cc_agent_events_agent_idx
+                ON voip.cc_agent_events (agent, occurred_at);
+            CREATE INDEX IF NOT EXISTS cc_agent_events_event_idx
+                ON voip.cc_agent_events (event, occurred_at);
+            CREATE INDEX IF NOT EXISTS cdr_agent_user_idx
+                ON voip.cdr (agent_user_id, cc_queue_answered_at)
+                WHERE agent_user_id IS NOT NULL;
+        `,
+    },
+    {
+        Version: 8,
+        Name:    "webhooks",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.webhook_subscriptions (
+                id         BIGSERIAL PRIMARY KEY,
+                consumer   TEXT NOT NULL,
+                url        TEXT NOT NULL,
+                secret     TEXT NOT NULL,
+                events     TEXT[] NOT NULL,
+                is_active  BOOLEAN NOT NULL DEFAULT TRUE,
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE TABLE IF NOT EXISTS voip.webhook_outbox (
+                id              BIGSERIAL PRIMARY KEY,
+                subscription_id BIGINT NOT NULL REFERENCES voip.webhook_subscriptions(id) ON DELETE CASCADE,
+                event           TEXT NOT NULL,
+                payload         JSONB NOT NULL,
+                status          TEXT NOT NULL DEFAULT 'pending',
+                attempts        INT NOT NULL DEFAULT 0,
+                next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                last_error      TEXT,
+                created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+                delivered_at    TIMESTAMPTZ
+            );
+
+            CREATE INDEX IF NOT EXISTS webhook_outbox_pending_idx
+                ON voip.webhook_outbox (next_attempt_at)
+                WHERE status = 'pending';
+
+            CREATE TABLE IF NOT EXISTS voip.webhook_deliveries (
+                id              BIGSERIAL PRIMARY KEY,
+                outbox_id       BIGINT NOT NULL REFERENCES voip.webhook_outbox(id) ON DELETE CASCADE,
+                subscription_id BIGINT NOT NULL REFERENCES voip.webhook_subscriptions(id) ON DELETE CASCADE,
+                attempt         INT NOT NULL,
+                status_code     INT,
+                error           TEXT,
+                duration_ms     INT NOT NULL,
+                attempted_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_idx
+                ON voip.webhook_deliveries (subscription_id, attempted_at);
+        `,
+    },
+    {
+        // Chuyển voip.cdr sang bảng partition theo tháng (UTC) của start_time.
+        // Bảng cũ được giữ nguyên dữ liệu và gắn vào làm partition cdr_legacy
+        // cho mọi CDR trước tháng kế tiếp; partition tháng mới do partition.Manager tạo.
+        // Khóa chính đổi thành (id, start_time), khóa chống trùng thành (call_uuid, start_time)
+        // vì unique index trên bảng partition phải chứa cột partition.
+        // Migration giữ ACCESS EXCLUSIVE lock trên voip.cdr trong lúc quét toàn bảng
+        // (SET NOT NULL, build unique index, kiểm tra ràng buộc khi attach): với bảng
+        // lớn phải chạy "voipadmind -migrate-only" trong cửa sổ bảo trì.
+        Version: 9,
+        Name:    "cdr_partitioning",
+        SQL: `
+            DO $$
+            DECLARE
+                bound TIMESTAMPTZ := date_trunc('month', now() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' + interval '1 month';
+                ident BOOLEAN;
+                seq   TEXT;
+                idx   RECORD;
+                fk    RECORD;
+            BEGIN
+                IF EXISTS (
+                    SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'voip.cdr'::regclass
+                ) THEN
+                    RETURN;
+                END IF;
+
+                ALTER TABLE voip.cdr RENAME TO cdr_legacy;
+                UPDATE voip.cdr_legacy SET start_time = COALESCE(created_at, now()) WHERE start_time IS NULL;
+                ALTER TABLE voip.cdr_legacy ALTER COLUMN start_time SET NOT NULL;
+
+                CREATE TABLE voip.cdr (
+                    LIKE voip.cdr_legacy INCLUDING DEFAULTS INCLUDING CONSTRAINTS INCLUDING IDENTITY
+                        INCLUDING GENERATED INCLUDING STORAGE INCLUDING COMMENTS,
+                    PRIMARY KEY (id, start_time),
+                    UNIQUE (call_uuid, start_time)
+                ) PARTITION BY RANGE (start_time);
+
+                -- Giữ dãy id liên tục: sequence của cột serial chuyển sang bảng mới,
+                -- cột identity thì đặt lại giá trị tiếp theo.
+                SELECT attidentity <> '' INTO ident
+                FROM pg_attribute
+                WHERE attrelid = 'voip.cdr_legacy'::regclass AND attname = 'id';
+                IF ident THEN
+                    PERFORM setval(pg_get_serial_sequence('voip.cdr', 'id'),
+                                   (SELECT COALESCE(MAX(id), 0) + 1 FROM voip.cdr_legacy), false);
+                    ALTER TABLE voip.cdr_legacy ALTER COLUMN id DROP IDENTITY;
+                ELSE
+                    seq := pg_get_serial_sequence('voip.cdr_legacy', 'id');
+                    IF seq IS NOT NULL THEN
+                        EXECUTE format('ALTER SEQUENCE %s OWNED BY voip.cdr.id', seq);
+                    END IF;
+                END IF;
+
+                -- Index thường được tạo lại trên bảng cha với tên cũ; khi attach,
+                -- index tương đương của cdr_legacy được dùng lại thay vì build mới.
+                FOR idx IN
+                    SELECT i.relname AS name, pg_get_indexdef(i.oid) AS def
+                    FROM pg_index x
+                    JOIN pg_class i ON i.oid = x.indexrelid
+                    WHERE x.indrelid = 'voip.cdr_legacy'::regclass AND NOT x.indisunique
+                LOOP
+                    EXECUTE format('ALTER INDEX voip.%I RENAME TO %I', idx.name, idx.name || '_legacy');
+                    EXECUTE replace(idx.def, ' ON voip.cdr_legacy ', ' ON voip.cdr ');
+                END LOOP;
+
+                FOR fk IN
+                    SELECT conname, pg_get_constraintdef(oid) AS def
+                    FROM pg_constraint
+                    WHERE conrelid = 'voip.cdr_legacy'::regclass AND contype = 'f'
+                LOOP
+                    EXECUTE format('ALTER TABLE voip.cdr ADD CONSTRAINT %I %s', fk.conname, fk.def);
+                END LOOP;
+
+                EXECUTE format(
+                    'ALTER TABLE voip.cdr ATTACH PARTITION voip.cdr_legacy FOR VALUES FROM (MINVALUE) TO (%L)',
+                    bound
+                );
+                -- Partition mặc định nhận CDR nằm ngoài các partition đã tạo (ví dụ start_time sai).
+                CREATE TABLE voip.cdr_default PARTITION OF voip.cdr DEFAULT;
+            END
+            $$;
+
+            CREATE TABLE IF NOT EXISTS voip.cdr_retention_policies (
+                domain_id      BIGINT PRIMARY KEY REFERENCES voip.domains(id) ON DELETE CASCADE,
+                retention_days INT NOT NULL CHECK (retention_days > 0),
+                updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+        `,
+    },
+    {
+        Version: 10,
+        Name:    "cdr_dead_letters",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.cdr_dead_letters (
+                id          BIGSERIAL PRIMARY KEY,
+                format      TEXT NOT NULL,
+                payload     TEXT NOT NULL,
+                stage       TEXT NOT NULL,
+                error       TEXT NOT NULL,
+                status      TEXT NOT NULL DEFAULT 'pending',
+                attempts    INT NOT NULL DEFAULT 0,
+                cdr_id      BIGINT,
+                received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE INDEX IF NOT EXISTS cdr_dead_letters_pending_idx
+                ON voip.cdr_dead_letters (id)
+                WHERE status = 'pending';
+        `,
+    },
+    {
+        Version: 11,
+        Name:    "cdr_reprocess_jobs",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.cdr_reprocess_jobs (
+                id                BIGSERIAL PRIMARY KEY,
+                from_time         TIMESTAMPTZ NOT NULL,
+                to_time           TIMESTAMPTZ NOT NULL,
+                dry_run           BOOLEAN NOT NULL,
+                status            TEXT NOT NULL DEFAULT 'pending',
+                cursor_start_time TIMESTAMPTZ,
+                cursor_id         BIGINT,
+                scanned           BIGINT NOT NULL DEFAULT 0,
+                changed           BIGINT NOT NULL DEFAULT 0,
+                failed            BIGINT NOT NULL DEFAULT 0,
+                field_changes     JSONB NOT NULL DEFAULT '{}',
+                diff_samples      INT NOT NULL DEFAULT 0,
+                last_error        TEXT,
+                created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+                updated_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+                finished_at       TIMESTAMPTZ
+            );
+
+            CREATE TABLE IF NOT EXISTS voip.cdr_reprocess_diffs (
+                job_id     BIGINT NOT NULL REFERENCES voip.cdr_reprocess_jobs(id) ON DELETE CASCADE,
+                cdr_id     BIGINT NOT NULL,
+                start_time TIMESTAMPTZ NOT NULL,
+                changes    JSONB NOT NULL,
+                PRIMARY KEY (job_id, cdr_id, start_time)
+            );
+        `,
+    },
+    {
+        // Mỗi leg của cuộc gọi là một dòng; bản gửi trùng được merge theo
+        // completeness (xem cdr.insertCDR) thay vì bị bỏ qua.
+        Version: 12,
+        Name:    "cdr_legs_and_duplicates",
+        SQL: `
+            ALTER TABLE voip.cdr
+                ADD COLUMN IF NOT EXISTS leg                  CHAR(1) NOT NULL DEFAULT 'A',
+                ADD COLUMN IF NOT EXISTS originating_leg_uuid TEXT,
+                ADD COLUMN IF NOT EXISTS completeness         INT NOT NULL DEFAULT 0,
+                ADD COLUMN IF NOT EXISTS merged_at            TIMESTAMPTZ;
+
+            DO $$
+            DECLARE
+                con RECORD;
+                idx RECORD;
+            BEGIN
+                -- Bỏ các ràng buộc/index unique chỉ theo call_uuid (bảng cha và cdr_legacy)
+                -- để A-leg/B-leg cùng call_uuid cùng tồn tại.
+                FOR con IN
+                    SELECT cl.relname, c.conname
+                    FROM pg_constraint c
+                    JOIN pg_class cl ON cl.oid = c.conrelid
+                    JOIN pg_namespace n ON n.oid = cl.relnamespace
+                    WHERE n.nspname = 'voip' AND cl.relname IN ('cdr', 'cdr_legacy')
+                      AND c.contype = 'u'
+                      AND c.conparentid = 0
+                      AND (
+                          SELECT array_agg(a.attname::text ORDER BY a.attname)
+                          FROM pg_attribute a
+                          WHERE a.attrelid = c.conrelid AND a.attnum = ANY(c.conkey)
+                      ) IN (ARRAY['call_uuid'], ARRAY['call_uuid', 'start_time'])
+                LOOP
+                    EXECUTE format('ALTER TABLE voip.%I DROP CONSTRAINT %I', con.relname, con.conname);
+                END LOOP;
+
+                FOR idx IN
+                    SELECT i.relname
+                    FROM pg_index x
+                    JOIN pg_class i ON i.oid = x.indexrelid
+                    JOIN pg_class cl ON cl.oid = x.indrelid
+                    JOIN pg_namespace n ON n.oid = cl.relnamespace
+                    JOIN pg_attribute a ON a.attrelid = x.indrelid AND a.attnum = x.indkey[0]
+                    WHERE n.nspname = 'voip' AND cl.relname = 'cdr_legacy'
+                      AND x.indisunique AND x.indnatts = 1 AND a.attname = 'call_uuid'
+                      AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = x.indexrelid)
+                LOOP
+                    EXECUTE format('DROP INDEX voip.%I', idx.relname);
+                END LOOP;
+            END
+            $$;
+
+            CREATE UNIQUE INDEX IF NOT EXISTS cdr_call_uuid_leg_start_idx
+                ON voip.cdr (call_uuid, leg, start_time);
+
+            CREATE TABLE IF NOT EXISTS voip.cdr_duplicates (
+                id                     BIGSERIAL PRIMARY KEY,
+                call_uuid              TEXT NOT NULL,
+                leg                    CHAR(1) NOT NULL,
+                cdr_id                 BIGINT NOT NULL,
+                action                 TEXT NOT NULL,
+                kept_completeness      INT NOT NULL,
+                discarded_completeness INT NOT NULL,
+                discarded_raw_json     TEXT,
+                received_at            TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE INDEX IF NOT EXISTS cdr_duplicates_call_uuid_idx
+                ON voip.cdr_duplicates (call_uuid);
+        `,
+    },
+    {
+        Version: 13,
+        Name:    "fraud_alerts",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.fraud_alerts (
+                id              BIGSERIAL PRIMARY KEY,
+                rule            TEXT NOT NULL,
+                rule_type       TEXT NOT NULL,
+                domain_id       BIGINT,
+                user_id         BIGINT,
+                caller          TEXT NOT NULL,
+                cdr_id          BIGINT NOT NULL,
+                value           NUMERIC(14,6) NOT NULL,
+                threshold       NUMERIC(14,6) NOT NULL,
+                blocked         BOOLEAN NOT NULL DEFAULT FALSE,
+                created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+                acknowledged_at TIMESTAMPTZ
+            );
+
+            CREATE INDEX IF NOT EXISTS fraud_alerts_account_idx
+                ON voip.fraud_alerts (rule, domain_id, caller, created_at DESC);
+            CREATE INDEX IF NOT EXISTS cdr_caller_start_idx
+                ON voip.cdr (domain_id, caller_id_number, start_time);
+        `,
+    },
+    {
+        Version: 14,
+        Name:    "cdr_variables_gin",
+        // Biểu thức phải trùng với cdr.variablesExpr.
+        SQL: `
+            CREATE INDEX IF NOT EXISTS cdr_variables_gin_idx
+                ON voip.cdr USING GIN ((raw_json::jsonb -> 'variables'));
+        `,
+    },
+    {
+        Version: 15,
+        Name:    "audit_log",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.audit_log (
+                id         BIGSERIAL PRIMARY KEY,
+                actor      TEXT NOT NULL,
+                action     TEXT NOT NULL,
+                target     TEXT NOT NULL,
+                details    JSONB,
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE INDEX IF NOT EXISTS audit_log_action_idx ON voip.audit_log (action, id);
+        `,
+    },
+    {
+        Version: 16,
+        Name:    "originate",
+        SQL: `
+            -- Class of service: prefix đích được phép/bị cấm; allow_prefixes rỗng là mọi số.
+            CREATE TABLE IF NOT EXISTS voip.classes_of_service (
+                id               BIGSERIAL PRIMARY KEY,
+                domain_id        BIGINT NOT NULL REFERENCES voip.domains(id) ON DELETE CASCADE,
+                name             TEXT NOT NULL,
+                allow_prefixes   TEXT[] NOT NULL DEFAULT '{}',
+                deny_prefixes    TEXT[] NOT NULL DEFAULT '{}',
+                max_call_seconds INT NOT NULL DEFAULT 0 CHECK (max_call_seconds >= 0),
+                UNIQUE (domain_id, name)
+            );
+
+            ALTER TABLE voip.users
+                ADD COLUMN IF NOT EXISTS caller_id_number TEXT,
+                ADD COLUMN IF NOT EXISTS caller_id_name   TEXT,
+                ADD COLUMN IF NOT EXISTS cos_id           BIGINT REFERENCES voip.classes_of_service(id) ON DELETE SET NULL;
+
+            CREATE TABLE IF NOT EXISTS voip.originate_requests (
+                id               BIGSERIAL PRIMARY KEY,
+                call_uuid        TEXT NOT NULL UNIQUE,
+                node             TEXT NOT NULL,
+                user_id          BIGINT NOT NULL,
+                extension        TEXT NOT NULL,
+                domain           TEXT NOT NULL,
+                destination      TEXT NOT NULL,
+                caller_id_number TEXT,
+                status           TEXT NOT NULL,
+                hangup_cause     TEXT,
+                error            TEXT,
+                requested_by     TEXT NOT NULL,
+                created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+                updated_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE INDEX IF NOT EXISTS originate_requests_open_idx
+                ON voip.originate_requests (status) WHERE status IN ('originating', 'agent_answered', 'bridged');
+        `,
+    },
+    {
+        Version: 17,
+        Name:    "callcenter_agent_state",
+        SQL: `
+            -- Trạng thái agent/tier mong muốn của mod_callcenter; được áp lại lên
+            -- node FreeSWITCH mỗi khi node kết nối (lại).
+            CREATE TABLE IF NOT EXISTS voip.cc_agents (
+                name       TEXT PRIMARY KEY,
+                user_id    BIGINT REFERENCES voip.users(id) ON DELETE CASCADE,
+                contact    TEXT NOT NULL,
+                status     TEXT NOT NULL DEFAULT 'Logged Out',
+                updated_by TEXT,
+                updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            CREATE TABLE IF NOT EXISTS voip.cc_tiers (
+                agent      TEXT NOT NULL REFERENCES voip.cc_agents(name) ON DELETE CASCADE,
+                queue      TEXT NOT NULL,
+                level      INT NOT NULL DEFAULT 1 CHECK (level >= 0),
+                position   INT NOT NULL DEFAULT 1 CHECK (position >= 0),
+                updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                PRIMARY KEY (agent, queue)
+            );
+        `,
+    },
+    {
+        Version: 18,
+        Name:    "trunk_addresses",
+        SQL: `
+            CREATE TABLE IF NOT EXISTS voip.trunks (
+                id         BIGSERIAL PRIMARY KEY,
+                name       TEXT NOT NULL UNIQUE,
+                is_active  BOOLEAN NOT NULL DEFAULT TRUE,
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+
+            -- Địa chỉ IP/subnet của trunk, được đồng bộ vào bảng address của Kamailio.
+            CREATE TABLE IF NOT EXISTS voip.trunk_addresses (
+                id       BIGSERIAL PRIMARY KEY,
+                trunk_id BIGINT NOT NULL REFERENCES voip.trunks(id) ON DELETE CASCADE,
+                network  CIDR NOT NULL,
+                port     INT NOT NULL DEFAULT 0 CHECK (port BETWEEN 0 AND 65535),
+                UNIQUE (trunk_id, network, port)
+            );
+        `,
+    },
+    {
+        Version: 19,
+        Name:    "dispatcher",
+        SQL: `
+            -- Đích dispatcher của Kamailio; được ghi vào bảng dispatcher của Kamailio
+            -- (kèm cờ disabled cho node bị drain hoặc lỗi health check) rồi reload qua RPC.
+            CREATE TABLE IF NOT EXISTS voip.dispatcher_destinations (
+                id          BIGSERIAL PRIMARY KEY,
+                setid       INT NOT NULL CHECK (setid >= 0),
+                destination TEXT NOT NULL,
+                node        TEXT,
+                weight      INT NOT NULL DEFAULT 0 CHECK (weight BETWEEN 0 AND 100),
+                priority    INT NOT NULL DEFAULT 0,
+                flags       INT NOT NULL DEFAULT 0 CHECK (flags >= 0),
+                attrs       TEXT NOT NULL DEFAULT '',
+                description TEXT NOT NULL DEFAULT '',
+                created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+                updated_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+                UNIQUE (setid, destination)
+            );
+
+            CREATE TABLE IF NOT EXISTS voip.dispatcher_drains (
+                node       TEXT PRIMARY KEY,
+                reason     TEXT NOT NULL DEFAULT '',
+                drained_by TEXT NOT NULL,
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+        `,
+    },
+    {
+        Version: 20,
+        Name:    "dispatcher_health",
+        SQL: `
+            -- Kết quả health check node dùng chung giữa các instance; chỉ instance giữ
+            -- advisory lock của vòng kiểm tra cập nhật bảng này.
+            CREATE TABLE IF NOT EXISTS voip.dispatcher_health (
+                node       TEXT PRIMARY KEY,
+                healthy    BOOLEAN NOT NULL DEFAULT TRUE,
+                failures   INT NOT NULL DEFAULT 0,
+                successes  INT NOT NULL DEFAULT 0,
+                last_error TEXT NOT NULL DEFAULT '',
+                last_check TIMESTAMPTZ NOT NULL DEFAULT now()
+            );
+        `,
+    },
+    {
+        Version: 21,
+        Name:    "kamailio_sync_owned",
+        SQL: `
+            -- Khóa các dòng Kamailio do service quản lý (đã được sync ghi hoặc nhận).
+            -- Sync chỉ xóa dòng thừa có trong bảng này; dòng khác chỉ được báo cáo.
+            CREATE TABLE IF NOT EXISTS voip.kamailio_sync_owned (
+                tbl        TEXT NOT NULL,
+                key        TEXT NOT NULL,
+                updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                PRIMARY KEY (tbl, key)
+            );
+        `,
+    },
+    {
+        Version: 22,
+        Name:    "cdr_click_to_call_user",
+        SQL: `
+            -- User đã yêu cầu cuộc gọi click-to-call (biến click_to_call_user_id của
+            -- kênh originate); caller_id_number của các CDR này là số đích.
+            ALTER TABLE voip.cdr ADD COLUMN IF NOT EXISTS click_to_call_user_id BIGINT;
+            CREATE INDEX IF NOT EXISTS cdr_click_to_call_user_idx
+                ON voip.cdr (click_to_call_user_id, start_time)
+                WHERE click_to_call_user_id IS NOT NULL;
+        `,
+    },
+    {
+        Version: 23,
+        Name:    "balance_reservations",
+        SQL: `
+            -- Phần số dư prepaid giữ cho cuộc gọi đang diễn ra (cấp phép ở dialplan,
+            -- bỏ khi CDR được rate); hết hạn thì không còn được tính.
+            CREATE TABLE IF NOT EXISTS voip.balance_reservations (
+                call_uuid    TEXT PRIMARY KEY,
+                domain_id    BIGINT NOT NULL REFERENCES voip.domains(id),
+                amount       NUMERIC(14,6) NOT NULL,
+                free_seconds INT NOT NULL DEFAULT 0,
+                created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+                expires_at   TIMESTAMPTZ NOT NULL
+            );
+
+            CREATE INDEX IF NOT EXISTS balance_reservations_domain_idx
+                ON voip.balance_reservations (domain_id, expires_at);
+        `,
+    },
+}
+
+// Migrate áp dụng các migration chưa chạy, mỗi bước trong một transaction.
+func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
+    _, err := pool.Exec(ctx, `
+        CREATE TABLE IF NOT EXISTS voip.schema_migrations (
+            version    INT PRIMARY KEY,
+            name       TEXT NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )
+    `)
+    if err != nil {
+        return err
+    }
+
+    for _, m := range migrations {
+        if err := apply(ctx, pool, m); err != nil {
+            return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+        }
+    }
+    return nil
+}
+
+func apply(ctx context.Context, pool *pgxpool.Pool, m Migration) error {
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    // Khóa để nhiều instance khởi động cùng lúc không chạy trùng migration.
+    if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('voip.schema_migrations'))`); err != nil {
+        return err
+    }
+
+    var applied bool
+    err = tx.QueryRow(ctx, `
+        SELECT EXISTS (SELECT 1 FROM voip.schema_migrations WHERE version=$1)
+    `, m.Version).Scan(&applied)
+    if err != nil {
+        return err
+    }
+    if applied {
+        return nil
+    }
+
+    log.Printf("applying migration %d (%s)", m.Version, m.Name)
+    start := time.Now()
+    if _, err := tx.Exec(ctx, m.SQL); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(ctx, `
+        INSERT INTO voip.schema_migrations (version, name) VALUES ($1, $2)
+    `, m.Version, m.Name); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return err
+    }
+    log.Printf("applied migration %d (%s) in %s", m.Version, m.Name, time.Since(start).Round(time.Millisecond))
+    return nil
+}
@@ -0,0 +1,69 @@
+package deadletter
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/webhook"
+)
+
+// Monitor cảnh báo khi số dead letter pending vượt Threshold: ghi log và gửi
+// webhook dead_letter.alert. Cảnh báo được gửi lại mỗi khi số lượng tăng thêm
+// Threshold, và reset khi số lượng giảm xuống dưới ngưỡng.
+type Monitor struct {
+    Pool      *pgxpool.Pool
+    Threshold int64
+
+    alertedAt int64
+}
+
+// Alert là payload của webhook dead_letter.alert.
+type Alert struct {
+    Pending   int64     `json:"pending"`
+    Threshold int64     `json:"threshold"`
+    CheckedAt time.Time `json:"checked_at"`
+}
+
+// Run kiểm tra định kỳ cho tới khi ctx bị hủy.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        if err := m.Check(ctx); err != nil && ctx.Err() == nil {
+            log.Printf("dead letter monitor: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (m *Monitor) Check(ctx context.Context) error {
+    if m.Threshold <= 0 {
+        return nil
+    }
+    n, err := PendingCount(ctx, m.Pool)
+    if err != nil {
+        return err
+    }
+    if n < m.Threshold {
+        m.alertedAt = 0
+        return nil
+    }
+    if m.alertedAt != 0 && n < m.alertedAt+m.Threshold {
+        return nil
+    }
+
+    log.Printf("ALERT: %d pending dead-letter CDRs (threshold %d)", n, m.Threshold)
+    alert := Alert{Pending: n, Threshold: m.Threshold, CheckedAt: time.Now().UTC()}
+    if err := webhook.Enqueue(ctx, m.Pool, webhook.EventDeadLetterAlert, alert); err != nil {
+        return err
+    }
+    m.alertedAt = n
+    return nil
+}
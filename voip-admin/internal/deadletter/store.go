@@ -0,0 +1,169 @@
+package deadletter
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Trạng thái của một dead letter.
+const (
+    StatusPending   = "pending"
+    StatusReplayed  = "replayed"
+    StatusDiscarded = "discarded"
+)
+
+// Giai đoạn ingest bị lỗi.
+const (
+    StageParse  = "parse"
+    StageInsert = "insert"
+)
+
+var (
+    // ErrNotFound được trả về khi dead letter không tồn tại.
+    ErrNotFound = errors.New("dead letter not found")
+    // ErrNotPending được trả về khi sửa/replay/discard dead letter đã xử lý xong.
+    ErrNotPending = errors.New("dead letter is not pending")
+)
+
+// Entry là một payload CDR bị từ chối. Payload chỉ có khi đọc chi tiết.
+type Entry struct {
+    ID          int64     `json:"id"`
+    Format      string    `json:"format"`
+    Payload     *string   `json:"payload,omitempty"`
+    PayloadSize int       `json:"payload_size"`
+    Stage       string    `json:"stage"`
+    Error       string    `json:"error"`
+    Status      string    `json:"status"`
+    Attempts    int       `json:"attempts"`
+    CDRID       *int64    `json:"cdr_id,omitempty"`
+    ReceivedAt  time.Time `json:"received_at"`
+    UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Store lưu payload bị lỗi cùng lý do.
+func Store(ctx context.Context, pool *pgxpool.Pool, format string, payload []byte, stage string, reason error) (int64, error) {
+    var id int64
+    err := pool.QueryRow(ctx, `
+        INSERT INTO voip.cdr_dead_letters (format, payload, stage, error)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id
+    `, format, string(payload), stage, reason.Error()).Scan(&id)
+    return id, err
+}
+
+// List trả dead letter theo id giảm dần; beforeID > 0 để lấy trang tiếp theo.
+func List(ctx context.Context, pool *pgxpool.Pool, status string, beforeID int64, limit int) ([]Entry, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT id, format, length(payload), stage, error, status, attempts, cdr_id, received_at, updated_at
+        FROM voip.cdr_dead_letters
+        WHERE ($1::text = '' OR status = $1)
+          AND ($2::bigint = 0 OR id < $2)
+        ORDER BY id DESC
+        LIMIT $3
+    `, status, beforeID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Entry
+    for rows.Next() {
+        var e Entry
+        if err := rows.Scan(
+            &e.ID, &e.Format, &e.PayloadSize, &e.Stage, &e.Error,
+            &e.Status, &e.Attempts, &e.CDRID, &e.ReceivedAt, &e.UpdatedAt,
+        ); err != nil {
+            return nil, err
+        }
+        out = append(out, e)
+    }
+    return out, rows.Err()
+}
+
+// Get đọc một dead letter kèm payload.
+func Get(ctx context.Context, pool *pgxpool.Pool, id int64) (*Entry, error) {
+    var e Entry
+    err := pool.QueryRow(ctx, `
+        SELECT id, format, payload, length(payload), stage, error, status, attempts, cdr_id, received_at, updated_at
+        FROM voip.cdr_dead_letters
+        WHERE id=$1
+    `, id).Scan(
+        &e.ID, &e.Format, &e.Payload, &e.PayloadSize, &e.Stage, &e.Error,
+        &e.Status, &e.Attempts, &e.CDRID, &e.ReceivedAt, &e.UpdatedAt,
+    )
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &e, nil
+}
+
+// UpdatePayload thay payload (đã được operator sửa) của dead letter đang pending.
+func UpdatePayload(ctx context.Context, pool *pgxpool.Pool, id int64, format string, payload []byte) error {
+    return updatePending(ctx, pool, id, `
+        UPDATE voip.cdr_dead_letters
+        SET format = $2, payload = $3, updated_at = now()
+        WHERE id = $1 AND status = 'pending'
+    `, format, string(payload))
+}
+
+// MarkReplayed đánh dấu dead letter đã ingest thành công thành CDR cdrID
+// (0 nếu CDR đã tồn tại từ trước).
+func MarkReplayed(ctx context.Context, pool *pgxpool.Pool, id, cdrID int64) error {
+    var ref *int64
+    if cdrID != 0 {
+        ref = &cdrID
+    }
+    return updatePending(ctx, pool, id, `
+        UPDATE voip.cdr_dead_letters
+        SET status = 'replayed', attempts = attempts + 1, cdr_id = $2, updated_at = now()
+        WHERE id = $1 AND status = 'pending'
+    `, ref)
+}
+
+// MarkFailed ghi lại lỗi của lần replay không thành công.
+func MarkFailed(ctx context.Context, pool *pgxpool.Pool, id int64, stage string, reason error) error {
+    return updatePending(ctx, pool, id, `
+        UPDATE voip.cdr_dead_letters
+        SET stage = $2, error = $3, attempts = attempts + 1, updated_at = now()
+        WHERE id = $1 AND status = 'pending'
+    `, stage, reason.Error())
+}
+
+// Discard bỏ qua dead letter; bản ghi được giữ lại để tra cứu.
+func Discard(ctx context.Context, pool *pgxpool.Pool, id int64) error {
+    return updatePending(ctx, pool, id, `
+        UPDATE voip.cdr_dead_letters
+        SET status = 'discarded', updated_at = now()
+        WHERE id = $1 AND status = 'pending'
+    `)
+}
+
+func updatePending(ctx context.Context, pool *pgxpool.Pool, id int64, sql string, args ...interface{}) error {
+    tag, err := pool.Exec(ctx, sql, append([]interface{}{id}, args...)...)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() > 0 {
+        return nil
+    }
+    if _, err := Get(ctx, pool, id); err != nil {
+        return err
+    }
+    return ErrNotPending
+}
+
+// PendingCount trả số dead letter chưa xử lý.
+func PendingCount(ctx context.Context, pool *pgxpool.Pool) (int64, error) {
+    var n int64
+    err := pool.QueryRow(ctx, `
+        SELECT COUNT(*) FROM voip.cdr_dead_letters WHERE status = 'pending'
+    `).Scan(&n)
+    return n, err
+}
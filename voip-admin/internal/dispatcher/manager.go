@@ -0,0 +1,511 @@
+package dispatcher
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/audit"
+    "voip-admin/internal/config"
+    "voip-admin/internal/esl"
+    "voip-admin/internal/kamailio"
+)
+
+// flagDisabled là cờ "admin disabled" của đích trong dispatcher Kamailio.
+const flagDisabled = 4
+
+// pushLockKey là khóa advisory khi ghi bảng dispatcher của Kamailio.
+const pushLockKey = 0x6b616d02
+
+// healthLockKey là khóa advisory của một vòng health check: chỉ instance giữ
+// khóa mới kiểm tra và cập nhật voip.dispatcher_health.
+const healthLockKey = 0x6b616d03
+
+// ErrNoDestinations được trả về khi chưa có đích nào được quản lý; Push từ chối
+// ghi để không xóa trắng bảng dispatcher đang dùng của Kamailio.
+var ErrNoDestinations = errors.New("no managed dispatcher destinations")
+
+// NodeHealth là kết quả health check của một node FreeSWITCH.
+type NodeHealth struct {
+    Node      string    `json:"node"`
+    Healthy   bool      `json:"healthy"`
+    Failures  int       `json:"failures"`
+    Successes int       `json:"successes"`
+    LastError string    `json:"last_error,omitempty"`
+    LastCheck time.Time `json:"last_check"`
+}
+
+// DestinationState là đích kèm trạng thái vòng dispatcher hiện tại.
+type DestinationState struct {
+    Destination
+    Drained    bool `json:"drained"`
+    Healthy    bool `json:"healthy"`
+    InRotation bool `json:"in_rotation"`
+    // Note giải thích khi đích lỗi vẫn được giữ trong vòng.
+    Note string `json:"note,omitempty"`
+}
+
+// ReloadResult là kết quả reload trên một instance Kamailio.
+type ReloadResult struct {
+    URL   string `json:"url"`
+    Error string `json:"error,omitempty"`
+}
+
+// PushResult là kết quả ghi bảng dispatcher và reload.
+type PushResult struct {
+    Destinations []DestinationState `json:"destinations"`
+    Reloads      []ReloadResult     `json:"reloads"`
+}
+
+// Manager ghi định nghĩa dispatcher set vào bảng dispatcher của Kamailio và
+// reload qua JSON-RPC. Đích của node bị drain hoặc lỗi health check (qua event
+// socket) được ghi với cờ disabled để Kamailio không chọn. Kết quả health check
+// lưu trong voip.dispatcher_health nên mọi instance tính cùng một trạng thái.
+type Manager struct {
+    Pool             *pgxpool.Pool
+    Kamailio         *kamailio.DB
+    FS               *esl.Manager
+    RPC              *RPCClient
+    RPCURLs          []string
+    FailThreshold    int
+    RecoverThreshold int
+
+    pushMu sync.Mutex
+}
+
+// NewManager tạo Manager theo cấu hình; trả nil nếu k là nil (tích hợp tắt).
+func NewManager(cfg config.KamailioConfig, pool *pgxpool.Pool, k *kamailio.DB, fs *esl.Manager) *Manager {
+    if k == nil {
+        return nil
+    }
+    return &Manager{
+        Pool:             pool,
+        Kamailio:         k,
+        FS:               fs,
+        RPC:              &RPCClient{HTTP: &http.Client{Timeout: cfg.Dispatcher.RPCTimeout}},
+        RPCURLs:          cfg.Dispatcher.RPCURLs,
+        FailThreshold:    cfg.Dispatcher.FailThreshold,
+        RecoverThreshold: cfg.Dispatcher.RecoverThreshold,
+    }
+}
+
+// Run nhập bảng dispatcher có sẵn của Kamailio nếu chưa có đích nào được quản
+// lý, đẩy dispatcher khi khởi động, sau đó health check các node định kỳ và
+// đẩy lại khi một node ra/vào vòng (hoặc lần đẩy trước lỗi), tới khi ctx bị hủy.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    imported := false
+    pending := true
+    for {
+        if !imported {
+            if n, err := m.Import(ctx); err != nil {
+                if ctx.Err() == nil {
+                    log.Printf("dispatcher import: %v", err)
+                }
+            } else {
+                imported = true
+                if n > 0 {
+                    log.Printf("dispatcher: imported %d destinations from kamailio", n)
+                }
+            }
+        }
+        if imported && pending {
+            _, err := m.Push(ctx)
+            switch {
+            case errors.Is(err, ErrNoDestinations):
+                pending = false
+            case err != nil:
+                if ctx.Err() == nil {
+                    log.Printf("dispatcher push: %v", err)
+                }
+            default:
+                pending = false
+            }
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        changed, err := m.checkHealth(ctx, interval)
+        if err != nil {
+            if ctx.Err() == nil {
+                log.Printf("dispatcher health check: %v", err)
+            }
+            continue
+        }
+        for _, h := range changed {
+            log.Printf("dispatcher: node %s healthy=%v (%s)", h.Node, h.Healthy, h.LastError)
+            if err := audit.Record(ctx, m.Pool, "health-check", "dispatcher.health", h.Node, h); err != nil {
+                log.Printf("audit dispatcher.health: %v", err)
+            }
+            pending = true
+        }
+    }
+}
+
+// Import chép các dòng đang có trong bảng dispatcher của Kamailio vào
+// voip.dispatcher_destinations khi bảng này còn trống, để lần đẩy đầu tiên giữ
+// nguyên cấu hình hiện có. Trả về số đích đã nhập.
+func (m *Manager) Import(ctx context.Context) (int, error) {
+    tx, err := m.Pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, `LOCK TABLE voip.dispatcher_destinations IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+        return 0, err
+    }
+    var exists bool
+    if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM voip.dispatcher_destinations)`).Scan(&exists); err != nil {
+        return 0, err
+    }
+    if exists {
+        return 0, nil
+    }
+
+    rows, err := m.Kamailio.Pool.Query(ctx, `
+        SELECT setid, destination, flags, priority, COALESCE(attrs, ''), COALESCE(description, '')
+        FROM `+m.Kamailio.Table("dispatcher")+`
+        ORDER BY setid, priority DESC, id
+    `)
+    if err != nil {
+        return 0, err
+    }
+    var dests []Destination
+    for rows.Next() {
+        var (
+            d     Destination
+            attrs string
+        )
+        if err := rows.Scan(&d.SetID, &d.Destination, &d.Flags, &d.Priority, &attrs, &d.Description); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        var pushed bool
+        d.Weight, d.Attrs, pushed = splitKamailioAttrs(attrs)
+        if pushed {
+            // Cờ disabled trên dòng do Push ghi là trạng thái vòng, không phải cấu hình.
+            d.Flags &^= flagDisabled
+        }
+        dests = append(dests, d)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    n := 0
+    for _, d := range dests {
+        tag, err := tx.Exec(ctx, `
+            INSERT INTO voip.dispatcher_destinations (setid, destination, weight, priority, flags, attrs, description)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            ON CONFLICT (setid, destination) DO NOTHING
+        `, d.SetID, d.Destination, d.Weight, d.Priority, d.Flags, d.Attrs, d.Description)
+        if err != nil {
+            return 0, err
+        }
+        n += int(tag.RowsAffected())
+    }
+    if n > 0 {
+        if err := audit.Record(ctx, tx, "dispatcher", "dispatcher.import", "kamailio", map[string]int{"destinations": n}); err != nil {
+            return 0, err
+        }
+    }
+    return n, tx.Commit(ctx)
+}
+
+// splitKamailioAttrs tách weight khỏi attrs của Kamailio và bỏ duid (được sinh
+// lại khi đẩy). pushed cho biết dòng có duid, tức do Push ghi trước đó.
+func splitKamailioAttrs(attrs string) (weight int, rest string, pushed bool) {
+    var parts []string
+    for _, p := range strings.Split(attrs, ";") {
+        name, value, _ := strings.Cut(p, "=")
+        switch name {
+        case "":
+            continue
+        case "duid":
+            pushed = true
+            continue
+        case "weight":
+            if w, err := strconv.Atoi(value); err == nil && w >= 0 && w <= 100 {
+                weight = w
+                continue
+            }
+        }
+        parts = append(parts, p)
+    }
+    return weight, strings.Join(parts, ";"), pushed
+}
+
+// Health trả kết quả health check các node, theo tên.
+func (m *Manager) Health(ctx context.Context) ([]NodeHealth, error) {
+    health, err := loadHealth(ctx, m.Pool)
+    if err != nil {
+        return nil, err
+    }
+    out := make([]NodeHealth, 0, len(health))
+    for _, h := range health {
+        out = append(out, h)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+    return out, nil
+}
+
+// healthQuerier là phần chung của *pgxpool.Pool và pgx.Tx dùng khi đọc health.
+type healthQuerier interface {
+    Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+func loadHealth(ctx context.Context, q healthQuerier) (map[string]NodeHealth, error) {
+    rows, err := q.Query(ctx, `
+        SELECT node, healthy, failures, successes, last_error, last_check
+        FROM voip.dispatcher_health
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    out := make(map[string]NodeHealth)
+    for rows.Next() {
+        var h NodeHealth
+        if err := rows.Scan(&h.Node, &h.Healthy, &h.Failures, &h.Successes, &h.LastError, &h.LastCheck); err != nil {
+            return nil, err
+        }
+        out[h.Node] = h
+    }
+    return out, rows.Err()
+}
+
+// checkHealth chạy "status" trên từng node có đích dispatcher và trả các node
+// vừa đổi trạng thái healthy. Vòng kiểm tra giữ advisory lock; instance khác
+// đang kiểm tra, hoặc vòng trước mới chạy chưa tới nửa interval, thì bỏ qua để
+// bộ đếm fail/recover không bị tăng gấp đôi khi chạy nhiều instance.
+func (m *Manager) checkHealth(ctx context.Context, interval time.Duration) ([]NodeHealth, error) {
+    dests, err := List(ctx, m.Pool)
+    if err != nil {
+        return nil, err
+    }
+    nodes := make(map[string]bool)
+    for _, d := range dests {
+        if d.Node != nil {
+            if _, ok := m.FS.Client(*d.Node); ok {
+                nodes[*d.Node] = true
+            }
+        }
+    }
+    if len(nodes) == 0 {
+        return nil, nil
+    }
+
+    tx, err := m.Pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    var locked, recent bool
+    if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, healthLockKey).Scan(&locked); err != nil {
+        return nil, err
+    }
+    if !locked {
+        return nil, nil
+    }
+    if err := tx.QueryRow(ctx, `
+        SELECT EXISTS (SELECT 1 FROM voip.dispatcher_health WHERE last_check > now() - $1::interval)
+    `, (interval / 2).String()).Scan(&recent); err != nil {
+        return nil, err
+    }
+    if recent {
+        return nil, nil
+    }
+    health, err := loadHealth(ctx, tx)
+    if err != nil {
+        return nil, err
+    }
+
+    var changed []NodeHealth
+    for node := range nodes {
+        cctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+        _, err := m.FS.API(cctx, node, "status")
+        cancel()
+        if ctx.Err() != nil {
+            return nil, ctx.Err()
+        }
+
+        h, ok := health[node]
+        if !ok {
+            h = NodeHealth{Node: node, Healthy: true}
+        }
+        h.LastCheck = time.Now()
+        if err != nil {
+            h.Failures++
+            h.Successes = 0
+            h.LastError = err.Error()
+            if h.Healthy && h.Failures >= m.FailThreshold {
+                h.Healthy = false
+                changed = append(changed, h)
+            }
+        } else {
+            h.Successes++
+            h.Failures = 0
+            h.LastError = ""
+            if !h.Healthy && h.Successes >= m.RecoverThreshold {
+                h.Healthy = true
+                changed = append(changed, h)
+            }
+        }
+        if _, err := tx.Exec(ctx, `
+            INSERT INTO voip.dispatcher_health (node, healthy, failures, successes, last_error, last_check)
+            VALUES ($1, $2, $3, $4, $5, $6)
+            ON CONFLICT (node) DO UPDATE
+            SET healthy = EXCLUDED.healthy,
+                failures = EXCLUDED.failures,
+                successes = EXCLUDED.successes,
+                last_error = EXCLUDED.last_error,
+                last_check = EXCLUDED.last_check
+        `, h.Node, h.Healthy, h.Failures, h.Successes, h.LastError, h.LastCheck); err != nil {
+            return nil, err
+        }
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+    return changed, nil
+}
+
+// States tính trạng thái vòng của mọi đích từ drain và health check. Nếu mọi
+// đích của một set đều lỗi health check (không bị drain), chúng vẫn được giữ
+// trong vòng để lỗi phía kiểm tra không làm mất toàn bộ set.
+func (m *Manager) States(ctx context.Context) ([]DestinationState, error) {
+    dests, err := List(ctx, m.Pool)
+    if err != nil {
+        return nil, err
+    }
+    drains, err := Drains(ctx, m.Pool)
+    if err != nil {
+        return nil, err
+    }
+    health, err := loadHealth(ctx, m.Pool)
+    if err != nil {
+        return nil, err
+    }
+    drained := make(map[string]bool, len(drains))
+    for _, d := range drains {
+        drained[d.Node] = true
+    }
+    return rotationStates(dests, drained, health), nil
+}
+
+// rotationStates áp quy tắc của States lên danh sách đích; node không có kết
+// quả health check được coi là healthy.
+func rotationStates(dests []Destination, drained map[string]bool, health map[string]NodeHealth) []DestinationState {
+    out := make([]DestinationState, len(dests))
+    for i, d := range dests {
+        s := DestinationState{Destination: d, Healthy: true}
+        if d.Node != nil {
+            s.Drained = drained[*d.Node]
+            if h, ok := health[*d.Node]; ok {
+                s.Healthy = h.Healthy
+            }
+        }
+        s.InRotation = !s.Drained && s.Healthy
+        out[i] = s
+    }
+
+    active := make(map[int]bool)
+    for _, s := range out {
+        if s.InRotation {
+            active[s.SetID] = true
+        }
+    }
+    for i := range out {
+        s := &out[i]
+        if !active[s.SetID] && !s.Drained && !s.Healthy {
+            s.InRotation = true
+            s.Note = "all destinations in set failed health check; kept in rotation"
+        }
+    }
+    return out
+}
+
+// Push ghi toàn bộ đích vào bảng dispatcher của Kamailio trong một transaction
+// rồi gọi dispatcher.reload trên mọi instance. Lỗi reload được trả trong kết quả.
+// Khi chưa có đích nào được quản lý, Push trả ErrNoDestinations và không ghi gì.
+func (m *Manager) Push(ctx context.Context) (*PushResult, error) {
+    m.pushMu.Lock()
+    defer m.pushMu.Unlock()
+
+    states, err := m.States(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if len(states) == 0 {
+        return nil, ErrNoDestinations
+    }
+
+    tx, err := m.Kamailio.Pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, pushLockKey); err != nil {
+        return nil, err
+    }
+    table := m.Kamailio.Table("dispatcher")
+    if _, err := tx.Exec(ctx, `DELETE FROM `+table); err != nil {
+        return nil, err
+    }
+    for _, s := range states {
+        flags := s.Flags
+        if !s.InRotation {
+            flags |= flagDisabled
+        }
+        if _, err := tx.Exec(ctx, `
+            INSERT INTO `+table+` (setid, destination, flags, priority, attrs, description)
+            VALUES ($1, $2, $3, $4, $5, $6)
+        `, s.SetID, s.Destination.Destination, flags, s.Priority, kamailioAttrs(s.Destination), s.Description); err != nil {
+            return nil, err
+        }
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+
+    res := &PushResult{Destinations: states, Reloads: []ReloadResult{}}
+    for _, url := range m.RPCURLs {
+        r := ReloadResult{URL: url}
+        if _, err := m.RPC.Call(ctx, url, "dispatcher.reload"); err != nil {
+            r.Error = err.Error()
+            log.Printf("dispatcher reload %s: %v", url, err)
+        }
+        res.Reloads = append(res.Reloads, r)
+    }
+    return res, nil
+}
+
+// kamailioAttrs ghép duid (id của đích) và weight với attrs tùy chỉnh.
+func kamailioAttrs(d Destination) string {
+    parts := []string{"duid=" + strconv.FormatInt(d.ID, 10)}
+    if d.Weight > 0 {
+        parts = append(parts, "weight="+strconv.Itoa(d.Weight))
+    }
+    if d.Attrs != "" {
+        parts = append(parts, d.Attrs)
+    }
+    return strings.Join(parts, ";")
+}
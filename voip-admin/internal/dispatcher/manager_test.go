@@ -0,0 +1,105 @@
+package dispatcher
+
+import "testing"
+
+func node(name string) *string { return &name }
+
+func TestRotationStates(t *testing.T) {
+    dests := []Destination{
+        {ID: 1, SetID: 1, Destination: "sip:10.0.0.11:5060", Node: node("fs1")},
+        {ID: 2, SetID: 1, Destination: "sip:10.0.0.12:5060", Node: node("fs2")},
+        {ID: 3, SetID: 2, Destination: "sip:10.0.0.21:5060", Node: node("fs3")},
+        {ID: 4, SetID: 2, Destination: "sip:10.0.0.22:5060", Node: node("fs4")},
+        {ID: 5, SetID: 3, Destination: "sip:203.0.113.5:5060"},
+    }
+    type state struct{ drained, healthy, inRotation, note bool }
+    tests := []struct {
+        name    string
+        drained map[string]bool
+        health  map[string]NodeHealth
+        want    map[int64]state
+    }{
+        {
+            name: "no health results: all in rotation",
+            want: map[int64]state{
+                1: {false, true, true, false}, 2: {false, true, true, false},
+                3: {false, true, true, false}, 4: {false, true, true, false}, 5: {false, true, true, false},
+            },
+        },
+        {
+            name:    "drained and unhealthy nodes leave rotation",
+            drained: map[string]bool{"fs1": true},
+            health:  map[string]NodeHealth{"fs3": {Node: "fs3", Healthy: false}, "fs4": {Node: "fs4", Healthy: true}},
+            want: map[int64]state{
+                1: {true, true, false, false}, 2: {false, true, true, false},
+                3: {false, false, false, false}, 4: {false, true, true, false}, 5: {false, true, true, false},
+            },
+        },
+        {
+            name:   "whole set unhealthy is kept in rotation",
+            health: map[string]NodeHealth{"fs3": {Node: "fs3"}, "fs4": {Node: "fs4"}},
+            want: map[int64]state{
+                1: {false, true, true, false}, 2: {false, true, true, false},
+                3: {false, false, true, true}, 4: {false, false, true, true}, 5: {false, true, true, false},
+            },
+        },
+        {
+            name:    "drained destination is never kept",
+            drained: map[string]bool{"fs3": true},
+            health:  map[string]NodeHealth{"fs3": {Node: "fs3"}, "fs4": {Node: "fs4"}},
+            want: map[int64]state{
+                1: {false, true, true, false}, 2: {false, true, true, false},
+                3: {true, false, false, false}, 4: {false, false, true, true}, 5: {false, true, true, false},
+            },
+        },
+        {
+            name:    "whole set drained leaves rotation",
+            drained: map[string]bool{"fs1": true, "fs2": true},
+            want: map[int64]state{
+                1: {true, true, false, false}, 2: {true, true, false, false},
+                3: {false, true, true, false}, 4: {false, true, true, false}, 5: {false, true, true, false},
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := rotationStates(dests, tt.drained, tt.health)
+            if len(got) != len(dests) {
+                t.Fatalf("%d states, want %d", len(got), len(dests))
+            }
+            for _, s := range got {
+                have := state{s.Drained, s.Healthy, s.InRotation, s.Note != ""}
+                if want := tt.want[s.ID]; have != want {
+                    t.Errorf("destination %d = %+v, want %+v", s.ID, have, want)
+                }
+            }
+        })
+    }
+}
+
+func TestSplitKamailioAttrs(t *testing.T) {
+    tests := []struct {
+        attrs  string
+        weight int
+        rest   string
+        pushed bool
+    }{
+        {"", 0, "", false},
+        {"weight=50", 50, "", false},
+        {"duid=7;weight=50;socket=udp:10.0.0.1:5060", 50, "socket=udp:10.0.0.1:5060", true},
+        {"socket=udp:10.0.0.1:5060;;ping_from=sip:kam@bsv.local", 0, "socket=udp:10.0.0.1:5060;ping_from=sip:kam@bsv.local", false},
+        // Weight ngoài khoảng 0-100 hoặc sai định dạng được giữ trong attrs.
+        {"weight=150;maxload=20", 0, "weight=150;maxload=20", false},
+        {"weight=abc", 0, "weight=abc", false},
+        {"duid=3", 0, "", true},
+        // kamailioAttrs rồi splitKamailioAttrs trả lại weight và attrs ban đầu.
+        {kamailioAttrs(Destination{ID: 9, Weight: 25, Attrs: "maxload=10"}), 25, "maxload=10", true},
+    }
+    for _, tt := range tests {
+        weight, rest, pushed := splitKamailioAttrs(tt.attrs)
+        if weight != tt.weight || rest != tt.rest || pushed != tt.pushed {
+            t.Errorf("splitKamailioAttrs(%q) = %d, %q, %v; want %d, %q, %v",
+                tt.attrs, weight, rest, pushed, tt.weight, tt.rest, tt.pushed)
+        }
+    }
+}
@@ -0,0 +1,62 @@
+package dispatcher
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync/atomic"
+)
+
+// RPCClient gọi JSON-RPC của Kamailio (module jsonrpcs qua xhttp).
+type RPCClient struct {
+    HTTP *http.Client
+    id   atomic.Int64
+}
+
+type rpcRequest struct {
+    JSONRPC string        `json:"jsonrpc"`
+    Method  string        `json:"method"`
+    Params  []interface{} `json:"params,omitempty"`
+    ID      int64         `json:"id"`
+}
+
+type rpcResponse struct {
+    Result json.RawMessage `json:"result"`
+    Error  *struct {
+        Code    int    `json:"code"`
+        Message string `json:"message"`
+    } `json:"error"`
+}
+
+// Call gọi method trên url và trả result.
+func (c *RPCClient) Call(ctx context.Context, url, method string, params ...interface{}) (json.RawMessage, error) {
+    body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", Method: method, Params: params, ID: c.id.Add(1)})
+    if err != nil {
+        return nil, err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := c.HTTP.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("kamailio rpc %s: http status %d", method, resp.StatusCode)
+    }
+
+    var r rpcResponse
+    if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+        return nil, fmt.Errorf("kamailio rpc %s: %w", method, err)
+    }
+    if r.Error != nil {
+        return nil, fmt.Errorf("kamailio rpc %s: %d %s", method, r.Error.Code, r.Error.Message)
+    }
+    return r.Result, nil
+}
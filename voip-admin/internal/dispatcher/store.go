@@ -0,0 +1,183 @@
+package dispatcher
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgconn"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+    // ErrNotFound được trả về khi đích dispatcher hoặc drain không tồn tại.
+    ErrNotFound = errors.New("not found")
+    // ErrDuplicate được trả về khi (setid, destination) đã tồn tại.
+    ErrDuplicate = errors.New("destination already exists in set")
+    // ErrInvalid được trả về khi dữ liệu đích không hợp lệ.
+    ErrInvalid = errors.New("invalid destination: expected sip:host[:port], weight 0..100, non-negative setid/flags and attrs of name=value;...")
+)
+
+var (
+    destinationPattern = regexp.MustCompile(`^sips?:[0-9A-Za-z.\-\[\]:]+(;[0-9A-Za-z=._-]+)*$`)
+    attrsPattern       = regexp.MustCompile(`^([0-9A-Za-z_]+=[0-9A-Za-z._:-]*)?(;[0-9A-Za-z_]+=[0-9A-Za-z._:-]*)*$`)
+)
+
+// Destination là một đích trong dispatcher set. Node là tên node FreeSWITCH
+// (theo cấu hình esl) dùng cho drain và health check; rỗng là không theo dõi.
+type Destination struct {
+    ID          int64     `json:"id"`
+    SetID       int       `json:"setid"`
+    Destination string    `json:"destination"`
+    Node        *string   `json:"node,omitempty"`
+    Weight      int       `json:"weight"`
+    Priority    int       `json:"priority"`
+    Flags       int       `json:"flags"`
+    Attrs       string    `json:"attrs"`
+    Description string    `json:"description"`
+    CreatedAt   time.Time `json:"created_at"`
+    UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (d *Destination) validate() error {
+    if d.SetID < 0 || d.Flags < 0 || d.Weight < 0 || d.Weight > 100 ||
+        !destinationPattern.MatchString(d.Destination) || !attrsPattern.MatchString(d.Attrs) {
+        return ErrInvalid
+    }
+    if d.Node != nil && *d.Node == "" {
+        d.Node = nil
+    }
+    return nil
+}
+
+// Drain là một node được đưa ra khỏi vòng dispatcher thủ công.
+type Drain struct {
+    Node      string    `json:"node"`
+    Reason    string    `json:"reason"`
+    DrainedBy string    `json:"drained_by"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+const destinationColumns = `id, setid, destination, node, weight, priority, flags, attrs, description, created_at, updated_at`
+
+func scanDestination(row pgx.Row, d *Destination) error {
+    return row.Scan(&d.ID, &d.SetID, &d.Destination, &d.Node, &d.Weight, &d.Priority,
+        &d.Flags, &d.Attrs, &d.Description, &d.CreatedAt, &d.UpdatedAt)
+}
+
+// List trả mọi đích theo set, priority.
+func List(ctx context.Context, pool *pgxpool.Pool) ([]Destination, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT `+destinationColumns+`
+        FROM voip.dispatcher_destinations
+        ORDER BY setid, priority DESC, id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Destination
+    for rows.Next() {
+        var d Destination
+        if err := scanDestination(rows, &d); err != nil {
+            return nil, err
+        }
+        out = append(out, d)
+    }
+    return out, rows.Err()
+}
+
+// Create thêm đích mới.
+func Create(ctx context.Context, pool *pgxpool.Pool, d *Destination) error {
+    if err := d.validate(); err != nil {
+        return err
+    }
+    err := scanDestination(pool.QueryRow(ctx, `
+        INSERT INTO voip.dispatcher_destinations (setid, destination, node, weight, priority, flags, attrs, description)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING `+destinationColumns,
+        d.SetID, d.Destination, d.Node, d.Weight, d.Priority, d.Flags, d.Attrs, d.Description), d)
+    return mapUnique(err)
+}
+
+// Update ghi đè đích theo d.ID.
+func Update(ctx context.Context, pool *pgxpool.Pool, d *Destination) error {
+    if err := d.validate(); err != nil {
+        return err
+    }
+    err := scanDestination(pool.QueryRow(ctx, `
+        UPDATE voip.dispatcher_destinations
+        SET setid = $2, destination = $3, node = $4, weight = $5, priority = $6,
+            flags = $7, attrs = $8, description = $9, updated_at = now()
+        WHERE id = $1
+        RETURNING `+destinationColumns,
+        d.ID, d.SetID, d.Destination, d.Node, d.Weight, d.Priority, d.Flags, d.Attrs, d.Description), d)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return ErrNotFound
+    }
+    return mapUnique(err)
+}
+
+// Delete xóa đích.
+func Delete(ctx context.Context, pool *pgxpool.Pool, id int64) error {
+    tag, err := pool.Exec(ctx, `DELETE FROM voip.dispatcher_destinations WHERE id = $1`, id)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+func mapUnique(err error) error {
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+        return ErrDuplicate
+    }
+    return err
+}
+
+// Drains trả các node đang bị drain.
+func Drains(ctx context.Context, pool *pgxpool.Pool) ([]Drain, error) {
+    rows, err := pool.Query(ctx, `SELECT node, reason, drained_by, created_at FROM voip.dispatcher_drains ORDER BY node`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Drain
+    for rows.Next() {
+        var d Drain
+        if err := rows.Scan(&d.Node, &d.Reason, &d.DrainedBy, &d.CreatedAt); err != nil {
+            return nil, err
+        }
+        out = append(out, d)
+    }
+    return out, rows.Err()
+}
+
+// SetDrain drain node (giữ nguyên lần drain trước nếu đã drain).
+func SetDrain(ctx context.Context, pool *pgxpool.Pool, node, reason, actor string) error {
+    _, err := pool.Exec(ctx, `
+        INSERT INTO voip.dispatcher_drains (node, reason, drained_by)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (node) DO NOTHING
+    `, node, reason, actor)
+    return err
+}
+
+// ClearDrain đưa node trở lại vòng (nếu health check vẫn đạt).
+func ClearDrain(ctx context.Context, pool *pgxpool.Pool, node string) error {
+    tag, err := pool.Exec(ctx, `DELETE FROM voip.dispatcher_drains WHERE node = $1`, node)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
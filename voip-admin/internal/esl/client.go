@@ -0,0 +1,358 @@
+package esl
+
+import (
+    "bufio"
+    "context"
+    "crypto/rand"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    // ErrNotConnected được trả về khi node chưa kết nối (đang reconnect).
+    ErrNotConnected = errors.New("esl: not connected")
+    // ErrAuth được trả về khi FreeSWITCH từ chối mật khẩu event socket.
+    ErrAuth = errors.New("esl: authentication failed")
+)
+
+// CommandError là lỗi "-ERR ..." FreeSWITCH trả về cho api/bgapi.
+type CommandError struct {
+    Reply string
+}
+
+func (e *CommandError) Error() string {
+    return "esl: " + e.Reply
+}
+
+// Client giữ kết nối inbound event socket tới một node FreeSWITCH: xác thực,
+// đăng ký Events dạng JSON và tự kết nối lại khi mất kết nối.
+type Client struct {
+    Node     string
+    Addr     string
+    Password string
+    // Events là danh sách event đăng ký; BACKGROUND_JOB luôn được thêm để BgAPI
+    // nhận kết quả.
+    Events []string
+
+    DialTimeout  time.Duration
+    ReconnectMin time.Duration
+    ReconnectMax time.Duration
+
+    // OnEvent nhận mọi event, được gọi tuần tự từ goroutine đọc nên không được block lâu.
+    OnEvent func(Event)
+    // OnConnect được gọi (trong goroutine riêng) mỗi lần kết nối và đăng ký event thành công.
+    OnConnect func(ctx context.Context, c *Client)
+
+    mu   sync.Mutex
+    sess *session
+}
+
+// session là một kết nối đã xác thực. Reply của command được trả theo đúng thứ
+// tự gửi nên pending là hàng đợi FIFO.
+type session struct {
+    conn net.Conn
+
+    mu      sync.Mutex
+    pending []chan *message
+    jobs    map[string]chan Event
+    done    chan struct{}
+    err     error
+}
+
+// Run kết nối và duy trì kết nối tới khi ctx bị hủy.
+func (c *Client) Run(ctx context.Context) {
+    backoff := c.ReconnectMin
+    if backoff <= 0 {
+        backoff = time.Second
+    }
+    for {
+        s, err := c.connect(ctx)
+        if err == nil {
+            log.Printf("esl %s: connected to %s", c.Node, c.Addr)
+            backoff = c.ReconnectMin
+            if backoff <= 0 {
+                backoff = time.Second
+            }
+            c.setSession(s)
+            if c.OnConnect != nil {
+                go c.OnConnect(ctx, c)
+            }
+            err = c.serve(ctx, s)
+            c.setSession(nil)
+        }
+        if ctx.Err() != nil {
+            return
+        }
+        log.Printf("esl %s: %v; reconnecting in %s", c.Node, err, backoff)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(backoff):
+        }
+        backoff *= 2
+        if c.ReconnectMax > 0 && backoff > c.ReconnectMax {
+            backoff = c.ReconnectMax
+        }
+    }
+}
+
+// Connected cho biết client đang có kết nối đã xác thực.
+func (c *Client) Connected() bool {
+    return c.session() != nil
+}
+
+// API chạy lệnh đồng bộ ("api <cmd>") và trả về kết quả.
+func (c *Client) API(ctx context.Context, cmd string) (string, error) {
+    s := c.session()
+    if s == nil {
+        return "", ErrNotConnected
+    }
+    m, err := s.send(ctx, "api "+cmd, nil)
+    if err != nil {
+        return "", err
+    }
+    return checkReply(string(m.Body))
+}
+
+// BgAPI chạy lệnh nền ("bgapi <cmd>") và chờ kết quả từ event BACKGROUND_JOB,
+// dùng cho lệnh lâu như originate để không chặn kết nối.
+func (c *Client) BgAPI(ctx context.Context, cmd string) (string, error) {
+    s := c.session()
+    if s == nil {
+        return "", ErrNotConnected
+    }
+    jobUUID, err := NewUUID()
+    if err != nil {
+        return "", err
+    }
+    ch := make(chan Event, 1)
+    s.mu.Lock()
+    s.jobs[jobUUID] = ch
+    s.mu.Unlock()
+    defer func() {
+        s.mu.Lock()
+        delete(s.jobs, jobUUID)
+        s.mu.Unlock()
+    }()
+
+    m, err := s.send(ctx, "bgapi "+cmd, map[string]string{"Job-UUID": jobUUID})
+    if err != nil {
+        return "", err
+    }
+    if _, err := checkReply(m.replyText()); err != nil {
+        return "", err
+    }
+
+    select {
+    case e := <-ch:
+        return checkReply(e.Body())
+    case <-s.done:
+        return "", s.err
+    case <-ctx.Done():
+        return "", ctx.Err()
+    }
+}
+
+func (c *Client) session() *session {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.sess
+}
+
+func (c *Client) setSession(s *session) {
+    c.mu.Lock()
+    c.sess = s
+    c.mu.Unlock()
+}
+
+// connect mở kết nối, xác thực và đăng ký event.
+func (c *Client) connect(ctx context.Context) (*session, error) {
+    timeout := c.DialTimeout
+    if timeout <= 0 {
+        timeout = 5 * time.Second
+    }
+    d := net.Dialer{Timeout: timeout}
+    conn, err := d.DialContext(ctx, "tcp", c.Addr)
+    if err != nil {
+        return nil, err
+    }
+    s := &session{conn: conn, jobs: make(map[string]chan Event), done: make(chan struct{})}
+    r := bufio.NewReader(conn)
+
+    _ = conn.SetDeadline(time.Now().Add(timeout))
+    if err := s.handshake(r, c.Password, c.eventList()); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    _ = conn.SetDeadline(time.Time{})
+
+    go s.readLoop(r, c.Node, c.OnEvent)
+    return s, nil
+}
+
+func (c *Client) eventList() string {
+    events := []string{"BACKGROUND_JOB"}
+    for _, e := range c.Events {
+        if e != "BACKGROUND_JOB" {
+            events = append(events, e)
+        }
+    }
+    return strings.Join(events, " ")
+}
+
+// serve chờ tới khi kết nối đóng hoặc ctx bị hủy.
+func (c *Client) serve(ctx context.Context, s *session) error {
+    select {
+    case <-s.done:
+        return s.err
+    case <-ctx.Done():
+        s.conn.Close()
+        <-s.done
+        return ctx.Err()
+    }
+}
+
+func (s *session) handshake(r *bufio.Reader, password, events string) error {
+    m, err := readMessage(r)
+    if err != nil {
+        return err
+    }
+    if m.contentType() != typeAuthRequest {
+        return fmt.Errorf("esl: unexpected %q before auth", m.contentType())
+    }
+    if err := s.command(r, "auth "+password); err != nil {
+        if errors.As(err, new(*CommandError)) {
+            return ErrAuth
+        }
+        return err
+    }
+    return s.command(r, "event json "+events)
+}
+
+// command gửi lệnh và đọc reply đồng bộ, chỉ dùng trước khi readLoop chạy.
+func (s *session) command(r *bufio.Reader, cmd string) error {
+    if _, err := s.conn.Write([]byte(cmd + "\n\n")); err != nil {
+        return err
+    }
+    m, err := readMessage(r)
+    if err != nil {
+        return err
+    }
+    if m.contentType() != typeReply {
+        return fmt.Errorf("esl: unexpected %q reply", m.contentType())
+    }
+    _, err = checkReply(m.replyText())
+    return err
+}
+
+// send ghi lệnh (kèm header) và chờ reply tương ứng.
+func (s *session) send(ctx context.Context, cmd string, headers map[string]string) (*message, error) {
+    var b strings.Builder
+    b.WriteString(cmd)
+    b.WriteString("\n")
+    for k, v := range headers {
+        b.WriteString(k + ": " + v + "\n")
+    }
+    b.WriteString("\n")
+
+    ch := make(chan *message, 1)
+    s.mu.Lock()
+    if s.err != nil {
+        s.mu.Unlock()
+        return nil, s.err
+    }
+    s.pending = append(s.pending, ch)
+    _, err := s.conn.Write([]byte(b.String()))
+    s.mu.Unlock()
+    if err != nil {
+        s.conn.Close()
+        return nil, err
+    }
+
+    select {
+    case m := <-ch:
+        return m, nil
+    case <-s.done:
+        return nil, s.err
+    case <-ctx.Done():
+        // Reply đến sau vẫn được readLoop lấy ra khỏi hàng đợi và bỏ qua.
+        return nil, ctx.Err()
+    }
+}
+
+func (s *session) readLoop(r *bufio.Reader, node string, onEvent func(Event)) {
+    err := s.read(r, node, onEvent)
+    s.mu.Lock()
+    s.err = err
+    s.mu.Unlock()
+    s.conn.Close()
+    close(s.done)
+}
+
+func (s *session) read(r *bufio.Reader, node string, onEvent func(Event)) error {
+    for {
+        m, err := readMessage(r)
+        if err != nil {
+            return err
+        }
+        switch m.contentType() {
+        case typeReply, typeAPIResponse:
+            s.mu.Lock()
+            var ch chan *message
+            if len(s.pending) > 0 {
+                ch, s.pending = s.pending[0], s.pending[1:]
+            }
+            s.mu.Unlock()
+            if ch != nil {
+                ch <- m
+            }
+        case typeEventJSON:
+            e, err := parseEvent(node, m.Body)
+            if err != nil {
+                log.Printf("esl %s: invalid event: %v", node, err)
+                continue
+            }
+            if e.Name() == "BACKGROUND_JOB" {
+                s.mu.Lock()
+                ch := s.jobs[e.Get("Job-UUID")]
+                s.mu.Unlock()
+                if ch != nil {
+                    select {
+                    case ch <- e:
+                    default:
+                    }
+                }
+            }
+            if onEvent != nil {
+                onEvent(e)
+            }
+        case typeDisconnect:
+            return errors.New("esl: disconnected by server")
+        }
+    }
+}
+
+// checkReply chuyển reply "-ERR ..." thành CommandError.
+func checkReply(reply string) (string, error) {
+    if strings.HasPrefix(reply, "-ERR") {
+        return "", &CommandError{Reply: strings.TrimSpace(reply)}
+    }
+    return reply, nil
+}
+
+// NewUUID tạo UUID v4 dùng làm Job-UUID hoặc call UUID.
+func NewUUID() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    b[6] = b[6]&0x0f | 0x40
+    b[8] = b[8]&0x3f | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,385 @@
+package esl
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// fakeServer là event socket tối giản: yêu cầu auth, trả lời event/api/bgapi
+// và cho phép test đẩy event hoặc ngắt kết nối.
+type fakeServer struct {
+    t        *testing.T
+    ln       net.Listener
+    password string
+
+    mu       sync.Mutex
+    conns    []net.Conn
+    accepted chan struct{}
+    commands chan string
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+    t.Helper()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := &fakeServer{
+        t:        t,
+        ln:       ln,
+        password: password,
+        accepted: make(chan struct{}, 10),
+        commands: make(chan string, 100),
+    }
+    go s.accept()
+    t.Cleanup(func() {
+        ln.Close()
+        s.mu.Lock()
+        for _, c := range s.conns {
+            c.Close()
+        }
+        s.mu.Unlock()
+    })
+    return s
+}
+
+func (s *fakeServer) addr() string {
+    return s.ln.Addr().String()
+}
+
+func (s *fakeServer) accept() {
+    for {
+        conn, err := s.ln.Accept()
+        if err != nil {
+            return
+        }
+        s.mu.Lock()
+        s.conns = append(s.conns, conn)
+        s.mu.Unlock()
+        go s.serve(conn)
+    }
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+    defer conn.Close()
+    r := bufio.NewReader(conn)
+    var wmu sync.Mutex
+    write := func(msg string) {
+        wmu.Lock()
+        defer wmu.Unlock()
+        _, _ = conn.Write([]byte(msg))
+    }
+    write("Content-Type: auth/request\n\n")
+
+    for {
+        cmd, headers, err := readCommand(r)
+        if err != nil {
+            return
+        }
+        s.commands <- cmd
+
+        switch {
+        case strings.HasPrefix(cmd, "auth "):
+            if strings.TrimPrefix(cmd, "auth ") != s.password {
+                write("Content-Type: command/reply\nReply-Text: -ERR invalid\n\n")
+                write("Content-Type: text/disconnect-notice\n\n")
+                return
+            }
+            write("Content-Type: command/reply\nReply-Text: +OK accepted\n\n")
+            s.accepted <- struct{}{}
+        case strings.HasPrefix(cmd, "event json "):
+            write("Content-Type: command/reply\nReply-Text: +OK event listener enabled json\n\n")
+        case strings.HasPrefix(cmd, "api "):
+            body := apiResult(strings.TrimPrefix(cmd, "api "))
+            write(fmt.Sprintf("Content-Type: api/response\nContent-Length: %d\n\n%s", len(body), body))
+        case strings.HasPrefix(cmd, "bgapi "):
+            job := headers["Job-UUID"]
+            write("Content-Type: command/reply\nReply-Text: +OK Job-UUID: " + job + "\nJob-UUID: " + job + "\n\n")
+            go func() {
+                time.Sleep(20 * time.Millisecond)
+                body := fmt.Sprintf(`{"Event-Name":"BACKGROUND_JOB","Job-UUID":%q,"_body":%q}`,
+                    job, apiResult(strings.TrimPrefix(cmd, "bgapi ")))
+                write(fmt.Sprintf("Content-Type: text/event-json\nContent-Length: %d\n\n%s", len(body), body))
+            }()
+        default:
+            write("Content-Type: command/reply\nReply-Text: -ERR command not found\n\n")
+        }
+    }
+}
+
+// readCommand đọc lệnh client gửi: dòng lệnh, các header "K: V", rồi dòng trống.
+func readCommand(r *bufio.Reader) (string, map[string]string, error) {
+    var cmd string
+    headers := map[string]string{}
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return "", nil, err
+        }
+        line = strings.TrimRight(line, "\r\n")
+        if line == "" {
+            if cmd == "" {
+                continue
+            }
+            return cmd, headers, nil
+        }
+        if cmd == "" {
+            cmd = line
+            continue
+        }
+        k, v, _ := strings.Cut(line, ":")
+        headers[k] = strings.TrimSpace(v)
+    }
+}
+
+func apiResult(cmd string) string {
+    switch cmd {
+    case "status":
+        return "UP 0 years, 0 days\n"
+    case "slow":
+        time.Sleep(200 * time.Millisecond)
+        return "+OK slow\n"
+    default:
+        return "-ERR " + cmd + " Command not found!\n"
+    }
+}
+
+// push gửi event tới mọi kết nối đang mở.
+func (s *fakeServer) push(event map[string]string) {
+    var parts []string
+    for k, v := range event {
+        parts = append(parts, fmt.Sprintf("%q:%q", k, v))
+    }
+    body := "{" + strings.Join(parts, ",") + "}"
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, c := range s.conns {
+        _, _ = c.Write([]byte(fmt.Sprintf("Content-Type: text/event-json\nContent-Length: %d\n\n%s", len(body), body)))
+    }
+}
+
+// dropAll đóng mọi kết nối để kiểm tra reconnect.
+func (s *fakeServer) dropAll() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, c := range s.conns {
+        c.Close()
+    }
+    s.conns = nil
+}
+
+func (s *fakeServer) waitAccepted(t *testing.T) {
+    t.Helper()
+    select {
+    case <-s.accepted:
+    case <-time.After(2 * time.Second):
+        t.Fatal("client did not authenticate")
+    }
+}
+
+func startClient(t *testing.T, c *Client) {
+    t.Helper()
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        c.Run(ctx)
+        close(done)
+    }()
+    t.Cleanup(func() {
+        cancel()
+        <-done
+    })
+}
+
+func waitConnected(t *testing.T, c *Client) {
+    t.Helper()
+    deadline := time.Now().Add(2 * time.Second)
+    for !c.Connected() {
+        if time.Now().After(deadline) {
+            t.Fatal("client not connected")
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+func TestClientAPI(t *testing.T) {
+    srv := newFakeServer(t, "ClueCon")
+    c := &Client{Node: "fs1", Addr: srv.addr(), Password: "ClueCon", Events: []string{"CHANNEL_CREATE"}}
+    startClient(t, c)
+    srv.waitAccepted(t)
+    waitConnected(t, c)
+
+    ctx := context.Background()
+    out, err := c.API(ctx, "status")
+    if err != nil {
+        t.Fatalf("api status: %v", err)
+    }
+    if !strings.HasPrefix(out, "UP") {
+        t.Fatalf("api status = %q", out)
+    }
+
+    _, err = c.API(ctx, "nope")
+    var cmdErr *CommandError
+    if !errors.As(err, &cmdErr) {
+        t.Fatalf("api nope: expected CommandError, got %v", err)
+    }
+}
+
+func TestClientSubscribesEvents(t *testing.T) {
+    srv := newFakeServer(t, "ClueCon")
+    c := &Client{Node: "fs1", Addr: srv.addr(), Password: "ClueCon", Events: []string{"CHANNEL_CREATE", "CHANNEL_HANGUP_COMPLETE"}}
+    startClient(t, c)
+    srv.waitAccepted(t)
+
+    deadline := time.After(2 * time.Second)
+    for {
+        select {
+        case cmd := <-srv.commands:
+            if strings.HasPrefix(cmd, "event json ") {
+                if cmd != "event json BACKGROUND_JOB CHANNEL_CREATE CHANNEL_HANGUP_COMPLETE" {
+                    t.Fatalf("subscription = %q", cmd)
+                }
+                return
+            }
+        case <-deadline:
+            t.Fatal("no event subscription")
+        }
+    }
+}
+
+func TestClientBgAPIConcurrent(t *testing.T) {
+    srv := newFakeServer(t, "ClueCon")
+    c := &Client{Node: "fs1", Addr: srv.addr(), Password: "ClueCon"}
+    startClient(t, c)
+    srv.waitAccepted(t)
+    waitConnected(t, c)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+
+    // bgapi chậm không được chặn api chạy song song trên cùng kết nối.
+    var wg sync.WaitGroup
+    errs := make(chan error, 2)
+    wg.Add(2)
+    go func() {
+        defer wg.Done()
+        out, err := c.BgAPI(ctx, "slow")
+        if err == nil && out != "+OK slow\n" {
+            err = fmt.Errorf("bgapi slow = %q", out)
+        }
+        errs <- err
+    }()
+    go func() {
+        defer wg.Done()
+        _, err := c.API(ctx, "status")
+        errs <- err
+    }()
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestManagerEventsAndReconnect(t *testing.T) {
+    srv := newFakeServer(t, "ClueCon")
+    m := &Manager{clients: map[string]*Client{}, subs: map[int]chan Event{}}
+    c := &Client{
+        Node:         "fs1",
+        Addr:         srv.addr(),
+        Password:     "ClueCon",
+        ReconnectMin: 10 * time.Millisecond,
+        ReconnectMax: 20 * time.Millisecond,
+        OnEvent:      m.publish,
+        OnConnect:    m.connected,
+    }
+    m.clients["fs1"] = c
+
+    connects := make(chan string, 10)
+    m.OnConnect(func(ctx context.Context, node string) { connects <- node })
+    events, cancelSub := m.Subscribe(10)
+    defer cancelSub()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        m.Run(ctx)
+        close(done)
+    }()
+    defer func() {
+        cancel()
+        <-done
+    }()
+
+    for i := 0; i < 2; i++ {
+        srv.waitAccepted(t)
+        select {
+        case node := <-connects:
+            if node != "fs1" {
+                t.Fatalf("connect hook node = %q", node)
+            }
+        case <-time.After(2 * time.Second):
+            t.Fatal("connect hook not called")
+        }
+        waitConnected(t, c)
+
+        srv.push(map[string]string{"Event-Name": "CHANNEL_CREATE", "Unique-ID": fmt.Sprintf("call-%d", i)})
+        select {
+        case e := <-events:
+            if e.Node != "fs1" || e.Name() != "CHANNEL_CREATE" || e.Get("Unique-ID") != fmt.Sprintf("call-%d", i) {
+                t.Fatalf("unexpected event %+v", e)
+            }
+        case <-time.After(2 * time.Second):
+            t.Fatal("event not delivered")
+        }
+
+        if i == 0 {
+            srv.dropAll()
+        }
+    }
+
+    if st := m.Nodes(); len(st) != 1 || !st[0].Connected {
+        t.Fatalf("nodes = %+v", st)
+    }
+}
+
+func TestClientAuthFailure(t *testing.T) {
+    srv := newFakeServer(t, "ClueCon")
+    c := &Client{Node: "fs1", Addr: srv.addr(), Password: "wrong", DialTimeout: time.Second}
+    _, err := c.connect(context.Background())
+    if !errors.Is(err, ErrAuth) {
+        t.Fatalf("expected ErrAuth, got %v", err)
+    }
+    if _, err := c.API(context.Background(), "status"); !errors.Is(err, ErrNotConnected) {
+        t.Fatalf("expected ErrNotConnected, got %v", err)
+    }
+}
+
+func TestReadMessage(t *testing.T) {
+    raw := "Content-Type: api/response\nContent-Length: 5\n\nhello\n\nContent-Type: command/reply\nReply-Text: %2BOK%20done\n\n"
+    r := bufio.NewReader(strings.NewReader(raw))
+
+    m, err := readMessage(r)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if m.contentType() != typeAPIResponse || string(m.Body) != "hello" {
+        t.Fatalf("first message = %+v", m)
+    }
+    m, err = readMessage(r)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if m.replyText() != "+OK done" {
+        t.Fatalf("reply text = %q", m.replyText())
+    }
+}
@@ -0,0 +1,157 @@
+package esl
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+
+    "voip-admin/internal/config"
+)
+
+// Manager giữ một Client cho mỗi node FreeSWITCH và phân phối event của mọi
+// node tới các subscriber trong service.
+type Manager struct {
+    clients map[string]*Client
+
+    mu     sync.Mutex
+    subs   map[int]chan Event
+    nextID int
+    hooks  []func(ctx context.Context, node string)
+}
+
+// NodeStatus là trạng thái kết nối của một node.
+type NodeStatus struct {
+    Node      string `json:"node"`
+    Addr      string `json:"addr"`
+    Connected bool   `json:"connected"`
+}
+
+// NewManager tạo client cho các node trong cfg; kết nối chỉ bắt đầu khi gọi Run.
+func NewManager(cfg config.ESLConfig) *Manager {
+    m := &Manager{
+        clients: make(map[string]*Client, len(cfg.Nodes)),
+        subs:    make(map[int]chan Event),
+    }
+    for _, n := range cfg.Nodes {
+        m.clients[n.Name] = &Client{
+            Node:         n.Name,
+            Addr:         n.Addr,
+            Password:     n.Password,
+            Events:       cfg.Events,
+            DialTimeout:  cfg.DialTimeout,
+            ReconnectMin: cfg.ReconnectMin,
+            ReconnectMax: cfg.ReconnectMax,
+            OnEvent:      m.publish,
+            OnConnect:    m.connected,
+        }
+    }
+    return m
+}
+
+// Run chạy client của mọi node tới khi ctx bị hủy.
+func (m *Manager) Run(ctx context.Context) {
+    var wg sync.WaitGroup
+    for _, c := range m.clients {
+        wg.Add(1)
+        go func(c *Client) {
+            defer wg.Done()
+            c.Run(ctx)
+        }(c)
+    }
+    wg.Wait()
+}
+
+// Subscribe đăng ký nhận event của mọi node. Event bị bỏ (có log) khi kênh đầy
+// để một subscriber chậm không chặn các node; gọi cancel để hủy đăng ký.
+func (m *Manager) Subscribe(buffer int) (<-chan Event, func()) {
+    ch := make(chan Event, buffer)
+    m.mu.Lock()
+    id := m.nextID
+    m.nextID++
+    m.subs[id] = ch
+    m.mu.Unlock()
+
+    var once sync.Once
+    return ch, func() {
+        once.Do(func() {
+            m.mu.Lock()
+            delete(m.subs, id)
+            m.mu.Unlock()
+            close(ch)
+        })
+    }
+}
+
+// OnConnect đăng ký hàm được gọi mỗi khi một node kết nối (lại) thành công.
+func (m *Manager) OnConnect(fn func(ctx context.Context, node string)) {
+    m.mu.Lock()
+    m.hooks = append(m.hooks, fn)
+    m.mu.Unlock()
+}
+
+// Client trả client của node.
+func (m *Manager) Client(node string) (*Client, bool) {
+    c, ok := m.clients[node]
+    return c, ok
+}
+
+// Nodes trả trạng thái các node theo tên.
+func (m *Manager) Nodes() []NodeStatus {
+    out := make([]NodeStatus, 0, len(m.clients))
+    for _, c := range m.clients {
+        out = append(out, NodeStatus{Node: c.Node, Addr: c.Addr, Connected: c.Connected()})
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+    return out
+}
+
+// PickNode trả node đang kết nối đầu tiên theo tên, rỗng nếu không node nào kết nối.
+func (m *Manager) PickNode() string {
+    for _, n := range m.Nodes() {
+        if n.Connected {
+            return n.Node
+        }
+    }
+    return ""
+}
+
+// API chạy lệnh api trên node.
+func (m *Manager) API(ctx context.Context, node, cmd string) (string, error) {
+    c, ok := m.clients[node]
+    if !ok {
+        return "", fmt.Errorf("esl: unknown node %q", node)
+    }
+    return c.API(ctx, cmd)
+}
+
+// BgAPI chạy lệnh bgapi trên node và chờ kết quả.
+func (m *Manager) BgAPI(ctx context.Context, node, cmd string) (string, error) {
+    c, ok := m.clients[node]
+    if !ok {
+        return "", fmt.Errorf("esl: unknown node %q", node)
+    }
+    return c.BgAPI(ctx, cmd)
+}
+
+func (m *Manager) publish(e Event) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, ch := range m.subs {
+        select {
+        case ch <- e:
+        default:
+            log.Printf("esl %s: subscriber queue full, dropped %s", e.Node, e.Name())
+        }
+    }
+}
+
+func (m *Manager) connected(ctx context.Context, c *Client) {
+    m.mu.Lock()
+    hooks := append([]func(context.Context, string){}, m.hooks...)
+    m.mu.Unlock()
+    for _, fn := range hooks {
+        fn(ctx, c.Node)
+    }
+}
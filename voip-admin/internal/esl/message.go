@@ -0,0 +1,115 @@
+package esl
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/url"
+    "strconv"
+    "strings"
+)
+
+// Content-Type của các message event socket.
+const (
+    typeAuthRequest = "auth/request"
+    typeReply       = "command/reply"
+    typeAPIResponse = "api/response"
+    typeEventJSON   = "text/event-json"
+    typeDisconnect  = "text/disconnect-notice"
+)
+
+// message là một message event socket: khối header kết thúc bằng dòng trống,
+// tiếp theo là body dài Content-Length byte (nếu có).
+type message struct {
+    Headers map[string]string
+    Body    []byte
+}
+
+func (m *message) contentType() string {
+    return m.Headers["Content-Type"]
+}
+
+// replyText trả Reply-Text của command/reply, hoặc body của api/response.
+func (m *message) replyText() string {
+    if m.contentType() == typeAPIResponse {
+        return string(m.Body)
+    }
+    return m.Headers["Reply-Text"]
+}
+
+func readMessage(r *bufio.Reader) (*message, error) {
+    m := &message{Headers: make(map[string]string)}
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return nil, err
+        }
+        line = strings.TrimRight(line, "\r\n")
+        if line == "" {
+            if len(m.Headers) == 0 {
+                // Dòng trống thừa giữa các message.
+                continue
+            }
+            break
+        }
+        k, v, ok := strings.Cut(line, ":")
+        if !ok {
+            return nil, fmt.Errorf("malformed header %q", line)
+        }
+        v = strings.TrimSpace(v)
+        if dec, err := url.PathUnescape(v); err == nil {
+            v = dec
+        }
+        m.Headers[k] = v
+    }
+
+    if s, ok := m.Headers["Content-Length"]; ok {
+        n, err := strconv.Atoi(s)
+        if err != nil || n < 0 {
+            return nil, fmt.Errorf("invalid Content-Length %q", s)
+        }
+        m.Body = make([]byte, n)
+        if _, err := io.ReadFull(r, m.Body); err != nil {
+            return nil, err
+        }
+    }
+    return m, nil
+}
+
+// Event là một event FreeSWITCH nhận qua "event json", kèm node phát sinh.
+type Event struct {
+    Node    string
+    Headers map[string]string
+}
+
+// Name trả Event-Name, ví dụ CHANNEL_ANSWER.
+func (e Event) Name() string {
+    return e.Headers["Event-Name"]
+}
+
+// Get trả giá trị header, rỗng nếu không có.
+func (e Event) Get(name string) string {
+    return e.Headers[name]
+}
+
+// Body trả body của event (ví dụ kết quả của BACKGROUND_JOB).
+func (e Event) Body() string {
+    return e.Headers["_body"]
+}
+
+func parseEvent(node string, body []byte) (Event, error) {
+    var raw map[string]interface{}
+    if err := json.Unmarshal(body, &raw); err != nil {
+        return Event{}, err
+    }
+    e := Event{Node: node, Headers: make(map[string]string, len(raw))}
+    for k, v := range raw {
+        if s, ok := v.(string); ok {
+            e.Headers[k] = s
+        } else {
+            e.Headers[k] = fmt.Sprint(v)
+        }
+    }
+    return e, nil
+}
@@ -0,0 +1,162 @@
+package export
+
+import (
+    "bufio"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "strconv"
+    "time"
+)
+
+// Writer ghi dữ liệu dạng bảng theo từng dòng, không giữ toàn bộ kết quả trong bộ nhớ.
+// Giá trị của một ô có thể là nil, string, int64, bool hoặc time.Time.
+type Writer interface {
+    WriteHeader(columns []string) error
+    WriteRow(values []interface{}) error
+    // Flush đẩy dữ liệu đã đệm xuống writer bên dưới.
+    Flush() error
+    // Close ghi phần kết thúc của định dạng (nếu có) và flush.
+    Close() error
+}
+
+// Format mô tả một định dạng export.
+type Format struct {
+    Name        string
+    ContentType string
+    Extension   string
+    // MaxRows là số dòng dữ liệu tối đa (không gồm tiêu đề), 0 là không giới hạn.
+    MaxRows int
+    New     func(w io.Writer, loc *time.Location) Writer
+}
+
+var formats = map[string]Format{
+    "csv": {
+        Name:        "csv",
+        ContentType: "text/csv; charset=utf-8",
+        Extension:   "csv",
+        New:         NewCSVWriter,
+    },
+    "ndjson": {
+        Name:        "ndjson",
+        ContentType: "application/x-ndjson",
+        Extension:   "ndjson",
+        New:         NewNDJSONWriter,
+    },
+    "xlsx": {
+        Name:        "xlsx",
+        ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+        Extension:   "xlsx",
+        MaxRows:     MaxXLSXRows - 1,
+        New:         NewXLSXWriter,
+    },
+}
+
+// LookupFormat trả về định dạng theo tên (csv, ndjson, xlsx).
+func LookupFormat(name string) (Format, bool) {
+    f, ok := formats[name]
+    return f, ok
+}
+
+// TimeLayout là layout dùng cho timestamp trong CSV/XLSX.
+const TimeLayout = "2006-01-02 15:04:05"
+
+func formatCell(v interface{}, loc *time.Location, layout string) string {
+    switch t := v.(type) {
+    case nil:
+        return ""
+    case string:
+        return t
+    case int64:
+        return strconv.FormatInt(t, 10)
+    case int32:
+        return strconv.FormatInt(int64(t), 10)
+    case bool:
+        return strconv.FormatBool(t)
+    case time.Time:
+        return t.In(loc).Format(layout)
+    default:
+        return fmt.Sprint(t)
+    }
+}
+
+type csvWriter struct {
+    w   *csv.Writer
+    loc *time.Location
+    buf []string
+}
+
+func NewCSVWriter(w io.Writer, loc *time.Location) Writer {
+    return &csvWriter{w: csv.NewWriter(w), loc: loc}
+}
+
+func (c *csvWriter) WriteHeader(columns []string) error {
+    return c.w.Write(columns)
+}
+
+func (c *csvWriter) WriteRow(values []interface{}) error {
+    c.buf = c.buf[:0]
+    for _, v := range values {
+        c.buf = append(c.buf, formatCell(v, c.loc, TimeLayout))
+    }
+    return c.w.Write(c.buf)
+}
+
+func (c *csvWriter) Flush() error {
+    c.w.Flush()
+    return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+    return c.Flush()
+}
+
+type ndjsonWriter struct {
+    w       *bufio.Writer
+    loc     *time.Location
+    columns []string
+}
+
+func NewNDJSONWriter(w io.Writer, loc *time.Location) Writer {
+    return &ndjsonWriter{w: bufio.NewWriter(w), loc: loc}
+}
+
+func (n *ndjsonWriter) WriteHeader(columns []string) error {
+    n.columns = columns
+    return nil
+}
+
+// WriteRow ghi một object JSON trên một dòng, giữ thứ tự cột đã chọn.
+func (n *ndjsonWriter) WriteRow(values []interface{}) error {
+    if err := n.w.WriteByte('{'); err != nil {
+        return err
+    }
+    for i, v := range values {
+        if i > 0 {
+            n.w.WriteByte(',')
+        }
+        key, _ := json.Marshal(n.columns[i])
+        n.w.Write(key)
+        n.w.WriteByte(':')
+
+        if t, ok := v.(time.Time); ok {
+            v = t.In(n.loc).Format(time.RFC3339)
+        }
+        val, err := json.Marshal(v)
+        if err != nil {
+            return err
+        }
+        n.w.Write(val)
+    }
+    n.w.WriteByte('}')
+    return n.w.WriteByte('\n')
+}
+
+func (n *ndjsonWriter) Flush() error {
+    return n.w.Flush()
+}
+
+func (n *ndjsonWriter) Close() error {
+    return n.w.Flush()
+}
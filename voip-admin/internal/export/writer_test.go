@@ -0,0 +1,150 @@
+package export
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/xml"
+    "io"
+    "strings"
+    "testing"
+    "time"
+)
+
+var (
+    testLoc  = time.FixedZone("ICT", 7*3600)
+    testTime = time.Date(2025, 3, 1, 1, 2, 3, 0, time.UTC)
+    testRows = [][]interface{}{
+        {int64(1), "0901234567", testTime, true},
+        {int32(2), nil, testTime.Add(time.Hour), false},
+        {int64(3), `a "quoted", <tagged> & multi
+line`, nil, nil},
+    }
+)
+
+func writeAll(t *testing.T, w Writer) {
+    t.Helper()
+    if err := w.WriteHeader([]string{"id", "caller", "start_time", "answered"}); err != nil {
+        t.Fatal(err)
+    }
+    for _, r := range testRows {
+        if err := w.WriteRow(r); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := w.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestCSVWriter(t *testing.T) {
+    var buf bytes.Buffer
+    writeAll(t, NewCSVWriter(&buf, testLoc))
+
+    want := "id,caller,start_time,answered\n" +
+        "1,0901234567,2025-03-01 08:02:03,true\n" +
+        "2,,2025-03-01 09:02:03,false\n" +
+        "3,\"a \"\"quoted\"\", <tagged> & multi\nline\",,\n"
+    if got := buf.String(); got != want {
+        t.Errorf("csv =\n%s\nwant\n%s", got, want)
+    }
+}
+
+func TestNDJSONWriter(t *testing.T) {
+    var buf bytes.Buffer
+    writeAll(t, NewNDJSONWriter(&buf, testLoc))
+
+    want := `{"id":1,"caller":"0901234567","start_time":"2025-03-01T08:02:03+07:00","answered":true}` + "\n" +
+        `{"id":2,"caller":null,"start_time":"2025-03-01T09:02:03+07:00","answered":false}` + "\n" +
+        `{"id":3,"caller":"a \"quoted\", \u003ctagged\u003e \u0026 multi\nline","start_time":null,"answered":null}` + "\n"
+    if got := buf.String(); got != want {
+        t.Errorf("ndjson =\n%s\nwant\n%s", got, want)
+    }
+}
+
+// xlsxSheet là phần sheet1.xml cần kiểm tra.
+type xlsxSheet struct {
+    Rows []struct {
+        R     int `xml:"r,attr"`
+        Cells []struct {
+            T      string `xml:"t,attr"`
+            V      string `xml:"v"`
+            Inline string `xml:"is>t"`
+        } `xml:"c"`
+    } `xml:"sheetData>row"`
+}
+
+func TestXLSXWriter(t *testing.T) {
+    var buf bytes.Buffer
+    writeAll(t, NewXLSXWriter(&buf, testLoc))
+
+    zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    var sheet []byte
+    for _, f := range zr.File {
+        names = append(names, f.Name)
+        if f.Name != "xl/worksheets/sheet1.xml" {
+            continue
+        }
+        rc, err := f.Open()
+        if err != nil {
+            t.Fatal(err)
+        }
+        sheet, err = io.ReadAll(rc)
+        rc.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    if got := strings.Join(names, ","); got != "[Content_Types].xml,_rels/.rels,xl/workbook.xml,xl/_rels/workbook.xml.rels,xl/worksheets/sheet1.xml" {
+        t.Fatalf("zip entries = %s", got)
+    }
+
+    var s xlsxSheet
+    if err := xml.Unmarshal(sheet, &s); err != nil {
+        t.Fatalf("sheet1.xml: %v", err)
+    }
+    if len(s.Rows) != 4 {
+        t.Fatalf("%d rows, want 4", len(s.Rows))
+    }
+    cell := func(row, col int) string {
+        c := s.Rows[row].Cells[col]
+        if c.T == "inlineStr" {
+            return "s:" + c.Inline
+        }
+        return c.V
+    }
+    checks := []struct {
+        row, col int
+        want     string
+    }{
+        {0, 0, "s:id"},
+        {1, 0, "1"},
+        {1, 1, "s:0901234567"},
+        {1, 2, "s:2025-03-01 08:02:03"},
+        {1, 3, "s:true"},
+        {2, 0, "2"},
+        {2, 1, ""},
+        {3, 1, "s:a \"quoted\", <tagged> & multi\nline"},
+    }
+    for _, c := range checks {
+        if got := cell(c.row, c.col); got != c.want {
+            t.Errorf("cell(%d,%d) = %q, want %q", c.row, c.col, got, c.want)
+        }
+    }
+    for i, r := range s.Rows {
+        if r.R != i+1 {
+            t.Errorf("row %d has r=%d", i, r.R)
+        }
+    }
+}
+
+func TestXLSXWriterTooManyRows(t *testing.T) {
+    x := NewXLSXWriter(io.Discard, time.UTC).(*xlsxWriter)
+    x.rows = MaxXLSXRows
+    if err := x.WriteRow([]interface{}{int64(1)}); err != ErrTooManyRows {
+        t.Errorf("err = %v, want ErrTooManyRows", err)
+    }
+}
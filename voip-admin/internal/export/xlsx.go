@@ -0,0 +1,146 @@
+package export
+
+import (
+    "archive/zip"
+    "bufio"
+    "encoding/xml"
+    "errors"
+    "io"
+    "strconv"
+    "time"
+)
+
+// MaxXLSXRows là giới hạn số dòng của một worksheet Excel (gồm cả dòng tiêu đề).
+const MaxXLSXRows = 1048576
+
+// ErrTooManyRows được trả về khi kết quả vượt quá số dòng tối đa của XLSX.
+var ErrTooManyRows = errors.New("xlsx: too many rows")
+
+// xlsxWriter ghi workbook một sheet dạng streaming: các phần cố định được ghi
+// ngay, sheet1.xml được ghi dần vào zip entry, không cần shared strings.
+type xlsxWriter struct {
+    zw    *zip.Writer
+    sheet *bufio.Writer
+    loc   *time.Location
+    rows  int
+    err   error
+}
+
+const (
+    xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+    xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+    xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="CDR" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+    xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+    xlsxSheetHead = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+    xlsxSheetTail = `</sheetData></worksheet>`
+)
+
+func NewXLSXWriter(w io.Writer, loc *time.Location) Writer {
+    x := &xlsxWriter{zw: zip.NewWriter(w), loc: loc}
+
+    parts := []struct{ name, body string }{
+        {"[Content_Types].xml", xlsxContentTypes},
+        {"_rels/.rels", xlsxRootRels},
+        {"xl/workbook.xml", xlsxWorkbook},
+        {"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+    }
+    for _, p := range parts {
+        f, err := x.zw.Create(p.name)
+        if err != nil {
+            x.err = err
+            return x
+        }
+        if _, err := io.WriteString(f, p.body); err != nil {
+            x.err = err
+            return x
+        }
+    }
+
+    // sheet1.xml phải là entry cuối cùng vì được ghi dần tới khi Close.
+    f, err := x.zw.Create("xl/worksheets/sheet1.xml")
+    if err != nil {
+        x.err = err
+        return x
+    }
+    x.sheet = bufio.NewWriter(f)
+    _, x.err = x.sheet.WriteString(xlsxSheetHead)
+    return x
+}
+
+func (x *xlsxWriter) WriteHeader(columns []string) error {
+    values := make([]interface{}, len(columns))
+    for i, c := range columns {
+        values[i] = c
+    }
+    return x.WriteRow(values)
+}
+
+func (x *xlsxWriter) WriteRow(values []interface{}) error {
+    if x.err != nil {
+        return x.err
+    }
+    if x.rows >= MaxXLSXRows {
+        return ErrTooManyRows
+    }
+    x.rows++
+
+    x.sheet.WriteString(`<row r="`)
+    x.sheet.WriteString(strconv.Itoa(x.rows))
+    x.sheet.WriteString(`">`)
+    for _, v := range values {
+        switch t := v.(type) {
+        case nil:
+            x.sheet.WriteString(`<c/>`)
+        case int64, int32:
+            x.sheet.WriteString(`<c><v>`)
+            x.sheet.WriteString(formatCell(t, x.loc, TimeLayout))
+            x.sheet.WriteString(`</v></c>`)
+        default:
+            x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+            if err := xml.EscapeText(x.sheet, []byte(formatCell(t, x.loc, TimeLayout))); err != nil {
+                x.err = err
+                return err
+            }
+            x.sheet.WriteString(`</t></is></c>`)
+        }
+    }
+    _, x.err = x.sheet.WriteString(`</row>`)
+    return x.err
+}
+
+// Flush chỉ đẩy buffer của sheet; zip writer tự ghi ra ngoài khi đủ dữ liệu nén.
+func (x *xlsxWriter) Flush() error {
+    if x.err != nil {
+        return x.err
+    }
+    return x.sheet.Flush()
+}
+
+func (x *xlsxWriter) Close() error {
+    if x.err != nil {
+        return x.err
+    }
+    if _, err := x.sheet.WriteString(xlsxSheetTail); err != nil {
+        return err
+    }
+    if err := x.sheet.Flush(); err != nil {
+        return err
+    }
+    return x.zw.Close()
+}
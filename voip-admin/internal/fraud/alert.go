@@ -0,0 +1,114 @@
+package fraud
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+    // ErrNotFound được trả về khi cảnh báo không tồn tại.
+    ErrNotFound = errors.New("fraud alert not found")
+    // ErrAcknowledged được trả về khi cảnh báo đã được xác nhận trước đó.
+    ErrAcknowledged = errors.New("fraud alert already acknowledged")
+)
+
+// Alert là một lần rule chống gian lận kích hoạt, cũng là payload của webhook fraud.alert.
+// Value/Threshold là số cuộc gọi, hoặc tổng cước với rule cost_velocity.
+type Alert struct {
+    ID             int64      `json:"id"`
+    Rule           string     `json:"rule"`
+    RuleType       string     `json:"rule_type"`
+    DomainID       *int64     `json:"domain_id,omitempty"`
+    UserID         *int64     `json:"user_id,omitempty"`
+    Caller         string     `json:"caller"`
+    CDRID          int64      `json:"cdr_id"`
+    Value          float64    `json:"value"`
+    Threshold      float64    `json:"threshold"`
+    Blocked        bool       `json:"blocked"`
+    CreatedAt      time.Time  `json:"created_at"`
+    AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+}
+
+const alertColumns = `id, rule, rule_type, domain_id, user_id, caller, cdr_id,
+    value::float8, threshold::float8, blocked, created_at, acknowledged_at`
+
+func scanAlert(row pgx.Row) (*Alert, error) {
+    var a Alert
+    err := row.Scan(
+        &a.ID, &a.Rule, &a.RuleType, &a.DomainID, &a.UserID, &a.Caller, &a.CDRID,
+        &a.Value, &a.Threshold, &a.Blocked, &a.CreatedAt, &a.AcknowledgedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &a, nil
+}
+
+// List trả các cảnh báo trong [from, to] mới nhất trước; pendingOnly chỉ lấy
+// cảnh báo chưa được xác nhận.
+func List(ctx context.Context, pool *pgxpool.Pool, from, to time.Time, pendingOnly bool, limit int) ([]Alert, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT `+alertColumns+`
+        FROM voip.fraud_alerts
+        WHERE created_at >= $1 AND created_at <= $2
+          AND (NOT $3 OR acknowledged_at IS NULL)
+        ORDER BY created_at DESC, id DESC
+        LIMIT $4
+    `, from, to, pendingOnly, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Alert
+    for rows.Next() {
+        a, err := scanAlert(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, *a)
+    }
+    return out, rows.Err()
+}
+
+// Acknowledge đánh dấu cảnh báo đã xử lý; unblock kích hoạt lại user bị khóa
+// bởi cảnh báo này.
+func Acknowledge(ctx context.Context, pool *pgxpool.Pool, id int64, unblock bool) (*Alert, error) {
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    a, err := scanAlert(tx.QueryRow(ctx, `
+        SELECT `+alertColumns+` FROM voip.fraud_alerts WHERE id=$1 FOR UPDATE
+    `, id))
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if a.AcknowledgedAt != nil {
+        return nil, ErrAcknowledged
+    }
+
+    if err := tx.QueryRow(ctx, `
+        UPDATE voip.fraud_alerts SET acknowledged_at = now() WHERE id=$1 RETURNING acknowledged_at
+    `, id).Scan(&a.AcknowledgedAt); err != nil {
+        return nil, err
+    }
+    if unblock && a.Blocked && a.UserID != nil {
+        if _, err := tx.Exec(ctx, `UPDATE voip.users SET is_active = TRUE WHERE id=$1`, *a.UserID); err != nil {
+            return nil, err
+        }
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+    return a, nil
+}
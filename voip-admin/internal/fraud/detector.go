@@ -0,0 +1,254 @@
+package fraud
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/config"
+    "voip-admin/internal/webhook"
+)
+
+// concurrentLookback giới hạn khoảng quét CDR khi đếm cuộc gọi đồng thời.
+const concurrentLookback = 24 * time.Hour
+
+// Detector đánh giá các rule chống gian lận cước trên từng CDR vừa ingest.
+// Account là user trong click_to_call_user_id (cuộc gọi click-to-call, khi đó
+// caller_id_number là số đích), hoặc user có username trùng caller_id_number
+// trong cùng domain; CDR không map được về user (gọi vào từ PSTN) và leg B
+// được bỏ qua.
+type Detector struct {
+    Pool     *pgxpool.Pool
+    Rules    []config.FraudRule
+    Cooldown time.Duration
+}
+
+// NewDetector tạo Detector từ config, trả nil khi tính năng tắt hoặc không có rule.
+func NewDetector(pool *pgxpool.Pool, cfg config.FraudConfig) *Detector {
+    if !cfg.Enabled || len(cfg.Rules) == 0 {
+        return nil
+    }
+    return &Detector{Pool: pool, Rules: cfg.Rules, Cooldown: cfg.Cooldown}
+}
+
+// call là CDR đang được đánh giá cùng account tương ứng. Caller là username
+// của account.
+type call struct {
+    ID          int64
+    DomainID    *int64
+    UserID      int64
+    Caller      string
+    Destination string
+    StartTime   time.Time
+}
+
+// Check đánh giá mọi rule cho CDR cdrID. Lỗi của một rule được log và không
+// chặn các rule còn lại.
+func (d *Detector) Check(ctx context.Context, cdrID int64) error {
+    var (
+        c   call
+        leg string
+    )
+    err := d.Pool.QueryRow(ctx, `
+        SELECT c.id, c.domain_id, u.id, u.username,
+               COALESCE(c.destination_number, ''), c.start_time, c.leg
+        FROM voip.cdr c
+        JOIN voip.users u ON u.id = c.click_to_call_user_id
+            OR (c.click_to_call_user_id IS NULL AND u.domain_id = c.domain_id AND u.username = c.caller_id_number)
+        WHERE c.id = $1
+    `, cdrID).Scan(&c.ID, &c.DomainID, &c.UserID, &c.Caller, &c.Destination, &c.StartTime, &leg)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if leg != "A" {
+        return nil
+    }
+
+    for _, rule := range d.Rules {
+        if err := d.checkRule(ctx, rule, c); err != nil {
+            log.Printf("fraud rule %s on cdr %d: %v", rule.Name, c.ID, err)
+        }
+    }
+    return nil
+}
+
+func (d *Detector) checkRule(ctx context.Context, rule config.FraudRule, c call) error {
+    patterns := prefixPatterns(rule.Prefixes)
+    if !matchesPrefix(c.Destination, rule.Prefixes) {
+        return nil
+    }
+
+    var (
+        value     float64
+        threshold = float64(rule.Threshold)
+        err       error
+    )
+    switch rule.Type {
+    case config.FraudDestinationSpike:
+        value, err = d.aggregate(ctx, "COUNT(*)", c, c.StartTime.Add(-rule.Window), patterns, "")
+    case config.FraudUnusualHours:
+        loc := time.UTC
+        if rule.TZ != "" {
+            if loc, err = time.LoadLocation(rule.TZ); err != nil {
+                return err
+            }
+        }
+        if !inHours(c.StartTime.In(loc).Hour(), rule.HourFrom, rule.HourTo) {
+            return nil
+        }
+        value, err = d.aggregate(ctx, "COUNT(*)", c, c.StartTime.Add(-rule.Window), patterns,
+            hoursCondition(loc.String(), rule.HourFrom, rule.HourTo))
+    case config.FraudConcurrentCalls:
+        value, err = d.aggregate(ctx, "COUNT(*)", c, c.StartTime.Add(-concurrentLookback), patterns,
+            "(end_time IS NULL OR end_time > $4)")
+    case config.FraudCostVelocity:
+        threshold = rule.MaxCost
+        value, err = d.aggregate(ctx, "COALESCE(SUM(cost), 0)", c, c.StartTime.Add(-rule.Window), patterns, "")
+    default:
+        return fmt.Errorf("unknown rule type %q", rule.Type)
+    }
+    if err != nil {
+        return err
+    }
+    if value < threshold {
+        return nil
+    }
+    return d.trigger(ctx, rule, c, value, threshold)
+}
+
+// aggregate tính expr trên các cuộc gọi leg A của account (gọi trực tiếp hoặc
+// click-to-call) trong (since, start_time của CDR hiện tại], lọc theo prefix
+// đích. extra là điều kiện bổ sung, có thể dùng $4 (start_time của CDR hiện tại).
+func (d *Detector) aggregate(ctx context.Context, expr string, c call, since time.Time, patterns []string, extra string) (float64, error) {
+    if extra != "" {
+        extra = " AND " + extra
+    }
+    var v float64
+    err := d.Pool.QueryRow(ctx, `
+        SELECT (`+expr+`)::float8
+        FROM voip.cdr
+        WHERE (click_to_call_user_id = $6
+               OR (click_to_call_user_id IS NULL AND domain_id IS NOT DISTINCT FROM $1 AND caller_id_number = $2))
+          AND leg = 'A'
+          AND start_time > $3 AND start_time <= $4
+          AND (cardinality($5::text[]) = 0 OR destination_number LIKE ANY($5))`+extra,
+        c.DomainID, c.Caller, since, c.StartTime, patterns, c.UserID).Scan(&v)
+    return v, err
+}
+
+// trigger ghi cảnh báo (tôn trọng Cooldown), gửi webhook fraud.alert và khóa
+// user nếu rule yêu cầu, tất cả trong một transaction.
+func (d *Detector) trigger(ctx context.Context, rule config.FraudRule, c call, value, threshold float64) error {
+    tx, err := d.Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    // Khóa theo rule + account để hai CDR ingest đồng thời không cùng tạo cảnh báo.
+    if _, err := tx.Exec(ctx, `
+        SELECT pg_advisory_xact_lock(hashtext('fraud/' || $1::text || '/' || $2::text))
+    `, rule.Name, c.Caller); err != nil {
+        return err
+    }
+    var recent bool
+    if err := tx.QueryRow(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM voip.fraud_alerts
+            WHERE rule = $1 AND domain_id IS NOT DISTINCT FROM $2 AND caller = $3
+              AND created_at > $4
+        )
+    `, rule.Name, c.DomainID, c.Caller, time.Now().Add(-d.Cooldown)).Scan(&recent); err != nil {
+        return err
+    }
+    if recent {
+        return nil
+    }
+
+    blocked := false
+    if rule.Block {
+        tag, err := tx.Exec(ctx, `UPDATE voip.users SET is_active = FALSE WHERE id = $1 AND is_active`, c.UserID)
+        if err != nil {
+            return err
+        }
+        blocked = tag.RowsAffected() > 0
+    }
+
+    a := Alert{
+        Rule:      rule.Name,
+        RuleType:  rule.Type,
+        DomainID:  c.DomainID,
+        UserID:    &c.UserID,
+        Caller:    c.Caller,
+        CDRID:     c.ID,
+        Value:     value,
+        Threshold: threshold,
+        Blocked:   blocked,
+    }
+    if err := tx.QueryRow(ctx, `
+        INSERT INTO voip.fraud_alerts (rule, rule_type, domain_id, user_id, caller, cdr_id, value, threshold, blocked)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id, created_at
+    `, a.Rule, a.RuleType, a.DomainID, a.UserID, a.Caller, a.CDRID, a.Value, a.Threshold, a.Blocked).Scan(&a.ID, &a.CreatedAt); err != nil {
+        return err
+    }
+    if err := webhook.Enqueue(ctx, tx, webhook.EventFraudAlert, a); err != nil {
+        return err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return err
+    }
+
+    log.Printf("ALERT: fraud rule %s (%s) triggered for %s: value %g >= %g, cdr %d, blocked=%t",
+        rule.Name, rule.Type, c.Caller, value, threshold, c.ID, blocked)
+    return nil
+}
+
+// prefixPatterns chuyển prefix sang pattern LIKE, escape ký tự đặc biệt.
+func prefixPatterns(prefixes []string) []string {
+    esc := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+    out := make([]string, 0, len(prefixes))
+    for _, p := range prefixes {
+        out = append(out, esc.Replace(p)+"%")
+    }
+    return out
+}
+
+func matchesPrefix(number string, prefixes []string) bool {
+    if len(prefixes) == 0 {
+        return true
+    }
+    for _, p := range prefixes {
+        if strings.HasPrefix(number, p) {
+            return true
+        }
+    }
+    return false
+}
+
+// inHours cho biết giờ h có thuộc [from, to) không, hỗ trợ khung qua nửa đêm.
+func inHours(h, from, to int) bool {
+    if from < to {
+        return h >= from && h < to
+    }
+    return h >= from || h < to
+}
+
+// hoursCondition tạo điều kiện SQL tương đương inHours trên start_time.
+// tz đã được kiểm tra bằng time.LoadLocation nên chỉ cần escape dấu nháy.
+func hoursCondition(tz string, from, to int) string {
+    h := fmt.Sprintf("EXTRACT(HOUR FROM start_time AT TIME ZONE '%s')", strings.ReplaceAll(tz, "'", "''"))
+    op := "AND"
+    if from >= to {
+        op = "OR"
+    }
+    return fmt.Sprintf("(%s >= %d %s %s < %d)", h, from, op, h, to)
+}
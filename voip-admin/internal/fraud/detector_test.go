@@ -0,0 +1,51 @@
+package fraud
+
+import "testing"
+
+func TestInHours(t *testing.T) {
+    tests := []struct {
+        h, from, to int
+        want        bool
+    }{
+        // Khung trong ngày [8, 18).
+        {7, 8, 18, false},
+        {8, 8, 18, true},
+        {17, 8, 18, true},
+        {18, 8, 18, false},
+        // Khung qua nửa đêm [22, 6).
+        {21, 22, 6, false},
+        {22, 22, 6, true},
+        {23, 22, 6, true},
+        {0, 22, 6, true},
+        {5, 22, 6, true},
+        {6, 22, 6, false},
+        {12, 22, 6, false},
+        // from == to là cả ngày.
+        {0, 0, 0, true},
+        {13, 5, 5, true},
+    }
+    for _, tt := range tests {
+        if got := inHours(tt.h, tt.from, tt.to); got != tt.want {
+            t.Errorf("inHours(%d, %d, %d) = %v, want %v", tt.h, tt.from, tt.to, got, tt.want)
+        }
+    }
+}
+
+func TestHoursCondition(t *testing.T) {
+    const h = "EXTRACT(HOUR FROM start_time AT TIME ZONE 'Asia/Ho_Chi_Minh')"
+    tests := []struct {
+        tz       string
+        from, to int
+        want     string
+    }{
+        {"Asia/Ho_Chi_Minh", 8, 18, "(" + h + " >= 8 AND " + h + " < 18)"},
+        {"Asia/Ho_Chi_Minh", 22, 6, "(" + h + " >= 22 OR " + h + " < 6)"},
+        {"Asia/Ho_Chi_Minh", 0, 0, "(" + h + " >= 0 OR " + h + " < 0)"},
+        {"x'y", 1, 2, "(EXTRACT(HOUR FROM start_time AT TIME ZONE 'x''y') >= 1 AND EXTRACT(HOUR FROM start_time AT TIME ZONE 'x''y') < 2)"},
+    }
+    for _, tt := range tests {
+        if got := hoursCondition(tt.tz, tt.from, tt.to); got != tt.want {
+            t.Errorf("hoursCondition(%q, %d, %d) =\n%s\nwant\n%s", tt.tz, tt.from, tt.to, got, tt.want)
+        }
+    }
+}
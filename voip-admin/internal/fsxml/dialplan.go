@@ -6,31 +6,49 @@ import (
     "fmt"
 
     "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/balance"
 )
 
+// ErrMissingDomain được trả về khi request dialplan không có domain của người
+// gọi; không tra extension trên mọi domain để tránh route nhầm tenant.
+var ErrMissingDomain = errors.New("missing domain")
+
 type DialplanService struct {
     Pool *pgxpool.Pool
+    // MaxCallSeconds giới hạn thời lượng cuộc gọi ra của account prepaid, 0 là không giới hạn.
+    MaxCallSeconds int
 }
 
 // BuildDialplan xây dialplan theo destination_number và context.
-func (s *DialplanService) BuildDialplan(ctx context.Context, caller, callee, contextName string) (*Document, error) {
+// domain (bắt buộc) giới hạn extension trong domain của người gọi; callUUID là uuid
+// kênh gọi, dùng làm khóa giữ chỗ số dư prepaid cho cuộc gọi ra.
+func (s *DialplanService) BuildDialplan(ctx context.Context, caller, callee, domain, contextName, callUUID string) (*Document, error) {
     if s.Pool == nil {
         return nil, errors.New("db pool is nil")
     }
+    if domain == "" {
+        return nil, ErrMissingDomain
+    }
 
     if contextName == "" {
         contextName = "default"
     }
 
-    var extType, serviceRef string
+    // Extension trunk_out dùng exten như prefix route (ví dụ "0", "00").
+    var (
+        extType, serviceRef string
+        domainID            int64
+    )
     err := s.Pool.QueryRow(ctx, `
-        SELECT type::text, COALESCE(service_ref::text, '')
+        SELECT type::text, COALESCE(service_ref::text, ''), e.domain_id
         FROM voip.extensions e
         JOIN voip.domains d ON d.id=e.domain_id
-        WHERE e.exten=$1
+        WHERE (e.exten=$1 OR (e.type='trunk_out' AND $1 LIKE e.exten || '%'))
+          AND d.name=$2
           AND e.is_active=TRUE
+        ORDER BY e.exten=$1 DESC, length(e.exten) DESC
         LIMIT 1
-    `, callee).Scan(&extType, &serviceRef)
+    `, callee, domain).Scan(&extType, &serviceRef, &domainID)
     if err != nil {
         return nil, err
     }
@@ -68,6 +86,21 @@ func (s *DialplanService) BuildDialplan(ctx context.Context, caller, callee, con
                 },
             },
         }
+    case "trunk_out":
+        actions, err := s.outboundActions(ctx, domainID, callUUID, callee, serviceRef)
+        if err != nil {
+            return nil, err
+        }
+        extensionNode = ExtensionNode{
+            Name: fmt.Sprintf("outbound_%s", callee),
+            Condition: []ConditionNode{
+                {
+                    Field:  "destination_number",
+                    Expr:   fmt.Sprintf("^%s$", callee),
+                    Action: actions,
+                },
+            },
+        }
     default:
         // Mặc định: gọi thẳng tới user/extension
         extensionNode = ExtensionNode{
@@ -99,3 +132,28 @@ func (s *DialplanService) BuildDialplan(ctx context.Context, caller, callee, con
 
     return doc, nil
 }
+
+// outboundActions kiểm tra số dư prepaid rồi bridge ra gateway trong service_ref.
+// Hết số dư thì từ chối; còn số dư thì đặt sched_hangup khi trả lời theo thời lượng tối đa.
+func (s *DialplanService) outboundActions(ctx context.Context, domainID int64, callUUID, callee, gateway string) ([]ActionNode, error) {
+    auth, err := balance.Authorize(ctx, s.Pool, domainID, callUUID, callee, s.MaxCallSeconds)
+    if err != nil {
+        return nil, err
+    }
+    if !auth.Allowed {
+        return []ActionNode{
+            {App: "log", Data: "WARNING prepaid call rejected: " + auth.Reason},
+            {App: "hangup", Data: "OUTGOING_CALL_BARRED"},
+        }, nil
+    }
+
+    var actions []ActionNode
+    if auth.MaxSeconds > 0 {
+        actions = append(actions, ActionNode{
+            App:  "set",
+            Data: fmt.Sprintf("execute_on_answer=sched_hangup +%d allotted_timeout", auth.MaxSeconds),
+        })
+    }
+    actions = append(actions, ActionNode{App: "bridge", Data: fmt.Sprintf("sofia/gateway/%s/%s", gateway, callee)})
+    return actions, nil
+}
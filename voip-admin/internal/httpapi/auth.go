@@ -1,7 +1,9 @@
 package httpapi
 
 import (
+    "context"
     "encoding/base64"
+    "log"
     "net/http"
     "strings"
 
@@ -52,18 +54,48 @@ func APIKeyAuth(cfg *config.Config) func(http.Handler) http.Handler {
                 http.Error(w, "api key required", http.StatusUnauthorized)
                 return
             }
-            ok := false
-            for _, k := range cfg.APIKeys {
-                if k.Key == key {
-                    ok = true
+            var matched *config.APIKey
+            for i := range cfg.APIKeys {
+                if cfg.APIKeys[i].Key == key {
+                    matched = &cfg.APIKeys[i]
                     break
                 }
             }
-            if !ok {
+            if matched == nil {
                 http.Error(w, "invalid api key", http.StatusForbidden)
                 return
             }
+            ctx := context.WithValue(r.Context(), apiKeyContextKey{}, *matched)
+            next.ServeHTTP(w, r.WithContext(ctx))
+        })
+    }
+}
+
+type apiKeyContextKey struct{}
+
+// RequirePermission chặn API key có role không được cấp perm; dùng sau APIKeyAuth.
+func RequirePermission(cfg *config.Config, perm string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            k, _ := apiKeyFromContext(r.Context())
+            if !cfg.Permitted(k.Role, perm) {
+                log.Printf("permission %s denied for api key %s (role %q)", perm, k.Name, k.Role)
+                http.Error(w, "permission denied: "+perm, http.StatusForbidden)
+                return
+            }
             next.ServeHTTP(w, r)
         })
     }
 }
+
+// dataPolicy trả policy dữ liệu theo role của API key đang gọi.
+func dataPolicy(cfg *config.Config, r *http.Request) config.DataPolicy {
+    k, _ := apiKeyFromContext(r.Context())
+    return cfg.DataPolicy(k.Role)
+}
+
+// apiKeyFromContext trả API key đã xác thực bởi APIKeyAuth.
+func apiKeyFromContext(ctx context.Context) (config.APIKey, bool) {
+    k, ok := ctx.Value(apiKeyContextKey{}).(config.APIKey)
+    return k, ok
+}
@@ -0,0 +1,35 @@
+package httpapi
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "time"
+)
+
+// cdrCursor là vị trí keyset (start_time, id) trong kết quả /api/cdr.
+// Client chỉ nhận chuỗi opaque, không nên tự tạo.
+type cdrCursor struct {
+    StartTime time.Time `json:"t"`
+    ID        int64     `json:"id"`
+    // Asc là thứ tự của trang gốc; Prev đánh dấu cursor đi ngược về trang trước.
+    Asc  bool `json:"a,omitempty"`
+    Prev bool `json:"p,omitempty"`
+}
+
+func (c cdrCursor) encode() string {
+    b, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCDRCursor(s string) (*cdrCursor, error) {
+    b, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return nil, errors.New("invalid cursor")
+    }
+    var c cdrCursor
+    if err := json.Unmarshal(b, &c); err != nil || c.ID == 0 || c.StartTime.IsZero() {
+        return nil, errors.New("invalid cursor")
+    }
+    return &c, nil
+}
@@ -0,0 +1,49 @@
+package httpapi
+
+import (
+    "encoding/base64"
+    "testing"
+    "time"
+)
+
+func TestCDRCursorRoundTrip(t *testing.T) {
+    start := time.Date(2025, 3, 1, 8, 30, 0, 123456000, time.UTC)
+    tests := []cdrCursor{
+        {StartTime: start, ID: 42},
+        {StartTime: start, ID: 42, Asc: true},
+        {StartTime: start, ID: 1, Prev: true},
+        {StartTime: start, ID: 9007199254740993, Asc: true, Prev: true},
+    }
+    for _, c := range tests {
+        got, err := decodeCDRCursor(c.encode())
+        if err != nil {
+            t.Fatalf("decode(%+v): %v", c, err)
+        }
+        if !got.StartTime.Equal(c.StartTime) || got.ID != c.ID || got.Asc != c.Asc || got.Prev != c.Prev {
+            t.Errorf("round trip = %+v, want %+v", *got, c)
+        }
+    }
+}
+
+func TestDecodeCDRCursorInvalid(t *testing.T) {
+    enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+    tests := []struct {
+        name   string
+        cursor string
+    }{
+        {"empty", ""},
+        {"not base64", "!!!"},
+        {"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"t":"2025-03-01T00:00:00Z","id":1}`))},
+        {"not json", enc("hello")},
+        {"missing id", enc(`{"t":"2025-03-01T00:00:00Z"}`)},
+        {"missing time", enc(`{"id":5}`)},
+        {"bad time", enc(`{"t":"yesterday","id":5}`)},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if c, err := decodeCDRCursor(tt.cursor); err == nil {
+                t.Errorf("decodeCDRCursor(%q) = %+v, want error", tt.cursor, *c)
+            }
+        })
+    }
+}
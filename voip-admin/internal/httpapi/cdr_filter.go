@@ -0,0 +1,247 @@
+package httpapi
+
+import (
+    "fmt"
+    "net/url"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// cdrFilter gom các điều kiện WHERE và tham số tương ứng cho truy vấn voip.cdr.
+type cdrFilter struct {
+    where []string
+    args  []interface{}
+}
+
+// add thêm điều kiện, mỗi ký tự "?" trong cond lần lượt được thay bằng
+// placeholder $n của tham số tương ứng.
+func (f *cdrFilter) add(cond string, args ...interface{}) {
+    for _, a := range args {
+        f.args = append(f.args, a)
+        cond = strings.Replace(cond, "?", "$"+strconv.Itoa(len(f.args)), 1)
+    }
+    f.where = append(f.where, cond)
+}
+
+func (f *cdrFilter) whereSQL() string {
+    if len(f.where) == 0 {
+        return ""
+    }
+    return " WHERE " + strings.Join(f.where, " AND ")
+}
+
+var (
+    numberPattern      = regexp.MustCompile(`^[0-9A-Za-z+#*@._-]+$`)
+    hangupCausePattern = regexp.MustCompile(`^[A-Z_]+$`)
+    domainPattern      = regexp.MustCompile(`^[0-9A-Za-z.-]+$`)
+)
+
+// parseCDRFilter đọc các filter chung của /api/cdr và các API dẫn xuất.
+// Tham số sai định dạng trả về lỗi có thể đưa thẳng cho client (400).
+//
+// Filter hỗ trợ:
+//   from, to                        RFC3339, lọc theo start_time
+//   caller, callee, number          số chính xác hoặc wildcard "*" (ví dụ 8490*);
+//                                   number khớp caller hoặc callee
+//   direction                       inbound | outbound
+//   leg                             A | B
+//   hangup_cause                    danh sách phân tách bởi dấu phẩy
+//   min_duration, max_duration      giây
+//   min_billsec, max_billsec        giây
+//   answered                        true | false
+//   queue_id, agent_id, trunk_id    id
+//   domain, domain_id               tên hoặc id domain
+//   has_recording                   true | false
+func parseCDRFilter(q url.Values) (*cdrFilter, error) {
+    f := &cdrFilter{}
+
+    var from, to time.Time
+    if s := q.Get("from"); s != "" {
+        t, err := time.Parse(time.RFC3339, s)
+        if err != nil {
+            return nil, fmt.Errorf("invalid from %q: expected RFC3339 timestamp", s)
+        }
+        from = t
+        f.add("start_time >= ?", t)
+    }
+    if s := q.Get("to"); s != "" {
+        t, err := time.Parse(time.RFC3339, s)
+        if err != nil {
+            return nil, fmt.Errorf("invalid to %q: expected RFC3339 timestamp", s)
+        }
+        to = t
+        f.add("start_time <= ?", t)
+    }
+    if !from.IsZero() && !to.IsZero() && to.Before(from) {
+        return nil, fmt.Errorf("invalid range: to is before from")
+    }
+
+    for _, p := range []struct{ param, column string }{
+        {"caller", "caller_id_number"},
+        {"callee", "destination_number"},
+    } {
+        if s := q.Get(p.param); s != "" {
+            cond, arg, err := numberCondition(p.param, p.column, s)
+            if err != nil {
+                return nil, err
+            }
+            f.add(cond, arg)
+        }
+    }
+    if s := q.Get("number"); s != "" {
+        callerCond, arg, err := numberCondition("number", "caller_id_number", s)
+        if err != nil {
+            return nil, err
+        }
+        calleeCond, _, _ := numberCondition("number", "destination_number", s)
+        f.add("("+callerCond+" OR "+calleeCond+")", arg, arg)
+    }
+
+    if s := q.Get("direction"); s != "" {
+        if s != "inbound" && s != "outbound" {
+            return nil, fmt.Errorf("invalid direction %q: expected inbound or outbound", s)
+        }
+        f.add("direction = ?", s)
+    }
+
+    if s := q.Get("leg"); s != "" {
+        s = strings.ToUpper(s)
+        if s != "A" && s != "B" {
+            return nil, fmt.Errorf("invalid leg %q: expected A or B", s)
+        }
+        f.add("leg = ?", s)
+    }
+
+    if s := q.Get("hangup_cause"); s != "" {
+        causes := strings.Split(s, ",")
+        for i, c := range causes {
+            c = strings.ToUpper(strings.TrimSpace(c))
+            if !hangupCausePattern.MatchString(c) {
+                return nil, fmt.Errorf("invalid hangup_cause %q", c)
+            }
+            causes[i] = c
+        }
+        f.add("hangup_cause = ANY(?)", causes)
+    }
+
+    for _, p := range []struct{ min, max, column string }{
+        {"min_duration", "max_duration", "duration"},
+        {"min_billsec", "max_billsec", "billsec"},
+    } {
+        lo, err := nonNegativeParam(q, p.min)
+        if err != nil {
+            return nil, err
+        }
+        hi, err := nonNegativeParam(q, p.max)
+        if err != nil {
+            return nil, err
+        }
+        if lo != nil && hi != nil && *hi < *lo {
+            return nil, fmt.Errorf("invalid %s: must not be less than %s", p.max, p.min)
+        }
+        if lo != nil {
+            f.add(p.column+" >= ?", *lo)
+        }
+        if hi != nil {
+            f.add(p.column+" <= ?", *hi)
+        }
+    }
+
+    answered, err := boolParam(q, "answered")
+    if err != nil {
+        return nil, err
+    }
+    if answered != nil {
+        if *answered {
+            f.where = append(f.where, "billsec > 0")
+        } else {
+            f.where = append(f.where, "billsec = 0")
+        }
+    }
+
+    for _, p := range []struct{ param, column string }{
+        {"queue_id", "queue_id"},
+        {"agent_id", "agent_user_id"},
+        {"trunk_id", "trunk_id"},
+        {"domain_id", "domain_id"},
+    } {
+        id, err := idParam(q, p.param)
+        if err != nil {
+            return nil, err
+        }
+        if id != nil {
+            f.add(p.column+" = ?", *id)
+        }
+    }
+
+    if s := q.Get("domain"); s != "" {
+        if !domainPattern.MatchString(s) {
+            return nil, fmt.Errorf("invalid domain %q", s)
+        }
+        f.add("domain_id = (SELECT id FROM voip.domains WHERE name = ?)", s)
+    }
+
+    hasRecording, err := boolParam(q, "has_recording")
+    if err != nil {
+        return nil, err
+    }
+    if hasRecording != nil {
+        if *hasRecording {
+            f.where = append(f.where, "recording_id IS NOT NULL")
+        } else {
+            f.where = append(f.where, "recording_id IS NULL")
+        }
+    }
+
+    return f, nil
+}
+
+// numberCondition tạo điều kiện so khớp số; "*" là wildcard, các ký tự LIKE khác được escape.
+func numberCondition(param, column, value string) (string, string, error) {
+    if !numberPattern.MatchString(value) {
+        return "", "", fmt.Errorf("invalid %s %q: allowed characters are digits, letters, + # * @ . _ -", param, value)
+    }
+    if !strings.Contains(value, "*") {
+        return column + " = ?", value, nil
+    }
+    escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+    return column + " LIKE ?", strings.ReplaceAll(escaped, "*", "%"), nil
+}
+
+func nonNegativeParam(q url.Values, name string) (*int, error) {
+    s := q.Get(name)
+    if s == "" {
+        return nil, nil
+    }
+    n, err := strconv.Atoi(s)
+    if err != nil || n < 0 {
+        return nil, fmt.Errorf("invalid %s %q: expected non-negative integer", name, s)
+    }
+    return &n, nil
+}
+
+func idParam(q url.Values, name string) (*int64, error) {
+    s := q.Get(name)
+    if s == "" {
+        return nil, nil
+    }
+    n, err := strconv.ParseInt(s, 10, 64)
+    if err != nil || n <= 0 {
+        return nil, fmt.Errorf("invalid %s %q: expected positive integer id", name, s)
+    }
+    return &n, nil
+}
+
+func boolParam(q url.Values, name string) (*bool, error) {
+    s := q.Get(name)
+    if s == "" {
+        return nil, nil
+    }
+    b, err := strconv.ParseBool(s)
+    if err != nil {
+        return nil, fmt.Errorf("invalid %s %q: expected true or false", name, s)
+    }
+    return &b, nil
+}
@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+    "net/url"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestParseCDRFilter(t *testing.T) {
+    from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+    to := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name  string
+        query string
+        where string
+        args  []interface{}
+    }{
+        {
+            name:  "no filter",
+            query: "",
+            where: "",
+        },
+        {
+            name:  "time range",
+            query: "from=2025-01-01T00:00:00Z&to=2025-02-01T00:00:00Z",
+            where: " WHERE start_time >= $1 AND start_time <= $2",
+            args:  []interface{}{from, to},
+        },
+        {
+            name:  "exact and wildcard numbers",
+            query: "caller=1001&callee=8490*",
+            where: " WHERE caller_id_number = $1 AND destination_number LIKE $2",
+            args:  []interface{}{"1001", "8490%"},
+        },
+        {
+            name:  "wildcard escapes like characters",
+            query: "callee=a_b*",
+            where: " WHERE destination_number LIKE $1",
+            args:  []interface{}{`a\_b%`},
+        },
+        {
+            name:  "number matches caller or callee",
+            query: "number=0901*",
+            where: " WHERE (caller_id_number LIKE $1 OR destination_number LIKE $2)",
+            args:  []interface{}{"0901%", "0901%"},
+        },
+        {
+            name:  "direction leg and causes",
+            query: "direction=inbound&leg=b&hangup_cause=normal_clearing,+USER_BUSY",
+            where: " WHERE direction = $1 AND leg = $2 AND hangup_cause = ANY($3)",
+            args:  []interface{}{"inbound", "B", []string{"NORMAL_CLEARING", "USER_BUSY"}},
+        },
+        {
+            name:  "durations",
+            query: "min_duration=10&max_duration=60&min_billsec=0",
+            where: " WHERE duration >= $1 AND duration <= $2 AND billsec >= $3",
+            args:  []interface{}{10, 60, 0},
+        },
+        {
+            name:  "booleans",
+            query: "answered=true&has_recording=false",
+            where: " WHERE billsec > 0 AND recording_id IS NULL",
+        },
+        {
+            name:  "ids and domain",
+            query: "queue_id=3&agent_id=4&trunk_id=5&domain_id=6&domain=bsv.local",
+            where: " WHERE queue_id = $1 AND agent_user_id = $2 AND trunk_id = $3 AND domain_id = $4" +
+                " AND domain_id = (SELECT id FROM voip.domains WHERE name = $5)",
+            args: []interface{}{int64(3), int64(4), int64(5), int64(6), "bsv.local"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            q, err := url.ParseQuery(tt.query)
+            if err != nil {
+                t.Fatal(err)
+            }
+            f, err := parseCDRFilter(q)
+            if err != nil {
+                t.Fatalf("parseCDRFilter(%q): %v", tt.query, err)
+            }
+            if got := f.whereSQL(); got != tt.where {
+                t.Errorf("where = %q, want %q", got, tt.where)
+            }
+            if !reflect.DeepEqual(f.args, tt.args) {
+                t.Errorf("args = %#v, want %#v", f.args, tt.args)
+            }
+        })
+    }
+}
+
+func TestParseCDRFilterInvalid(t *testing.T) {
+    tests := []string{
+        "from=2025-01-01",
+        "to=tomorrow",
+        "from=2025-02-01T00:00:00Z&to=2025-01-01T00:00:00Z",
+        "caller=1001%3BDROP",
+        "number=%25",
+        "direction=sideways",
+        "leg=C",
+        "hangup_cause=NORMAL-CLEARING",
+        "min_duration=-1",
+        "max_billsec=abc",
+        "min_duration=60&max_duration=10",
+        "answered=maybe",
+        "queue_id=0",
+        "agent_id=x",
+        "domain=bsv.local'",
+        "has_recording=2",
+    }
+    for _, query := range tests {
+        q, err := url.ParseQuery(query)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := parseCDRFilter(q); err == nil {
+            t.Errorf("parseCDRFilter(%q): want error", query)
+        }
+    }
+}
@@ -0,0 +1,129 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/go-chi/chi/v5"
+    "voip-admin/internal/callcenter"
+)
+
+type AgentStatusRequest struct {
+    Status string `json:"status"`
+}
+
+type AgentTierRequest struct {
+    Level    *int `json:"level"`
+    Position *int `json:"position"`
+}
+
+// AgentDetail là trạng thái đã lưu của agent kèm trạng thái thực tế trên từng node.
+type AgentDetail struct {
+    *callcenter.Agent
+    Nodes []callcenter.NodeState `json:"nodes"`
+}
+
+// AgentChange là kết quả thay đổi agent; node lỗi sẽ được áp lại khi kết nối lại.
+type AgentChange struct {
+    *callcenter.Agent
+    Nodes []callcenter.NodeResult `json:"nodes"`
+}
+
+// AgentsHandler liệt kê agent được quản lý qua API kèm tier.
+func AgentsHandler(agents *callcenter.Agents) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        items, err := agents.List(r.Context())
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if items == nil {
+            items = []callcenter.Agent{}
+        }
+        writeJSON(w, http.StatusOK, items)
+    }
+}
+
+// AgentHandler trả agent ("username@domain") kèm status/state trên các node.
+func AgentHandler(agents *callcenter.Agents) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        a, err := agents.Get(r.Context(), chi.URLParam(r, "agent"))
+        if errors.Is(err, callcenter.ErrNotFound) {
+            http.Error(w, "not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        nodes := agents.LiveState(r.Context(), a.Name)
+        if nodes == nil {
+            nodes = []callcenter.NodeState{}
+        }
+        writeJSON(w, http.StatusOK, AgentDetail{Agent: a, Nodes: nodes})
+    }
+}
+
+// SetAgentStatusHandler đặt trạng thái agent: Available, Available (On Demand),
+// On Break hoặc Logged Out.
+func SetAgentStatusHandler(agents *callcenter.Agents) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req AgentStatusRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        a, nodes, err := agents.SetStatus(r.Context(), chi.URLParam(r, "agent"), req.Status, consumerName(r))
+        writeAgentChange(w, a, nodes, err)
+    }
+}
+
+// AddAgentTierHandler thêm agent vào queue hoặc cập nhật level/position (mặc định 1).
+func AddAgentTierHandler(agents *callcenter.Agents) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req AgentTierRequest
+        if err := decodeOptional(r, &req); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        t := callcenter.Tier{Queue: chi.URLParam(r, "queue"), Level: 1, Position: 1}
+        if req.Level != nil {
+            t.Level = *req.Level
+        }
+        if req.Position != nil {
+            t.Position = *req.Position
+        }
+        a, nodes, err := agents.AddTier(r.Context(), chi.URLParam(r, "agent"), t, consumerName(r))
+        writeAgentChange(w, a, nodes, err)
+    }
+}
+
+// RemoveAgentTierHandler xóa agent khỏi queue.
+func RemoveAgentTierHandler(agents *callcenter.Agents) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        a, nodes, err := agents.RemoveTier(r.Context(), chi.URLParam(r, "agent"), chi.URLParam(r, "queue"), consumerName(r))
+        writeAgentChange(w, a, nodes, err)
+    }
+}
+
+func writeAgentChange(w http.ResponseWriter, a *callcenter.Agent, nodes []callcenter.NodeResult, err error) {
+    switch {
+    case errors.Is(err, callcenter.ErrNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    case errors.Is(err, callcenter.ErrInvalidStatus), errors.Is(err, callcenter.ErrInvalidName),
+        errors.Is(err, callcenter.ErrInvalidTier):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    case err != nil:
+        log.Printf("agent update: %v", err)
+        http.Error(w, "agent update failed", http.StatusInternalServerError)
+        return
+    }
+    if nodes == nil {
+        nodes = []callcenter.NodeResult{}
+    }
+    writeJSON(w, http.StatusOK, AgentChange{Agent: a, Nodes: nodes})
+}
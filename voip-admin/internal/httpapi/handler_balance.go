@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/balance"
+    "voip-admin/internal/rating"
+)
+
+type TopUpRequest struct {
+    Amount string `json:"amount"`
+    Reason string `json:"reason"`
+}
+
+func BalanceHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        domainID, err := strconv.ParseInt(chi.URLParam(r, "domainID"), 10, 64)
+        if err != nil {
+            http.Error(w, "invalid domain id", http.StatusBadRequest)
+            return
+        }
+
+        acc, err := balance.Get(r.Context(), pool, domainID)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if acc == nil {
+            http.Error(w, "not found", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, acc)
+    }
+}
+
+// TopUpHandler nạp tiền (hoặc điều chỉnh âm) vào số dư prepaid của domain.
+func TopUpHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        domainID, err := strconv.ParseInt(chi.URLParam(r, "domainID"), 10, 64)
+        if err != nil {
+            http.Error(w, "invalid domain id", http.StatusBadRequest)
+            return
+        }
+
+        var req TopUpRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "invalid body", http.StatusBadRequest)
+            return
+        }
+        amount, err := rating.ParseMoney(req.Amount)
+        if err != nil || amount == 0 {
+            http.Error(w, "invalid amount", http.StatusBadRequest)
+            return
+        }
+
+        acc, err := balance.TopUp(r.Context(), pool, domainID, amount, req.Reason)
+        if errors.Is(err, balance.ErrNoAccount) {
+            http.Error(w, "no balance account for domain", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            log.Printf("topup domain %d: %v", domainID, err)
+            http.Error(w, "topup failed", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusOK, acc)
+    }
+}
@@ -0,0 +1,56 @@
+package httpapi
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/callcenter"
+)
+
+// CallcenterEventsHandler nhận sự kiện callcenter::info dạng JSON (một object
+// header hoặc mảng object) do FreeSWITCH chuyển tiếp và lưu các sự kiện agent.
+func CallcenterEventsHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        body, err := io.ReadAll(r.Body)
+        if err != nil {
+            http.Error(w, "invalid body", http.StatusBadRequest)
+            return
+        }
+        defer r.Body.Close()
+
+        var events []map[string]string
+        if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+            err = json.Unmarshal(body, &events)
+        } else {
+            var one map[string]string
+            err = json.Unmarshal(body, &one)
+            events = append(events, one)
+        }
+        if err != nil {
+            http.Error(w, "invalid body", http.StatusBadRequest)
+            return
+        }
+
+        stored := 0
+        for _, h := range events {
+            ev, ok := callcenter.EventFromHeaders(h)
+            if !ok {
+                continue
+            }
+            if err := callcenter.RecordEvent(r.Context(), pool, ev); err != nil {
+                log.Printf("record callcenter event: %v", err)
+                http.Error(w, "failed to store event", http.StatusInternalServerError)
+                return
+            }
+            if err := callcenter.SyncStatus(r.Context(), pool, ev); err != nil {
+                log.Printf("sync agent status %s: %v", ev.Agent, err)
+            }
+            stored++
+        }
+        writeJSON(w, http.StatusOK, map[string]int{"stored": stored})
+    }
+}
@@ -0,0 +1,178 @@
+package httpapi
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/audit"
+    "voip-admin/internal/callctl"
+    "voip-admin/internal/calls"
+    "voip-admin/internal/esl"
+)
+
+type HangupRequest struct {
+    Cause string `json:"cause"`
+}
+
+type TransferRequest struct {
+    Destination string `json:"destination"`
+    // Mode: blind (mặc định) hoặc attended.
+    Mode string `json:"mode"`
+    // Leg của blind transfer: self (mặc định), peer hoặc both.
+    Leg string `json:"leg"`
+}
+
+type MonitorRequest struct {
+    UserID    int64  `json:"user_id"`
+    Domain    string `json:"domain"`
+    Extension string `json:"extension"`
+}
+
+// decodeOptional đọc body JSON; body rỗng được chấp nhận.
+func decodeOptional(r *http.Request, v interface{}) error {
+    err := json.NewDecoder(r.Body).Decode(v)
+    if errors.Is(err, io.EOF) {
+        return nil
+    }
+    return err
+}
+
+// HangupCallHandler kết thúc cuộc gọi; body tùy chọn {"cause": "..."}.
+func HangupCallHandler(pool *pgxpool.Pool, ctl *callctl.Controller) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req HangupRequest
+        if err := decodeOptional(r, &req); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        call, err := ctl.Hangup(r.Context(), chi.URLParam(r, "uuid"), req.Cause)
+        if finishCallAction(w, r, pool, "call.hangup", call, map[string]interface{}{"cause": req.Cause}, err) {
+            w.WriteHeader(http.StatusNoContent)
+        }
+    }
+}
+
+// TransferCallHandler chuyển tiếp cuộc gọi (blind hoặc attended).
+func TransferCallHandler(pool *pgxpool.Pool, ctl *callctl.Controller) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req TransferRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        if req.Mode != "" && req.Mode != "blind" && req.Mode != "attended" {
+            http.Error(w, "invalid mode: expected blind or attended", http.StatusBadRequest)
+            return
+        }
+        call, err := ctl.Transfer(r.Context(), chi.URLParam(r, "uuid"), callctl.TransferParams{
+            Destination: req.Destination,
+            Attended:    req.Mode == "attended",
+            Leg:         req.Leg,
+        })
+        if finishCallAction(w, r, pool, "call.transfer", call, map[string]interface{}{
+            "destination": req.Destination,
+            "mode":        req.Mode,
+            "leg":         req.Leg,
+        }, err) {
+            w.WriteHeader(http.StatusNoContent)
+        }
+    }
+}
+
+// HoldCallHandler giữ máy (on=true) hoặc bỏ giữ máy cuộc gọi.
+func HoldCallHandler(pool *pgxpool.Pool, ctl *callctl.Controller, on bool) http.HandlerFunc {
+    action := "call.hold"
+    if !on {
+        action = "call.unhold"
+    }
+    return func(w http.ResponseWriter, r *http.Request) {
+        call, err := ctl.Hold(r.Context(), chi.URLParam(r, "uuid"), on)
+        if finishCallAction(w, r, pool, action, call, nil, err) {
+            w.WriteHeader(http.StatusNoContent)
+        }
+    }
+}
+
+// MonitorCallHandler gọi tới máy supervisor để nghe, nhắc (whisper) hoặc tham
+// gia (barge) cuộc gọi. Trả 202 với UUID leg của supervisor.
+func MonitorCallHandler(pool *pgxpool.Pool, ctl *callctl.Controller, mode string) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req MonitorRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        if req.UserID <= 0 && (req.Domain == "" || req.Extension == "") {
+            http.Error(w, "user_id or domain and extension are required", http.StatusBadRequest)
+            return
+        }
+        call, leg, err := ctl.Monitor(r.Context(), chi.URLParam(r, "uuid"), mode, callctl.Supervisor{
+            UserID:    req.UserID,
+            Extension: req.Extension,
+            Domain:    req.Domain,
+        })
+        details := map[string]interface{}{
+            "user_id":         req.UserID,
+            "extension":       req.Extension,
+            "domain":          req.Domain,
+            "supervisor_uuid": leg,
+        }
+        if !finishCallAction(w, r, pool, "call."+mode, call, details, err) {
+            return
+        }
+        writeJSON(w, http.StatusAccepted, map[string]string{"uuid": call.UUID, "supervisor_uuid": leg})
+    }
+}
+
+// finishCallAction ghi audit cho thao tác đã gửi tới node (kể cả khi node báo lỗi)
+// và trả lỗi cho client; trả true nếu thao tác thành công để handler ghi response.
+func finishCallAction(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, action string, call calls.Call, details map[string]interface{}, err error) bool {
+    var cmdErr *esl.CommandError
+    switch {
+    case errors.Is(err, callctl.ErrCallNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return false
+    case errors.Is(err, callctl.ErrInvalidDestination), errors.Is(err, callctl.ErrInvalidCause),
+        errors.Is(err, callctl.ErrInvalidLeg):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return false
+    case errors.Is(err, callctl.ErrUserNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return false
+    case errors.Is(err, callctl.ErrNotBridged):
+        http.Error(w, err.Error(), http.StatusConflict)
+        return false
+    }
+
+    if details == nil {
+        details = map[string]interface{}{}
+    }
+    details["node"] = call.Node
+    if err != nil {
+        details["error"] = err.Error()
+    }
+    // Audit không phụ thuộc request context để vẫn được ghi khi client ngắt kết nối.
+    if aerr := audit.Record(context.Background(), pool, consumerName(r), action, call.UUID, details); aerr != nil {
+        log.Printf("audit %s %s: %v", action, call.UUID, aerr)
+    }
+
+    switch {
+    case errors.As(err, &cmdErr):
+        http.Error(w, err.Error(), http.StatusConflict)
+        return false
+    case errors.Is(err, esl.ErrNotConnected):
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+        return false
+    case err != nil:
+        log.Printf("%s %s: %v", action, call.UUID, err)
+        http.Error(w, "call control failed", http.StatusBadGateway)
+        return false
+    }
+    return true
+}
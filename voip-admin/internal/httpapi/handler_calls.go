@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "voip-admin/internal/calls"
+    "voip-admin/internal/config"
+    "voip-admin/internal/models"
+    "voip-admin/internal/privacy"
+)
+
+const streamHeartbeat = 15 * time.Second
+
+// ActiveCallsHandler trả các channel đang hoạt động trên mọi node.
+// Tham số lọc: node, domain, queue, leg (A|B).
+func ActiveCallsHandler(cfg *config.Config, live *calls.Table) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        policy := dataPolicy(cfg, r)
+        items := live.List(callsFilter(r.URL.Query()))
+        for i := range items {
+            maskCall(policy, &items[i])
+        }
+        writeJSON(w, http.StatusOK, items)
+    }
+}
+
+// CallStreamHandler stream thay đổi cuộc gọi dạng Server-Sent Events: đầu tiên
+// là trạng thái hiện tại (call.updated cho từng cuộc gọi), sau đó call.updated,
+// call.ended và cdr.completed khi CDR của cuộc gọi được ingest. Cùng tham số
+// lọc như /api/calls/active (không áp dụng cho cdr.completed).
+func CallStreamHandler(cfg *config.Config, live *calls.Table) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        policy := dataPolicy(cfg, r)
+        f := callsFilter(r.URL.Query())
+
+        // Đăng ký trước khi lấy snapshot để không lỡ thay đổi ở giữa.
+        updates, cancel := live.Subscribe(256)
+        defer cancel()
+
+        rc := http.NewResponseController(w)
+        _ = rc.SetWriteDeadline(time.Time{})
+        w.Header().Set("Content-Type", "text/event-stream")
+        w.Header().Set("Cache-Control", "no-cache")
+        w.Header().Set("X-Accel-Buffering", "no")
+        w.WriteHeader(http.StatusOK)
+
+        send := func(u calls.Update) bool {
+            if u.Call != nil {
+                if !f.Match(*u.Call) {
+                    return true
+                }
+                c := *u.Call
+                maskCall(policy, &c)
+                u.Call = &c
+            }
+            if c, ok := u.CDR.(*models.CDR); ok && policy.MaskNumbers {
+                m := *c
+                m.CallerIDNumber = privacy.MaskNumberPtr(m.CallerIDNumber, policy.KeepDigits)
+                m.DestinationNumber = privacy.MaskNumberPtr(m.DestinationNumber, policy.KeepDigits)
+                u.CDR = &m
+            }
+            data, err := json.Marshal(u)
+            if err != nil {
+                return true
+            }
+            if _, err := w.Write([]byte("event: " + u.Type + "\ndata: " + string(data) + "\n\n")); err != nil {
+                return false
+            }
+            return true
+        }
+
+        for _, c := range live.List(f) {
+            c := c
+            if !send(calls.Update{Type: calls.UpdateCall, Call: &c}) {
+                return
+            }
+        }
+        _ = rc.Flush()
+
+        ticker := time.NewTicker(streamHeartbeat)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-r.Context().Done():
+                return
+            case u := <-updates:
+                if !send(u) {
+                    return
+                }
+            case <-ticker.C:
+                if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+                    return
+                }
+            }
+            if err := rc.Flush(); err != nil {
+                return
+            }
+        }
+    }
+}
+
+func callsFilter(q url.Values) calls.Filter {
+    return calls.Filter{
+        Node:   q.Get("node"),
+        Domain: q.Get("domain"),
+        Queue:  q.Get("queue"),
+        Leg:    strings.ToUpper(q.Get("leg")),
+    }
+}
+
+func maskCall(policy config.DataPolicy, c *calls.Call) {
+    if policy.MaskNumbers {
+        c.Caller = privacy.MaskNumber(c.Caller, policy.KeepDigits)
+        c.Callee = privacy.MaskNumber(c.Callee, policy.KeepDigits)
+        c.CallerName = ""
+    }
+}
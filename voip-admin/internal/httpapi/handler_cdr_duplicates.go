@@ -0,0 +1,43 @@
+package httpapi
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/cdr"
+    "voip-admin/internal/config"
+)
+
+// CDRDuplicatesHandler trả log các CDR nhận trùng và quyết định merge.
+// Tham số: call_uuid (kèm payload bị loại), limit (mặc định 100, tối đa 1000).
+func CDRDuplicatesHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        limit := 100
+        if s := q.Get("limit"); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n <= 0 || n > 1000 {
+                http.Error(w, "invalid limit: expected 1..1000", http.StatusBadRequest)
+                return
+            }
+            limit = n
+        }
+
+        items, err := cdr.ListDuplicates(r.Context(), pool, q.Get("call_uuid"), limit)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if items == nil {
+            items = []cdr.Duplicate{}
+        }
+        if dataPolicy(cfg, r).HideRawJSON {
+            for i := range items {
+                items[i].DiscardedRawJSON = nil
+            }
+        }
+        writeJSON(w, http.StatusOK, items)
+    }
+}
@@ -0,0 +1,196 @@
+package httpapi
+
+import (
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/config"
+    "voip-admin/internal/export"
+    "voip-admin/internal/privacy"
+)
+
+type exportColumn struct {
+    Name string
+    Expr string
+}
+
+// cdrExportColumns là các cột được phép export, Expr đã ép kiểu về dạng mà
+// export.Writer hiểu (text, bigint, timestamptz).
+var cdrExportColumns = []exportColumn{
+    {"id", "id"},
+    {"call_uuid", "call_uuid"},
+    {"direction", "direction::text"},
+    {"caller_id_number", "caller_id_number"},
+    {"destination_number", "destination_number"},
+    {"start_time", "start_time"},
+    {"answer_time", "answer_time"},
+    {"end_time", "end_time"},
+    {"duration", "duration::bigint"},
+    {"billsec", "billsec::bigint"},
+    {"hangup_cause", "hangup_cause"},
+    {"queue_id", "queue_id"},
+    {"agent_user_id", "agent_user_id"},
+    {"trunk_id", "trunk_id"},
+    {"recording_id", "recording_id"},
+    {"domain_id", "domain_id"},
+    {"cost", "cost::text"},
+    {"currency", "currency"},
+    {"leg", "leg::text"},
+    {"originating_leg_uuid", "originating_leg_uuid"},
+    {"created_at", "created_at"},
+}
+
+var defaultExportColumns = []string{
+    "id", "call_uuid", "direction", "caller_id_number", "destination_number",
+    "start_time", "answer_time", "end_time", "duration", "billsec",
+    "hangup_cause", "cost", "currency",
+}
+
+const exportFlushEvery = 1000
+
+// CDRExportHandler stream toàn bộ CDR khớp filter ra CSV, NDJSON hoặc XLSX.
+// Tham số: format, columns (danh sách phân tách bởi dấu phẩy), tz (IANA time zone)
+// và các filter giống /api/cdr.
+func CDRExportHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    byName := make(map[string]exportColumn, len(cdrExportColumns))
+    for _, c := range cdrExportColumns {
+        byName[c.Name] = c
+    }
+
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        formatName := q.Get("format")
+        if formatName == "" {
+            formatName = "csv"
+        }
+        format, ok := export.LookupFormat(formatName)
+        if !ok {
+            http.Error(w, "invalid format: expected csv, ndjson or xlsx", http.StatusBadRequest)
+            return
+        }
+
+        loc := time.UTC
+        if tz := q.Get("tz"); tz != "" {
+            l, err := time.LoadLocation(tz)
+            if err != nil {
+                http.Error(w, "invalid tz", http.StatusBadRequest)
+                return
+            }
+            loc = l
+        }
+
+        names := defaultExportColumns
+        if s := q.Get("columns"); s != "" {
+            names = strings.Split(s, ",")
+        }
+        policy := dataPolicy(cfg, r)
+        columns := make([]string, 0, len(names))
+        exprs := make([]string, 0, len(names))
+        var masked []int
+        for _, n := range names {
+            n = strings.TrimSpace(n)
+            col, ok := byName[n]
+            if !ok {
+                http.Error(w, "unknown column: "+n, http.StatusBadRequest)
+                return
+            }
+            if policy.MaskNumbers && (n == "caller_id_number" || n == "destination_number") {
+                masked = append(masked, len(columns))
+            }
+            columns = append(columns, n)
+            exprs = append(exprs, col.Expr)
+        }
+
+        f, err := parseCDRFilter(q)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        query := "SELECT " + strings.Join(exprs, ", ") + " FROM voip.cdr" +
+            f.whereSQL() + " ORDER BY start_time, id"
+
+        // Đếm và stream trên cùng snapshot: header 200 đã gửi thì không báo lỗi được nữa,
+        // nên vượt giới hạn dòng của định dạng phải bị từ chối trước khi stream.
+        tx, err := pool.BeginTx(r.Context(), pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        defer tx.Rollback(r.Context())
+
+        if format.MaxRows > 0 {
+            var count int
+            err := tx.QueryRow(r.Context(), "SELECT count(*) FROM (SELECT 1 FROM voip.cdr"+
+                f.whereSQL()+" LIMIT "+strconv.Itoa(format.MaxRows+1)+") t", f.args...).Scan(&count)
+            if err != nil {
+                http.Error(w, "query error", http.StatusInternalServerError)
+                return
+            }
+            if count > format.MaxRows {
+                http.Error(w, fmt.Sprintf("too many rows for %s export (max %d), narrow the filter", format.Name, format.MaxRows),
+                    http.StatusRequestEntityTooLarge)
+                return
+            }
+        }
+
+        rows, err := tx.Query(r.Context(), query, f.args...)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        defer rows.Close()
+
+        // Export lớn có thể vượt WriteTimeout của server.
+        rc := http.NewResponseController(w)
+        _ = rc.SetWriteDeadline(time.Time{})
+
+        w.Header().Set("Content-Type", format.ContentType)
+        w.Header().Set("Content-Disposition", `attachment; filename="cdr-export.`+format.Extension+`"`)
+        w.WriteHeader(http.StatusOK)
+
+        out := format.New(w, loc)
+        if err := out.WriteHeader(columns); err != nil {
+            log.Printf("cdr export: %v", err)
+            return
+        }
+
+        n := 0
+        for rows.Next() {
+            values, err := rows.Values()
+            if err != nil {
+                log.Printf("cdr export: %v", err)
+                return
+            }
+            for _, i := range masked {
+                if s, ok := values[i].(string); ok {
+                    values[i] = privacy.MaskNumber(s, policy.KeepDigits)
+                }
+            }
+            if err := out.WriteRow(values); err != nil {
+                log.Printf("cdr export aborted after %d rows: %v", n, err)
+                return
+            }
+            n++
+            if n%exportFlushEvery == 0 {
+                if err := out.Flush(); err != nil {
+                    return
+                }
+                _ = rc.Flush()
+            }
+        }
+        if err := rows.Err(); err != nil {
+            log.Printf("cdr export aborted after %d rows: %v", n, err)
+            return
+        }
+        if err := out.Close(); err != nil {
+            log.Printf("cdr export: %v", err)
+        }
+    }
+}
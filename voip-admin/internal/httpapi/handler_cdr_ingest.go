@@ -1,15 +1,32 @@
 package httpapi
 
 import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
     "io"
+    "log"
+    "mime"
     "net/http"
+    "net/url"
 
+    "github.com/jackc/pgx/v5"
     "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/balance"
+    "voip-admin/internal/calls"
     "voip-admin/internal/cdr"
     "voip-admin/internal/config"
+    "voip-admin/internal/deadletter"
+    "voip-admin/internal/fraud"
+    "voip-admin/internal/models"
+    "voip-admin/internal/rating"
+    "voip-admin/internal/webhook"
 )
 
-func CDRIngestHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+func CDRIngestHandler(cfg *config.Config, pool *pgxpool.Pool, live *calls.Table) http.HandlerFunc {
+    ingester := newCDRIngester(cfg, pool, live)
+
     return func(w http.ResponseWriter, r *http.Request) {
         body, err := io.ReadAll(r.Body)
         if err != nil {
@@ -18,12 +35,162 @@ func CDRIngestHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
         }
         defer r.Body.Close()
 
-        if err := cdr.InsertCDR(r.Context(), pool, body); err != nil {
-            http.Error(w, "failed to insert cdr", http.StatusInternalServerError)
-            return
+        payload, isXML, err := cdrPayload(r.Header.Get("Content-Type"), body)
+        if err != nil {
+            payload, isXML = body, looksLikeXML(body)
+            err = fmt.Errorf("%w: %v", cdr.ErrInvalidPayload, err)
+        } else {
+            _, err = ingester.ingest(r.Context(), payload, isXML)
+        }
+
+        // Payload lỗi được giữ lại trong dead-letter để sửa và replay; chỉ trả lỗi
+        // cho FreeSWITCH (để nó gửi lại) khi không lưu được cả dead-letter.
+        if err != nil {
+            stage := deadletter.StageInsert
+            if errors.Is(err, cdr.ErrInvalidPayload) {
+                stage = deadletter.StageParse
+            }
+            id, dlErr := deadletter.Store(r.Context(), pool, payloadFormat(isXML), payload, stage, err)
+            if dlErr != nil {
+                log.Printf("cdr ingest: %v; store dead letter: %v", err, dlErr)
+                http.Error(w, "failed to insert cdr", http.StatusInternalServerError)
+                return
+            }
+            log.Printf("cdr ingest: %v; stored as dead letter %d", err, id)
         }
 
         w.WriteHeader(http.StatusOK)
         _, _ = w.Write([]byte("OK"))
     }
 }
+
+// cdrIngester xử lý một payload CDR: insert, rate, kiểm tra gian lận, ghi webhook
+// và đẩy sự kiện cdr.completed tới stream cuộc gọi (nếu live khác nil).
+type cdrIngester struct {
+    pool  *pgxpool.Pool
+    rater *rating.Rater
+    fraud *fraud.Detector
+    live  *calls.Table
+}
+
+func newCDRIngester(cfg *config.Config, pool *pgxpool.Pool, live *calls.Table) *cdrIngester {
+    return &cdrIngester{
+        pool:  pool,
+        rater: &rating.Rater{Pool: pool, AfterRate: balance.Debit},
+        fraud: fraud.NewDetector(pool, cfg.Fraud),
+        live:  live,
+    }
+}
+
+// ingest insert payload CDR, sau đó xử lý tiếp CDR mới hoặc vừa được thay bằng
+// bản đầy đủ hơn. Rating và webhook outbox nằm trong transaction insert nên
+// event không bị mất khi service dừng giữa chừng. Rating, gian lận, call.ended và
+// cdr.completed chỉ áp dụng cho A-leg; CDR B-leg chỉ được lưu (và báo
+// recording.available nếu có bản ghi âm).
+// Trả về id CDR, hoặc 0 nếu bản trùng bị bỏ qua.
+func (in *cdrIngester) ingest(ctx context.Context, payload []byte, isXML bool) (int64, error) {
+    var c *models.CDR
+    after := func(ctx context.Context, tx pgx.Tx, cdrID int64) error {
+        // Lỗi rating chỉ log lại (có thể rerate sau qua API); savepoint giữ phần còn lại.
+        sp, err := tx.Begin(ctx)
+        if err != nil {
+            return err
+        }
+        if _, err := in.rater.RateTx(ctx, sp, cdrID); err != nil {
+            log.Printf("rate cdr %d: %v", cdrID, err)
+            if err := sp.Rollback(ctx); err != nil {
+                return err
+            }
+        } else if err := sp.Commit(ctx); err != nil {
+            return err
+        }
+        if c, err = cdr.Get(ctx, tx, cdrID); err != nil {
+            return fmt.Errorf("load cdr %d: %w", cdrID, err)
+        }
+        return enqueueCDRWebhooks(ctx, tx, c)
+    }
+
+    var (
+        cdrID int64
+        err   error
+    )
+    if isXML {
+        cdrID, err = cdr.InsertXMLCDR(ctx, in.pool, payload, after)
+    } else {
+        cdrID, err = cdr.InsertCDR(ctx, in.pool, payload, after)
+    }
+    if err != nil || cdrID == 0 {
+        return cdrID, err
+    }
+
+    // Kiểm tra gian lận sau khi rate để rule cost_velocity thấy cước của CDR này;
+    // lỗi chỉ log lại.
+    if in.fraud != nil {
+        if err := in.fraud.Check(ctx, cdrID); err != nil {
+            log.Printf("fraud check cdr %d: %v", cdrID, err)
+        }
+    }
+    if in.live != nil && c.Leg == cdr.LegA {
+        in.live.Publish(calls.Update{Type: calls.UpdateCDR, CDR: c})
+    }
+    return cdrID, nil
+}
+
+func payloadFormat(isXML bool) string {
+    if isXML {
+        return "xml"
+    }
+    return "json"
+}
+
+// enqueueCDRWebhooks ghi event call.ended (chỉ với A-leg) và recording.available
+// (nếu CDR có bản ghi âm) vào outbox, sau khi CDR đã được rate.
+func enqueueCDRWebhooks(ctx context.Context, db webhook.Execer, c *models.CDR) error {
+    if c.Leg == cdr.LegA {
+        if err := webhook.Enqueue(ctx, db, webhook.EventCallEnded, c); err != nil {
+            return err
+        }
+    }
+    if c.RecordingID == nil {
+        return nil
+    }
+    return webhook.Enqueue(ctx, db, webhook.EventRecordingAvailable, map[string]interface{}{
+        "recording_id": *c.RecordingID,
+        "cdr_id":       c.ID,
+        "call_uuid":    c.CallUUID,
+        "download_url": fmt.Sprintf("/api/recordings/%d", *c.RecordingID),
+    })
+}
+
+// cdrPayload tách payload CDR và xác định định dạng.
+// mod_json_cdr/mod_xml_cdr có thể POST body thô (application/json, application/xml)
+// hoặc form url-encoded với field "cdr"; khi đó định dạng lấy theo field "format"
+// hoặc ký tự đầu tiên của payload.
+func cdrPayload(contentType string, body []byte) ([]byte, bool, error) {
+    mediaType, _, _ := mime.ParseMediaType(contentType)
+
+    switch mediaType {
+    case "application/xml", "text/xml":
+        return body, true, nil
+    case "application/json":
+        return body, false, nil
+    case "application/x-www-form-urlencoded":
+        form, err := url.ParseQuery(string(body))
+        if err != nil {
+            return nil, false, err
+        }
+        payload := []byte(form.Get("cdr"))
+        switch form.Get("format") {
+        case "xml":
+            return payload, true, nil
+        case "json":
+            return payload, false, nil
+        }
+        return payload, looksLikeXML(payload), nil
+    }
+    return body, looksLikeXML(body), nil
+}
+
+func looksLikeXML(b []byte) bool {
+    return bytes.HasPrefix(bytes.TrimSpace(b), []byte("<"))
+}
@@ -1,87 +1,119 @@
 package httpapi
 
 import (
-    "encoding/json"
     "net/http"
+    "net/url"
     "strconv"
-    "strings"
-    "time"
 
     "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/config"
     "voip-admin/internal/models"
+    "voip-admin/internal/privacy"
 )
 
 type CDRResponse struct {
-    Items []models.CDR `json:"items"`
+    Items      []models.CDR `json:"items"`
+    NextCursor string       `json:"next_cursor,omitempty"`
+    PrevCursor string       `json:"prev_cursor,omitempty"`
+    Total      *int64       `json:"total,omitempty"`
 }
 
-func CDRQueryHandler(pool *pgxpool.Pool) http.HandlerFunc {
+// CDRQueryHandler trả CDR theo keyset pagination trên (start_time, id).
+// Tham số: limit, order (desc|asc), cursor (next_cursor/prev_cursor của trang trước),
+// total=true để đếm tổng số bản ghi khớp filter.
+func CDRQueryHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    return cdrListHandler(cfg, pool, nil)
+}
+
+// cdrListHandler là phần chung của /api/cdr và các API tìm kiếm CDR; extend
+// (nếu có) thêm điều kiện riêng vào filter, lỗi trả về 400.
+func cdrListHandler(cfg *config.Config, pool *pgxpool.Pool, extend func(q url.Values, f *cdrFilter) error) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         q := r.URL.Query()
+        policy := dataPolicy(cfg, r)
 
-        fromStr := q.Get("from")
-        toStr := q.Get("to")
-        caller := q.Get("caller")
-        callee := q.Get("callee")
-
-        limitStr := q.Get("limit")
-        if limitStr == "" {
-            limitStr = "100"
-        }
-        limit, _ := strconv.Atoi(limitStr)
-        if limit <= 0 {
-            limit = 100
+        limit := 100
+        if s := q.Get("limit"); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n <= 0 {
+                http.Error(w, "invalid limit: expected positive integer", http.StatusBadRequest)
+                return
+            }
+            limit = n
         }
         if limit > 1000 {
             limit = 1000
         }
 
-        var (
-            where []string
-            args  []interface{}
-            idx   = 1
-        )
-
-        layout := time.RFC3339
-        if fromStr != "" {
-            if t, err := time.Parse(layout, fromStr); err == nil {
-                where = append(where, "start_time >= $"+strconv.Itoa(idx))
-                args = append(args, t)
-                idx++
+        var cur *cdrCursor
+        asc := false
+        switch q.Get("order") {
+        case "", "desc":
+        case "asc":
+            asc = true
+        default:
+            http.Error(w, "invalid order: expected asc or desc", http.StatusBadRequest)
+            return
+        }
+        if s := q.Get("cursor"); s != "" {
+            c, err := decodeCDRCursor(s)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
             }
+            cur = c
+            asc = c.Asc
         }
-        if toStr != "" {
-            if t, err := time.Parse(layout, toStr); err == nil {
-                where = append(where, "start_time <= $"+strconv.Itoa(idx))
-                args = append(args, t)
-                idx++
+
+        f, err := parseCDRFilter(q)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if extend != nil {
+            if err := extend(q, f); err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
             }
         }
-        if caller != "" {
-            where = append(where, "caller_id_number = $"+strconv.Itoa(idx))
-            args = append(args, caller)
-            idx++
+
+        res := CDRResponse{}
+        if q.Get("total") == "true" {
+            var total int64
+            if err := pool.QueryRow(r.Context(), "SELECT COUNT(*) FROM voip.cdr"+f.whereSQL(), f.args...).Scan(&total); err != nil {
+                http.Error(w, "query error", http.StatusInternalServerError)
+                return
+            }
+            res.Total = &total
         }
-        if callee != "" {
-            where = append(where, "destination_number = $"+strconv.Itoa(idx))
-            args = append(args, callee)
-            idx++
+
+        // Trang "prev" được đọc theo chiều ngược lại rồi đảo lại trước khi trả về.
+        backward := cur != nil && cur.Prev
+        scanAsc := asc != backward
+        if cur != nil {
+            op := "<"
+            if scanAsc {
+                op = ">"
+            }
+            f.add("(start_time, id) "+op+" (?, ?)", cur.StartTime, cur.ID)
         }
 
-        query := "SELECT id, call_uuid, direction, caller_id_number, destination_number, start_time, answer_time, end_time, duration, billsec, hangup_cause, queue_id, agent_user_id, trunk_id, recording_id, created_at FROM voip.cdr"
-        if len(where) > 0 {
-            query += " WHERE " + strings.Join(where, " AND ")
+        dir := " DESC"
+        if scanAsc {
+            dir = " ASC"
         }
-        query += " ORDER BY start_time DESC LIMIT " + strconv.Itoa(limit)
 
-        rows, err := pool.Query(r.Context(), query, args...)
+        query := "SELECT id, call_uuid, direction, caller_id_number, destination_number, start_time, answer_time, end_time, duration, billsec, hangup_cause, queue_id, agent_user_id, trunk_id, recording_id, domain_id, cost::text, currency, leg, originating_leg_uuid, created_at FROM voip.cdr"
+        query += f.whereSQL()
+        query += " ORDER BY start_time" + dir + ", id" + dir + " LIMIT " + strconv.Itoa(limit+1)
+
+        rows, err := pool.Query(r.Context(), query, f.args...)
         if err != nil {
             http.Error(w, "query error", http.StatusInternalServerError)
             return
         }
         defer rows.Close()
 
-        res := CDRResponse{}
         for rows.Next() {
             var c models.CDR
             if err := rows.Scan(
@@ -90,15 +122,48 @@ func CDRQueryHandler(pool *pgxpool.Pool) http.HandlerFunc {
                 &c.StartTime, &c.AnswerTime, &c.EndTime,
                 &c.Duration, &c.BillSec, &c.HangupCause,
                 &c.QueueID, &c.AgentUserID, &c.TrunkID, &c.RecordingID,
+                &c.DomainID, &c.Cost, &c.Currency,
+                &c.Leg, &c.OriginatingLeg,
                 &c.CreatedAt,
             ); err != nil {
                 http.Error(w, "scan error", http.StatusInternalServerError)
                 return
             }
+            if policy.MaskNumbers {
+                c.CallerIDNumber = privacy.MaskNumberPtr(c.CallerIDNumber, policy.KeepDigits)
+                c.DestinationNumber = privacy.MaskNumberPtr(c.DestinationNumber, policy.KeepDigits)
+            }
             res.Items = append(res.Items, c)
         }
+        if err := rows.Err(); err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+
+        more := len(res.Items) > limit
+        if more {
+            res.Items = res.Items[:limit]
+        }
+        if backward {
+            for i, j := 0, len(res.Items)-1; i < j; i, j = i+1, j-1 {
+                res.Items[i], res.Items[j] = res.Items[j], res.Items[i]
+            }
+        }
+
+        if n := len(res.Items); n > 0 {
+            first, last := res.Items[0], res.Items[n-1]
+            // Đi tới: còn trang sau nếu đọc dư; luôn có trang trước nếu đã dùng cursor.
+            // Đi lùi: ngược lại.
+            hasNext := (!backward && more) || backward
+            hasPrev := (backward && more) || (!backward && cur != nil)
+            if hasNext {
+                res.NextCursor = cdrCursor{StartTime: last.StartTime, ID: last.ID, Asc: asc}.encode()
+            }
+            if hasPrev {
+                res.PrevCursor = cdrCursor{StartTime: first.StartTime, ID: first.ID, Asc: asc, Prev: true}.encode()
+            }
+        }
 
-        w.Header().Set("Content-Type", "application/json")
-        _ = json.NewEncoder(w).Encode(res)
+        writeJSON(w, http.StatusOK, res)
     }
 }
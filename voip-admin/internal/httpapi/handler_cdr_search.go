@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/cdr"
+    "voip-admin/internal/config"
+)
+
+// maxVarConditions giới hạn số điều kiện biến trong một lần tìm.
+const maxVarConditions = 5
+
+// CDRSearchHandler tìm CDR theo biến kênh trong raw_json, ví dụ
+// var=sip_call_id:eq:abc@10.0.0.1 hoặc var=crm_ticket_id:prefix:T-2024.
+// Tham số var lặp lại được (AND), chỉ nhận biến trong cdr_search.variables;
+// from và to là bắt buộc và không vượt quá cdr_search.max_range. Các filter,
+// phân trang và định dạng trả về giống /api/cdr.
+func CDRSearchHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    allowed := make(map[string]bool, len(cfg.CDRSearch.Variables))
+    for _, v := range cfg.CDRSearch.Variables {
+        allowed[v] = true
+    }
+    maxRange := cfg.CDRSearch.MaxRange
+
+    return cdrListHandler(cfg, pool, func(q url.Values, f *cdrFilter) error {
+        from, to, err := parseTimeRange(q)
+        if err != nil {
+            return err
+        }
+        if to.Sub(from) > maxRange {
+            return fmt.Errorf("invalid range: must not exceed %s", maxRange)
+        }
+
+        vars := q["var"]
+        if len(vars) == 0 {
+            return errors.New("at least one var condition is required")
+        }
+        if len(vars) > maxVarConditions {
+            return fmt.Errorf("too many var conditions: at most %d", maxVarConditions)
+        }
+        for _, s := range vars {
+            c, err := cdr.ParseVarCondition(s, allowed)
+            if err != nil {
+                return err
+            }
+            cond, args := c.SQL()
+            f.add(cond, args...)
+        }
+        return nil
+    })
+}
+
+// CDRSearchVariablesHandler trả allow-list biến có thể tìm kiếm.
+func CDRSearchVariablesHandler(cfg *config.Config) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+            "variables": cfg.CDRSearch.Variables,
+            "operators": []string{cdr.OpEq, cdr.OpNe, cdr.OpPrefix, cdr.OpContains, cdr.OpIn, cdr.OpExists},
+            "max_range": cfg.CDRSearch.MaxRange.String(),
+        })
+    }
+}
@@ -0,0 +1,209 @@
+package httpapi
+
+import (
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/cdr"
+    "voip-admin/internal/config"
+    "voip-admin/internal/deadletter"
+)
+
+type ReplayResponse struct {
+    Replayed bool              `json:"replayed"`
+    CDRID    *int64            `json:"cdr_id,omitempty"`
+    Error    string            `json:"error,omitempty"`
+    Entry    *deadletter.Entry `json:"entry"`
+}
+
+// DeadLettersHandler liệt kê payload CDR bị từ chối.
+// Tham số: status (pending|replayed|discarded), before (id), limit (mặc định 100, tối đa 1000).
+func DeadLettersHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        status := q.Get("status")
+        switch status {
+        case "", deadletter.StatusPending, deadletter.StatusReplayed, deadletter.StatusDiscarded:
+        default:
+            http.Error(w, "invalid status: expected pending, replayed or discarded", http.StatusBadRequest)
+            return
+        }
+        before, err := idParam(q, "before")
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        var beforeID int64
+        if before != nil {
+            beforeID = *before
+        }
+        limit := 100
+        if s := q.Get("limit"); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n <= 0 || n > 1000 {
+                http.Error(w, "invalid limit: expected 1..1000", http.StatusBadRequest)
+                return
+            }
+            limit = n
+        }
+
+        items, err := deadletter.List(r.Context(), pool, status, beforeID, limit)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if items == nil {
+            items = []deadletter.Entry{}
+        }
+        writeJSON(w, http.StatusOK, items)
+    }
+}
+
+func DeadLetterHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, ok := deadLetterID(w, r)
+        if !ok {
+            return
+        }
+        e, err := deadletter.Get(r.Context(), pool, id)
+        if err != nil {
+            writeDeadLetterError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, hidePayload(cfg, r, e))
+    }
+}
+
+// FixDeadLetterHandler thay payload bằng body của request (JSON hoặc XML,
+// cùng cách nhận dạng như /fs/cdr). Payload chỉ được ingest lại khi gọi replay.
+func FixDeadLetterHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, ok := deadLetterID(w, r)
+        if !ok {
+            return
+        }
+        body, err := io.ReadAll(r.Body)
+        if err != nil || len(body) == 0 {
+            http.Error(w, "invalid body", http.StatusBadRequest)
+            return
+        }
+        payload, isXML, err := cdrPayload(r.Header.Get("Content-Type"), body)
+        if err != nil {
+            http.Error(w, "invalid body", http.StatusBadRequest)
+            return
+        }
+
+        if err := deadletter.UpdatePayload(r.Context(), pool, id, payloadFormat(isXML), payload); err != nil {
+            writeDeadLetterError(w, err)
+            return
+        }
+        e, err := deadletter.Get(r.Context(), pool, id)
+        if err != nil {
+            writeDeadLetterError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, hidePayload(cfg, r, e))
+    }
+}
+
+// ReplayDeadLetterHandler ingest lại payload như một CDR mới (xử lý như /fs/cdr,
+// trừ stream cuộc gọi vì cuộc gọi đã kết thúc từ lâu).
+// Lỗi replay được ghi vào dead letter và trả về 422.
+func ReplayDeadLetterHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    ingester := newCDRIngester(cfg, pool, nil)
+
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, ok := deadLetterID(w, r)
+        if !ok {
+            return
+        }
+        e, err := deadletter.Get(r.Context(), pool, id)
+        if err != nil {
+            writeDeadLetterError(w, err)
+            return
+        }
+        if e.Status != deadletter.StatusPending {
+            writeDeadLetterError(w, deadletter.ErrNotPending)
+            return
+        }
+
+        resp := ReplayResponse{}
+        status := http.StatusOK
+        cdrID, ingestErr := ingester.ingest(r.Context(), []byte(*e.Payload), e.Format == "xml")
+        if ingestErr != nil {
+            stage := deadletter.StageInsert
+            if errors.Is(ingestErr, cdr.ErrInvalidPayload) {
+                stage = deadletter.StageParse
+            }
+            err = deadletter.MarkFailed(r.Context(), pool, id, stage, ingestErr)
+            resp.Error = ingestErr.Error()
+            status = http.StatusUnprocessableEntity
+        } else {
+            err = deadletter.MarkReplayed(r.Context(), pool, id, cdrID)
+            resp.Replayed = true
+            if cdrID != 0 {
+                resp.CDRID = &cdrID
+            }
+        }
+        if err != nil {
+            log.Printf("update dead letter %d: %v", id, err)
+            writeDeadLetterError(w, err)
+            return
+        }
+
+        if resp.Entry, err = deadletter.Get(r.Context(), pool, id); err != nil {
+            writeDeadLetterError(w, err)
+            return
+        }
+        resp.Entry = hidePayload(cfg, r, resp.Entry)
+        writeJSON(w, status, resp)
+    }
+}
+
+func DiscardDeadLetterHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, ok := deadLetterID(w, r)
+        if !ok {
+            return
+        }
+        if err := deadletter.Discard(r.Context(), pool, id); err != nil {
+            writeDeadLetterError(w, err)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// hidePayload bỏ payload gốc khỏi response nếu role không được xem raw CDR.
+func hidePayload(cfg *config.Config, r *http.Request, e *deadletter.Entry) *deadletter.Entry {
+    if dataPolicy(cfg, r).HideRawJSON {
+        e.Payload = nil
+    }
+    return e
+}
+
+func deadLetterID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil {
+        http.Error(w, "invalid id", http.StatusBadRequest)
+        return 0, false
+    }
+    return id, true
+}
+
+func writeDeadLetterError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, deadletter.ErrNotFound):
+        http.Error(w, "not found", http.StatusNotFound)
+    case errors.Is(err, deadletter.ErrNotPending):
+        http.Error(w, err.Error(), http.StatusConflict)
+    default:
+        http.Error(w, "query error", http.StatusInternalServerError)
+    }
+}
@@ -0,0 +1,224 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "regexp"
+    "strconv"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/audit"
+    "voip-admin/internal/dispatcher"
+)
+
+var nodeNamePattern = regexp.MustCompile(`^[0-9A-Za-z._-]{1,64}$`)
+
+type DrainRequest struct {
+    Reason string `json:"reason"`
+}
+
+// DispatcherStatus là định nghĩa dispatcher kèm drain và health check node.
+type DispatcherStatus struct {
+    Destinations []dispatcher.DestinationState `json:"destinations"`
+    Drains       []dispatcher.Drain            `json:"drains"`
+    Health       []dispatcher.NodeHealth       `json:"health"`
+}
+
+// DispatcherChange là kết quả thay đổi dispatcher và lần đẩy sang Kamailio.
+type DispatcherChange struct {
+    Destination *dispatcher.Destination `json:"destination,omitempty"`
+    Push        *dispatcher.PushResult  `json:"push"`
+}
+
+// DispatcherHandler trả các đích dispatcher với trạng thái vòng hiện tại.
+func DispatcherHandler(mgr *dispatcher.Manager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if mgr == nil {
+            http.Error(w, "kamailio integration is not enabled", http.StatusServiceUnavailable)
+            return
+        }
+        states, err := mgr.States(r.Context())
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        drains, err := dispatcher.Drains(r.Context(), mgr.Pool)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if states == nil {
+            states = []dispatcher.DestinationState{}
+        }
+        health, err := mgr.Health(r.Context())
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if drains == nil {
+            drains = []dispatcher.Drain{}
+        }
+        writeJSON(w, http.StatusOK, DispatcherStatus{Destinations: states, Drains: drains, Health: health})
+    }
+}
+
+// CreateDispatcherDestinationHandler thêm đích vào set rồi đẩy sang Kamailio.
+func CreateDispatcherDestinationHandler(pool *pgxpool.Pool, mgr *dispatcher.Manager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if mgr == nil {
+            http.Error(w, "kamailio integration is not enabled", http.StatusServiceUnavailable)
+            return
+        }
+        var d dispatcher.Destination
+        if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        if err := dispatcher.Create(r.Context(), pool, &d); err != nil {
+            writeDispatcherError(w, err)
+            return
+        }
+        pushDispatcher(w, r, pool, mgr, http.StatusCreated, "dispatcher.create", strconv.FormatInt(d.ID, 10), &d)
+    }
+}
+
+// UpdateDispatcherDestinationHandler ghi đè đích rồi đẩy sang Kamailio.
+func UpdateDispatcherDestinationHandler(pool *pgxpool.Pool, mgr *dispatcher.Manager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if mgr == nil {
+            http.Error(w, "kamailio integration is not enabled", http.StatusServiceUnavailable)
+            return
+        }
+        id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+        if err != nil {
+            http.Error(w, "invalid id", http.StatusBadRequest)
+            return
+        }
+        var d dispatcher.Destination
+        if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        d.ID = id
+        if err := dispatcher.Update(r.Context(), pool, &d); err != nil {
+            writeDispatcherError(w, err)
+            return
+        }
+        pushDispatcher(w, r, pool, mgr, http.StatusOK, "dispatcher.update", strconv.FormatInt(id, 10), &d)
+    }
+}
+
+// DeleteDispatcherDestinationHandler xóa đích rồi đẩy sang Kamailio.
+func DeleteDispatcherDestinationHandler(pool *pgxpool.Pool, mgr *dispatcher.Manager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if mgr == nil {
+            http.Error(w, "kamailio integration is not enabled", http.StatusServiceUnavailable)
+            return
+        }
+        id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+        if err != nil {
+            http.Error(w, "invalid id", http.StatusBadRequest)
+            return
+        }
+        if err := dispatcher.Delete(r.Context(), pool, id); err != nil {
+            writeDispatcherError(w, err)
+            return
+        }
+        pushDispatcher(w, r, pool, mgr, http.StatusOK, "dispatcher.delete", strconv.FormatInt(id, 10), nil)
+    }
+}
+
+// DrainNodeHandler đưa mọi đích của node ra khỏi vòng dispatcher (cuộc gọi đang
+// diễn ra không bị ảnh hưởng); body tùy chọn {"reason": "..."}.
+func DrainNodeHandler(pool *pgxpool.Pool, mgr *dispatcher.Manager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if mgr == nil {
+            http.Error(w, "kamailio integration is not enabled", http.StatusServiceUnavailable)
+            return
+        }
+        node := chi.URLParam(r, "node")
+        if !nodeNamePattern.MatchString(node) {
+            http.Error(w, "invalid node", http.StatusBadRequest)
+            return
+        }
+        var req DrainRequest
+        if err := decodeOptional(r, &req); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        if err := dispatcher.SetDrain(r.Context(), pool, node, req.Reason, consumerName(r)); err != nil {
+            writeDispatcherError(w, err)
+            return
+        }
+        pushDispatcher(w, r, pool, mgr, http.StatusOK, "dispatcher.drain", node, nil)
+    }
+}
+
+// UndrainNodeHandler đưa node trở lại vòng (nếu health check đạt).
+func UndrainNodeHandler(pool *pgxpool.Pool, mgr *dispatcher.Manager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if mgr == nil {
+            http.Error(w, "kamailio integration is not enabled", http.StatusServiceUnavailable)
+            return
+        }
+        node := chi.URLParam(r, "node")
+        if err := dispatcher.ClearDrain(r.Context(), pool, node); err != nil {
+            writeDispatcherError(w, err)
+            return
+        }
+        pushDispatcher(w, r, pool, mgr, http.StatusOK, "dispatcher.undrain", node, nil)
+    }
+}
+
+// ReloadDispatcherHandler ghi lại bảng dispatcher và reload Kamailio.
+func ReloadDispatcherHandler(pool *pgxpool.Pool, mgr *dispatcher.Manager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if mgr == nil {
+            http.Error(w, "kamailio integration is not enabled", http.StatusServiceUnavailable)
+            return
+        }
+        pushDispatcher(w, r, pool, mgr, http.StatusOK, "dispatcher.reload", "kamailio", nil)
+    }
+}
+
+// pushDispatcher ghi audit thay đổi rồi đẩy sang Kamailio. Thay đổi đã lưu nên
+// lỗi đẩy trả 502; có thể gọi lại /api/dispatcher/reload. Khi không còn đích nào
+// được quản lý, bảng của Kamailio được giữ nguyên và trả 409.
+func pushDispatcher(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, mgr *dispatcher.Manager, status int, action, target string, d *dispatcher.Destination) {
+    var details interface{}
+    if d != nil {
+        details = d
+    }
+    if err := audit.Record(r.Context(), pool, consumerName(r), action, target, details); err != nil {
+        log.Printf("audit %s: %v", action, err)
+    }
+
+    res, err := mgr.Push(r.Context())
+    if errors.Is(err, dispatcher.ErrNoDestinations) {
+        http.Error(w, "saved, but no managed destinations left; kamailio dispatcher table left unchanged", http.StatusConflict)
+        return
+    }
+    if err != nil {
+        log.Printf("dispatcher push: %v", err)
+        http.Error(w, "saved, but writing kamailio dispatcher table failed", http.StatusBadGateway)
+        return
+    }
+    writeJSON(w, status, DispatcherChange{Destination: d, Push: res})
+}
+
+func writeDispatcherError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, dispatcher.ErrNotFound):
+        http.Error(w, "not found", http.StatusNotFound)
+    case errors.Is(err, dispatcher.ErrInvalid):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, dispatcher.ErrDuplicate):
+        http.Error(w, err.Error(), http.StatusConflict)
+    default:
+        log.Printf("dispatcher: %v", err)
+        http.Error(w, "query error", http.StatusInternalServerError)
+    }
+}
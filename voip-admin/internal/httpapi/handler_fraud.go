@@ -0,0 +1,106 @@
+package httpapi
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/audit"
+    "voip-admin/internal/config"
+    "voip-admin/internal/fraud"
+    "voip-admin/internal/privacy"
+)
+
+// FraudAlertsHandler liệt kê cảnh báo gian lận trong khoảng from/to.
+// Tham số: pending=true chỉ lấy cảnh báo chưa xác nhận, limit (mặc định 100, tối đa 1000).
+func FraudAlertsHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        from, to, err := parseTimeRange(q)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        pending, err := boolParam(q, "pending")
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        limit := 100
+        if s := q.Get("limit"); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n <= 0 || n > 1000 {
+                http.Error(w, "invalid limit: expected 1..1000", http.StatusBadRequest)
+                return
+            }
+            limit = n
+        }
+
+        items, err := fraud.List(r.Context(), pool, from, to, pending != nil && *pending, limit)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if items == nil {
+            items = []fraud.Alert{}
+        }
+        for i := range items {
+            maskAlert(cfg, r, &items[i])
+        }
+        writeJSON(w, http.StatusOK, items)
+    }
+}
+
+// AckFraudAlertHandler xác nhận cảnh báo; unblock=true kích hoạt lại user đã bị khóa.
+// Mỗi lần xác nhận (và mở khóa) được ghi audit.
+func AckFraudAlertHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+        if err != nil {
+            http.Error(w, "invalid id", http.StatusBadRequest)
+            return
+        }
+        unblock, err := boolParam(r.URL.Query(), "unblock")
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        doUnblock := unblock != nil && *unblock
+        a, err := fraud.Acknowledge(r.Context(), pool, id, doUnblock)
+        switch {
+        case errors.Is(err, fraud.ErrNotFound):
+            http.Error(w, "not found", http.StatusNotFound)
+            return
+        case errors.Is(err, fraud.ErrAcknowledged):
+            http.Error(w, err.Error(), http.StatusConflict)
+            return
+        case err != nil:
+            log.Printf("ack fraud alert %d: %v", id, err)
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+
+        action := "fraud.ack"
+        details := map[string]interface{}{"rule": a.Rule}
+        if doUnblock && a.Blocked && a.UserID != nil {
+            action = "fraud.unblock"
+            details["user_id"] = *a.UserID
+        }
+        if err := audit.Record(r.Context(), pool, consumerName(r), action, strconv.FormatInt(id, 10), details); err != nil {
+            log.Printf("audit %s: %v", action, err)
+        }
+
+        maskAlert(cfg, r, a)
+        writeJSON(w, http.StatusOK, a)
+    }
+}
+
+func maskAlert(cfg *config.Config, r *http.Request, a *fraud.Alert) {
+    if policy := dataPolicy(cfg, r); policy.MaskNumbers {
+        a.Caller = privacy.MaskNumber(a.Caller, policy.KeepDigits)
+    }
+}
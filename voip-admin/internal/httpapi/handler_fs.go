@@ -0,0 +1,14 @@
+package httpapi
+
+import (
+    "net/http"
+
+    "voip-admin/internal/esl"
+)
+
+// FSNodesHandler trả trạng thái kết nối event socket tới từng node FreeSWITCH.
+func FSNodesHandler(fs *esl.Manager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, fs.Nodes())
+    }
+}
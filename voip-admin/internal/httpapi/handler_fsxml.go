@@ -35,22 +35,28 @@ func DirectoryHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
 }
 
 func DialplanHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
-    svc := &fsxml.DialplanService{Pool: pool}
+    svc := &fsxml.DialplanService{Pool: pool, MaxCallSeconds: cfg.Prepaid.MaxCallSeconds}
 
     return func(w http.ResponseWriter, r *http.Request) {
         caller := r.URL.Query().Get("caller")
         callee := r.URL.Query().Get("callee")
+        domain := r.URL.Query().Get("domain")
         contextName := r.URL.Query().Get("context")
         if contextName == "" {
             contextName = "default"
         }
+        // uuid kênh gọi: tham số uuid, hoặc trường Unique-ID mod_xml_curl POST kèm.
+        callUUID := r.URL.Query().Get("uuid")
+        if callUUID == "" {
+            callUUID = r.PostFormValue("Unique-ID")
+        }
 
-        if callee == "" {
-            http.Error(w, "missing callee", http.StatusBadRequest)
+        if callee == "" || domain == "" {
+            http.Error(w, "missing callee or domain", http.StatusBadRequest)
             return
         }
 
-        doc, err := svc.BuildDialplan(r.Context(), caller, callee, contextName)
+        doc, err := svc.BuildDialplan(r.Context(), caller, callee, domain, contextName, callUUID)
         if err != nil {
             http.Error(w, "no route", http.StatusNotFound)
             return
@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+    "context"
+    "log"
+    "net/http"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/audit"
+    "voip-admin/internal/kamailio"
+)
+
+// KamailioDriftHandler so sánh subscriber/domain/address của Kamailio với dữ
+// liệu của service mà không sửa gì.
+func KamailioDriftHandler(syncer *kamailio.Syncer) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if syncer == nil {
+            http.Error(w, "kamailio integration is not enabled", http.StatusServiceUnavailable)
+            return
+        }
+        rep, err := syncer.Check(r.Context())
+        if err != nil {
+            log.Printf("kamailio drift check: %v", err)
+            http.Error(w, "drift check failed", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusOK, rep)
+    }
+}
+
+// KamailioSyncHandler đồng bộ ngay (ngoài chu kỳ nền) và ghi audit nếu có thay đổi.
+func KamailioSyncHandler(pool *pgxpool.Pool, syncer *kamailio.Syncer) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if syncer == nil {
+            http.Error(w, "kamailio integration is not enabled", http.StatusServiceUnavailable)
+            return
+        }
+        rep, err := syncer.Sync(r.Context())
+        if err != nil {
+            log.Printf("kamailio sync: %v", err)
+            http.Error(w, "sync failed", http.StatusInternalServerError)
+            return
+        }
+        if !rep.InSync {
+            if err := audit.Record(context.Background(), pool, consumerName(r), "kamailio.sync", "kamailio", rep.Tables); err != nil {
+                log.Printf("audit kamailio.sync: %v", err)
+            }
+        }
+        writeJSON(w, http.StatusOK, rep)
+    }
+}
@@ -0,0 +1,103 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/calls"
+    "voip-admin/internal/config"
+    "voip-admin/internal/originate"
+    "voip-admin/internal/privacy"
+)
+
+// OriginateRequest là body của POST /api/calls; user xác định bằng user_id
+// hoặc domain + extension.
+type OriginateRequest struct {
+    UserID      int64  `json:"user_id"`
+    Domain      string `json:"domain"`
+    Extension   string `json:"extension"`
+    Destination string `json:"destination"`
+    Node        string `json:"node"`
+}
+
+// OriginateStatus là tiến trình yêu cầu click-to-call kèm trạng thái channel
+// nếu cuộc gọi còn đang diễn ra.
+type OriginateStatus struct {
+    *originate.Request
+    Call *calls.Call `json:"call,omitempty"`
+}
+
+// OriginateHandler gọi extension của user, khi user nhấc máy thì nối tới số
+// đích. Trả 202 với call UUID để theo dõi qua /api/calls/originate/{uuid}.
+func OriginateHandler(svc *originate.Service) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req OriginateRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        if req.UserID <= 0 && (req.Domain == "" || req.Extension == "") {
+            http.Error(w, "user_id or domain and extension are required", http.StatusBadRequest)
+            return
+        }
+
+        res, err := svc.Originate(r.Context(), originate.Params{
+            UserID:      req.UserID,
+            Extension:   req.Extension,
+            Domain:      req.Domain,
+            Destination: req.Destination,
+            Node:        req.Node,
+            RequestedBy: consumerName(r),
+        })
+        var denied *originate.DeniedError
+        switch {
+        case errors.Is(err, originate.ErrInvalidDestination):
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        case errors.Is(err, originate.ErrNotFound):
+            http.Error(w, "user not found", http.StatusNotFound)
+            return
+        case errors.Is(err, originate.ErrUserInactive), errors.As(err, &denied):
+            http.Error(w, err.Error(), http.StatusForbidden)
+            return
+        case errors.Is(err, originate.ErrNoNode):
+            http.Error(w, err.Error(), http.StatusServiceUnavailable)
+            return
+        case err != nil:
+            log.Printf("originate: %v", err)
+            http.Error(w, "originate failed", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusAccepted, res)
+    }
+}
+
+// OriginateStatusHandler trả tiến trình của yêu cầu click-to-call.
+func OriginateStatusHandler(cfg *config.Config, pool *pgxpool.Pool, live *calls.Table) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        req, err := originate.Get(r.Context(), pool, chi.URLParam(r, "uuid"))
+        if errors.Is(err, originate.ErrNotFound) {
+            http.Error(w, "not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+
+        policy := dataPolicy(cfg, r)
+        if policy.MaskNumbers {
+            req.Destination = privacy.MaskNumber(req.Destination, policy.KeepDigits)
+        }
+        res := OriginateStatus{Request: req}
+        if c, ok := live.Get(req.CallUUID); ok {
+            maskCall(policy, &c)
+            res.Call = &c
+        }
+        writeJSON(w, http.StatusOK, res)
+    }
+}
@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/audit"
+    "voip-admin/internal/config"
+    "voip-admin/internal/privacy"
+)
+
+type ErasureRequest struct {
+    Number string `json:"number"`
+}
+
+// ErasureHandler ẩn danh một số điện thoại trên toàn bộ CDR và xóa bản ghi âm
+// liên quan. Chỉ role có allow_erasure được gọi; kết quả được ghi vào audit log.
+func ErasureHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if !dataPolicy(cfg, r).AllowErasure {
+            http.Error(w, "erasure is not allowed for this api key", http.StatusForbidden)
+            return
+        }
+        var req ErasureRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+
+        res, err := privacy.Erase(r.Context(), pool, cfg.Recordings.BasePath, cfg.CDRPartitions.ArchiveDir, req.Number, consumerName(r))
+        if errors.Is(err, privacy.ErrInvalidNumber) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if err != nil {
+            log.Printf("erasure: %v", err)
+            http.Error(w, "erasure failed", http.StatusInternalServerError)
+            return
+        }
+        log.Printf("erasure %s by %s: %d cdrs, %d recordings", res.Subject, consumerName(r), res.CDRs, res.Recordings)
+        writeJSON(w, http.StatusOK, res)
+    }
+}
+
+// AuditLogHandler liệt kê audit log. Tham số: action (tiền tố, ví dụ "privacy."),
+// before (id), limit (mặc định 100, tối đa 1000).
+func AuditLogHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        before, err := idParam(q, "before")
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        var beforeID int64
+        if before != nil {
+            beforeID = *before
+        }
+        limit := 100
+        if s := q.Get("limit"); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n <= 0 || n > 1000 {
+                http.Error(w, "invalid limit: expected 1..1000", http.StatusBadRequest)
+                return
+            }
+            limit = n
+        }
+
+        items, err := audit.List(r.Context(), pool, q.Get("action"), beforeID, limit)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if items == nil {
+            items = []audit.Entry{}
+        }
+        writeJSON(w, http.StatusOK, items)
+    }
+}
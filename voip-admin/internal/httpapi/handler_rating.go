@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/balance"
+    "voip-admin/internal/rating"
+)
+
+type RatingTotalsResponse struct {
+    Items []rating.AccountTotal `json:"items"`
+}
+
+// RerateHandler tính lại cước cho các CDR trong khoảng from/to, tùy chọn theo domain_id.
+func RerateHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    rater := &rating.Rater{Pool: pool, AfterRate: balance.Debit}
+
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        from, to, err := parseTimeRange(q)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        var domainID *int64
+        if s := q.Get("domain_id"); s != "" {
+            id, err := strconv.ParseInt(s, 10, 64)
+            if err != nil {
+                http.Error(w, "invalid domain_id", http.StatusBadRequest)
+                return
+            }
+            domainID = &id
+        }
+
+        n, err := rater.Rerate(r.Context(), from, to, domainID)
+        if err != nil {
+            log.Printf("rerate: %v", err)
+            http.Error(w, "rerate failed", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusOK, map[string]int{"rated": n})
+    }
+}
+
+// RatingTotalsHandler trả tổng cước theo account (domain) trong khoảng from/to.
+func RatingTotalsHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    rater := &rating.Rater{Pool: pool}
+
+    return func(w http.ResponseWriter, r *http.Request) {
+        from, to, err := parseTimeRange(r.URL.Query())
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        items, err := rater.Totals(r.Context(), from, to)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusOK, RatingTotalsResponse{Items: items})
+    }
+}
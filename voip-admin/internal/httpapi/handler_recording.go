@@ -14,6 +14,10 @@ import (
 
 func RecordingHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
+        if dataPolicy(cfg, r).DenyRecordings {
+            http.Error(w, "recordings are not available for this api key", http.StatusForbidden)
+            return
+        }
         idStr := chi.URLParam(r, "id")
         id, err := strconv.ParseInt(idStr, 10, 64)
         if err != nil {
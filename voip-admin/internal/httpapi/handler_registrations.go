@@ -0,0 +1,103 @@
+package httpapi
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/config"
+    "voip-admin/internal/esl"
+    "voip-admin/internal/kamailio"
+    "voip-admin/internal/registrations"
+)
+
+// RegistrationList là danh sách đăng ký kèm lỗi của các nguồn không đọc được.
+type RegistrationList struct {
+    Registrations []registrations.Registration `json:"registrations"`
+    Errors        []registrations.SourceError  `json:"errors,omitempty"`
+}
+
+// RegistrationSummary là tổng hợp đăng ký theo domain.
+type RegistrationSummary struct {
+    Domains []registrations.DomainSummary `json:"domains"`
+    Errors  []registrations.SourceError   `json:"errors,omitempty"`
+}
+
+// RegistrationsHandler liệt kê đăng ký SIP từ FreeSWITCH (sofia) và/hoặc bảng
+// location của Kamailio. Tham số: domain, user, source (freeswitch | kamailio,
+// mặc định mọi nguồn đã cấu hình).
+func RegistrationsHandler(cfg *config.Config, fs *esl.Manager, kam *kamailio.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        items, errs, err := loadRegistrations(r.Context(), cfg, fs, kam, r.URL.Query())
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if items == nil {
+            items = []registrations.Registration{}
+        }
+        writeJSON(w, http.StatusOK, RegistrationList{Registrations: items, Errors: errs})
+    }
+}
+
+// RegistrationSummaryHandler tổng hợp theo domain: số user, số user đã đăng ký,
+// số contact và số contact trên từng node. Cùng tham số domain, source.
+func RegistrationSummaryHandler(cfg *config.Config, pool *pgxpool.Pool, fs *esl.Manager, kam *kamailio.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        q.Del("user")
+        items, errs, err := loadRegistrations(r.Context(), cfg, fs, kam, q)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        domains, err := registrations.Summarize(r.Context(), pool, items, q.Get("domain"))
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusOK, RegistrationSummary{Domains: domains, Errors: errs})
+    }
+}
+
+func loadRegistrations(ctx context.Context, cfg *config.Config, fs *esl.Manager, kam *kamailio.DB, q url.Values) ([]registrations.Registration, []registrations.SourceError, error) {
+    f := registrations.Filter{Domain: q.Get("domain"), User: q.Get("user")}
+    if f.Domain != "" && !domainPattern.MatchString(f.Domain) {
+        return nil, nil, fmt.Errorf("invalid domain %q", f.Domain)
+    }
+    if f.User != "" && !numberPattern.MatchString(f.User) {
+        return nil, nil, fmt.Errorf("invalid user %q", f.User)
+    }
+
+    source := q.Get("source")
+    switch source {
+    case "", registrations.SourceFreeSWITCH:
+    case registrations.SourceKamailio:
+        if kam == nil {
+            return nil, nil, fmt.Errorf("kamailio integration is not enabled")
+        }
+    default:
+        return nil, nil, fmt.Errorf("invalid source %q: expected freeswitch or kamailio", source)
+    }
+
+    var (
+        items []registrations.Registration
+        errs  []registrations.SourceError
+    )
+    if source == "" || source == registrations.SourceFreeSWITCH {
+        items, errs = registrations.FromFreeSWITCH(ctx, fs, cfg.ESL.SofiaProfiles, f)
+    }
+    if kam != nil && (source == "" || source == registrations.SourceKamailio) {
+        k, err := registrations.FromKamailio(ctx, kam, f)
+        if err != nil {
+            log.Printf("kamailio registrations: %v", err)
+            errs = append(errs, registrations.SourceError{Source: registrations.SourceKamailio, Error: "query error"})
+        }
+        items = append(items, k...)
+    }
+    registrations.Sort(items)
+    return items, errs, nil
+}
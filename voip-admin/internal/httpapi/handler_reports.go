@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/reports"
+)
+
+type QueueReportResponse struct {
+    Queue      string                  `json:"queue"`
+    SLASeconds int                     `json:"sla_seconds"`
+    Items      []reports.QueueInterval `json:"items"`
+}
+
+// QueueReportHandler trả số liệu SLA/abandon của một queue.
+// Tham số: from, to, interval (hour|day|total, mặc định hour), sla (giây, mặc định 20), tz.
+func QueueReportHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        from, to, err := parseTimeRange(q)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        p := reports.QueueParams{
+            Queue:      chi.URLParam(r, "queue"),
+            From:       from,
+            To:         to,
+            Interval:   q.Get("interval"),
+            TZ:         q.Get("tz"),
+            SLASeconds: 20,
+        }
+        if p.Interval == "" {
+            p.Interval = "hour"
+        }
+        if !reports.ValidInterval(p.Interval) {
+            http.Error(w, "invalid interval: expected hour, day or total", http.StatusBadRequest)
+            return
+        }
+        if p.TZ != "" {
+            if _, err := time.LoadLocation(p.TZ); err != nil {
+                http.Error(w, "invalid tz", http.StatusBadRequest)
+                return
+            }
+        }
+        if s := q.Get("sla"); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n <= 0 {
+                http.Error(w, "invalid sla: expected positive number of seconds", http.StatusBadRequest)
+                return
+            }
+            p.SLASeconds = n
+        }
+
+        items, err := reports.QueueReport(r.Context(), pool, p)
+        if err != nil {
+            log.Printf("queue report: %v", err)
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusOK, QueueReportResponse{Queue: p.Queue, SLASeconds: p.SLASeconds, Items: items})
+    }
+}
+
+type AgentReportResponse struct {
+    Items []reports.AgentPerformance `json:"items"`
+}
+
+// AgentReportHandler trả hiệu suất agent trong khoảng from/to, filter tùy chọn queue và user_id.
+func AgentReportHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        from, to, err := parseTimeRange(q)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        p := reports.AgentParams{From: from, To: to, Queue: q.Get("queue")}
+        if p.UserID, err = idParam(q, "user_id"); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        items, err := reports.AgentReport(r.Context(), pool, p)
+        if err != nil {
+            log.Printf("agent report: %v", err)
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusOK, AgentReportResponse{Items: items})
+    }
+}
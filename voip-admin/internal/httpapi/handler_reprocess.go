@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/config"
+    "voip-admin/internal/reprocess"
+)
+
+// CreateReprocessHandler tạo job tính lại cột CDR từ raw_json trong khoảng from/to.
+// dry_run=true chỉ báo cáo khác biệt. Job chạy nền; theo dõi qua GET /api/cdr/reprocess/{id}.
+func CreateReprocessHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        from, to, err := parseTimeRange(q)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        dryRun, err := boolParam(q, "dry_run")
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        job, err := reprocess.Create(r.Context(), pool, from, to, dryRun != nil && *dryRun)
+        if err != nil {
+            log.Printf("create reprocess job: %v", err)
+            http.Error(w, "create failed", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusAccepted, job)
+    }
+}
+
+func ReprocessJobHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, ok := reprocessJobID(w, r)
+        if !ok {
+            return
+        }
+        job, err := reprocess.Get(r.Context(), pool, id)
+        if err != nil {
+            writeReprocessError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, job)
+    }
+}
+
+// ReprocessDiffsHandler trả các khác biệt mẫu của job. Tham số: limit (mặc định 100, tối đa 1000).
+// Với role che số, giá trị cũ/mới của cột số gọi/bị gọi cũng được che.
+func ReprocessDiffsHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, ok := reprocessJobID(w, r)
+        if !ok {
+            return
+        }
+        limit := 100
+        if s := r.URL.Query().Get("limit"); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n <= 0 || n > 1000 {
+                http.Error(w, "invalid limit: expected 1..1000", http.StatusBadRequest)
+                return
+            }
+            limit = n
+        }
+
+        if _, err := reprocess.Get(r.Context(), pool, id); err != nil {
+            writeReprocessError(w, err)
+            return
+        }
+        diffs, err := reprocess.Diffs(r.Context(), pool, id, limit)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if diffs == nil {
+            diffs = []reprocess.Diff{}
+        }
+        if policy := dataPolicy(cfg, r); policy.MaskNumbers {
+            for _, d := range diffs {
+                reprocess.MaskNumbers(d, policy.KeepDigits)
+            }
+        }
+        writeJSON(w, http.StatusOK, diffs)
+    }
+}
+
+func CancelReprocessHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, ok := reprocessJobID(w, r)
+        if !ok {
+            return
+        }
+        job, err := reprocess.Cancel(r.Context(), pool, id)
+        if err != nil {
+            writeReprocessError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, job)
+    }
+}
+
+func reprocessJobID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil {
+        http.Error(w, "invalid id", http.StatusBadRequest)
+        return 0, false
+    }
+    return id, true
+}
+
+func writeReprocessError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, reprocess.ErrNotFound):
+        http.Error(w, "not found", http.StatusNotFound)
+    case errors.Is(err, reprocess.ErrFinished):
+        http.Error(w, err.Error(), http.StatusConflict)
+    default:
+        http.Error(w, "query error", http.StatusInternalServerError)
+    }
+}
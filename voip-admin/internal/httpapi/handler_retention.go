@@ -0,0 +1,114 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgconn"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/config"
+    "voip-admin/internal/partition"
+)
+
+type RetentionResponse struct {
+    DefaultRetentionDays int                `json:"default_retention_days"`
+    ArchiveEnabled       bool               `json:"archive_enabled"`
+    Policies             []partition.Policy `json:"policies"`
+}
+
+type SetRetentionRequest struct {
+    RetentionDays int `json:"retention_days"`
+}
+
+// CDRPartitionsHandler liệt kê các partition của voip.cdr.
+func CDRPartitionsHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        parts, err := partition.List(r.Context(), pool)
+        if err != nil {
+            log.Printf("list cdr partitions: %v", err)
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if parts == nil {
+            parts = []partition.Partition{}
+        }
+        writeJSON(w, http.StatusOK, parts)
+    }
+}
+
+func RetentionPoliciesHandler(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        policies, err := partition.ListPolicies(r.Context(), pool)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if policies == nil {
+            policies = []partition.Policy{}
+        }
+        writeJSON(w, http.StatusOK, RetentionResponse{
+            DefaultRetentionDays: cfg.CDRPartitions.RetentionDays,
+            ArchiveEnabled:       cfg.CDRPartitions.ArchiveDir != "",
+            Policies:             policies,
+        })
+    }
+}
+
+// SetRetentionHandler đặt retention riêng (ngày) cho CDR của một domain.
+func SetRetentionHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        domainID, err := strconv.ParseInt(chi.URLParam(r, "domainID"), 10, 64)
+        if err != nil {
+            http.Error(w, "invalid domain id", http.StatusBadRequest)
+            return
+        }
+
+        var req SetRetentionRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "invalid body", http.StatusBadRequest)
+            return
+        }
+        if req.RetentionDays <= 0 {
+            http.Error(w, "invalid retention_days: expected positive number of days", http.StatusBadRequest)
+            return
+        }
+
+        p, err := partition.SetPolicy(r.Context(), pool, domainID, req.RetentionDays)
+        var pgErr *pgconn.PgError
+        if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+            http.Error(w, "domain not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            log.Printf("set retention for domain %d: %v", domainID, err)
+            http.Error(w, "update failed", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusOK, p)
+    }
+}
+
+// DeleteRetentionHandler xóa policy riêng, domain quay về retention mặc định.
+func DeleteRetentionHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        domainID, err := strconv.ParseInt(chi.URLParam(r, "domainID"), 10, 64)
+        if err != nil {
+            http.Error(w, "invalid domain id", http.StatusBadRequest)
+            return
+        }
+        err = partition.DeletePolicy(r.Context(), pool, domainID)
+        if errors.Is(err, partition.ErrNotFound) {
+            http.Error(w, "not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, "delete failed", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
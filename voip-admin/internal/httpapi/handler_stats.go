@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/stats"
+)
+
+type StatsResponse struct {
+    GroupBy string      `json:"group_by"`
+    Items   []stats.Row `json:"items"`
+}
+
+// StatsHandler trả ASR, ACD, số cuộc gọi và đỉnh đồng thời trong khoảng from/to.
+// Tham số: group_by (hour|day|trunk|domain|direction|hangup_cause, mặc định day),
+// tz cho group theo thời gian, và filter domain_id, trunk_id, direction.
+func StatsHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        from, to, err := parseTimeRange(q)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        p := stats.Params{From: from, To: to, GroupBy: q.Get("group_by"), TZ: q.Get("tz")}
+        if p.GroupBy == "" {
+            p.GroupBy = "day"
+        }
+        if !stats.ValidGroupBy(p.GroupBy) {
+            http.Error(w, "invalid group_by: expected hour, day, trunk, domain, direction or hangup_cause", http.StatusBadRequest)
+            return
+        }
+        if p.TZ != "" {
+            if _, err := time.LoadLocation(p.TZ); err != nil {
+                http.Error(w, "invalid tz", http.StatusBadRequest)
+                return
+            }
+        }
+        if p.DomainID, err = idParam(q, "domain_id"); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if p.TrunkID, err = idParam(q, "trunk_id"); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        switch d := q.Get("direction"); d {
+        case "", "inbound", "outbound":
+            p.Direction = d
+        default:
+            http.Error(w, "invalid direction: expected inbound or outbound", http.StatusBadRequest)
+            return
+        }
+
+        items, err := stats.Query(r.Context(), pool, p)
+        if err != nil {
+            log.Printf("stats query: %v", err)
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusOK, StatsResponse{GroupBy: p.GroupBy, Items: items})
+    }
+}
@@ -0,0 +1,147 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/webhook"
+)
+
+type CreateSubscriptionRequest struct {
+    URL    string   `json:"url"`
+    Secret string   `json:"secret"`
+    Events []string `json:"events"`
+}
+
+// Subscription/delivery luôn thuộc consumer là tên của API key đang gọi.
+func consumerName(r *http.Request) string {
+    k, _ := apiKeyFromContext(r.Context())
+    return k.Name
+}
+
+func ListSubscriptionsHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        subs, err := webhook.ListSubscriptions(r.Context(), pool, consumerName(r))
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if subs == nil {
+            subs = []webhook.Subscription{}
+        }
+        writeJSON(w, http.StatusOK, subs)
+    }
+}
+
+// CreateSubscriptionHandler tạo subscription. Nếu không truyền secret, server sinh
+// secret ngẫu nhiên; secret chỉ được trả về trong response này.
+func CreateSubscriptionHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req CreateSubscriptionRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "invalid body", http.StatusBadRequest)
+            return
+        }
+        u, err := url.Parse(req.URL)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            http.Error(w, "invalid url: expected absolute http(s) url", http.StatusBadRequest)
+            return
+        }
+        // Hostname được kiểm tra lại khi gửi (webhook.NewClient); ở đây chỉ
+        // từ chối sớm địa chỉ IP nội bộ viết trực tiếp.
+        if ip := net.ParseIP(u.Hostname()); ip != nil && webhook.BlockedIP(ip) {
+            http.Error(w, "invalid url: "+webhook.ErrBlockedAddress.Error(), http.StatusBadRequest)
+            return
+        }
+        if len(req.Events) == 0 {
+            http.Error(w, "events required", http.StatusBadRequest)
+            return
+        }
+        for _, ev := range req.Events {
+            if !webhook.ValidEvent(ev) {
+                http.Error(w, "unsupported event "+strconv.Quote(ev), http.StatusBadRequest)
+                return
+            }
+        }
+        if req.Secret == "" {
+            req.Secret, err = webhook.GenerateSecret()
+            if err != nil {
+                http.Error(w, "secret generation failed", http.StatusInternalServerError)
+                return
+            }
+        }
+
+        sub := &webhook.Subscription{
+            Consumer: consumerName(r),
+            URL:      req.URL,
+            Secret:   req.Secret,
+            Events:   req.Events,
+            IsActive: true,
+        }
+        if err := webhook.CreateSubscription(r.Context(), pool, sub); err != nil {
+            log.Printf("create webhook subscription: %v", err)
+            http.Error(w, "create failed", http.StatusInternalServerError)
+            return
+        }
+        writeJSON(w, http.StatusCreated, sub)
+    }
+}
+
+func DeleteSubscriptionHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+        if err != nil {
+            http.Error(w, "invalid id", http.StatusBadRequest)
+            return
+        }
+        err = webhook.DeleteSubscription(r.Context(), pool, consumerName(r), id)
+        if errors.Is(err, webhook.ErrNotFound) {
+            http.Error(w, "not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, "delete failed", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// WebhookDeliveriesHandler trả log gửi webhook. Tham số: subscription_id, limit (mặc định 100, tối đa 1000).
+func WebhookDeliveriesHandler(pool *pgxpool.Pool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        subID, err := idParam(q, "subscription_id")
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        limit := 100
+        if s := q.Get("limit"); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n <= 0 || n > 1000 {
+                http.Error(w, "invalid limit: expected 1..1000", http.StatusBadRequest)
+                return
+            }
+            limit = n
+        }
+
+        items, err := webhook.ListDeliveries(r.Context(), pool, consumerName(r), subID, limit)
+        if err != nil {
+            http.Error(w, "query error", http.StatusInternalServerError)
+            return
+        }
+        if items == nil {
+            items = []webhook.Delivery{}
+        }
+        writeJSON(w, http.StatusOK, items)
+    }
+}
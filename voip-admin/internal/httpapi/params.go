@@ -0,0 +1,35 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+// parseTimeRange đọc tham số from/to (RFC3339), cả hai đều bắt buộc.
+func parseTimeRange(q url.Values) (time.Time, time.Time, error) {
+    fromStr, toStr := q.Get("from"), q.Get("to")
+    if fromStr == "" || toStr == "" {
+        return time.Time{}, time.Time{}, errors.New("from and to are required")
+    }
+    from, err := time.Parse(time.RFC3339, fromStr)
+    if err != nil {
+        return time.Time{}, time.Time{}, errors.New("invalid from: expected RFC3339")
+    }
+    to, err := time.Parse(time.RFC3339, toStr)
+    if err != nil {
+        return time.Time{}, time.Time{}, errors.New("invalid to: expected RFC3339")
+    }
+    if to.Before(from) {
+        return time.Time{}, time.Time{}, errors.New("to must not be before from")
+    }
+    return from, to, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    _ = json.NewEncoder(w).Encode(v)
+}
@@ -5,11 +5,20 @@ import (
 
     "github.com/go-chi/chi/v5"
     "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/callcenter"
+    "voip-admin/internal/callctl"
+    "voip-admin/internal/calls"
     "voip-admin/internal/config"
+    "voip-admin/internal/dispatcher"
+    "voip-admin/internal/esl"
+    "voip-admin/internal/kamailio"
+    "voip-admin/internal/originate"
 )
 
-func NewRouter(cfg *config.Config, pool *pgxpool.Pool) http.Handler {
+func NewRouter(cfg *config.Config, pool *pgxpool.Pool, fs *esl.Manager, live *calls.Table, orig *originate.Service, agents *callcenter.Agents, kam *kamailio.DB, disp *dispatcher.Manager) http.Handler {
     r := chi.NewRouter()
+    ctl := &callctl.Controller{Pool: pool, FS: fs, Live: live}
+    syncer := kamailio.NewSyncer(cfg.Kamailio, pool, kam)
 
     r.Use(LoggingMiddleware)
     r.Use(RecoverMiddleware)
@@ -24,12 +33,103 @@ func NewRouter(cfg *config.Config, pool *pgxpool.Pool) http.Handler {
     })
 
     // CDR ingest
-    r.With(CDRTokenAuth(cfg)).Post("/fs/cdr", CDRIngestHandler(cfg, pool))
+    r.With(CDRTokenAuth(cfg)).Post("/fs/cdr", CDRIngestHandler(cfg, pool, live))
+    r.With(CDRTokenAuth(cfg)).Post("/fs/callcenter/events", CallcenterEventsHandler(pool))
 
     // External APIs
     r.Route("/api", func(api chi.Router) {
-        api.With(APIKeyAuth(cfg)).Get("/cdr", CDRQueryHandler(pool))
+        api.With(APIKeyAuth(cfg)).Get("/cdr", CDRQueryHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg)).Get("/cdr/export", CDRExportHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg)).Get("/cdr/search", CDRSearchHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg)).Get("/cdr/search/variables", CDRSearchVariablesHandler(cfg))
+        api.With(APIKeyAuth(cfg)).Get("/cdr/duplicates", CDRDuplicatesHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg)).Get("/cdr/partitions", CDRPartitionsHandler(pool))
+        api.With(APIKeyAuth(cfg)).Get("/cdr/retention", RetentionPoliciesHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCDRRetention)).Put("/cdr/retention/{domainID}", SetRetentionHandler(pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCDRRetention)).Delete("/cdr/retention/{domainID}", DeleteRetentionHandler(pool))
+
+        // CDR dead letters
+        api.With(APIKeyAuth(cfg)).Get("/cdr/dead-letters", DeadLettersHandler(pool))
+        api.With(APIKeyAuth(cfg)).Get("/cdr/dead-letters/{id}", DeadLetterHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCDRDeadLetters)).Put("/cdr/dead-letters/{id}", FixDeadLetterHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCDRDeadLetters)).Post("/cdr/dead-letters/{id}/replay", ReplayDeadLetterHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCDRDeadLetters)).Post("/cdr/dead-letters/{id}/discard", DiscardDeadLetterHandler(pool))
+
+        // CDR reprocessing
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCDRReprocess)).Post("/cdr/reprocess", CreateReprocessHandler(pool))
+        api.With(APIKeyAuth(cfg)).Get("/cdr/reprocess/{id}", ReprocessJobHandler(pool))
+        api.With(APIKeyAuth(cfg)).Get("/cdr/reprocess/{id}/diffs", ReprocessDiffsHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCDRReprocess)).Post("/cdr/reprocess/{id}/cancel", CancelReprocessHandler(pool))
         api.With(APIKeyAuth(cfg)).Get("/recordings/{id}", RecordingHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg)).Get("/stats", StatsHandler(pool))
+        api.With(APIKeyAuth(cfg)).Get("/queues/{queue}/report", QueueReportHandler(pool))
+        api.With(APIKeyAuth(cfg)).Get("/agents/report", AgentReportHandler(pool))
+
+        // Callcenter agent state
+        api.With(APIKeyAuth(cfg)).Get("/agents", AgentsHandler(agents))
+        api.With(APIKeyAuth(cfg)).Get("/agents/{agent}", AgentHandler(agents))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallcenterManage)).Put("/agents/{agent}/status", SetAgentStatusHandler(agents))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallcenterManage)).Put("/agents/{agent}/tiers/{queue}", AddAgentTierHandler(agents))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallcenterManage)).Delete("/agents/{agent}/tiers/{queue}", RemoveAgentTierHandler(agents))
+
+        // Rating
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermRatingRerate)).Post("/rating/rerate", RerateHandler(pool))
+        api.With(APIKeyAuth(cfg)).Get("/rating/totals", RatingTotalsHandler(pool))
+
+        // Prepaid balances
+        api.With(APIKeyAuth(cfg)).Get("/balances/{domainID}", BalanceHandler(pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermBalanceTopUp)).Post("/balances/{domainID}/topup", TopUpHandler(pool))
+
+        // Toll-fraud detection
+        api.With(APIKeyAuth(cfg)).Get("/fraud/alerts", FraudAlertsHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermFraudManage)).Post("/fraud/alerts/{id}/ack", AckFraudAlertHandler(cfg, pool))
+
+        // FreeSWITCH event socket
+        api.With(APIKeyAuth(cfg)).Get("/fs/nodes", FSNodesHandler(fs))
+
+        // SIP registrations
+        api.With(APIKeyAuth(cfg)).Get("/registrations", RegistrationsHandler(cfg, fs, kam))
+        api.With(APIKeyAuth(cfg)).Get("/registrations/summary", RegistrationSummaryHandler(cfg, pool, fs, kam))
+
+        // Kamailio subscriber/domain/address sync
+        api.With(APIKeyAuth(cfg)).Get("/kamailio/drift", KamailioDriftHandler(syncer))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermKamailioSync)).Post("/kamailio/sync", KamailioSyncHandler(pool, syncer))
+
+        // Kamailio dispatcher
+        api.With(APIKeyAuth(cfg)).Get("/dispatcher", DispatcherHandler(disp))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermDispatcherManage)).Post("/dispatcher/destinations", CreateDispatcherDestinationHandler(pool, disp))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermDispatcherManage)).Put("/dispatcher/destinations/{id}", UpdateDispatcherDestinationHandler(pool, disp))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermDispatcherManage)).Delete("/dispatcher/destinations/{id}", DeleteDispatcherDestinationHandler(pool, disp))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermDispatcherManage)).Post("/dispatcher/nodes/{node}/drain", DrainNodeHandler(pool, disp))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermDispatcherManage)).Post("/dispatcher/nodes/{node}/undrain", UndrainNodeHandler(pool, disp))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermDispatcherManage)).Post("/dispatcher/reload", ReloadDispatcherHandler(pool, disp))
+
+        // Live calls
+        api.With(APIKeyAuth(cfg)).Get("/calls/active", ActiveCallsHandler(cfg, live))
+        api.With(APIKeyAuth(cfg)).Get("/calls/stream", CallStreamHandler(cfg, live))
+
+        // Click-to-call
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallOriginate)).Post("/calls", OriginateHandler(orig))
+        api.With(APIKeyAuth(cfg)).Get("/calls/originate/{uuid}", OriginateStatusHandler(cfg, pool, live))
+
+        // In-call control
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallHangup)).Post("/calls/{uuid}/hangup", HangupCallHandler(pool, ctl))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallTransfer)).Post("/calls/{uuid}/transfer", TransferCallHandler(pool, ctl))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallHold)).Post("/calls/{uuid}/hold", HoldCallHandler(pool, ctl, true))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallHold)).Post("/calls/{uuid}/unhold", HoldCallHandler(pool, ctl, false))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallEavesdrop)).Post("/calls/{uuid}/eavesdrop", MonitorCallHandler(pool, ctl, callctl.MonitorEavesdrop))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallWhisper)).Post("/calls/{uuid}/whisper", MonitorCallHandler(pool, ctl, callctl.MonitorWhisper))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermCallBarge)).Post("/calls/{uuid}/barge", MonitorCallHandler(pool, ctl, callctl.MonitorBarge))
+
+        // Data protection
+        api.With(APIKeyAuth(cfg)).Post("/privacy/erasures", ErasureHandler(cfg, pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermAuditRead)).Get("/audit", AuditLogHandler(pool))
+
+        // Webhooks
+        api.With(APIKeyAuth(cfg)).Get("/webhooks/subscriptions", ListSubscriptionsHandler(pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermWebhookManage)).Post("/webhooks/subscriptions", CreateSubscriptionHandler(pool))
+        api.With(APIKeyAuth(cfg), RequirePermission(cfg, config.PermWebhookManage)).Delete("/webhooks/subscriptions/{id}", DeleteSubscriptionHandler(pool))
+        api.With(APIKeyAuth(cfg)).Get("/webhooks/deliveries", WebhookDeliveriesHandler(pool))
     })
 
     return r
@@ -0,0 +1,43 @@
+package kamailio
+
+import (
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/config"
+    "voip-admin/internal/db"
+)
+
+// DB là kết nối tới các bảng của Kamailio.
+type DB struct {
+    Pool   *pgxpool.Pool
+    Schema string
+    // owned cho biết Pool được mở riêng và cần đóng trong Close.
+    owned bool
+}
+
+// Open mở kết nối theo cfg; DSN rỗng thì dùng chung pool của service.
+// Trả nil nếu tích hợp Kamailio không được bật.
+func Open(cfg config.KamailioConfig, shared *pgxpool.Pool) (*DB, error) {
+    if !cfg.Enabled {
+        return nil, nil
+    }
+    if cfg.DBDSN == "" {
+        return &DB{Pool: shared, Schema: cfg.Schema}, nil
+    }
+    pool, err := db.NewPool(cfg.DBDSN)
+    if err != nil {
+        return nil, err
+    }
+    return &DB{Pool: pool, Schema: cfg.Schema, owned: true}, nil
+}
+
+// Close đóng pool nếu được mở riêng.
+func (k *DB) Close() {
+    if k != nil && k.owned {
+        k.Pool.Close()
+    }
+}
+
+// Table trả tên bảng đầy đủ; schema đã được kiểm tra khi nạp cấu hình.
+func (k *DB) Table(name string) string {
+    return k.Schema + "." + name
+}
@@ -0,0 +1,529 @@
+package kamailio
+
+import (
+    "context"
+    "crypto/md5"
+    "encoding/hex"
+    "fmt"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/config"
+)
+
+// maxDriftItems giới hạn số dòng chênh lệch chi tiết trong báo cáo.
+const maxDriftItems = 1000
+
+// syncLockKey là khóa advisory để chỉ một tiến trình đồng bộ chạy tại một thời điểm.
+const syncLockKey = 0x6b616d01
+
+// Loại chênh lệch giữa dữ liệu của service và bảng Kamailio.
+const (
+    DriftMissing = "missing"
+    DriftExtra   = "extra"
+    DriftChanged = "changed"
+    // DriftUnmanaged là dòng thừa không do service tạo: chỉ báo cáo, không xóa.
+    DriftUnmanaged = "unmanaged"
+)
+
+// Drift là một dòng chênh lệch. Giá trị không được đưa vào báo cáo vì có thể
+// chứa mật khẩu/hash, chỉ có tên cột khác nhau.
+type Drift struct {
+    Table  string   `json:"table"`
+    Kind   string   `json:"kind"`
+    Key    string   `json:"key"`
+    Fields []string `json:"fields,omitempty"`
+}
+
+// TableReport là số liệu chênh lệch của một bảng.
+type TableReport struct {
+    Desired int `json:"desired"`
+    Actual  int `json:"actual"`
+    Missing int `json:"missing"`
+    Extra   int `json:"extra"`
+    Changed int `json:"changed"`
+    // Unmanaged là số dòng thừa không thuộc service; không tính vào InSync.
+    Unmanaged int `json:"unmanaged"`
+}
+
+// Report là kết quả kiểm tra (hoặc đồng bộ) subscriber, domain và address.
+type Report struct {
+    CheckedAt time.Time              `json:"checked_at"`
+    Applied   bool                   `json:"applied"`
+    InSync    bool                   `json:"in_sync"`
+    Tables    map[string]TableReport `json:"tables"`
+    Drift     []Drift                `json:"drift"`
+    // Truncated cho biết Drift đã bị cắt bớt.
+    Truncated bool `json:"truncated,omitempty"`
+}
+
+// row là giá trị các cột so sánh của một dòng, theo tên cột.
+type row map[string]string
+
+// tableSync mô tả cách đọc và ghi một bảng Kamailio được đồng bộ.
+type tableSync struct {
+    name    string
+    desired map[string]row
+    actual  map[string]row
+    insert  func(ctx context.Context, tx pgx.Tx, key string, r row) error
+    update  func(ctx context.Context, tx pgx.Tx, key string, r row) error
+    delete  func(ctx context.Context, tx pgx.Tx, key string) error
+}
+
+// Syncer đồng bộ bảng subscriber (ha1/ha1b), domain và address (IP trunk) của
+// Kamailio theo voip.users, voip.domains và voip.trunk_addresses. Service là
+// nguồn gốc: dòng thiếu được thêm, dòng khác được sửa, tất cả trong một
+// transaction. Dòng thừa chỉ bị xóa nếu thuộc service (ghi trong
+// voip.kamailio_sync_owned); dòng có sẵn do người khác tạo được giữ nguyên.
+type Syncer struct {
+    Source         *pgxpool.Pool
+    Kamailio       *DB
+    AddressGroup   int
+    StorePasswords bool
+}
+
+// NewSyncer tạo Syncer theo cấu hình; trả nil nếu k là nil (tích hợp tắt).
+func NewSyncer(cfg config.KamailioConfig, source *pgxpool.Pool, k *DB) *Syncer {
+    if k == nil {
+        return nil
+    }
+    return &Syncer{Source: source, Kamailio: k, AddressGroup: cfg.AddressGroup, StorePasswords: cfg.StorePasswords}
+}
+
+// Run đồng bộ định kỳ tới khi ctx bị hủy.
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        rep, err := s.Sync(ctx)
+        if err != nil && ctx.Err() == nil {
+            log.Printf("kamailio sync: %v", err)
+        } else if err == nil && !rep.InSync {
+            log.Printf("kamailio sync: fixed %d drifted rows", countDrift(rep))
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Check so sánh mà không ghi gì.
+func (s *Syncer) Check(ctx context.Context) (*Report, error) {
+    return s.run(ctx, false)
+}
+
+// Sync so sánh và sửa mọi chênh lệch trong một transaction.
+func (s *Syncer) Sync(ctx context.Context) (*Report, error) {
+    return s.run(ctx, true)
+}
+
+func (s *Syncer) run(ctx context.Context, apply bool) (*Report, error) {
+    tables, err := s.desired(ctx)
+    if err != nil {
+        return nil, err
+    }
+    owned, err := s.owned(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    tx, err := s.Kamailio.Pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    if apply {
+        if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, syncLockKey); err != nil {
+            return nil, err
+        }
+    }
+    for _, t := range tables {
+        if t.actual, err = s.actual(ctx, tx, t.name); err != nil {
+            return nil, err
+        }
+    }
+
+    rep := &Report{CheckedAt: time.Now(), Tables: make(map[string]TableReport), Drift: []Drift{}}
+    for _, t := range tables {
+        tr := TableReport{Desired: len(t.desired), Actual: len(t.actual)}
+        for _, key := range sortedKeys(t.desired) {
+            want := t.desired[key]
+            have, ok := t.actual[key]
+            switch {
+            case !ok:
+                tr.Missing++
+                rep.add(Drift{Table: t.name, Kind: DriftMissing, Key: key})
+                if apply {
+                    err = t.insert(ctx, tx, key, want)
+                }
+            default:
+                fields := changedFields(want, have)
+                if len(fields) == 0 {
+                    continue
+                }
+                tr.Changed++
+                rep.add(Drift{Table: t.name, Kind: DriftChanged, Key: key, Fields: fields})
+                if apply {
+                    err = t.update(ctx, tx, key, want)
+                }
+            }
+            if err != nil {
+                return nil, err
+            }
+        }
+        for _, key := range sortedKeys(t.actual) {
+            if _, ok := t.desired[key]; ok {
+                continue
+            }
+            if !owned[t.name][key] {
+                tr.Unmanaged++
+                rep.add(Drift{Table: t.name, Kind: DriftUnmanaged, Key: key})
+                continue
+            }
+            tr.Extra++
+            rep.add(Drift{Table: t.name, Kind: DriftExtra, Key: key})
+            if apply {
+                if err := t.delete(ctx, tx, key); err != nil {
+                    return nil, err
+                }
+            }
+        }
+        rep.Tables[t.name] = tr
+    }
+    rep.InSync = countDrift(rep) == 0
+
+    if apply {
+        if err := tx.Commit(ctx); err != nil {
+            return nil, err
+        }
+        rep.Applied = true
+        // Ghi sau commit: nếu lỗi, lần sync sau nhận lại các dòng đã ghi vì chúng
+        // vẫn nằm trong desired.
+        if err := s.setOwned(ctx, tables); err != nil {
+            return nil, fmt.Errorf("record owned rows: %w", err)
+        }
+    }
+    return rep, nil
+}
+
+// owned đọc khóa các dòng Kamailio do service quản lý, theo bảng.
+func (s *Syncer) owned(ctx context.Context) (map[string]map[string]bool, error) {
+    rows, err := s.Source.Query(ctx, `SELECT tbl, key FROM voip.kamailio_sync_owned`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    out := make(map[string]map[string]bool)
+    for rows.Next() {
+        var tbl, key string
+        if err := rows.Scan(&tbl, &key); err != nil {
+            return nil, err
+        }
+        if out[tbl] == nil {
+            out[tbl] = make(map[string]bool)
+        }
+        out[tbl][key] = true
+    }
+    return out, rows.Err()
+}
+
+// setOwned đặt tập dòng thuộc service của mỗi bảng bằng tập desired vừa đồng bộ.
+func (s *Syncer) setOwned(ctx context.Context, tables []*tableSync) error {
+    tx, err := s.Source.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    for _, t := range tables {
+        keys := sortedKeys(t.desired)
+        if _, err := tx.Exec(ctx, `
+            DELETE FROM voip.kamailio_sync_owned
+            WHERE tbl = $1 AND NOT (key = ANY($2::text[]))
+        `, t.name, keys); err != nil {
+            return err
+        }
+        if _, err := tx.Exec(ctx, `
+            INSERT INTO voip.kamailio_sync_owned (tbl, key)
+            SELECT $1, unnest($2::text[])
+            ON CONFLICT (tbl, key) DO NOTHING
+        `, t.name, keys); err != nil {
+            return err
+        }
+    }
+    return tx.Commit(ctx)
+}
+
+func (r *Report) add(d Drift) {
+    if len(r.Drift) >= maxDriftItems {
+        r.Truncated = true
+        return
+    }
+    r.Drift = append(r.Drift, d)
+}
+
+func countDrift(r *Report) int {
+    n := 0
+    for _, t := range r.Tables {
+        n += t.Missing + t.Extra + t.Changed
+    }
+    return n
+}
+
+// desired đọc trạng thái mong muốn từ DB của service.
+func (s *Syncer) desired(ctx context.Context) ([]*tableSync, error) {
+    subscribers := make(map[string]row)
+    rows, err := s.Source.Query(ctx, `
+        SELECT u.username, d.name, u.sip_password
+        FROM voip.users u
+        JOIN voip.domains d ON d.id = u.domain_id
+        WHERE u.is_active
+    `)
+    if err != nil {
+        return nil, err
+    }
+    for rows.Next() {
+        var user, domain, password string
+        if err := rows.Scan(&user, &domain, &password); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        subscribers[user+"@"+domain] = subscriberRow(user, domain, password, s.StorePasswords)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    domains := make(map[string]row)
+    rows, err = s.Source.Query(ctx, `SELECT name FROM voip.domains`)
+    if err != nil {
+        return nil, err
+    }
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        domains[name] = row{}
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    addresses := make(map[string]row)
+    rows, err = s.Source.Query(ctx, `
+        SELECT host(a.network), masklen(a.network), a.port, t.name
+        FROM voip.trunk_addresses a
+        JOIN voip.trunks t ON t.id = a.trunk_id
+        WHERE t.is_active
+        ORDER BY t.name, a.id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    for rows.Next() {
+        var (
+            ip         string
+            mask, port int
+            trunk      string
+        )
+        if err := rows.Scan(&ip, &mask, &port, &trunk); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        key := addressKey(ip, mask, port)
+        if _, dup := addresses[key]; dup {
+            // Cùng địa chỉ khai báo cho nhiều trunk: giữ trunk đầu tiên theo tên.
+            continue
+        }
+        addresses[key] = row{"tag": "trunk:" + trunk}
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    subscriber, domain, address := s.Kamailio.Table("subscriber"), s.Kamailio.Table("domain"), s.Kamailio.Table("address")
+    grp := s.AddressGroup
+    return []*tableSync{
+        {
+            name:    "subscriber",
+            desired: subscribers,
+            insert: func(ctx context.Context, tx pgx.Tx, key string, r row) error {
+                user, dom, _ := strings.Cut(key, "@")
+                _, err := tx.Exec(ctx, `INSERT INTO `+subscriber+` (username, domain, password, ha1, ha1b) VALUES ($1, $2, $3, $4, $5)`,
+                    user, dom, r["password"], r["ha1"], r["ha1b"])
+                return err
+            },
+            update: func(ctx context.Context, tx pgx.Tx, key string, r row) error {
+                user, dom, _ := strings.Cut(key, "@")
+                _, err := tx.Exec(ctx, `UPDATE `+subscriber+` SET password = $3, ha1 = $4, ha1b = $5 WHERE username = $1 AND domain = $2`,
+                    user, dom, r["password"], r["ha1"], r["ha1b"])
+                return err
+            },
+            delete: func(ctx context.Context, tx pgx.Tx, key string) error {
+                user, dom, _ := strings.Cut(key, "@")
+                _, err := tx.Exec(ctx, `DELETE FROM `+subscriber+` WHERE username = $1 AND domain = $2`, user, dom)
+                return err
+            },
+        },
+        {
+            name:    "domain",
+            desired: domains,
+            insert: func(ctx context.Context, tx pgx.Tx, key string, r row) error {
+                _, err := tx.Exec(ctx, `INSERT INTO `+domain+` (domain) VALUES ($1)`, key)
+                return err
+            },
+            update: func(ctx context.Context, tx pgx.Tx, key string, r row) error { return nil },
+            delete: func(ctx context.Context, tx pgx.Tx, key string) error {
+                _, err := tx.Exec(ctx, `DELETE FROM `+domain+` WHERE domain = $1`, key)
+                return err
+            },
+        },
+        {
+            name:    "address",
+            desired: addresses,
+            insert: func(ctx context.Context, tx pgx.Tx, key string, r row) error {
+                ip, mask, port := parseAddressKey(key)
+                _, err := tx.Exec(ctx, `INSERT INTO `+address+` (grp, ip_addr, mask, port, tag) VALUES ($1, $2, $3, $4, $5)`,
+                    grp, ip, mask, port, r["tag"])
+                return err
+            },
+            update: func(ctx context.Context, tx pgx.Tx, key string, r row) error {
+                ip, mask, port := parseAddressKey(key)
+                _, err := tx.Exec(ctx, `UPDATE `+address+` SET tag = $5 WHERE grp = $1 AND ip_addr = $2 AND mask = $3 AND port = $4`,
+                    grp, ip, mask, port, r["tag"])
+                return err
+            },
+            delete: func(ctx context.Context, tx pgx.Tx, key string) error {
+                ip, mask, port := parseAddressKey(key)
+                _, err := tx.Exec(ctx, `DELETE FROM `+address+` WHERE grp = $1 AND ip_addr = $2 AND mask = $3 AND port = $4`,
+                    grp, ip, mask, port)
+                return err
+            },
+        },
+    }, nil
+}
+
+// actual đọc trạng thái hiện tại của bảng Kamailio; dòng trùng khóa được coi
+// là một dòng (bản sau bị bỏ qua).
+func (s *Syncer) actual(ctx context.Context, tx pgx.Tx, table string) (map[string]row, error) {
+    var (
+        query string
+        args  []interface{}
+    )
+    switch table {
+    case "subscriber":
+        query = `SELECT username || '@' || domain, COALESCE(password, ''), COALESCE(ha1, ''), COALESCE(ha1b, '') FROM ` + s.Kamailio.Table("subscriber")
+    case "domain":
+        query = `SELECT domain FROM ` + s.Kamailio.Table("domain")
+    case "address":
+        query = `SELECT ip_addr, mask, port, COALESCE(tag, '') FROM ` + s.Kamailio.Table("address") + ` WHERE grp = $1`
+        args = append(args, s.AddressGroup)
+    }
+    rows, err := tx.Query(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    out := make(map[string]row)
+    for rows.Next() {
+        var (
+            key string
+            r   row
+        )
+        switch table {
+        case "subscriber":
+            var password, ha1, ha1b string
+            if err := rows.Scan(&key, &password, &ha1, &ha1b); err != nil {
+                return nil, err
+            }
+            r = row{"password": password, "ha1": ha1, "ha1b": ha1b}
+        case "domain":
+            if err := rows.Scan(&key); err != nil {
+                return nil, err
+            }
+            r = row{}
+        case "address":
+            var (
+                ip         string
+                mask, port int
+                tag        string
+            )
+            if err := rows.Scan(&ip, &mask, &port, &tag); err != nil {
+                return nil, err
+            }
+            key, r = addressKey(ip, mask, port), row{"tag": tag}
+        }
+        if _, dup := out[key]; !dup {
+            out[key] = r
+        }
+    }
+    return out, rows.Err()
+}
+
+func changedFields(want, have row) []string {
+    var out []string
+    for k, v := range want {
+        if have[k] != v {
+            out = append(out, k)
+        }
+    }
+    sort.Strings(out)
+    return out
+}
+
+func sortedKeys(m map[string]row) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// addressKey là khóa "ip/mask:port" của một dòng address.
+func addressKey(ip string, mask, port int) string {
+    return ip + "/" + strconv.Itoa(mask) + ":" + strconv.Itoa(port)
+}
+
+func parseAddressKey(key string) (string, int, int) {
+    i := strings.LastIndex(key, ":")
+    j := strings.LastIndex(key[:i], "/")
+    mask, _ := strconv.Atoi(key[j+1 : i])
+    port, _ := strconv.Atoi(key[i+1:])
+    return key[:j], mask, port
+}
+
+// subscriberRow là dòng subscriber mong muốn; realm là domain của user.
+// Mật khẩu rõ chỉ được ghi khi storePassword.
+func subscriberRow(user, domain, password string, storePassword bool) row {
+    r := row{
+        "ha1":      md5hex(user + ":" + domain + ":" + password),
+        "ha1b":     md5hex(user + "@" + domain + ":" + domain + ":" + password),
+        "password": "",
+    }
+    if storePassword {
+        r["password"] = password
+    }
+    return r
+}
+
+func md5hex(s string) string {
+    sum := md5.Sum([]byte(s))
+    return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,70 @@
+package kamailio
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestSubscriberRow(t *testing.T) {
+    // Giá trị tham chiếu: md5("1001:bsv.local:secret"), md5("1001@bsv.local:bsv.local:secret").
+    const (
+        ha1  = "1e3dfcdff5fd1148aba3ead85f3378b9"
+        ha1b = "01dc918aaa1fb92b63190cb2351aa96f"
+    )
+    tests := []struct {
+        store bool
+        want  row
+    }{
+        {false, row{"ha1": ha1, "ha1b": ha1b, "password": ""}},
+        {true, row{"ha1": ha1, "ha1b": ha1b, "password": "secret"}},
+    }
+    for _, tt := range tests {
+        if got := subscriberRow("1001", "bsv.local", "secret", tt.store); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("subscriberRow(store=%v) = %v, want %v", tt.store, got, tt.want)
+        }
+    }
+}
+
+func TestChangedFields(t *testing.T) {
+    tests := []struct {
+        name       string
+        want, have row
+        changed    []string
+    }{
+        {"equal", row{"ha1": "a", "ha1b": "b"}, row{"ha1": "a", "ha1b": "b"}, nil},
+        {"one changed", row{"ha1": "a", "ha1b": "b"}, row{"ha1": "a", "ha1b": "x"}, []string{"ha1b"}},
+        {"sorted", row{"tag": "1", "ha1": "2", "password": "3"}, row{}, []string{"ha1", "password", "tag"}},
+        // Cột chỉ có ở Kamailio không được coi là khác biệt.
+        {"extra column ignored", row{"ha1": "a"}, row{"ha1": "a", "rpid": "x"}, nil},
+        {"empty vs missing", row{"password": ""}, row{}, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := changedFields(tt.want, tt.have); !reflect.DeepEqual(got, tt.changed) {
+                t.Errorf("changedFields = %v, want %v", got, tt.changed)
+            }
+        })
+    }
+}
+
+func TestAddressKey(t *testing.T) {
+    tests := []struct {
+        ip         string
+        mask, port int
+        key        string
+    }{
+        {"203.0.113.10", 32, 5060, "203.0.113.10/32:5060"},
+        {"198.51.100.0", 24, 0, "198.51.100.0/24:0"},
+        {"2001:db8::1", 128, 5061, "2001:db8::1/128:5061"},
+    }
+    for _, tt := range tests {
+        key := addressKey(tt.ip, tt.mask, tt.port)
+        if key != tt.key {
+            t.Errorf("addressKey(%s, %d, %d) = %q, want %q", tt.ip, tt.mask, tt.port, key, tt.key)
+        }
+        ip, mask, port := parseAddressKey(key)
+        if ip != tt.ip || mask != tt.mask || port != tt.port {
+            t.Errorf("parseAddressKey(%q) = %s, %d, %d", key, ip, mask, port)
+        }
+    }
+}
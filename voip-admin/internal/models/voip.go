@@ -47,6 +47,11 @@ type CDR struct {
     AgentUserID       *int64     `db:"agent_user_id" json:"agent_user_id,omitempty"`
     TrunkID           *int64     `db:"trunk_id" json:"trunk_id,omitempty"`
     RecordingID       *int64     `db:"recording_id" json:"recording_id,omitempty"`
+    DomainID          *int64     `db:"domain_id" json:"domain_id,omitempty"`
+    Cost              *string    `db:"cost" json:"cost,omitempty"`
+    Currency          *string    `db:"currency" json:"currency,omitempty"`
+    Leg               string     `db:"leg" json:"leg"`
+    OriginatingLeg    *string    `db:"originating_leg_uuid" json:"originating_leg_uuid,omitempty"`
     CreatedAt         time.Time  `db:"created_at" json:"created_at"`
 }
 
@@ -0,0 +1,320 @@
+package originate
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/audit"
+    "voip-admin/internal/esl"
+)
+
+// Trạng thái của một yêu cầu click-to-call.
+const (
+    StatusOriginating   = "originating"
+    StatusAgentAnswered = "agent_answered"
+    StatusBridged       = "bridged"
+    StatusCompleted     = "completed"
+    StatusFailed        = "failed"
+)
+
+var (
+    // ErrNotFound được trả về khi user hoặc yêu cầu originate không tồn tại.
+    ErrNotFound = errors.New("not found")
+    // ErrUserInactive được trả về khi user bị khóa.
+    ErrUserInactive = errors.New("user is inactive")
+    // ErrNoNode được trả về khi không có node FreeSWITCH nào đang kết nối.
+    ErrNoNode = errors.New("no freeswitch node connected")
+    // ErrInvalidDestination được trả về khi số đích có ký tự không hợp lệ.
+    ErrInvalidDestination = errors.New("invalid destination")
+)
+
+// DeniedError là lỗi khi class of service của user không cho gọi số đích.
+type DeniedError struct {
+    Reason string
+}
+
+func (e *DeniedError) Error() string {
+    return "destination not allowed: " + e.Reason
+}
+
+// destinationPattern giới hạn số đích để đưa an toàn vào lệnh originate.
+var destinationPattern = regexp.MustCompile(`^\+?[0-9*#]{2,32}$`)
+
+// Request là một yêu cầu click-to-call và tiến trình của nó.
+type Request struct {
+    ID             int64     `json:"id"`
+    CallUUID       string    `json:"call_uuid"`
+    Node           string    `json:"node"`
+    UserID         int64     `json:"user_id"`
+    Extension      string    `json:"extension"`
+    Domain         string    `json:"domain"`
+    Destination    string    `json:"destination"`
+    CallerIDNumber *string   `json:"caller_id_number,omitempty"`
+    Status         string    `json:"status"`
+    HangupCause    *string   `json:"hangup_cause,omitempty"`
+    Error          *string   `json:"error,omitempty"`
+    RequestedBy    string    `json:"requested_by"`
+    CreatedAt      time.Time `json:"created_at"`
+    UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Params là tham số originate; user xác định bằng UserID hoặc Extension + Domain.
+type Params struct {
+    UserID      int64
+    Extension   string
+    Domain      string
+    Destination string
+    // Node rỗng thì chọn node đang kết nối đầu tiên.
+    Node        string
+    RequestedBy string
+}
+
+// Service originate cuộc gọi qua event socket: gọi extension của user trước,
+// khi user nhấc máy thì chuyển vào dialplan tới số đích (nên route, trunk và
+// kiểm tra prepaid vẫn áp dụng). Tiến trình được cập nhật từ channel event.
+type Service struct {
+    Pool *pgxpool.Pool
+    FS   *esl.Manager
+    // Timeout là thời gian chờ user nhấc máy.
+    Timeout time.Duration
+
+    events <-chan esl.Event
+    mu     sync.Mutex
+    open   map[string]bool
+}
+
+// DefaultTimeout là thời gian chờ user nhấc máy mặc định.
+const DefaultTimeout = 30 * time.Second
+
+// NewService đăng ký nhận event của fs; gọi trước fs.Run.
+func NewService(pool *pgxpool.Pool, fs *esl.Manager) *Service {
+    s := &Service{Pool: pool, FS: fs, Timeout: DefaultTimeout, open: make(map[string]bool)}
+    s.events, _ = fs.Subscribe(1024)
+    return s
+}
+
+type user struct {
+    ID             int64
+    Extension      string
+    Domain         string
+    DomainID       int64
+    IsActive       bool
+    CallerIDNumber *string
+    CallerIDName   *string
+    AllowPrefixes  []string
+    DenyPrefixes   []string
+    MaxCallSeconds int
+}
+
+func (s *Service) loadUser(ctx context.Context, p Params) (*user, error) {
+    var u user
+    err := s.Pool.QueryRow(ctx, `
+        SELECT u.id, u.username, d.name, d.id, u.is_active, u.caller_id_number,
+               COALESCE(u.caller_id_name, u.full_name),
+               COALESCE(c.allow_prefixes, '{}'), COALESCE(c.deny_prefixes, '{}'),
+               COALESCE(c.max_call_seconds, 0)
+        FROM voip.users u
+        JOIN voip.domains d ON d.id = u.domain_id
+        LEFT JOIN voip.classes_of_service c ON c.id = u.cos_id
+        WHERE ($1::bigint > 0 AND u.id = $1)
+           OR ($1::bigint = 0 AND u.username = $2 AND d.name = $3)
+    `, p.UserID, p.Extension, p.Domain).Scan(
+        &u.ID, &u.Extension, &u.Domain, &u.DomainID, &u.IsActive,
+        &u.CallerIDNumber, &u.CallerIDName,
+        &u.AllowPrefixes, &u.DenyPrefixes, &u.MaxCallSeconds,
+    )
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &u, nil
+}
+
+// checkCOS áp dụng class of service của user cho số đích.
+func checkCOS(u *user, destination string) error {
+    for _, p := range u.DenyPrefixes {
+        if strings.HasPrefix(destination, p) {
+            return &DeniedError{Reason: "prefix " + p + " is denied by class of service"}
+        }
+    }
+    if len(u.AllowPrefixes) == 0 {
+        return nil
+    }
+    for _, p := range u.AllowPrefixes {
+        if strings.HasPrefix(destination, p) {
+            return nil
+        }
+    }
+    return &DeniedError{Reason: "no allowed prefix matches"}
+}
+
+// Originate kiểm tra user và class of service, ghi yêu cầu rồi gửi originate
+// chạy nền; kết quả được theo dõi qua Get.
+func (s *Service) Originate(ctx context.Context, p Params) (*Request, error) {
+    if !destinationPattern.MatchString(p.Destination) {
+        return nil, ErrInvalidDestination
+    }
+    u, err := s.loadUser(ctx, p)
+    if err != nil {
+        return nil, err
+    }
+    if !u.IsActive {
+        return nil, ErrUserInactive
+    }
+    if err := checkCOS(u, p.Destination); err != nil {
+        return nil, err
+    }
+
+    node := p.Node
+    if node == "" {
+        node = s.FS.PickNode()
+    }
+    c, ok := s.FS.Client(node)
+    if !ok || !c.Connected() {
+        return nil, ErrNoNode
+    }
+    callUUID, err := esl.NewUUID()
+    if err != nil {
+        return nil, err
+    }
+
+    req := &Request{
+        CallUUID:       callUUID,
+        Node:           node,
+        UserID:         u.ID,
+        Extension:      u.Extension,
+        Domain:         u.Domain,
+        Destination:    p.Destination,
+        CallerIDNumber: u.CallerIDNumber,
+        Status:         StatusOriginating,
+        RequestedBy:    p.RequestedBy,
+    }
+    tx, err := s.Pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    if err := tx.QueryRow(ctx, `
+        INSERT INTO voip.originate_requests
+            (call_uuid, node, user_id, extension, domain, destination, caller_id_number, status, requested_by)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id, created_at, updated_at
+    `, req.CallUUID, req.Node, req.UserID, req.Extension, req.Domain, req.Destination,
+        req.CallerIDNumber, req.Status, req.RequestedBy).Scan(&req.ID, &req.CreatedAt, &req.UpdatedAt); err != nil {
+        return nil, err
+    }
+    if err := audit.Record(ctx, tx, p.RequestedBy, "call.originate", callUUID, map[string]interface{}{
+        "node":        node,
+        "user_id":     u.ID,
+        "extension":   u.Extension + "@" + u.Domain,
+        "destination": p.Destination,
+    }); err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+
+    s.mu.Lock()
+    s.open[callUUID] = true
+    s.mu.Unlock()
+
+    cmd := originateCommand(u, callUUID, p.Destination, s.Timeout)
+    go s.run(node, callUUID, cmd)
+    return req, nil
+}
+
+// originateCommand tạo lệnh originate: leg A gọi user, số hiển thị trên máy user
+// là số đích; leg B (qua dialplan) mang caller ID đã cấu hình của user.
+// click_to_call_user_id gắn CDR với user để rating và fraud tính đúng account,
+// vì caller_id_number của leg A là số đích.
+func originateCommand(u *user, callUUID, destination string, timeout time.Duration) string {
+    vars := []string{
+        "origination_uuid=" + callUUID,
+        "origination_caller_id_number=" + destination,
+        "origination_caller_id_name=Click-to-call",
+        "domain_name=" + u.Domain,
+        "ignore_early_media=true",
+        fmt.Sprintf("originate_timeout=%d", int(timeout.Seconds())),
+        "click_to_call=true",
+        fmt.Sprintf("click_to_call_user_id=%d", u.ID),
+    }
+    if u.CallerIDNumber != nil && *u.CallerIDNumber != "" {
+        vars = append(vars, "effective_caller_id_number="+escapeVar(*u.CallerIDNumber))
+    }
+    if u.CallerIDName != nil && *u.CallerIDName != "" {
+        vars = append(vars, "effective_caller_id_name="+escapeVar(*u.CallerIDName))
+    }
+    if u.MaxCallSeconds > 0 {
+        // Tính từ lúc user nhấc máy, bao gồm thời gian đổ chuông phía đích.
+        vars = append(vars, fmt.Sprintf("execute_on_answer='sched_hangup +%d allotted_timeout'", u.MaxCallSeconds))
+    }
+    return fmt.Sprintf("originate {%s}user/%s@%s %s XML default",
+        strings.Join(vars, ","), u.Extension, u.Domain, destination)
+}
+
+// escapeVar bảo vệ giá trị biến khỏi ký tự phân tách của originate.
+func escapeVar(v string) string {
+    v = strings.NewReplacer(",", `\,`, "'", "", "{", "", "}", "").Replace(v)
+    if strings.ContainsAny(v, " ") {
+        return "'" + v + "'"
+    }
+    return v
+}
+
+func (s *Service) run(node, callUUID, cmd string) {
+    ctx, cancel := context.WithTimeout(context.Background(), s.Timeout+30*time.Second)
+    defer cancel()
+
+    _, err := s.FS.BgAPI(ctx, node, cmd)
+    if err == nil {
+        return
+    }
+    log.Printf("originate %s on %s: %v", callUUID, node, err)
+    s.finish(callUUID)
+    if _, dbErr := s.Pool.Exec(context.Background(), `
+        UPDATE voip.originate_requests
+        SET status = $2, error = $3, updated_at = now()
+        WHERE call_uuid = $1 AND status = $4
+    `, callUUID, StatusFailed, err.Error(), StatusOriginating); dbErr != nil {
+        log.Printf("originate %s: %v", callUUID, dbErr)
+    }
+}
+
+func (s *Service) finish(callUUID string) {
+    s.mu.Lock()
+    delete(s.open, callUUID)
+    s.mu.Unlock()
+}
+
+// Get đọc yêu cầu theo call UUID.
+func Get(ctx context.Context, pool *pgxpool.Pool, callUUID string) (*Request, error) {
+    var r Request
+    err := pool.QueryRow(ctx, `
+        SELECT id, call_uuid, node, user_id, extension, domain, destination, caller_id_number,
+               status, hangup_cause, error, requested_by, created_at, updated_at
+        FROM voip.originate_requests
+        WHERE call_uuid = $1
+    `, callUUID).Scan(
+        &r.ID, &r.CallUUID, &r.Node, &r.UserID, &r.Extension, &r.Domain, &r.Destination, &r.CallerIDNumber,
+        &r.Status, &r.HangupCause, &r.Error, &r.RequestedBy, &r.CreatedAt, &r.UpdatedAt,
+    )
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &r, nil
+}
@@ -0,0 +1,58 @@
+package originate
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestEscapeVar(t *testing.T) {
+    tests := []struct {
+        in, want string
+    }{
+        {"0901234567", "0901234567"},
+        {"Nguyen Van A", "'Nguyen Van A'"},
+        {"a,b", `a\,b`},
+        {"O'Brien", "OBrien"},
+        {"x}y{z", "xyz"},
+        // Không thoát được khỏi dấu nháy hoặc chèn thêm biến.
+        {"a' b", "'a b'"},
+        {"1,origination_uuid=evil", `1\,origination_uuid=evil`},
+        {"}bridge{", "bridge"},
+        {"", ""},
+    }
+    for _, tt := range tests {
+        if got := escapeVar(tt.in); got != tt.want {
+            t.Errorf("escapeVar(%q) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestCheckCOS(t *testing.T) {
+    tests := []struct {
+        name        string
+        allow, deny []string
+        destination string
+        denied      bool
+    }{
+        {"no restrictions", nil, nil, "0061212345678", false},
+        {"denied prefix", nil, []string{"00", "1900"}, "0061212345678", true},
+        {"not denied", nil, []string{"00", "1900"}, "0901234567", false},
+        {"allowed prefix", []string{"09", "08"}, nil, "0901234567", false},
+        {"no allowed prefix", []string{"09", "08"}, nil, "0281234567", true},
+        // Deny được xét trước allow.
+        {"deny wins over allow", []string{"0"}, []string{"00"}, "0061212345678", true},
+        {"allow with other deny", []string{"0"}, []string{"00"}, "0281234567", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := checkCOS(&user{AllowPrefixes: tt.allow, DenyPrefixes: tt.deny}, tt.destination)
+            var denied *DeniedError
+            if got := errors.As(err, &denied); got != tt.denied {
+                t.Errorf("checkCOS(%s) = %v, want denied %v", tt.destination, err, tt.denied)
+            }
+            if !tt.denied && err != nil {
+                t.Errorf("checkCOS(%s) = %v, want nil", tt.destination, err)
+            }
+        })
+    }
+}
@@ -0,0 +1,82 @@
+package originate
+
+import (
+    "context"
+    "log"
+)
+
+// Run cập nhật tiến trình các yêu cầu đang mở theo channel event của leg A
+// (leg gọi tới user) tới khi ctx bị hủy.
+func (s *Service) Run(ctx context.Context) {
+    if err := s.loadOpen(ctx); err != nil {
+        log.Printf("originate tracker: %v", err)
+    }
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case e := <-s.events:
+            uuid := e.Get("Unique-ID")
+            s.mu.Lock()
+            tracked := s.open[uuid]
+            s.mu.Unlock()
+            if !tracked {
+                continue
+            }
+
+            var err error
+            switch e.Name() {
+            case "CHANNEL_ANSWER":
+                err = s.advance(ctx, uuid, StatusAgentAnswered, nil, StatusOriginating)
+            case "CHANNEL_BRIDGE":
+                err = s.advance(ctx, uuid, StatusBridged, nil, StatusOriginating, StatusAgentAnswered)
+            case "CHANNEL_HANGUP_COMPLETE":
+                s.finish(uuid)
+                cause := e.Get("Hangup-Cause")
+                status := StatusCompleted
+                if e.Get("Caller-Channel-Answered-Time") == "0" {
+                    // User không nhấc máy.
+                    status = StatusFailed
+                }
+                err = s.advance(ctx, uuid, status, &cause, StatusOriginating, StatusAgentAnswered, StatusBridged)
+            }
+            if err != nil && ctx.Err() == nil {
+                log.Printf("originate tracker %s: %v", uuid, err)
+            }
+        }
+    }
+}
+
+// advance chuyển trạng thái nếu trạng thái hiện tại thuộc from, để event đến
+// muộn không làm lùi trạng thái.
+func (s *Service) advance(ctx context.Context, uuid, status string, cause *string, from ...string) error {
+    _, err := s.Pool.Exec(ctx, `
+        UPDATE voip.originate_requests
+        SET status = $2, hangup_cause = COALESCE($3, hangup_cause), updated_at = now()
+        WHERE call_uuid = $1 AND status = ANY($4)
+    `, uuid, status, cause, from)
+    return err
+}
+
+// loadOpen nạp các yêu cầu chưa kết thúc (ví dụ sau khi service khởi động lại).
+func (s *Service) loadOpen(ctx context.Context) error {
+    rows, err := s.Pool.Query(ctx, `
+        SELECT call_uuid FROM voip.originate_requests
+        WHERE status IN ('originating', 'agent_answered', 'bridged')
+    `)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for rows.Next() {
+        var uuid string
+        if err := rows.Scan(&uuid); err != nil {
+            return err
+        }
+        s.open[uuid] = true
+    }
+    return rows.Err()
+}
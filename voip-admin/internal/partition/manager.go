@@ -0,0 +1,249 @@
+package partition
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "regexp"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Manager quản lý partition tháng của voip.cdr: tạo trước partition cho các
+// tháng tới và áp dụng retention (lưu trữ rồi xóa CDR hết hạn).
+// Ranh giới tháng tính theo UTC.
+type Manager struct {
+    Pool *pgxpool.Pool
+    // MonthsAhead là số tháng sau tháng hiện tại được tạo sẵn partition.
+    MonthsAhead int
+    // DefaultRetentionDays áp dụng cho domain không có policy riêng; 0 là giữ vĩnh viễn.
+    DefaultRetentionDays int
+    // ArchiveDir là thư mục chứa file NDJSON nén; rỗng thì không áp dụng retention.
+    ArchiveDir string
+}
+
+// Partition mô tả một partition của voip.cdr. From/To nil nghĩa là không giới hạn
+// (MINVALUE/MAXVALUE hoặc partition DEFAULT).
+type Partition struct {
+    Name          string     `json:"name"`
+    From          *time.Time `json:"from,omitempty"`
+    To            *time.Time `json:"to,omitempty"`
+    IsDefault     bool       `json:"is_default"`
+    EstimatedRows int64      `json:"estimated_rows"`
+}
+
+// Run chạy Maintain định kỳ cho tới khi ctx bị hủy.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        if err := m.Maintain(ctx); err != nil && ctx.Err() == nil {
+            log.Printf("cdr partitions: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Maintain tạo partition còn thiếu rồi áp dụng retention. Lỗi tạo partition không
+// chặn retention; cả hai lỗi (nếu có) được trả về cùng nhau.
+func (m *Manager) Maintain(ctx context.Context) error {
+    var errs []error
+    if err := m.EnsurePartitions(ctx, time.Now()); err != nil {
+        errs = append(errs, fmt.Errorf("ensure partitions: %w", err))
+    }
+    if err := m.EnforceRetention(ctx, time.Now()); err != nil {
+        errs = append(errs, fmt.Errorf("retention: %w", err))
+    }
+    return errors.Join(errs...)
+}
+
+// EnsurePartitions tạo partition cho tháng hiện tại và MonthsAhead tháng tiếp theo.
+// Tháng đã nằm trong một partition có sẵn (ví dụ cdr_legacy) được bỏ qua. Một tháng
+// lỗi không chặn các tháng còn lại.
+func (m *Manager) EnsurePartitions(ctx context.Context, now time.Time) error {
+    parts, err := List(ctx, m.Pool)
+    if err != nil {
+        return err
+    }
+    var def *Partition
+    for i := range parts {
+        if parts[i].IsDefault {
+            def = &parts[i]
+        }
+    }
+
+    var errs []error
+    month := monthStart(now)
+    for i := 0; i <= m.MonthsAhead; i++ {
+        from, to := month.AddDate(0, i, 0), month.AddDate(0, i+1, 0)
+        if overlaps(parts, from, to) {
+            continue
+        }
+        name := "cdr_" + from.Format("200601")
+        moved, err := m.createPartition(ctx, name, def, from, to)
+        if err != nil {
+            log.Printf("cdr partitions: create %s failed, CDRs of that month stay in the default partition: %v", name, err)
+            errs = append(errs, fmt.Errorf("create %s: %w", name, err))
+            continue
+        }
+        if moved > 0 {
+            log.Printf("created cdr partition %s and moved %d rows from %s", name, moved, def.Name)
+        } else {
+            log.Printf("created cdr partition %s", name)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// createPartition tạo partition [from, to). Postgres không cho tạo partition khi
+// partition DEFAULT đang chứa dòng thuộc khoảng đó (CDR nhận lúc job không chạy),
+// nên khi đó default được detach, các dòng được chuyển sang partition mới rồi
+// attach lại, tất cả trong một transaction.
+func (m *Manager) createPartition(ctx context.Context, name string, def *Partition, from, to time.Time) (int64, error) {
+    table := pgx.Identifier{"voip", name}.Sanitize()
+    create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF voip.cdr FOR VALUES FROM ('%s') TO ('%s')`,
+        table, from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+    tx, err := m.Pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    var (
+        pending  bool
+        defTable string
+    )
+    if def != nil {
+        defTable = pgx.Identifier{"voip", def.Name}.Sanitize()
+        err := tx.QueryRow(ctx, `
+            SELECT EXISTS (SELECT 1 FROM `+defTable+` WHERE start_time >= $1 AND start_time < $2)
+        `, from, to).Scan(&pending)
+        if err != nil {
+            return 0, err
+        }
+    }
+    if !pending {
+        if _, err := tx.Exec(ctx, create); err != nil {
+            return 0, err
+        }
+        return 0, tx.Commit(ctx)
+    }
+
+    if _, err := tx.Exec(ctx, `ALTER TABLE voip.cdr DETACH PARTITION `+defTable); err != nil {
+        return 0, err
+    }
+    if _, err := tx.Exec(ctx, create); err != nil {
+        return 0, err
+    }
+    tag, err := tx.Exec(ctx, `
+        WITH moved AS (
+            DELETE FROM `+defTable+` WHERE start_time >= $1 AND start_time < $2
+            RETURNING *
+        )
+        INSERT INTO voip.cdr OVERRIDING SYSTEM VALUE SELECT * FROM moved
+    `, from, to)
+    if err != nil {
+        return 0, err
+    }
+    if _, err := tx.Exec(ctx, `ALTER TABLE voip.cdr ATTACH PARTITION `+defTable+` DEFAULT`); err != nil {
+        return 0, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return 0, err
+    }
+    return tag.RowsAffected(), nil
+}
+
+func monthStart(t time.Time) time.Time {
+    t = t.UTC()
+    return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func overlaps(parts []Partition, from, to time.Time) bool {
+    for _, p := range parts {
+        if p.IsDefault {
+            continue
+        }
+        if (p.From == nil || p.From.Before(to)) && (p.To == nil || p.To.After(from)) {
+            return true
+        }
+    }
+    return false
+}
+
+var boundPattern = regexp.MustCompile(`^FOR VALUES FROM \((.+)\) TO \((.+)\)$`)
+
+// List trả các partition của voip.cdr theo thứ tự tên.
+func List(ctx context.Context, pool *pgxpool.Pool) ([]Partition, error) {
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    // Cố định định dạng hiển thị ranh giới để parse.
+    if _, err := tx.Exec(ctx, `SET LOCAL TimeZone = 'UTC'; SET LOCAL DateStyle = 'ISO'`); err != nil {
+        return nil, err
+    }
+    rows, err := tx.Query(ctx, `
+        SELECT c.relname, pg_get_expr(c.relpartbound, c.oid), GREATEST(c.reltuples, 0)::bigint
+        FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = 'voip.cdr'::regclass
+        ORDER BY c.relname
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Partition
+    for rows.Next() {
+        var (
+            p     Partition
+            bound string
+        )
+        if err := rows.Scan(&p.Name, &bound, &p.EstimatedRows); err != nil {
+            return nil, err
+        }
+        if bound == "DEFAULT" {
+            p.IsDefault = true
+        } else {
+            sm := boundPattern.FindStringSubmatch(bound)
+            if sm == nil {
+                return nil, fmt.Errorf("partition %s: unexpected bound %q", p.Name, bound)
+            }
+            if p.From, err = parseBound(sm[1]); err != nil {
+                return nil, fmt.Errorf("partition %s: %w", p.Name, err)
+            }
+            if p.To, err = parseBound(sm[2]); err != nil {
+                return nil, fmt.Errorf("partition %s: %w", p.Name, err)
+            }
+        }
+        out = append(out, p)
+    }
+    return out, rows.Err()
+}
+
+func parseBound(s string) (*time.Time, error) {
+    if s == "MINVALUE" || s == "MAXVALUE" {
+        return nil, nil
+    }
+    if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+        return nil, fmt.Errorf("unexpected bound value %s", s)
+    }
+    t, err := time.Parse("2006-01-02 15:04:05.999999-07", s[1:len(s)-1])
+    if err != nil {
+        return nil, err
+    }
+    return &t, nil
+}
@@ -0,0 +1,324 @@
+package partition
+
+import (
+    "bufio"
+    "compress/gzip"
+    "context"
+    "errors"
+    "fmt"
+    "io/fs"
+    "log"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ArchiveLockKey là khóa advisory giữa việc ghi file lưu trữ và privacy.Erase
+// (ghi lại file lưu trữ), để file không bị tạo từ dữ liệu chưa xóa số.
+const ArchiveLockKey = 0x6b616d04
+
+// ErrNotFound được trả về khi domain chưa có retention policy.
+var ErrNotFound = errors.New("retention policy not found")
+
+// Policy là retention riêng của một domain.
+type Policy struct {
+    DomainID      int64     `json:"domain_id"`
+    RetentionDays int       `json:"retention_days"`
+    UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func ListPolicies(ctx context.Context, pool *pgxpool.Pool) ([]Policy, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT domain_id, retention_days, updated_at
+        FROM voip.cdr_retention_policies
+        ORDER BY domain_id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Policy
+    for rows.Next() {
+        var p Policy
+        if err := rows.Scan(&p.DomainID, &p.RetentionDays, &p.UpdatedAt); err != nil {
+            return nil, err
+        }
+        out = append(out, p)
+    }
+    return out, rows.Err()
+}
+
+func SetPolicy(ctx context.Context, pool *pgxpool.Pool, domainID int64, days int) (*Policy, error) {
+    p := Policy{DomainID: domainID}
+    err := pool.QueryRow(ctx, `
+        INSERT INTO voip.cdr_retention_policies (domain_id, retention_days)
+        VALUES ($1, $2)
+        ON CONFLICT (domain_id) DO UPDATE
+        SET retention_days = EXCLUDED.retention_days, updated_at = now()
+        RETURNING retention_days, updated_at
+    `, domainID, days).Scan(&p.RetentionDays, &p.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    return &p, nil
+}
+
+func DeletePolicy(ctx context.Context, pool *pgxpool.Pool, domainID int64) error {
+    tag, err := pool.Exec(ctx, `DELETE FROM voip.cdr_retention_policies WHERE domain_id=$1`, domainID)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// retentionGroup là tập CDR cùng mốc hết hạn: một domain có policy riêng,
+// hoặc mọi domain còn lại (kể cả CDR không có domain) theo retention mặc định.
+type retentionGroup struct {
+    label  string
+    cond   string
+    arg    interface{}
+    cutoff time.Time
+}
+
+// EnforceRetention lưu trữ rồi xóa CDR hết hạn. Mốc hết hạn được làm tròn xuống
+// đầu tháng (UTC) nên CDR luôn được giữ ít nhất số ngày retention.
+// Partition mà mọi CDR đều hết hạn được lưu trữ nguyên vẹn rồi detach và drop;
+// các partition còn lại chỉ bị xóa phần hết hạn của từng domain.
+func (m *Manager) EnforceRetention(ctx context.Context, now time.Time) error {
+    if m.ArchiveDir == "" {
+        return nil
+    }
+
+    policies, err := ListPolicies(ctx, m.Pool)
+    if err != nil {
+        return err
+    }
+
+    var groups []retentionGroup
+    policyDomains := make([]int64, 0, len(policies))
+    for _, p := range policies {
+        policyDomains = append(policyDomains, p.DomainID)
+        groups = append(groups, retentionGroup{
+            label:  fmt.Sprintf("domain%d", p.DomainID),
+            cond:   "domain_id = $2",
+            arg:    p.DomainID,
+            cutoff: monthStart(now.AddDate(0, 0, -p.RetentionDays)),
+        })
+    }
+    if m.DefaultRetentionDays > 0 {
+        groups = append(groups, retentionGroup{
+            label:  "default",
+            cond:   "(domain_id IS NULL OR domain_id <> ALL($2))",
+            arg:    policyDomains,
+            cutoff: monthStart(now.AddDate(0, 0, -m.DefaultRetentionDays)),
+        })
+    }
+    if len(groups) == 0 {
+        return nil
+    }
+
+    parts, err := List(ctx, m.Pool)
+    if err != nil {
+        return err
+    }
+    for _, p := range parts {
+        if !p.IsDefault && p.To != nil && m.DefaultRetentionDays > 0 && expiredForAll(groups, *p.To) {
+            if err := m.dropPartition(ctx, p, now); err != nil {
+                return fmt.Errorf("drop %s: %w", p.Name, err)
+            }
+            continue
+        }
+        for _, g := range groups {
+            if p.From != nil && !p.From.Before(g.cutoff) {
+                continue
+            }
+            end := g.cutoff
+            if p.To != nil && p.To.Before(end) {
+                end = *p.To
+            }
+            if err := m.purgeGroup(ctx, p, g, end, now); err != nil {
+                return fmt.Errorf("purge %s (%s): %w", p.Name, g.label, err)
+            }
+        }
+    }
+    return nil
+}
+
+func expiredForAll(groups []retentionGroup, to time.Time) bool {
+    for _, g := range groups {
+        if g.cutoff.Before(to) {
+            return false
+        }
+    }
+    return true
+}
+
+// dropPartition lưu trữ toàn bộ partition rồi detach và drop. Partition bị khóa
+// ghi trong lúc lưu trữ để CDR đến muộn không bị mất.
+func (m *Manager) dropPartition(ctx context.Context, p Partition, run time.Time) error {
+    table := pgx.Identifier{"voip", p.Name}.Sanitize()
+
+    conn, unlock, err := m.lockArchives(ctx)
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    tx, err := conn.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, `LOCK TABLE `+table+` IN SHARE MODE`); err != nil {
+        return err
+    }
+    path := filepath.Join(m.ArchiveDir, p.Name+"_"+runStamp(run)+".ndjson.gz")
+    n, err := archive(ctx, tx, path, `SELECT row_to_json(c)::text FROM `+table+` c ORDER BY start_time, id`)
+    if err != nil {
+        return err
+    }
+    if _, err := tx.Exec(ctx, `ALTER TABLE voip.cdr DETACH PARTITION `+table); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(ctx, `DROP TABLE `+table); err != nil {
+        return err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return err
+    }
+    log.Printf("archived %d cdr rows to %s and dropped partition %s", n, path, p.Name)
+    return nil
+}
+
+// purgeGroup lưu trữ rồi xóa CDR của nhóm có start_time < end trong partition.
+// Đọc và xóa dùng cùng snapshot nên chỉ những dòng đã lưu trữ mới bị xóa.
+// Tên file có thời điểm chạy vì CDR đến muộn có thể bị purge lại ở lần chạy sau.
+func (m *Manager) purgeGroup(ctx context.Context, p Partition, g retentionGroup, end, run time.Time) error {
+    table := pgx.Identifier{"voip", p.Name}.Sanitize()
+    where := ` WHERE start_time < $1 AND ` + g.cond
+
+    conn, unlock, err := m.lockArchives(ctx)
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    path := filepath.Join(m.ArchiveDir, fmt.Sprintf("%s_%s_before_%s_%s.ndjson.gz", p.Name, g.label, end.Format("200601"), runStamp(run)))
+    n, err := archive(ctx, tx, path, `SELECT row_to_json(c)::text FROM `+table+` c`+where+` ORDER BY start_time, id`, end, g.arg)
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return nil
+    }
+    if _, err := tx.Exec(ctx, `DELETE FROM `+table+where, end, g.arg); err != nil {
+        return err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return err
+    }
+    log.Printf("archived and deleted %d cdr rows from %s (%s) to %s", n, p.Name, g.label, path)
+    return nil
+}
+
+// lockArchives lấy ArchiveLockKey ở mức session trước khi mở transaction, để
+// snapshot của transaction (RepeatableRead) được chụp sau khi erasure đang chạy đã commit.
+func (m *Manager) lockArchives(ctx context.Context) (*pgxpool.Conn, func(), error) {
+    conn, err := m.Pool.Acquire(ctx)
+    if err != nil {
+        return nil, nil, err
+    }
+    if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, ArchiveLockKey); err != nil {
+        conn.Release()
+        return nil, nil, err
+    }
+    return conn, func() {
+        if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, ArchiveLockKey); err != nil {
+            // Không mở được khóa: bỏ kết nối để khóa session được giải phóng.
+            conn.Conn().Close(context.Background())
+        }
+        conn.Release()
+    }, nil
+}
+
+func runStamp(t time.Time) string {
+    return t.UTC().Format("20060102T150405")
+}
+
+// archive ghi mỗi dòng kết quả (một JSON) thành một dòng NDJSON nén gzip.
+// File được ghi ra path.tmp và chỉ đổi tên khi đã sync; không có dòng nào thì không tạo file.
+// File đã tồn tại không bao giờ bị ghi đè (dữ liệu trong đó đã bị xóa khỏi database).
+func archive(ctx context.Context, tx pgx.Tx, path, query string, args ...interface{}) (n int64, err error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+        return 0, err
+    }
+    if _, err := os.Stat(path); err == nil {
+        return 0, fmt.Errorf("archive %s already exists", path)
+    } else if !errors.Is(err, fs.ErrNotExist) {
+        return 0, err
+    }
+    tmp := path + ".tmp"
+    f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+    if err != nil {
+        return 0, err
+    }
+    defer func() {
+        f.Close()
+        if err != nil || n == 0 {
+            os.Remove(tmp)
+        }
+    }()
+
+    zw := gzip.NewWriter(f)
+    bw := bufio.NewWriter(zw)
+
+    rows, err := tx.Query(ctx, query, args...)
+    if err != nil {
+        return 0, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var line string
+        if err := rows.Scan(&line); err != nil {
+            return n, err
+        }
+        bw.WriteString(line)
+        bw.WriteByte('\n')
+        n++
+    }
+    if err := rows.Err(); err != nil {
+        return n, err
+    }
+    if n == 0 {
+        return 0, nil
+    }
+
+    if err := bw.Flush(); err != nil {
+        return n, err
+    }
+    if err := zw.Close(); err != nil {
+        return n, err
+    }
+    if err := f.Sync(); err != nil {
+        return n, err
+    }
+    if err := f.Close(); err != nil {
+        return n, err
+    }
+    return n, os.Rename(tmp, path)
+}
@@ -0,0 +1,143 @@
+package partition
+
+import (
+    "bufio"
+    "compress/gzip"
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/cdr"
+    "voip-admin/internal/db"
+)
+
+// testPool mở database thử nghiệm từ VOIPADMIN_TEST_DSN; không có thì bỏ qua test.
+func testPool(t *testing.T) *pgxpool.Pool {
+    t.Helper()
+    dsn := os.Getenv("VOIPADMIN_TEST_DSN")
+    if dsn == "" {
+        t.Skip("VOIPADMIN_TEST_DSN not set")
+    }
+    pool, err := db.NewPool(dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(pool.Close)
+
+    ctx := context.Background()
+    if err := db.Migrate(ctx, pool); err != nil {
+        t.Fatal(err)
+    }
+    return pool
+}
+
+// TestPurgeGroupTwice: CDR đến muộn cho cùng partition, nhóm và tháng được purge ở
+// lần chạy sau không được ghi đè file lưu trữ của lần trước.
+func TestPurgeGroupTwice(t *testing.T) {
+    pool := testPool(t)
+    ctx := context.Background()
+    m := &Manager{Pool: pool, ArchiveDir: t.TempDir()}
+
+    start := time.Date(2001, 1, 15, 10, 0, 0, 0, time.UTC)
+    end := time.Date(2001, 2, 1, 0, 0, 0, 0, time.UTC)
+    parts, err := List(ctx, pool)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var part *Partition
+    for i, p := range parts {
+        if !p.IsDefault && (p.From == nil || !p.From.After(start)) && (p.To == nil || p.To.After(start)) {
+            part = &parts[i]
+        }
+    }
+    if part == nil {
+        t.Fatal("no partition covers the test month")
+    }
+
+    prefix := fmt.Sprintf("test-purge-%d", time.Now().UnixNano())
+    uuids := []string{prefix + "-a", prefix + "-b"}
+    g := retentionGroup{label: "test", cond: "call_uuid = ANY($2)", arg: uuids}
+    run := time.Now()
+
+    insert := func(uuid string) {
+        raw := fmt.Sprintf(`{"variables":{"uuid":%q,"direction":"outbound","caller_id_number":"1001",`+
+            `"destination_number":"0901234567","start_stamp":%q,"end_stamp":%q,"duration":"60","billsec":"50"}}`,
+            uuid, start.Format("2006-01-02 15:04:05"), start.Add(time.Minute).Format("2006-01-02 15:04:05"))
+        if _, err := cdr.InsertCDR(ctx, pool, []byte(raw), nil); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for i, uuid := range uuids {
+        insert(uuid)
+        if err := m.purgeGroup(ctx, *part, g, end, run.Add(time.Duration(i)*time.Second)); err != nil {
+            t.Fatalf("purge %d: %v", i+1, err)
+        }
+    }
+
+    files, err := filepath.Glob(filepath.Join(m.ArchiveDir, "*.ndjson.gz"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(files) != 2 {
+        t.Fatalf("got %d archive files, want 2: %v", len(files), files)
+    }
+    var archived []string
+    for _, f := range files {
+        archived = append(archived, readArchive(t, f)...)
+    }
+    for _, uuid := range uuids {
+        found := false
+        for _, line := range archived {
+            if strings.Contains(line, `"`+uuid+`"`) {
+                found = true
+            }
+        }
+        if !found {
+            t.Errorf("cdr %s missing from archives", uuid)
+        }
+    }
+
+    // Cùng thời điểm chạy: file đã có nên purge thất bại và không xóa gì.
+    insert(prefix + "-c")
+    g.arg = []string{prefix + "-c"}
+    if err := m.purgeGroup(ctx, *part, g, end, run); err == nil {
+        t.Error("purge with an existing archive name: want error")
+    }
+    var left int
+    if err := pool.QueryRow(ctx, `SELECT count(*) FROM voip.cdr WHERE call_uuid=$1`, prefix+"-c").Scan(&left); err != nil {
+        t.Fatal(err)
+    }
+    if left != 1 {
+        t.Errorf("cdr deleted without archive: %d rows left", left)
+    }
+    _, _ = pool.Exec(ctx, `DELETE FROM voip.cdr WHERE call_uuid=$1`, prefix+"-c")
+}
+
+func readArchive(t *testing.T, path string) []string {
+    t.Helper()
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    zr, err := gzip.NewReader(f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var lines []string
+    sc := bufio.NewScanner(zr)
+    sc.Buffer(nil, 1<<20)
+    for sc.Scan() {
+        lines = append(lines, sc.Text())
+    }
+    if err := sc.Err(); err != nil {
+        t.Fatal(err)
+    }
+    return lines
+}
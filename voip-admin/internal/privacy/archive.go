@@ -0,0 +1,124 @@
+package privacy
+
+import (
+    "bufio"
+    "compress/gzip"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+// replaceNumber thay mọi dãy chữ số digits không nằm giữa chữ số khác bằng
+// Erased (tương đương pattern (?<![0-9])digits(?![0-9]) dùng trong database).
+func replaceNumber(s, digits string) (string, bool) {
+    var (
+        out     []byte
+        changed bool
+        last    int
+    )
+    for i := 0; i+len(digits) <= len(s); {
+        end := i + len(digits)
+        if s[i:end] == digits && (i == 0 || !isDigit(s[i-1])) && (end == len(s) || !isDigit(s[end])) {
+            out = append(out, s[last:i]...)
+            out = append(out, Erased...)
+            last, i, changed = end, end, true
+            continue
+        }
+        i++
+    }
+    if !changed {
+        return s, false
+    }
+    return string(append(out, s[last:]...)), true
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+// scrubArchives ghi lại các file NDJSON gzip trong dir (do partition retention
+// tạo) với số digits đã được thay bằng Erased. File không chứa số được giữ nguyên.
+// Trả số file đã ghi lại.
+func scrubArchives(dir, digits string) (int, error) {
+    if dir == "" {
+        return 0, nil
+    }
+    files, err := filepath.Glob(filepath.Join(dir, "*.ndjson.gz"))
+    if err != nil {
+        return 0, err
+    }
+    n := 0
+    for _, f := range files {
+        changed, err := scrubArchive(f, digits)
+        if err != nil {
+            return n, err
+        }
+        if changed {
+            n++
+        }
+    }
+    return n, nil
+}
+
+func scrubArchive(path, digits string) (changed bool, err error) {
+    in, err := os.Open(path)
+    if err != nil {
+        return false, err
+    }
+    defer in.Close()
+    zr, err := gzip.NewReader(in)
+    if err != nil {
+        return false, err
+    }
+    defer zr.Close()
+
+    tmp := path + ".tmp"
+    out, err := os.Create(tmp)
+    if err != nil {
+        return false, err
+    }
+    defer func() {
+        if out != nil {
+            out.Close()
+        }
+        if err != nil || !changed {
+            os.Remove(tmp)
+        }
+    }()
+    zw := gzip.NewWriter(out)
+
+    r := bufio.NewReader(zr)
+    for {
+        line, rerr := r.ReadString('\n')
+        if line != "" {
+            if s, ok := replaceNumber(line, digits); ok {
+                line, changed = s, true
+            }
+            if _, err := io.WriteString(zw, line); err != nil {
+                return false, err
+            }
+        }
+        if rerr == io.EOF {
+            break
+        }
+        if rerr != nil {
+            return false, rerr
+        }
+    }
+    if !changed {
+        return false, nil
+    }
+    if err := zw.Close(); err != nil {
+        return false, err
+    }
+    if err := out.Sync(); err != nil {
+        return false, err
+    }
+    if err := out.Close(); err != nil {
+        out = nil
+        return false, err
+    }
+    out = nil
+    if err := os.Rename(tmp, path); err != nil {
+        return false, err
+    }
+    return true, nil
+}
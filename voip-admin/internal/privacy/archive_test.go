@@ -0,0 +1,90 @@
+package privacy
+
+import (
+    "compress/gzip"
+    "io"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestReplaceNumber(t *testing.T) {
+    tests := []struct {
+        in      string
+        want    string
+        changed bool
+    }{
+        {`{"caller_id_number":"84901234567"}`, `{"caller_id_number":"ERASED"}`, true},
+        {`{"n":"+84901234567"}`, `{"n":"+ERASED"}`, true},
+        {`%2B84901234567&x=84901234567`, `%2BERASED&x=ERASED`, true},
+        {`184901234567`, `184901234567`, false},
+        {`849012345678`, `849012345678`, false},
+        {`84901234567`, `ERASED`, true},
+        {`no numbers`, `no numbers`, false},
+    }
+    for _, tt := range tests {
+        got, changed := replaceNumber(tt.in, "84901234567")
+        if got != tt.want || changed != tt.changed {
+            t.Errorf("replaceNumber(%q) = %q, %v; want %q, %v", tt.in, got, changed, tt.want, tt.changed)
+        }
+    }
+}
+
+func TestScrubArchives(t *testing.T) {
+    dir := t.TempDir()
+    writeGzip(t, filepath.Join(dir, "cdr_2024_01.ndjson.gz"),
+        "{\"caller_id_number\":\"84901234567\"}\n{\"caller_id_number\":\"1001\"}\n")
+    writeGzip(t, filepath.Join(dir, "cdr_2024_02.ndjson.gz"), "{\"caller_id_number\":\"1002\"}\n")
+
+    n, err := scrubArchives(dir, "84901234567")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != 1 {
+        t.Errorf("rewrote %d archives, want 1", n)
+    }
+    if got, want := readGzip(t, filepath.Join(dir, "cdr_2024_01.ndjson.gz")),
+        "{\"caller_id_number\":\"ERASED\"}\n{\"caller_id_number\":\"1001\"}\n"; got != want {
+        t.Errorf("archive = %q, want %q", got, want)
+    }
+    if got, want := readGzip(t, filepath.Join(dir, "cdr_2024_02.ndjson.gz")), "{\"caller_id_number\":\"1002\"}\n"; got != want {
+        t.Errorf("untouched archive = %q, want %q", got, want)
+    }
+    if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) != 0 {
+        t.Errorf("temporary files left: %v", tmp)
+    }
+}
+
+func writeGzip(t *testing.T, path, s string) {
+    t.Helper()
+    f, err := os.Create(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    zw := gzip.NewWriter(f)
+    if _, err := io.WriteString(zw, s); err != nil {
+        t.Fatal(err)
+    }
+    if err := zw.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func readGzip(t *testing.T, path string) string {
+    t.Helper()
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    zr, err := gzip.NewReader(f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    b, err := io.ReadAll(zr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(b)
+}
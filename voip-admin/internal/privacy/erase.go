@@ -0,0 +1,195 @@
+package privacy
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "log"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/audit"
+    "voip-admin/internal/partition"
+)
+
+// Erased là giá trị thay cho số điện thoại đã bị xóa.
+const Erased = "ERASED"
+
+// ActionErasure là action ghi vào audit log.
+const ActionErasure = "privacy.erasure"
+
+// ErrInvalidNumber được trả về khi số cần xóa không hợp lệ; yêu cầu ít nhất 6
+// chữ số để không thay nhầm các chuỗi số ngắn trong payload.
+var ErrInvalidNumber = errors.New("invalid number: expected optional + followed by 6-20 digits")
+
+var subjectPattern = regexp.MustCompile(`^\+?[0-9]{6,20}$`)
+
+// Erasure là kết quả của một yêu cầu xóa dữ liệu. Subject là SHA-256 của số
+// (chỉ phần chữ số) để audit không lưu lại chính số điện thoại.
+type Erasure struct {
+    Subject           string   `json:"subject"`
+    CDRs              int64    `json:"cdrs_anonymized"`
+    Recordings        int      `json:"recordings_deleted"`
+    RecordingFailures []string `json:"recording_failures,omitempty"`
+    DeadLetters       int64    `json:"dead_letters_scrubbed"`
+    Duplicates        int64    `json:"duplicates_scrubbed"`
+    FraudAlerts       int64    `json:"fraud_alerts_scrubbed"`
+    WebhookEvents     int64    `json:"webhook_events_scrubbed"`
+    Archives          int      `json:"archives_rewritten"`
+}
+
+// Erase ẩn danh số number trên toàn bộ CDR (cột số gọi/bị gọi, raw_json, raw_xml),
+// các bảng phụ còn giữ payload, các file lưu trữ NDJSON trong archiveDir và xóa
+// bản ghi âm của các cuộc gọi đó. Thay đổi trong database và audit nằm trong một
+// transaction; file lưu trữ được ghi lại trước commit (lỗi làm hủy yêu cầu), file
+// ghi âm được xóa sau commit, lỗi xóa file được trả về trong kết quả và ghi thêm vào audit.
+func Erase(ctx context.Context, pool *pgxpool.Pool, recordingsBase, archiveDir, number, actor string) (*Erasure, error) {
+    if !subjectPattern.MatchString(number) {
+        return nil, ErrInvalidNumber
+    }
+    digits := strings.TrimPrefix(number, "+")
+    sum := sha256.Sum256([]byte(digits))
+    res := &Erasure{Subject: hex.EncodeToString(sum[:])}
+
+    // Số có thể xuất hiện dạng "+849..", "849.." hoặc "%2B849.." trong payload;
+    // pattern khớp dãy chữ số không nằm giữa chữ số khác.
+    variants := []string{digits, "+" + digits}
+    pattern := `(?<![0-9])` + digits + `(?![0-9])`
+
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    // Chặn retention tạo file lưu trữ mới từ dữ liệu chưa xóa trong lúc erasure chạy.
+    if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, partition.ArchiveLockKey); err != nil {
+        return nil, err
+    }
+
+    // CDR chỉ chứa số trong payload (leg chuyển tiếp, sip_from_user,
+    // effective_caller_id_number, biến tùy chỉnh) cũng phải được xóa, nếu không
+    // job reprocess có thể suy lại số từ raw payload.
+    rows, err := tx.Query(ctx, `
+        SELECT DISTINCT recording_id
+        FROM voip.cdr
+        WHERE (caller_id_number = ANY($1) OR destination_number = ANY($1)
+               OR raw_json::text ~ $2 OR raw_xml ~ $2)
+          AND recording_id IS NOT NULL
+    `, variants, pattern)
+    if err != nil {
+        return nil, err
+    }
+    var recordingIDs []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        recordingIDs = append(recordingIDs, id)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    tag, err := tx.Exec(ctx, `
+        UPDATE voip.cdr
+        SET caller_id_number = CASE WHEN caller_id_number = ANY($1) THEN $3 ELSE caller_id_number END,
+            destination_number = CASE WHEN destination_number = ANY($1) THEN $3 ELSE destination_number END,
+            raw_json = regexp_replace(raw_json::text, $2, $3, 'g')::jsonb,
+            raw_xml = regexp_replace(raw_xml, $2, $3, 'g'),
+            recording_id = NULL
+        WHERE caller_id_number = ANY($1) OR destination_number = ANY($1)
+           OR raw_json::text ~ $2 OR raw_xml ~ $2
+    `, variants, pattern, Erased)
+    if err != nil {
+        return nil, err
+    }
+    res.CDRs = tag.RowsAffected()
+
+    var paths []string
+    if len(recordingIDs) > 0 {
+        // CDR khác (nếu có) dùng chung bản ghi âm cũng bỏ tham chiếu.
+        if _, err := tx.Exec(ctx, `
+            UPDATE voip.cdr SET recording_id = NULL WHERE recording_id = ANY($1)
+        `, recordingIDs); err != nil {
+            return nil, err
+        }
+        rows, err := tx.Query(ctx, `DELETE FROM voip.recordings WHERE id = ANY($1) RETURNING path`, recordingIDs)
+        if err != nil {
+            return nil, err
+        }
+        for rows.Next() {
+            var p string
+            if err := rows.Scan(&p); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            paths = append(paths, p)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+    }
+    res.Recordings = len(paths)
+
+    for _, s := range []struct {
+        sql string
+        n   *int64
+    }{
+        {`UPDATE voip.cdr_dead_letters SET payload = regexp_replace(payload, $1, $2, 'g')
+          WHERE payload ~ $1`, &res.DeadLetters},
+        {`UPDATE voip.cdr_duplicates SET discarded_raw_json = regexp_replace(discarded_raw_json, $1, $2, 'g')
+          WHERE discarded_raw_json ~ $1`, &res.Duplicates},
+        {`UPDATE voip.webhook_outbox SET payload = regexp_replace(payload::text, $1, $2, 'g')::jsonb
+          WHERE payload::text ~ $1`, &res.WebhookEvents},
+    } {
+        tag, err := tx.Exec(ctx, s.sql, pattern, Erased)
+        if err != nil {
+            return nil, err
+        }
+        *s.n = tag.RowsAffected()
+    }
+    tag, err = tx.Exec(ctx, `UPDATE voip.fraud_alerts SET caller = $2 WHERE caller = ANY($1)`, variants, Erased)
+    if err != nil {
+        return nil, err
+    }
+    res.FraudAlerts = tag.RowsAffected()
+    // Diff của job reprocess giữ giá trị cũ/mới của cột số.
+    if _, err := tx.Exec(ctx, `DELETE FROM voip.cdr_reprocess_diffs WHERE changes::text ~ $1`, pattern); err != nil {
+        return nil, err
+    }
+    if res.Archives, err = scrubArchives(archiveDir, digits); err != nil {
+        return nil, err
+    }
+
+    if err := audit.Record(ctx, tx, actor, ActionErasure, res.Subject, res); err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+
+    for _, p := range paths {
+        err := os.Remove(filepath.Join(recordingsBase, p))
+        if err != nil && !errors.Is(err, os.ErrNotExist) {
+            log.Printf("erasure %s: remove recording %s: %v", res.Subject, p, err)
+            res.RecordingFailures = append(res.RecordingFailures, p)
+        }
+    }
+    if len(res.RecordingFailures) > 0 {
+        if err := audit.Record(ctx, pool, actor, ActionErasure+".files_failed", res.Subject, map[string]interface{}{
+            "paths": res.RecordingFailures,
+        }); err != nil {
+            log.Printf("erasure %s: audit: %v", res.Subject, err)
+        }
+    }
+    return res, nil
+}
@@ -0,0 +1,27 @@
+package privacy
+
+// MaskNumber thay các chữ số bằng "*", giữ lại keep chữ số cuối; ký tự khác
+// (+, @, domain...) được giữ nguyên.
+func MaskNumber(s string, keep int) string {
+    b := []byte(s)
+    digits := 0
+    for i := len(b) - 1; i >= 0; i-- {
+        if b[i] < '0' || b[i] > '9' {
+            continue
+        }
+        digits++
+        if digits > keep {
+            b[i] = '*'
+        }
+    }
+    return string(b)
+}
+
+// MaskNumberPtr giống MaskNumber cho giá trị có thể NULL.
+func MaskNumberPtr(s *string, keep int) *string {
+    if s == nil {
+        return nil
+    }
+    m := MaskNumber(*s, keep)
+    return &m
+}
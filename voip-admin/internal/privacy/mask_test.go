@@ -0,0 +1,35 @@
+package privacy
+
+import "testing"
+
+func TestMaskNumber(t *testing.T) {
+    tests := []struct {
+        in   string
+        keep int
+        want string
+    }{
+        {"0901234567", 3, "*******567"},
+        {"+84901234567", 3, "+********567"},
+        {"1001@bsv.local", 2, "**01@bsv.local"},
+        {"123", 3, "123"},
+        {"12", 3, "12"},
+        {"0901234567", 0, "**********"},
+        {"", 3, ""},
+        {"anonymous", 3, "anonymous"},
+    }
+    for _, tt := range tests {
+        if got := MaskNumber(tt.in, tt.keep); got != tt.want {
+            t.Errorf("MaskNumber(%q, %d) = %q, want %q", tt.in, tt.keep, got, tt.want)
+        }
+    }
+}
+
+func TestMaskNumberPtr(t *testing.T) {
+    if MaskNumberPtr(nil, 3) != nil {
+        t.Error("MaskNumberPtr(nil) != nil")
+    }
+    s := "0901234567"
+    if got := MaskNumberPtr(&s, 3); got == nil || *got != "*******567" || s != "0901234567" {
+        t.Errorf("MaskNumberPtr = %v, original %q", got, s)
+    }
+}
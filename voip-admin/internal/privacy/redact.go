@@ -0,0 +1,39 @@
+package privacy
+
+import (
+    "encoding/json"
+
+    "voip-admin/internal/config"
+)
+
+// numberFields là các field số điện thoại trong payload webhook
+// (call.ended dùng caller_id_number/destination_number, fraud.alert dùng caller).
+var numberFields = []string{"caller_id_number", "destination_number", "caller"}
+
+// RedactPayload áp data policy của consumer lên payload webhook trước khi gửi:
+// che số, bỏ raw_json/raw_xml và link tải bản ghi âm theo policy.
+// Payload không phải JSON object được trả nguyên.
+func RedactPayload(p config.DataPolicy, data json.RawMessage) (json.RawMessage, error) {
+    if !p.MaskNumbers && !p.HideRawJSON && !p.DenyRecordings {
+        return data, nil
+    }
+    var m map[string]interface{}
+    if err := json.Unmarshal(data, &m); err != nil || m == nil {
+        return data, nil
+    }
+    if p.MaskNumbers {
+        for _, f := range numberFields {
+            if s, ok := m[f].(string); ok {
+                m[f] = MaskNumber(s, p.KeepDigits)
+            }
+        }
+    }
+    if p.HideRawJSON {
+        delete(m, "raw_json")
+        delete(m, "raw_xml")
+    }
+    if p.DenyRecordings {
+        delete(m, "download_url")
+    }
+    return json.Marshal(m)
+}
@@ -0,0 +1,110 @@
+package rating
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// Money là số tiền tính theo micro-unit (1e-6 đơn vị tiền tệ) để tránh sai số float.
+type Money int64
+
+const microsPerUnit = 1000000
+
+var moneyPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]{1,6})?$`)
+
+func (m Money) String() string {
+    sign := ""
+    if m < 0 {
+        sign = "-"
+        m = -m
+    }
+    return fmt.Sprintf("%s%d.%06d", sign, int64(m)/microsPerUnit, int64(m)%microsPerUnit)
+}
+
+// MarshalJSON xuất số tiền dạng chuỗi thập phân, giữ nguyên độ chính xác.
+func (m Money) MarshalJSON() ([]byte, error) {
+    return []byte(`"` + m.String() + `"`), nil
+}
+
+// ParseMoney đọc số tiền thập phân tối đa 6 chữ số lẻ, ví dụ "1500" hoặc "-12.5".
+func ParseMoney(s string) (Money, error) {
+    s = strings.TrimSpace(s)
+    if !moneyPattern.MatchString(s) {
+        return 0, errors.New("invalid amount")
+    }
+    neg := strings.HasPrefix(s, "-")
+    s = strings.TrimPrefix(s, "-")
+
+    whole, frac, _ := strings.Cut(s, ".")
+    w, err := strconv.ParseInt(whole, 10, 64)
+    if err != nil {
+        return 0, errors.New("invalid amount")
+    }
+    var f int64
+    if frac != "" {
+        f, err = strconv.ParseInt(frac+strings.Repeat("0", 6-len(frac)), 10, 64)
+        if err != nil {
+            return 0, errors.New("invalid amount")
+        }
+    }
+    if w > (math.MaxInt64-f)/microsPerUnit {
+        return 0, errors.New("amount out of range")
+    }
+
+    m := Money(w*microsPerUnit + f)
+    if neg {
+        m = -m
+    }
+    return m, nil
+}
+
+// Rate là giá áp dụng cho một prefix đích, đã gộp giá trị mặc định của tariff.
+type Rate struct {
+    TariffID         int64
+    Prefix           string
+    Currency         string
+    RatePerMinute    Money
+    ConnectionFee    Money
+    InitialIncrement int
+    Increment        int
+    BundleEligible   bool
+    // FreeSeconds là số giây miễn phí mỗi tháng của tariff (gói phút).
+    FreeSeconds int
+}
+
+// BillableSeconds làm tròn billsec theo block đầu và các block tiếp theo,
+// ví dụ 30/6: 10s -> 30s, 31s -> 36s.
+func BillableSeconds(billsec, initial, increment int) int {
+    if billsec <= 0 {
+        return 0
+    }
+    if initial <= 0 {
+        initial = 1
+    }
+    if increment <= 0 {
+        increment = 1
+    }
+    if billsec <= initial {
+        return initial
+    }
+    rest := billsec - initial
+    return initial + (rest+increment-1)/increment*increment
+}
+
+// Price tính cước cho số giây đã làm tròn sau khi trừ phần miễn phí.
+// Phí kết nối chỉ áp dụng cho cuộc gọi có trả lời.
+func Price(rate Rate, billableSeconds, freeSeconds int) Money {
+    if billableSeconds <= 0 {
+        return 0
+    }
+    chargeable := billableSeconds - freeSeconds
+    if chargeable < 0 {
+        chargeable = 0
+    }
+    perSecond := int64(rate.RatePerMinute) * int64(chargeable)
+    return rate.ConnectionFee + Money((perSecond+59)/60)
+}
@@ -0,0 +1,91 @@
+package rating
+
+import (
+    "math"
+    "testing"
+)
+
+func TestBillableSeconds(t *testing.T) {
+    tests := []struct {
+        billsec, initial, increment int
+        want                        int
+    }{
+        {0, 30, 6, 0},
+        {-5, 30, 6, 0},
+        {1, 30, 6, 30},
+        {10, 30, 6, 30},
+        {30, 30, 6, 30},
+        {31, 30, 6, 36},
+        {36, 30, 6, 36},
+        {37, 30, 6, 42},
+        {61, 60, 60, 120},
+        {59, 60, 60, 60},
+        {7, 1, 1, 7},
+        // initial/increment không hợp lệ được coi là 1.
+        {7, 0, 0, 7},
+        {7, -1, -1, 7},
+    }
+    for _, tt := range tests {
+        if got := BillableSeconds(tt.billsec, tt.initial, tt.increment); got != tt.want {
+            t.Errorf("BillableSeconds(%d, %d, %d) = %d, want %d", tt.billsec, tt.initial, tt.increment, got, tt.want)
+        }
+    }
+}
+
+func TestPrice(t *testing.T) {
+    rate := Rate{RatePerMinute: 1200 * microsPerUnit, ConnectionFee: 100 * microsPerUnit}
+    tests := []struct {
+        name     string
+        rate     Rate
+        billable int
+        free     int
+        want     Money
+    }{
+        {"unanswered", rate, 0, 0, 0},
+        {"one minute", rate, 60, 0, 1300 * microsPerUnit},
+        {"per second", rate, 30, 0, 700 * microsPerUnit},
+        {"partly free", rate, 90, 60, 700 * microsPerUnit},
+        // Phần miễn phí lớn hơn số giây: chỉ còn phí kết nối.
+        {"fully free", rate, 60, 120, 100 * microsPerUnit},
+        // Làm tròn lên micro-unit: 1 đơn vị/phút trong 1 giây = 16666.67 micro.
+        {"rounds up", Rate{RatePerMinute: 1 * microsPerUnit}, 1, 0, 16667},
+        {"no connection fee", Rate{RatePerMinute: 600 * microsPerUnit}, 6, 0, 60 * microsPerUnit},
+    }
+    for _, tt := range tests {
+        if got := Price(tt.rate, tt.billable, tt.free); got != tt.want {
+            t.Errorf("%s: Price = %s, want %s", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestParseMoney(t *testing.T) {
+    tests := []struct {
+        in      string
+        want    Money
+        wantErr bool
+    }{
+        {in: "1500", want: 1500 * microsPerUnit},
+        {in: "-12.5", want: -12500000},
+        {in: "0.000001", want: 1},
+        {in: " 7.25 ", want: 7250000},
+        {in: "1.0000001", wantErr: true},
+        {in: ".5", wantErr: true},
+        {in: "abc", wantErr: true},
+        {in: "", wantErr: true},
+        {in: "--5", wantErr: true},
+        {in: "5.-3", wantErr: true},
+        {in: "+5", wantErr: true},
+        {in: "5.", wantErr: true},
+        {in: "1e3", wantErr: true},
+        {in: "9223372036854.775807", want: math.MaxInt64},
+        {in: "9223372036854.775808", wantErr: true},
+        {in: "9223372036855", wantErr: true},
+        {in: "99999999999999999999", wantErr: true},
+    }
+    for _, tt := range tests {
+        got, err := ParseMoney(tt.in)
+        if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+            t.Errorf("ParseMoney(%q) = %s, %v; want %s, error %v", tt.in, got, err, tt.want, tt.wantErr)
+        }
+    }
+}
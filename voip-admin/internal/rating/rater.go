@@ -0,0 +1,323 @@
+package rating
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrNoTariff được trả về khi domain chưa được gán tariff.
+var ErrNoTariff = errors.New("no tariff for domain")
+
+type Rater struct {
+    Pool *pgxpool.Pool
+    // AfterRate (tùy chọn) chạy trong cùng transaction sau khi CDR được rate,
+    // ví dụ để trừ số dư prepaid.
+    AfterRate func(ctx context.Context, tx pgx.Tx, res *Result) error
+}
+
+// Result mô tả kết quả rate một CDR.
+type Result struct {
+    CDRID        int64
+    CallUUID     string
+    DomainID     int64
+    Currency     string
+    Cost         Money
+    PreviousCost Money
+    RatedSeconds int
+    FreeSeconds  int
+}
+
+// AccountTotal là tổng cước theo domain (account) trong một khoảng thời gian.
+type AccountTotal struct {
+    DomainID     int64  `json:"domain_id"`
+    Domain       string `json:"domain"`
+    Currency     string `json:"currency"`
+    Calls        int64  `json:"calls"`
+    BillSec      int64  `json:"billsec"`
+    RatedSeconds int64  `json:"rated_seconds"`
+    FreeSeconds  int64  `json:"free_seconds"`
+    Cost         Money  `json:"cost"`
+}
+
+// Querier là phần chung của *pgxpool.Pool và pgx.Tx dùng cho truy vấn một dòng.
+type Querier interface {
+    QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// LookupRate tìm giá theo longest-prefix match của số đích trong tariff của domain.
+// Trả về rate nil nếu tariff không có prefix nào khớp.
+func LookupRate(ctx context.Context, q Querier, domainID int64, number string) (*Rate, error) {
+    var (
+        r     Rate
+        found bool
+    )
+    err := q.QueryRow(ctx, `
+        SELECT t.id, t.currency, t.free_seconds,
+               COALESCE(tr.prefix, ''),
+               COALESCE((tr.rate_per_minute * 1000000)::bigint, 0),
+               (COALESCE(tr.connection_fee, t.connection_fee) * 1000000)::bigint,
+               COALESCE(tr.initial_increment, t.initial_increment),
+               COALESCE(tr.increment, t.increment),
+               COALESCE(tr.bundle_eligible, FALSE),
+               tr.id IS NOT NULL
+        FROM voip.tariffs t
+        LEFT JOIN LATERAL (
+            SELECT *
+            FROM voip.tariff_rates
+            WHERE tariff_id = t.id
+              AND $2 LIKE prefix || '%'
+            ORDER BY length(prefix) DESC
+            LIMIT 1
+        ) tr ON TRUE
+        WHERE t.domain_id=$1
+    `, domainID, number).Scan(
+        &r.TariffID, &r.Currency, &r.FreeSeconds,
+        &r.Prefix, &r.RatePerMinute, &r.ConnectionFee,
+        &r.InitialIncrement, &r.Increment, &r.BundleEligible,
+        &found,
+    )
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrNoTariff
+    }
+    if err != nil {
+        return nil, err
+    }
+    if !found {
+        return nil, nil
+    }
+    return &r, nil
+}
+
+// RateCDR tính lại cước cho một CDR trong transaction riêng.
+func (s *Rater) RateCDR(ctx context.Context, cdrID int64) (*Result, error) {
+    tx, err := s.Pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    res, err := s.RateTx(ctx, tx, cdrID)
+    if err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+    return res, nil
+}
+
+// RateTx giống RateCDR nhưng chạy trong transaction của caller (kể cả AfterRate).
+func (s *Rater) RateTx(ctx context.Context, tx pgx.Tx, cdrID int64) (*Result, error) {
+    res, err := RateCDRTx(ctx, tx, cdrID)
+    if err != nil {
+        return nil, err
+    }
+    if res != nil && s.AfterRate != nil {
+        if err := s.AfterRate(ctx, tx, res); err != nil {
+            return nil, err
+        }
+    }
+    return res, nil
+}
+
+// RateCDRTx tính cước cho CDR trong transaction của caller: hoàn lại số giây
+// miễn phí đã dùng ở lần rate trước, áp dụng gói phút của tháng rồi cập nhật
+// cost/currency trên bản ghi. Cuộc gọi click-to-call tính cho domain của user
+// đã yêu cầu (click_to_call_user_id). CDR không có domain, domain không có tariff hoặc
+// CDR của B-leg (cuộc gọi chỉ tính cước một lần theo A-leg) được bỏ qua
+// (trả về nil, nil).
+func RateCDRTx(ctx context.Context, tx pgx.Tx, cdrID int64) (*Result, error) {
+    var (
+        domainID    *int64
+        destination *string
+        billsec     int
+        startTime   time.Time
+        prevFree    int
+        prevCost    int64
+        leg         string
+        callUUID    string
+    )
+    err := tx.QueryRow(ctx, `
+        SELECT COALESCE(u.domain_id, c.domain_id), c.destination_number, c.billsec, c.start_time,
+               c.free_seconds, COALESCE((c.cost * 1000000)::bigint, 0), c.leg, c.call_uuid
+        FROM voip.cdr c
+        LEFT JOIN voip.users u ON u.id = c.click_to_call_user_id
+        WHERE c.id=$1
+        FOR UPDATE OF c
+    `, cdrID).Scan(&domainID, &destination, &billsec, &startTime, &prevFree, &prevCost, &leg, &callUUID)
+    if err != nil {
+        return nil, err
+    }
+    if domainID == nil || leg != "A" {
+        return nil, nil
+    }
+
+    number := ""
+    if destination != nil {
+        number = *destination
+    }
+    rate, err := LookupRate(ctx, tx, *domainID, number)
+    if errors.Is(err, ErrNoTariff) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    period := time.Date(startTime.Year(), startTime.Month(), 1, 0, 0, 0, 0, startTime.Location())
+    if prevFree > 0 {
+        if _, err := tx.Exec(ctx, `
+            UPDATE voip.bundle_usage
+            SET used_seconds = GREATEST(used_seconds - $3, 0)
+            WHERE domain_id=$1 AND period=$2
+        `, *domainID, period, prevFree); err != nil {
+            return nil, err
+        }
+    }
+
+    res := &Result{
+        CDRID:        cdrID,
+        CallUUID:     callUUID,
+        DomainID:     *domainID,
+        PreviousCost: Money(prevCost),
+    }
+
+    if rate != nil {
+        res.Currency = rate.Currency
+        res.RatedSeconds = BillableSeconds(billsec, rate.InitialIncrement, rate.Increment)
+
+        if rate.BundleEligible && rate.FreeSeconds > 0 && res.RatedSeconds > 0 {
+            free, err := consumeBundle(ctx, tx, *domainID, period, rate.FreeSeconds, res.RatedSeconds)
+            if err != nil {
+                return nil, err
+            }
+            res.FreeSeconds = free
+        }
+        res.Cost = Price(*rate, res.RatedSeconds, res.FreeSeconds)
+    }
+
+    var currency *string
+    if res.Currency != "" {
+        currency = &res.Currency
+    }
+    _, err = tx.Exec(ctx, `
+        UPDATE voip.cdr
+        SET cost = $2::numeric / 1000000,
+            currency = $3,
+            rated_seconds = $4,
+            free_seconds = $5,
+            rated_at = now()
+        WHERE id=$1
+    `, cdrID, int64(res.Cost), currency, res.RatedSeconds, res.FreeSeconds)
+    if err != nil {
+        return nil, err
+    }
+    return res, nil
+}
+
+// consumeBundle trừ tối đa want giây khỏi gói phút của domain trong kỳ,
+// trả về số giây thực sự được miễn phí.
+func consumeBundle(ctx context.Context, tx pgx.Tx, domainID int64, period time.Time, allowance, want int) (int, error) {
+    var used int64
+    err := tx.QueryRow(ctx, `
+        INSERT INTO voip.bundle_usage (domain_id, period, used_seconds)
+        VALUES ($1, $2, 0)
+        ON CONFLICT (domain_id, period) DO UPDATE
+        SET used_seconds = voip.bundle_usage.used_seconds
+        RETURNING used_seconds
+    `, domainID, period).Scan(&used)
+    if err != nil {
+        return 0, err
+    }
+
+    remaining := int64(allowance) - used
+    if remaining <= 0 {
+        return 0, nil
+    }
+    free := int64(want)
+    if free > remaining {
+        free = remaining
+    }
+
+    _, err = tx.Exec(ctx, `
+        UPDATE voip.bundle_usage
+        SET used_seconds = used_seconds + $3
+        WHERE domain_id=$1 AND period=$2
+    `, domainID, period, free)
+    if err != nil {
+        return 0, err
+    }
+    return int(free), nil
+}
+
+// Rerate tính lại cước cho mọi CDR A-leg trong khoảng [from, to] theo thứ tự thời gian,
+// tùy chọn giới hạn trong một domain. Trả về số CDR đã được rate.
+func (s *Rater) Rerate(ctx context.Context, from, to time.Time, domainID *int64) (int, error) {
+    rows, err := s.Pool.Query(ctx, `
+        SELECT id
+        FROM voip.cdr
+        WHERE start_time >= $1 AND start_time <= $2
+          AND domain_id IS NOT NULL
+          AND leg = 'A'
+          AND ($3::bigint IS NULL OR domain_id = $3)
+        ORDER BY start_time, id
+    `, from, to, domainID)
+    if err != nil {
+        return 0, err
+    }
+    ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+    if err != nil {
+        return 0, err
+    }
+
+    rated := 0
+    for _, id := range ids {
+        res, err := s.RateCDR(ctx, id)
+        if err != nil {
+            return rated, err
+        }
+        if res != nil {
+            rated++
+        }
+    }
+    return rated, nil
+}
+
+// Totals tổng hợp cước theo domain và tiền tệ.
+func (s *Rater) Totals(ctx context.Context, from, to time.Time) ([]AccountTotal, error) {
+    rows, err := s.Pool.Query(ctx, `
+        SELECT c.domain_id, d.name, c.currency,
+               COUNT(*), COALESCE(SUM(c.billsec), 0),
+               COALESCE(SUM(c.rated_seconds), 0), COALESCE(SUM(c.free_seconds), 0),
+               COALESCE((SUM(c.cost) * 1000000)::bigint, 0)
+        FROM voip.cdr c
+        JOIN voip.domains d ON d.id = c.domain_id
+        WHERE c.start_time >= $1 AND c.start_time <= $2
+          AND c.leg = 'A'
+          AND c.rated_at IS NOT NULL
+          AND c.currency IS NOT NULL
+        GROUP BY c.domain_id, d.name, c.currency
+        ORDER BY d.name, c.currency
+    `, from, to)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []AccountTotal
+    for rows.Next() {
+        var t AccountTotal
+        if err := rows.Scan(
+            &t.DomainID, &t.Domain, &t.Currency,
+            &t.Calls, &t.BillSec, &t.RatedSeconds, &t.FreeSeconds, &t.Cost,
+        ); err != nil {
+            return nil, err
+        }
+        out = append(out, t)
+    }
+    return out, rows.Err()
+}
@@ -0,0 +1,88 @@
+package rating
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "testing"
+    "time"
+
+    "voip-admin/internal/db"
+)
+
+// TestLookupRateLongestPrefix cần database thử nghiệm (VOIPADMIN_TEST_DSN); dữ
+// liệu được tạo trong transaction và rollback khi xong.
+func TestLookupRateLongestPrefix(t *testing.T) {
+    dsn := os.Getenv("VOIPADMIN_TEST_DSN")
+    if dsn == "" {
+        t.Skip("VOIPADMIN_TEST_DSN not set")
+    }
+    pool, err := db.NewPool(dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer pool.Close()
+    ctx := context.Background()
+    if err := db.Migrate(ctx, pool); err != nil {
+        t.Fatal(err)
+    }
+
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer tx.Rollback(ctx)
+
+    var domainID, tariffID int64
+    if err := tx.QueryRow(ctx, `INSERT INTO voip.domains (name) VALUES ($1) RETURNING id`,
+        fmt.Sprintf("rating-test-%d.local", time.Now().UnixNano())).Scan(&domainID); err != nil {
+        t.Fatal(err)
+    }
+    if err := tx.QueryRow(ctx, `
+        INSERT INTO voip.tariffs (domain_id, name, connection_fee, initial_increment, increment)
+        VALUES ($1, 'test', 50, 30, 6) RETURNING id
+    `, domainID).Scan(&tariffID); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := tx.Exec(ctx, `
+        INSERT INTO voip.tariff_rates (tariff_id, prefix, rate_per_minute, connection_fee, initial_increment, increment)
+        VALUES ($1, '0', 1000, NULL, NULL, NULL),
+               ($1, '09', 800, NULL, 60, 60),
+               ($1, '0901', 500, 0, NULL, NULL),
+               ($1, '00', 5000, 200, NULL, NULL)
+    `, tariffID); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        number     string
+        prefix     string
+        perMinute  Money
+        connection Money
+        initial    int
+        increment  int
+    }{
+        {"0901234567", "0901", 500 * microsPerUnit, 0, 30, 6},
+        {"0911234567", "09", 800 * microsPerUnit, 50 * microsPerUnit, 60, 60},
+        {"0281234567", "0", 1000 * microsPerUnit, 50 * microsPerUnit, 30, 6},
+        {"0061212345678", "00", 5000 * microsPerUnit, 200 * microsPerUnit, 30, 6},
+    }
+    for _, tt := range tests {
+        r, err := LookupRate(ctx, tx, domainID, tt.number)
+        if err != nil {
+            t.Fatalf("LookupRate(%s): %v", tt.number, err)
+        }
+        if r == nil {
+            t.Fatalf("LookupRate(%s): no rate", tt.number)
+        }
+        if r.Prefix != tt.prefix || r.RatePerMinute != tt.perMinute || r.ConnectionFee != tt.connection ||
+            r.InitialIncrement != tt.initial || r.Increment != tt.increment {
+            t.Errorf("LookupRate(%s) = %+v, want prefix %s rate %s fee %s %d/%d",
+                tt.number, *r, tt.prefix, tt.perMinute, tt.connection, tt.initial, tt.increment)
+        }
+    }
+
+    if r, err := LookupRate(ctx, tx, domainID, "1900"); err != nil || r != nil {
+        t.Errorf("LookupRate(1900) = %+v, %v; want no rate", r, err)
+    }
+}
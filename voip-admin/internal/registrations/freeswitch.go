@@ -0,0 +1,126 @@
+package registrations
+
+import (
+    "context"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "voip-admin/internal/esl"
+)
+
+// xmlProfile là kết quả "sofia xmlstatus profile <name> reg".
+type xmlProfile struct {
+    Registrations []struct {
+        CallID    string `xml:"call-id"`
+        User      string `xml:"user"`
+        Contact   string `xml:"contact"`
+        Agent     string `xml:"agent"`
+        Status    string `xml:"status"`
+        NetworkIP string `xml:"network-ip"`
+        Port      string `xml:"network-port"`
+        AuthUser  string `xml:"sip-auth-user"`
+        AuthRealm string `xml:"sip-auth-realm"`
+    } `xml:"registrations>registration"`
+}
+
+// expPattern lấy thời điểm hết hạn từ status, ví dụ
+// "Registered(UDP)(unknown) EXP(2024-05-01 10:00:00) EXPSECS(3600)".
+var expPattern = regexp.MustCompile(`EXP\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\)`)
+
+// FromFreeSWITCH đọc đăng ký trên các profile sofia của mọi node đang kết nối.
+func FromFreeSWITCH(ctx context.Context, fs *esl.Manager, profiles []string, f Filter) ([]Registration, []SourceError) {
+    var (
+        out  []Registration
+        errs []SourceError
+    )
+    for _, n := range fs.Nodes() {
+        if !n.Connected {
+            errs = append(errs, SourceError{Source: SourceFreeSWITCH, Node: n.Node, Error: esl.ErrNotConnected.Error()})
+            continue
+        }
+        for _, profile := range profiles {
+            reply, err := fs.API(ctx, n.Node, "sofia xmlstatus profile "+profile+" reg")
+            if err != nil {
+                errs = append(errs, SourceError{Source: SourceFreeSWITCH, Node: n.Node, Error: err.Error()})
+                continue
+            }
+            items, err := parseXMLStatus(n.Node, profile, reply)
+            if err != nil {
+                errs = append(errs, SourceError{Source: SourceFreeSWITCH, Node: n.Node, Error: "profile " + profile + ": " + err.Error()})
+                continue
+            }
+            for _, r := range items {
+                if f.match(r) {
+                    out = append(out, r)
+                }
+            }
+        }
+    }
+    return out, errs
+}
+
+// charsetReader đọc khai báo encoding="ISO-8859-1" mà sofia xmlstatus luôn in ra.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+    switch strings.ToLower(label) {
+    case "iso-8859-1", "latin1":
+        b, err := io.ReadAll(input)
+        if err != nil {
+            return nil, err
+        }
+        runes := make([]rune, len(b))
+        for i, c := range b {
+            runes[i] = rune(c)
+        }
+        return strings.NewReader(string(runes)), nil
+    case "utf-8":
+        return input, nil
+    }
+    return nil, fmt.Errorf("unsupported charset %q", label)
+}
+
+func parseXMLStatus(node, profile, reply string) ([]Registration, error) {
+    reply = strings.TrimSpace(reply)
+    if !strings.HasPrefix(reply, "<") {
+        // Profile không tồn tại: FreeSWITCH trả thông báo dạng text.
+        return nil, &esl.CommandError{Reply: reply}
+    }
+    var p xmlProfile
+    dec := xml.NewDecoder(strings.NewReader(reply))
+    dec.CharsetReader = charsetReader
+    if err := dec.Decode(&p); err != nil {
+        return nil, err
+    }
+
+    out := make([]Registration, 0, len(p.Registrations))
+    for _, x := range p.Registrations {
+        user, domain := x.AuthUser, x.AuthRealm
+        if u, d, ok := strings.Cut(x.User, "@"); ok {
+            user, domain = u, d
+        }
+        r := Registration{
+            Source:    SourceFreeSWITCH,
+            Node:      node,
+            Profile:   profile,
+            User:      user,
+            Domain:    domain,
+            Contact:   x.Contact,
+            UserAgent: x.Agent,
+            SourceIP:  x.NetworkIP,
+            CallID:    x.CallID,
+        }
+        r.SourcePort, _ = strconv.Atoi(x.Port)
+        if m := expPattern.FindStringSubmatch(x.Status); m != nil {
+            // FreeSWITCH in thời điểm theo giờ địa phương của node.
+            if t, err := time.ParseInLocation("2006-01-02 15:04:05", m[1], time.Local); err == nil {
+                r.Expires = &t
+            }
+        }
+        out = append(out, r)
+    }
+    return out, nil
+}
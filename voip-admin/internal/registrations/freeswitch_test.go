@@ -0,0 +1,113 @@
+package registrations
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "voip-admin/internal/esl"
+)
+
+// xmlStatusReply theo định dạng "sofia xmlstatus profile internal reg"
+// (mã hóa ISO-8859-1, \xe9 là "é").
+const xmlStatusReply = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<profile>
+  <registrations>
+    <registration>
+      <call-id>a1b2c3</call-id>
+      <user>1001@bsv.local</user>
+      <contact>&quot;1001&quot; &lt;sip:1001@10.0.0.5:5060;transport=udp&gt;</contact>
+      <agent>Yealink T46 caf` + "\xe9" + `</agent>
+      <status>Registered(UDP)(unknown) EXP(2024-05-01 10:00:00) EXPSECS(3600)</status>
+      <network-ip>203.0.113.7</network-ip>
+      <network-port>50312</network-port>
+      <sip-auth-user>1001</sip-auth-user>
+      <sip-auth-realm>bsv.local</sip-auth-realm>
+    </registration>
+    <registration>
+      <call-id>d4e5f6</call-id>
+      <user>1002</user>
+      <contact>sip:1002@[2001:db8::5]:5062</contact>
+      <status>Registered(TCP)(unknown)</status>
+      <network-ip>2001:db8::5</network-ip>
+      <network-port>x</network-port>
+      <sip-auth-user>1002</sip-auth-user>
+      <sip-auth-realm>pbx.local</sip-auth-realm>
+    </registration>
+  </registrations>
+</profile>`
+
+func TestParseXMLStatus(t *testing.T) {
+    regs, err := parseXMLStatus("fs1", "internal", xmlStatusReply)
+    if err != nil {
+        t.Fatal(err)
+    }
+    exp := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+    tests := []struct {
+        want    Registration
+        expires *time.Time
+    }{
+        {
+            // user@realm trong <user> được ưu tiên.
+            want: Registration{
+                Source: SourceFreeSWITCH, Node: "fs1", Profile: "internal",
+                User: "1001", Domain: "bsv.local",
+                Contact:   `"1001" <sip:1001@10.0.0.5:5060;transport=udp>`,
+                UserAgent: "Yealink T46 café", SourceIP: "203.0.113.7", SourcePort: 50312, CallID: "a1b2c3",
+            },
+            expires: &exp,
+        },
+        {
+            // <user> không có realm: lấy sip-auth-user/sip-auth-realm; port lỗi thành 0.
+            want: Registration{
+                Source: SourceFreeSWITCH, Node: "fs1", Profile: "internal",
+                User: "1002", Domain: "pbx.local",
+                Contact:  "sip:1002@[2001:db8::5]:5062",
+                SourceIP: "2001:db8::5", CallID: "d4e5f6",
+            },
+        },
+    }
+    if len(regs) != len(tests) {
+        t.Fatalf("got %d registrations, want %d", len(regs), len(tests))
+    }
+    for i, tt := range tests {
+        got := regs[i]
+        if (got.Expires == nil) != (tt.expires == nil) || (got.Expires != nil && !got.Expires.Equal(*tt.expires)) {
+            t.Errorf("registration %d: expires = %v, want %v", i, got.Expires, tt.expires)
+        }
+        got.Expires = nil
+        if got != tt.want {
+            t.Errorf("registration %d:\n got %+v\nwant %+v", i, got, tt.want)
+        }
+    }
+}
+
+func TestParseXMLStatusErrors(t *testing.T) {
+    tests := []struct {
+        name    string
+        reply   string
+        command bool
+    }{
+        {"profile not found", "Invalid Profile!\n", true},
+        {"malformed xml", "<profile><registrations>", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := parseXMLStatus("fs1", "missing", tt.reply)
+            if err == nil {
+                t.Fatal("want error")
+            }
+            var cmdErr *esl.CommandError
+            if errors.As(err, &cmdErr) != tt.command {
+                t.Errorf("err = %v, command error %v", err, tt.command)
+            }
+        })
+    }
+}
+
+func TestParseXMLStatusEmpty(t *testing.T) {
+    regs, err := parseXMLStatus("fs1", "internal", "<profile><registrations></registrations></profile>")
+    if err != nil || len(regs) != 0 {
+        t.Errorf("got %v, %v; want no registrations", regs, err)
+    }
+}
@@ -0,0 +1,74 @@
+package registrations
+
+import (
+    "context"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+
+    "voip-admin/internal/kamailio"
+)
+
+// FromKamailio đọc bảng location của Kamailio (usrloc với db_mode ghi DB).
+// Contact đã hết hạn nhưng chưa được dọn bị bỏ qua.
+func FromKamailio(ctx context.Context, k *kamailio.DB, f Filter) ([]Registration, error) {
+    rows, err := k.Pool.Query(ctx, `
+        SELECT username, COALESCE(domain, ''), contact, COALESCE(received, ''),
+               COALESCE(user_agent, ''), COALESCE(socket, ''), COALESCE(callid, ''), expires
+        FROM `+k.Table("location")+`
+        WHERE ($1 = '' OR domain = $1) AND ($2 = '' OR username = $2)
+    `, f.Domain, f.User)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    now := time.Now()
+    var out []Registration
+    for rows.Next() {
+        var (
+            r        = Registration{Source: SourceKamailio}
+            received string
+            expires  time.Time
+        )
+        if err := rows.Scan(&r.User, &r.Domain, &r.Contact, &received, &r.UserAgent, &r.Node, &r.CallID, &expires); err != nil {
+            return nil, err
+        }
+        // Cột expires là timestamp không time zone, Kamailio ghi theo giờ địa phương;
+        // năm 1970 nghĩa là đăng ký không hết hạn.
+        if expires.Year() > 1970 {
+            t := time.Date(expires.Year(), expires.Month(), expires.Day(),
+                expires.Hour(), expires.Minute(), expires.Second(), 0, time.Local)
+            if t.Before(now) {
+                continue
+            }
+            r.Expires = &t
+        }
+        // received (sau NAT) là địa chỉ nguồn thực; nếu không có thì lấy từ contact.
+        src := received
+        if src == "" {
+            src = r.Contact
+        }
+        r.SourceIP, r.SourcePort = sipHostPort(src)
+        out = append(out, r)
+    }
+    return out, rows.Err()
+}
+
+// sipHostPort tách host và port từ SIP URI như "sip:1001@10.0.0.5:5060;transport=udp".
+func sipHostPort(uri string) (string, int) {
+    s := strings.TrimPrefix(strings.TrimPrefix(uri, "sips:"), "sip:")
+    if i := strings.LastIndex(s, "@"); i >= 0 {
+        s = s[i+1:]
+    }
+    if i := strings.IndexAny(s, ";>?"); i >= 0 {
+        s = s[:i]
+    }
+    host, port, err := net.SplitHostPort(s)
+    if err != nil {
+        return strings.Trim(s, "[]"), 0
+    }
+    p, _ := strconv.Atoi(port)
+    return host, p
+}
@@ -0,0 +1,28 @@
+package registrations
+
+import "testing"
+
+func TestSIPHostPort(t *testing.T) {
+    tests := []struct {
+        uri  string
+        host string
+        port int
+    }{
+        {"sip:1001@10.0.0.5:5060;transport=udp", "10.0.0.5", 5060},
+        {"sip:10.0.0.5:5062", "10.0.0.5", 5062},
+        {"sips:1001@pbx.example.com;transport=tls", "pbx.example.com", 0},
+        {"<sip:1001@10.0.0.5:5060>", "10.0.0.5", 5060},
+        {"sip:1001@10.0.0.5:5060?X-Header=1", "10.0.0.5", 5060},
+        {"sip:1001@[2001:db8::1]:5060;transport=tcp", "2001:db8::1", 5060},
+        {"sip:[::1]:5060", "::1", 5060},
+        {"sip:1001@[::1]", "::1", 0},
+        {"sip:user%40x@host@10.0.0.9:5080", "10.0.0.9", 5080},
+        {"", "", 0},
+    }
+    for _, tt := range tests {
+        host, port := sipHostPort(tt.uri)
+        if host != tt.host || port != tt.port {
+            t.Errorf("sipHostPort(%q) = %q, %d; want %q, %d", tt.uri, host, port, tt.host, tt.port)
+        }
+    }
+}
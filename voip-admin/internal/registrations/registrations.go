@@ -0,0 +1,132 @@
+package registrations
+
+import (
+    "context"
+    "sort"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Nguồn dữ liệu đăng ký.
+const (
+    SourceFreeSWITCH = "freeswitch"
+    SourceKamailio   = "kamailio"
+)
+
+// Registration là một contact đang đăng ký của user.
+type Registration struct {
+    Source string `json:"source"`
+    // Node là node FreeSWITCH, hoặc socket nhận đăng ký của Kamailio.
+    Node       string `json:"node"`
+    Profile    string `json:"profile,omitempty"`
+    User       string `json:"user"`
+    Domain     string `json:"domain"`
+    Contact    string `json:"contact"`
+    UserAgent  string `json:"user_agent,omitempty"`
+    SourceIP   string `json:"source_ip,omitempty"`
+    SourcePort int    `json:"source_port,omitempty"`
+    // Expires rỗng nếu không xác định được (hoặc đăng ký tĩnh).
+    Expires *time.Time `json:"expires,omitempty"`
+    CallID  string     `json:"call_id,omitempty"`
+}
+
+// Filter lọc đăng ký theo domain và user (rỗng là tất cả).
+type Filter struct {
+    Domain string
+    User   string
+}
+
+func (f Filter) match(r Registration) bool {
+    return (f.Domain == "" || r.Domain == f.Domain) && (f.User == "" || r.User == f.User)
+}
+
+// SourceError là lỗi đọc một nguồn (ví dụ node mất kết nối); các nguồn khác
+// vẫn được trả về.
+type SourceError struct {
+    Source string `json:"source"`
+    Node   string `json:"node,omitempty"`
+    Error  string `json:"error"`
+}
+
+// DomainSummary là tổng hợp đăng ký của một domain.
+type DomainSummary struct {
+    Domain string `json:"domain"`
+    // Users là số user đang hoạt động của domain.
+    Users           int64          `json:"users"`
+    RegisteredUsers int            `json:"registered_users"`
+    Contacts        int            `json:"contacts"`
+    ByNode          map[string]int `json:"by_node"`
+}
+
+// Sort sắp xếp theo domain, user rồi node.
+func Sort(items []Registration) {
+    sort.Slice(items, func(i, j int) bool {
+        a, b := items[i], items[j]
+        if a.Domain != b.Domain {
+            return a.Domain < b.Domain
+        }
+        if a.User != b.User {
+            return a.User < b.User
+        }
+        return a.Node < b.Node
+    })
+}
+
+// Summarize tổng hợp đăng ký theo domain, kèm số user đang hoạt động của mọi
+// domain trong voip.domains (domain chưa có đăng ký nào vẫn xuất hiện).
+func Summarize(ctx context.Context, pool *pgxpool.Pool, items []Registration, domain string) ([]DomainSummary, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT d.name, COUNT(u.id) FILTER (WHERE u.is_active)
+        FROM voip.domains d
+        LEFT JOIN voip.users u ON u.domain_id = d.id
+        WHERE $1 = '' OR d.name = $1
+        GROUP BY d.name
+    `, domain)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    byDomain := make(map[string]*DomainSummary)
+    get := func(name string) *DomainSummary {
+        s, ok := byDomain[name]
+        if !ok {
+            s = &DomainSummary{Domain: name, ByNode: map[string]int{}}
+            byDomain[name] = s
+        }
+        return s
+    }
+    for rows.Next() {
+        var (
+            name  string
+            users int64
+        )
+        if err := rows.Scan(&name, &users); err != nil {
+            return nil, err
+        }
+        get(name).Users = users
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    users := make(map[string]map[string]bool)
+    for _, r := range items {
+        s := get(r.Domain)
+        s.Contacts++
+        s.ByNode[r.Node]++
+        if users[r.Domain] == nil {
+            users[r.Domain] = make(map[string]bool)
+        }
+        users[r.Domain][r.User] = true
+    }
+
+    out := make([]DomainSummary, 0, len(byDomain))
+    for name, s := range byDomain {
+        s.RegisteredUsers = len(users[name])
+        out = append(out, *s)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Domain < out[j].Domain })
+    return out, nil
+}
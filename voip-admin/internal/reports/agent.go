@@ -0,0 +1,105 @@
+package reports
+
+import (
+    "context"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AgentParams là tham số báo cáo hiệu suất agent.
+type AgentParams struct {
+    From, To time.Time
+    // Queue (tùy chọn) giới hạn cuộc gọi và lần offer lỗi trong một queue.
+    Queue string
+    // UserID (tùy chọn) giới hạn một agent.
+    UserID *int64
+}
+
+// AgentPerformance là số liệu của một agent, thời gian tính theo giây.
+type AgentPerformance struct {
+    UserID            int64   `json:"user_id"`
+    Agent             string  `json:"agent"`
+    HandledCalls      int64   `json:"handled_calls"`
+    TalkTime          float64 `json:"talk_time"`
+    WrapUpTime        float64 `json:"wrap_up_time"`
+    AverageHandleTime float64 `json:"average_handle_time"`
+    MissedOffers      int64   `json:"missed_offers"`
+}
+
+// AgentReport tổng hợp hiệu suất agent:
+//   - cuộc đã xử lý và talk time từ CDR phía member gắn agent_user_id
+//     (từ lúc agent nhận tới khi kết thúc);
+//   - missed offers từ sự kiện bridge-agent-fail;
+//   - wrap-up time là thời gian agent ở state "Idle" (sau cuộc gọi) theo
+//     sự kiện agent-state-change, không phụ thuộc queue.
+//
+// Agent trong sự kiện callcenter được định danh "username@domain".
+func AgentReport(ctx context.Context, pool *pgxpool.Pool, p AgentParams) ([]AgentPerformance, error) {
+    rows, err := pool.Query(ctx, `
+        WITH agents AS (
+            SELECT u.id AS user_id, u.username || '@' || d.name AS agent
+            FROM voip.users u
+            JOIN voip.domains d ON d.id = u.domain_id
+            WHERE $4::bigint IS NULL OR u.id = $4
+        ), talk AS (
+            SELECT agent_user_id AS user_id,
+                   COUNT(*) AS handled,
+                   SUM(EXTRACT(EPOCH FROM (
+                       COALESCE(cc_queue_terminated_at, end_time) - cc_queue_answered_at
+                   ))) AS talk
+            FROM voip.cdr
+            WHERE agent_user_id IS NOT NULL
+              AND cc_queue_answered_at >= $1 AND cc_queue_answered_at <= $2
+              AND ($3 = '' OR cc_queue = $3)
+              AND COALESCE(cc_side, 'member') = 'member'
+            GROUP BY 1
+        ), missed AS (
+            SELECT agent, COUNT(*) AS missed
+            FROM voip.cc_agent_events
+            WHERE event = 'bridge-agent-fail'
+              AND occurred_at >= $1 AND occurred_at <= $2
+              AND ($3 = '' OR queue = $3)
+            GROUP BY 1
+        ), states AS (
+            SELECT agent, state, occurred_at,
+                   LEAD(occurred_at) OVER (PARTITION BY agent ORDER BY occurred_at) AS next_at
+            FROM voip.cc_agent_events
+            WHERE event = 'agent-state-change'
+              AND occurred_at >= $1 AND occurred_at <= $2
+        ), wrap AS (
+            SELECT agent, SUM(EXTRACT(EPOCH FROM (next_at - occurred_at))) AS wrap
+            FROM states
+            WHERE state = 'Idle' AND next_at IS NOT NULL
+            GROUP BY 1
+        )
+        SELECT a.user_id, a.agent,
+               COALESCE(t.handled, 0),
+               COALESCE(t.talk, 0)::float8,
+               COALESCE(w.wrap, 0)::float8,
+               COALESCE(m.missed, 0)
+        FROM agents a
+        LEFT JOIN talk t ON t.user_id = a.user_id
+        LEFT JOIN missed m ON m.agent = a.agent
+        LEFT JOIN wrap w ON w.agent = a.agent
+        WHERE t.handled IS NOT NULL OR m.missed IS NOT NULL OR w.wrap IS NOT NULL
+        ORDER BY a.agent
+    `, p.From, p.To, p.Queue, p.UserID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []AgentPerformance
+    for rows.Next() {
+        var a AgentPerformance
+        if err := rows.Scan(&a.UserID, &a.Agent, &a.HandledCalls, &a.TalkTime, &a.WrapUpTime, &a.MissedOffers); err != nil {
+            return nil, err
+        }
+        if a.HandledCalls > 0 {
+            a.AverageHandleTime = (a.TalkTime + a.WrapUpTime) / float64(a.HandledCalls)
+        }
+        out = append(out, a)
+    }
+    return out, rows.Err()
+}
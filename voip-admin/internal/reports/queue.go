@@ -0,0 +1,121 @@
+package reports
+
+import (
+    "context"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// QueueParams là tham số báo cáo queue.
+type QueueParams struct {
+    Queue    string
+    From, To time.Time
+    // Interval: hour, day hoặc total.
+    Interval string
+    TZ       string
+    // SLASeconds là ngưỡng thời gian chờ tối đa để tính "trả lời trong SLA".
+    SLASeconds int
+}
+
+// QueueInterval là số liệu của queue trong một khoảng.
+// Tỷ lệ tính theo phần trăm trên số cuộc offered, thời gian chờ theo giây.
+type QueueInterval struct {
+    Interval          string  `json:"interval"`
+    Offered           int64   `json:"offered"`
+    Answered          int64   `json:"answered"`
+    AnsweredWithinSLA int64   `json:"answered_within_sla"`
+    Abandoned         int64   `json:"abandoned"`
+    ServiceLevel      float64 `json:"service_level"`
+    AbandonRate       float64 `json:"abandon_rate"`
+    AvgWait           float64 `json:"avg_wait"`
+    AvgAnswerWait     float64 `json:"avg_answer_wait"`
+    LongestWait       float64 `json:"longest_wait"`
+}
+
+// intervalKeys map interval sang biểu thức khóa theo cột thời gian %[1]s,
+// %[2]s là placeholder của time zone.
+var intervalKeys = map[string]string{
+    "hour":  `to_char(date_trunc('hour', %[1]s AT TIME ZONE %[2]s), 'YYYY-MM-DD"T"HH24:00')`,
+    "day":   `to_char(date_trunc('day', %[1]s AT TIME ZONE %[2]s), 'YYYY-MM-DD')`,
+    "total": `'total'`,
+}
+
+// intervalKey trả biểu thức khóa cho column và thêm time zone vào args khi cần.
+func intervalKey(interval, column, tz string, args *[]interface{}) string {
+    tmpl := intervalKeys[interval]
+    if !strings.Contains(tmpl, "%[2]s") {
+        return tmpl
+    }
+    if tz == "" {
+        tz = "UTC"
+    }
+    *args = append(*args, tz)
+    return fmt.Sprintf(tmpl, column, "$"+strconv.Itoa(len(*args)))
+}
+
+// ValidInterval cho biết interval có được hỗ trợ không.
+func ValidInterval(s string) bool {
+    _, ok := intervalKeys[s]
+    return ok
+}
+
+// QueueReport tính số liệu SLA của một queue từ các CDR phía member
+// (người gọi vào queue), theo thời điểm vào queue.
+func QueueReport(ctx context.Context, pool *pgxpool.Pool, p QueueParams) ([]QueueInterval, error) {
+    if !ValidInterval(p.Interval) {
+        return nil, fmt.Errorf("unsupported interval %q", p.Interval)
+    }
+    args := []interface{}{p.Queue, p.From, p.To, p.SLASeconds}
+    key := intervalKey(p.Interval, "cc_queue_joined_at", p.TZ, &args)
+
+    rows, err := pool.Query(ctx, `
+        WITH calls AS (
+            SELECT cc_queue_joined_at,
+                   cc_queue_answered_at,
+                   EXTRACT(EPOCH FROM (
+                       COALESCE(cc_queue_answered_at, cc_queue_canceled_at, cc_queue_terminated_at, end_time)
+                       - cc_queue_joined_at
+                   )) AS wait
+            FROM voip.cdr
+            WHERE cc_queue = $1
+              AND cc_queue_joined_at >= $2 AND cc_queue_joined_at <= $3
+              AND COALESCE(cc_side, 'member') = 'member'
+        )
+        SELECT `+key+` AS interval,
+               COUNT(*),
+               COUNT(cc_queue_answered_at),
+               COUNT(*) FILTER (WHERE cc_queue_answered_at IS NOT NULL AND wait <= $4),
+               COUNT(*) FILTER (WHERE cc_queue_answered_at IS NULL),
+               COALESCE(AVG(wait), 0)::float8,
+               COALESCE(AVG(wait) FILTER (WHERE cc_queue_answered_at IS NOT NULL), 0)::float8,
+               COALESCE(MAX(wait), 0)::float8
+        FROM calls
+        GROUP BY 1
+        ORDER BY 1
+    `, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []QueueInterval
+    for rows.Next() {
+        var q QueueInterval
+        if err := rows.Scan(
+            &q.Interval, &q.Offered, &q.Answered, &q.AnsweredWithinSLA, &q.Abandoned,
+            &q.AvgWait, &q.AvgAnswerWait, &q.LongestWait,
+        ); err != nil {
+            return nil, err
+        }
+        if q.Offered > 0 {
+            q.ServiceLevel = float64(q.AnsweredWithinSLA) * 100 / float64(q.Offered)
+            q.AbandonRate = float64(q.Abandoned) * 100 / float64(q.Offered)
+        }
+        out = append(out, q)
+    }
+    return out, rows.Err()
+}
@@ -0,0 +1,190 @@
+package reprocess
+
+import (
+    "context"
+    "errors"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "voip-admin/internal/cdr"
+)
+
+// storedRow là giá trị hiện có của các cột được tính lại.
+type storedRow struct {
+    ID                  int64
+    StartTime           time.Time
+    RawJSON             *string
+    Direction           *string
+    CallerIDNumber      string
+    DestinationNumber   string
+    AnswerTime          *time.Time
+    EndTime             *time.Time
+    Duration            int
+    BillSec             int
+    HangupCause         string
+    DomainID            *int64
+    AgentUserID         *int64
+    CCQueue             *string
+    CCSide              *string
+    CCAgent             *string
+    CCCause             *string
+    CCCancelReason      *string
+    CCQueueJoinedAt     *time.Time
+    CCQueueAnsweredAt   *time.Time
+    CCQueueTerminatedAt *time.Time
+    CCQueueCanceledAt   *time.Time
+    Leg                 string
+    OriginatingLegUUID  *string
+    Completeness        int
+    ClickToCallUserID   *int64
+}
+
+// derived là cdr.Columns sau khi đã tra domain_id, agent_user_id và
+// click_to_call_user_id.
+type derived struct {
+    cdr.Columns
+    DomainID     *int64
+    AgentUserID  *int64
+    Completeness int
+}
+
+// resolver tra domain/agent/user như lúc ingest, cache theo khóa trong suốt một job.
+type resolver struct {
+    domains map[string]*int64
+    agents  map[string]*int64
+    users   map[string]*int64
+}
+
+func newResolver() *resolver {
+    return &resolver{domains: map[string]*int64{}, agents: map[string]*int64{}, users: map[string]*int64{}}
+}
+
+func lookupID(ctx context.Context, tx pgx.Tx, cache map[string]*int64, key, sql string) (*int64, error) {
+    if id, ok := cache[key]; ok {
+        return id, nil
+    }
+    var id int64
+    err := tx.QueryRow(ctx, sql, key).Scan(&id)
+    switch {
+    case errors.Is(err, pgx.ErrNoRows):
+        cache[key] = nil
+        return nil, nil
+    case err != nil:
+        return nil, err
+    }
+    cache[key] = &id
+    return &id, nil
+}
+
+func derive(ctx context.Context, tx pgx.Tx, res *resolver, raw []byte) (derived, error) {
+    fs, err := cdr.ParseRawJSON(raw)
+    if err != nil {
+        return derived{}, err
+    }
+    d := derived{Columns: cdr.Derive(fs), Completeness: cdr.Completeness(raw)}
+
+    d.DomainID, err = lookupID(ctx, tx, res.domains, d.DomainName, `
+        SELECT id FROM voip.domains WHERE name=$1
+    `)
+    if err != nil {
+        return d, err
+    }
+    if d.CCAgent != nil && strings.Contains(*d.CCAgent, "@") {
+        d.AgentUserID, err = lookupID(ctx, tx, res.agents, *d.CCAgent, `
+            SELECT u.id
+            FROM voip.users u
+            JOIN voip.domains d ON d.id = u.domain_id
+            WHERE u.username = split_part($1, '@', 1)
+              AND d.name = split_part($1, '@', 2)
+        `)
+        if err != nil {
+            return d, err
+        }
+    }
+    if d.ClickToCallUserID != nil {
+        d.ClickToCallUserID, err = lookupID(ctx, tx, res.users, strconv.FormatInt(*d.ClickToCallUserID, 10), `
+            SELECT id FROM voip.users WHERE id=$1::bigint
+        `)
+        if err != nil {
+            return d, err
+        }
+    }
+    return d, nil
+}
+
+// fieldChange là giá trị cũ/mới của một cột, dạng JSON (nil là NULL).
+type fieldChange struct {
+    Old interface{} `json:"old"`
+    New interface{} `json:"new"`
+}
+
+// diff so sánh giá trị đang lưu với giá trị tính lại, trả về các cột khác nhau.
+func diff(s storedRow, d derived) map[string]fieldChange {
+    out := map[string]fieldChange{}
+    add := func(field string, old, new interface{}) {
+        o, n := normalize(old), normalize(new)
+        if o != n {
+            out[field] = fieldChange{Old: o, New: n}
+        }
+    }
+
+    add("direction", s.Direction, d.Direction)
+    add("caller_id_number", s.CallerIDNumber, d.CallerIDNumber)
+    add("destination_number", s.DestinationNumber, d.DestinationNumber)
+    add("start_time", s.StartTime, d.StartTime)
+    add("answer_time", s.AnswerTime, d.AnswerTime)
+    add("end_time", s.EndTime, d.EndTime)
+    add("duration", s.Duration, d.Duration)
+    add("billsec", s.BillSec, d.BillSec)
+    add("hangup_cause", s.HangupCause, d.HangupCause)
+    add("domain_id", s.DomainID, d.DomainID)
+    add("agent_user_id", s.AgentUserID, d.AgentUserID)
+    add("cc_queue", s.CCQueue, d.CCQueue)
+    add("cc_side", s.CCSide, d.CCSide)
+    add("cc_agent", s.CCAgent, d.CCAgent)
+    add("cc_cause", s.CCCause, d.CCCause)
+    add("cc_cancel_reason", s.CCCancelReason, d.CCCancelReason)
+    add("cc_queue_joined_at", s.CCQueueJoinedAt, d.CCQueueJoinedAt)
+    add("cc_queue_answered_at", s.CCQueueAnsweredAt, d.CCQueueAnsweredAt)
+    add("cc_queue_terminated_at", s.CCQueueTerminatedAt, d.CCQueueTerminatedAt)
+    add("cc_queue_canceled_at", s.CCQueueCanceledAt, d.CCQueueCanceledAt)
+    add("leg", s.Leg, d.Leg)
+    add("originating_leg_uuid", s.OriginatingLegUUID, nullIfEmpty(d.OriginatingLegUUID))
+    add("completeness", s.Completeness, d.Completeness)
+    add("click_to_call_user_id", s.ClickToCallUserID, d.ClickToCallUserID)
+    return out
+}
+
+func nullIfEmpty(s string) *string {
+    if s == "" {
+        return nil
+    }
+    return &s
+}
+
+// normalize đưa giá trị về dạng so sánh được: con trỏ nil thành nil,
+// thời gian thành chuỗi RFC3339 UTC (độ chính xác micro giây như PostgreSQL).
+func normalize(v interface{}) interface{} {
+    switch x := v.(type) {
+    case *string:
+        if x == nil {
+            return nil
+        }
+        return *x
+    case *int64:
+        if x == nil {
+            return nil
+        }
+        return *x
+    case *time.Time:
+        if x == nil {
+            return nil
+        }
+        return normalize(*x)
+    case time.Time:
+        return x.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)
+    }
+    return v
+}
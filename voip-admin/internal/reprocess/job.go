@@ -0,0 +1,170 @@
+package reprocess
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/privacy"
+)
+
+// Trạng thái job.
+const (
+    StatusPending   = "pending"
+    StatusRunning   = "running"
+    StatusCompleted = "completed"
+    StatusFailed    = "failed"
+    StatusCanceled  = "canceled"
+    // StatusHeld là job do lệnh CLI tạo hoặc dừng; worker của service không nhận.
+    StatusHeld = "held"
+)
+
+var (
+    // ErrNotFound được trả về khi job không tồn tại.
+    ErrNotFound = errors.New("reprocess job not found")
+    // ErrFinished được trả về khi hủy hoặc chạy lại job đã kết thúc.
+    ErrFinished = errors.New("reprocess job already finished")
+    // ErrRunning được trả về khi chạy job đang được tiến trình khác chạy.
+    ErrRunning = errors.New("reprocess job is running")
+)
+
+// Job là một lần tính lại cột CDR từ raw_json trong [From, To].
+// Cursor (start_time, id) là checkpoint của batch cuối đã xử lý.
+type Job struct {
+    ID              int64            `json:"id"`
+    From            time.Time        `json:"from"`
+    To              time.Time        `json:"to"`
+    DryRun          bool             `json:"dry_run"`
+    Status          string           `json:"status"`
+    CursorStartTime *time.Time       `json:"cursor_start_time,omitempty"`
+    CursorID        *int64           `json:"cursor_id,omitempty"`
+    Scanned         int64            `json:"scanned"`
+    Changed         int64            `json:"changed"`
+    Failed          int64            `json:"failed"`
+    FieldChanges    map[string]int64 `json:"field_changes"`
+    DiffSamples     int              `json:"diff_samples"`
+    LastError       *string          `json:"last_error,omitempty"`
+    CreatedAt       time.Time        `json:"created_at"`
+    UpdatedAt       time.Time        `json:"updated_at"`
+    FinishedAt      *time.Time       `json:"finished_at,omitempty"`
+}
+
+// Diff là khác biệt của một CDR: field -> {old, new}.
+type Diff struct {
+    CDRID     int64                      `json:"cdr_id"`
+    StartTime time.Time                  `json:"start_time"`
+    Changes   map[string]json.RawMessage `json:"changes"`
+}
+
+const jobColumns = `
+    id, from_time, to_time, dry_run, status, cursor_start_time, cursor_id,
+    scanned, changed, failed, field_changes, diff_samples, last_error,
+    created_at, updated_at, finished_at
+`
+
+func scanJob(row pgx.Row) (*Job, error) {
+    var j Job
+    err := row.Scan(
+        &j.ID, &j.From, &j.To, &j.DryRun, &j.Status, &j.CursorStartTime, &j.CursorID,
+        &j.Scanned, &j.Changed, &j.Failed, &j.FieldChanges, &j.DiffSamples, &j.LastError,
+        &j.CreatedAt, &j.UpdatedAt, &j.FinishedAt,
+    )
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if j.FieldChanges == nil {
+        j.FieldChanges = map[string]int64{}
+    }
+    return &j, nil
+}
+
+// Create tạo job ở trạng thái pending; worker của service sẽ nhận và chạy.
+func Create(ctx context.Context, pool *pgxpool.Pool, from, to time.Time, dryRun bool) (*Job, error) {
+    return scanJob(pool.QueryRow(ctx, `
+        INSERT INTO voip.cdr_reprocess_jobs (from_time, to_time, dry_run)
+        VALUES ($1, $2, $3)
+        RETURNING `+jobColumns, from, to, dryRun))
+}
+
+// CreateHeld tạo job ở trạng thái held để lệnh CLI tự chạy (xem Runner.Start).
+func CreateHeld(ctx context.Context, pool *pgxpool.Pool, from, to time.Time, dryRun bool) (*Job, error) {
+    return scanJob(pool.QueryRow(ctx, `
+        INSERT INTO voip.cdr_reprocess_jobs (from_time, to_time, dry_run, status)
+        VALUES ($1, $2, $3, 'held')
+        RETURNING `+jobColumns, from, to, dryRun))
+}
+
+func Get(ctx context.Context, pool *pgxpool.Pool, id int64) (*Job, error) {
+    return scanJob(pool.QueryRow(ctx, `
+        SELECT `+jobColumns+` FROM voip.cdr_reprocess_jobs WHERE id=$1
+    `, id))
+}
+
+// Cancel dừng job chưa kết thúc; batch đang chạy vẫn được commit.
+func Cancel(ctx context.Context, pool *pgxpool.Pool, id int64) (*Job, error) {
+    j, err := scanJob(pool.QueryRow(ctx, `
+        UPDATE voip.cdr_reprocess_jobs
+        SET status = 'canceled', updated_at = now(), finished_at = now()
+        WHERE id = $1 AND status IN ('pending', 'running', 'held')
+        RETURNING `+jobColumns, id))
+    if errors.Is(err, ErrNotFound) {
+        if _, err := Get(ctx, pool, id); err != nil {
+            return nil, err
+        }
+        return nil, ErrFinished
+    }
+    return j, err
+}
+
+// Diffs trả các khác biệt mẫu đã ghi lại của job.
+func Diffs(ctx context.Context, pool *pgxpool.Pool, jobID int64, limit int) ([]Diff, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT cdr_id, start_time, changes
+        FROM voip.cdr_reprocess_diffs
+        WHERE job_id = $1
+        ORDER BY start_time, cdr_id
+        LIMIT $2
+    `, jobID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Diff
+    for rows.Next() {
+        var d Diff
+        if err := rows.Scan(&d.CDRID, &d.StartTime, &d.Changes); err != nil {
+            return nil, err
+        }
+        out = append(out, d)
+    }
+    return out, rows.Err()
+}
+
+// MaskNumbers che giá trị cũ/mới của các cột số gọi/bị gọi trong d.
+func MaskNumbers(d Diff, keep int) {
+    for _, field := range []string{"caller_id_number", "destination_number"} {
+        raw, ok := d.Changes[field]
+        if !ok {
+            continue
+        }
+        var c struct {
+            Old *string `json:"old"`
+            New *string `json:"new"`
+        }
+        if err := json.Unmarshal(raw, &c); err != nil {
+            delete(d.Changes, field)
+            continue
+        }
+        c.Old = privacy.MaskNumberPtr(c.Old, keep)
+        c.New = privacy.MaskNumberPtr(c.New, keep)
+        masked, _ := json.Marshal(c)
+        d.Changes[field] = masked
+    }
+}
@@ -0,0 +1,301 @@
+package reprocess
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "voip-admin/internal/cdr"
+)
+
+// Runner tính lại các cột voip.cdr từ raw_json theo batch, lưu checkpoint sau
+// mỗi batch để có thể dừng và chạy tiếp. Ở chế độ dry-run chỉ ghi thống kê và
+// khác biệt mẫu, không sửa CDR.
+//
+// Cột rating (cost, rated_*) và recording_id không được tính lại; sau khi job
+// chạy thật, rollup thống kê được đánh dấu tính lại từ From.
+type Runner struct {
+    Pool      *pgxpool.Pool
+    BatchSize int
+    // MaxDiffSamples là số CDR khác biệt tối đa được lưu chi tiết cho mỗi job.
+    MaxDiffSamples int
+    // Hold: job bị ngắt quay về held thay vì pending (lệnh CLI chạy tiếp bằng -resume).
+    Hold bool
+}
+
+// staleAfter: job running không cập nhật quá thời gian này được coi là của
+// instance đã dừng và được nhận lại.
+const staleAfter = 5 * time.Minute
+
+// Run nhận và chạy job pending cho tới khi ctx bị hủy.
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        for ctx.Err() == nil {
+            job, err := r.claim(ctx)
+            if err != nil {
+                log.Printf("cdr reprocess: claim job: %v", err)
+                break
+            }
+            if job == nil {
+                break
+            }
+            if err := r.Execute(ctx, job); err != nil && ctx.Err() == nil {
+                log.Printf("cdr reprocess job %d: %v", job.ID, err)
+            }
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (r *Runner) claim(ctx context.Context) (*Job, error) {
+    j, err := scanJob(r.Pool.QueryRow(ctx, `
+        UPDATE voip.cdr_reprocess_jobs
+        SET status = 'running', updated_at = now()
+        WHERE id = (
+            SELECT id FROM voip.cdr_reprocess_jobs
+            WHERE status = 'pending'
+               OR (status = 'running' AND updated_at < now() - $1::interval)
+            ORDER BY id
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+jobColumns, staleAfter.String()))
+    if errors.Is(err, ErrNotFound) {
+        return nil, nil
+    }
+    return j, err
+}
+
+// Start chuyển job sang running để chạy trực tiếp (ví dụ từ lệnh CLI). Giống
+// claim, chỉ nhận job pending/held, job running đã quá staleAfter, hoặc job
+// failed (chạy tiếp từ checkpoint); job đang chạy ở nơi khác trả ErrRunning.
+func (r *Runner) Start(ctx context.Context, id int64) (*Job, error) {
+    j, err := scanJob(r.Pool.QueryRow(ctx, `
+        UPDATE voip.cdr_reprocess_jobs
+        SET status = 'running', last_error = NULL, finished_at = NULL, updated_at = now()
+        WHERE id = $1
+          AND (status IN ('pending', 'held', 'failed')
+               OR (status = 'running' AND updated_at < now() - $2::interval))
+        RETURNING `+jobColumns, id, staleAfter.String()))
+    if errors.Is(err, ErrNotFound) {
+        cur, err := Get(ctx, r.Pool, id)
+        if err != nil {
+            return nil, err
+        }
+        if cur.Status == StatusRunning {
+            return nil, ErrRunning
+        }
+        return nil, ErrFinished
+    }
+    return j, err
+}
+
+// Execute chạy job (đã ở trạng thái running) tới khi xong, bị hủy hoặc lỗi.
+// Khi ctx bị hủy, job quay về pending (held nếu Hold) để được chạy tiếp sau.
+func (r *Runner) Execute(ctx context.Context, job *Job) error {
+    res := newResolver()
+    for {
+        done, err := r.batch(ctx, job, res)
+        if err != nil {
+            r.finish(job.ID, err)
+            return err
+        }
+        if done {
+            log.Printf("cdr reprocess job %d: %s, scanned %d, changed %d, failed %d",
+                job.ID, job.Status, job.Scanned, job.Changed, job.Failed)
+            return nil
+        }
+    }
+}
+
+// finish ghi trạng thái khi job dừng vì lỗi hoặc do service tắt.
+func (r *Runner) finish(id int64, err error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    if errors.Is(err, context.Canceled) {
+        status := StatusPending
+        if r.Hold {
+            status = StatusHeld
+        }
+        _, err = r.Pool.Exec(ctx, `
+            UPDATE voip.cdr_reprocess_jobs SET status = $2, updated_at = now()
+            WHERE id = $1 AND status = 'running'
+        `, id, status)
+    } else {
+        _, err = r.Pool.Exec(ctx, `
+            UPDATE voip.cdr_reprocess_jobs
+            SET status = 'failed', last_error = $2, updated_at = now(), finished_at = now()
+            WHERE id = $1 AND status = 'running'
+        `, id, err.Error())
+    }
+    if err != nil {
+        log.Printf("cdr reprocess job %d: update status: %v", id, err)
+    }
+}
+
+// batch xử lý một batch và cập nhật checkpoint trong cùng transaction.
+func (r *Runner) batch(ctx context.Context, job *Job, res *resolver) (bool, error) {
+    tx, err := r.Pool.Begin(ctx)
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback(ctx)
+
+    rows, err := tx.Query(ctx, `
+        SELECT id, start_time, raw_json::text,
+               direction::text, COALESCE(caller_id_number, ''), COALESCE(destination_number, ''),
+               answer_time, end_time, COALESCE(duration, 0), COALESCE(billsec, 0),
+               COALESCE(hangup_cause, ''), domain_id, agent_user_id,
+               cc_queue, cc_side, cc_agent, cc_cause, cc_cancel_reason,
+               cc_queue_joined_at, cc_queue_answered_at, cc_queue_terminated_at, cc_queue_canceled_at,
+               leg, originating_leg_uuid, completeness, click_to_call_user_id
+        FROM voip.cdr
+        WHERE start_time >= $1 AND start_time <= $2
+          AND ($3::timestamptz IS NULL OR (start_time, id) > ($3, $4))
+        ORDER BY start_time, id
+        LIMIT $5
+    `, job.From, job.To, job.CursorStartTime, job.CursorID, r.BatchSize)
+    if err != nil {
+        return false, err
+    }
+    var batch []storedRow
+    for rows.Next() {
+        var s storedRow
+        if err := rows.Scan(
+            &s.ID, &s.StartTime, &s.RawJSON,
+            &s.Direction, &s.CallerIDNumber, &s.DestinationNumber,
+            &s.AnswerTime, &s.EndTime, &s.Duration, &s.BillSec,
+            &s.HangupCause, &s.DomainID, &s.AgentUserID,
+            &s.CCQueue, &s.CCSide, &s.CCAgent, &s.CCCause, &s.CCCancelReason,
+            &s.CCQueueJoinedAt, &s.CCQueueAnsweredAt, &s.CCQueueTerminatedAt, &s.CCQueueCanceledAt,
+            &s.Leg, &s.OriginatingLegUUID, &s.Completeness, &s.ClickToCallUserID,
+        ); err != nil {
+            rows.Close()
+            return false, err
+        }
+        batch = append(batch, s)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return false, err
+    }
+
+    for _, s := range batch {
+        job.Scanned++
+        if s.RawJSON == nil {
+            job.Failed++
+            continue
+        }
+        d, err := derive(ctx, tx, res, []byte(*s.RawJSON))
+        if errors.Is(err, cdr.ErrInvalidPayload) {
+            job.Failed++
+            continue
+        }
+        if err != nil {
+            return false, err
+        }
+        changes := diff(s, d)
+        if len(changes) == 0 {
+            continue
+        }
+
+        job.Changed++
+        for field := range changes {
+            job.FieldChanges[field]++
+        }
+        if job.DiffSamples < r.MaxDiffSamples {
+            if _, err := tx.Exec(ctx, `
+                INSERT INTO voip.cdr_reprocess_diffs (job_id, cdr_id, start_time, changes)
+                VALUES ($1, $2, $3, $4)
+                ON CONFLICT DO NOTHING
+            `, job.ID, s.ID, s.StartTime, changes); err != nil {
+                return false, err
+            }
+            job.DiffSamples++
+        }
+        if !job.DryRun {
+            if err := update(ctx, tx, s, d); err != nil {
+                return false, fmt.Errorf("update cdr %d: %w", s.ID, err)
+            }
+        }
+    }
+
+    done := len(batch) < r.BatchSize
+    if len(batch) > 0 {
+        last := batch[len(batch)-1]
+        job.CursorStartTime, job.CursorID = &last.StartTime, &last.ID
+    }
+    job.Status = StatusRunning
+    if done {
+        job.Status = StatusCompleted
+    }
+
+    // Job bị hủy trong lúc chạy: không ghi đè trạng thái, bỏ batch hiện tại.
+    tag, err := tx.Exec(ctx, `
+        UPDATE voip.cdr_reprocess_jobs
+        SET status = $2, cursor_start_time = $3, cursor_id = $4,
+            scanned = $5, changed = $6, failed = $7,
+            field_changes = $8, diff_samples = $9, updated_at = now(),
+            finished_at = CASE WHEN $2 = 'completed' THEN now() END
+        WHERE id = $1 AND status = 'running'
+    `, job.ID, job.Status, job.CursorStartTime, job.CursorID,
+        job.Scanned, job.Changed, job.Failed, job.FieldChanges, job.DiffSamples)
+    if err != nil {
+        return false, err
+    }
+    if tag.RowsAffected() == 0 {
+        job.Status = StatusCanceled
+        return true, nil
+    }
+
+    // CDR đã đổi nên rollup thống kê của khoảng này cần tính lại.
+    if done && !job.DryRun && job.Changed > 0 {
+        if _, err := tx.Exec(ctx, `
+            UPDATE voip.cdr_rollup_state
+            SET rolled_until = LEAST(rolled_until, date_trunc('hour', $1::timestamptz))
+            WHERE id = 1
+        `, job.From); err != nil {
+            return false, err
+        }
+    }
+    return done, tx.Commit(ctx)
+}
+
+func update(ctx context.Context, tx pgx.Tx, s storedRow, d derived) error {
+    _, err := tx.Exec(ctx, `
+        UPDATE voip.cdr
+        SET direction = $3, caller_id_number = $4, destination_number = $5,
+            start_time = $6, answer_time = $7, end_time = $8,
+            duration = $9, billsec = $10, hangup_cause = $11,
+            domain_id = $12, agent_user_id = $13,
+            cc_queue = $14, cc_side = $15, cc_agent = $16, cc_cause = $17, cc_cancel_reason = $18,
+            cc_queue_joined_at = $19, cc_queue_answered_at = $20,
+            cc_queue_terminated_at = $21, cc_queue_canceled_at = $22,
+            leg = $23, originating_leg_uuid = $24, completeness = $25,
+            click_to_call_user_id = $26
+        WHERE id = $1 AND start_time = $2
+    `,
+        s.ID, s.StartTime,
+        d.Direction, d.CallerIDNumber, d.DestinationNumber,
+        d.StartTime, d.AnswerTime, d.EndTime,
+        d.Duration, d.BillSec, d.HangupCause,
+        d.DomainID, d.AgentUserID,
+        d.CCQueue, d.CCSide, d.CCAgent, d.CCCause, d.CCCancelReason,
+        d.CCQueueJoinedAt, d.CCQueueAnsweredAt, d.CCQueueTerminatedAt, d.CCQueueCanceledAt,
+        d.Leg, nullIfEmpty(d.OriginatingLegUUID), d.Completeness,
+        d.ClickToCallUserID,
+    )
+    return err
+}
@@ -0,0 +1,201 @@
+package stats
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Params là tham số truy vấn thống kê.
+type Params struct {
+    From, To  time.Time
+    GroupBy   string
+    TZ        string
+    DomainID  *int64
+    TrunkID   *int64
+    Direction string
+}
+
+// Row là một dòng thống kê. ASR tính theo phần trăm, ACD theo giây/cuộc trả lời.
+type Row struct {
+    Key             string  `json:"key"`
+    Calls           int64   `json:"calls"`
+    Answered        int64   `json:"answered"`
+    ASR             float64 `json:"asr"`
+    ACD             float64 `json:"acd"`
+    DurationSum     int64   `json:"duration_sum"`
+    BillSecSum      int64   `json:"billsec_sum"`
+    PeakConcurrency *int64  `json:"peak_concurrency,omitempty"`
+}
+
+// groupKeys map group_by sang biểu thức khóa; "%[1]s" là placeholder của time zone.
+var groupKeys = map[string]string{
+    "hour":         `to_char(date_trunc('hour', bucket AT TIME ZONE %[1]s), 'YYYY-MM-DD"T"HH24:00')`,
+    "day":          `to_char(date_trunc('day', bucket AT TIME ZONE %[1]s), 'YYYY-MM-DD')`,
+    "trunk":        `trunk_id::text`,
+    "domain":       `domain_id::text`,
+    "direction":    `direction`,
+    "hangup_cause": `hangup_cause`,
+}
+
+// ValidGroupBy cho biết group_by có được hỗ trợ không.
+func ValidGroupBy(g string) bool {
+    _, ok := groupKeys[g]
+    return ok
+}
+
+// Query trả thống kê trong [From, To]. Phần đã roll up đọc từ bảng rollup,
+// phần sau watermark (giờ hiện tại, CDR chưa tổng hợp) được tính trực tiếp từ voip.cdr.
+// From được làm tròn xuống đầu giờ. Đỉnh đồng thời chỉ có khi group theo hour/day
+// và không lọc theo domain/trunk/direction.
+func Query(ctx context.Context, pool *pgxpool.Pool, p Params) ([]Row, error) {
+    keyTmpl, ok := groupKeys[p.GroupBy]
+    if !ok {
+        return nil, fmt.Errorf("unsupported group_by %q", p.GroupBy)
+    }
+    timeKey := strings.Contains(keyTmpl, "%[1]s")
+    if p.TZ == "" {
+        p.TZ = "UTC"
+    }
+
+    var rolled time.Time
+    err := pool.QueryRow(ctx, `SELECT rolled_until FROM voip.cdr_rollup_state WHERE id=1`).Scan(&rolled)
+    if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+        return nil, err
+    }
+
+    // Bucket rollup chỉ dùng cho các giờ trọn vẹn trước To; giờ cuối dở dang
+    // và phần sau watermark đọc từ voip.cdr trong [rollupEnd, To].
+    from := p.From.Truncate(time.Hour)
+    rollupEnd := p.To.Truncate(time.Hour)
+    if rolled.Before(rollupEnd) {
+        rollupEnd = rolled
+    }
+    if rollupEnd.Before(from) {
+        rollupEnd = from
+    }
+    liveStart := rollupEnd
+
+    args := []interface{}{from, rollupEnd, liveStart, p.To}
+    keyExpr := keyTmpl
+    if timeKey {
+        args = append(args, p.TZ)
+        keyExpr = fmt.Sprintf(keyTmpl, "$"+strconv.Itoa(len(args)))
+    }
+
+    var where []string
+    addFilter := func(cond string, arg interface{}) {
+        args = append(args, arg)
+        where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+    }
+    if p.DomainID != nil {
+        addFilter("domain_id = ?", *p.DomainID)
+    }
+    if p.TrunkID != nil {
+        addFilter("trunk_id = ?", *p.TrunkID)
+    }
+    if p.Direction != "" {
+        addFilter("direction = ?", p.Direction)
+    }
+    whereSQL := ""
+    if len(where) > 0 {
+        whereSQL = "WHERE " + strings.Join(where, " AND ")
+    }
+
+    query := `
+        WITH src AS (
+            SELECT bucket, domain_id, trunk_id, direction, hangup_cause,
+                   calls, answered, duration_sum, billsec_sum
+            FROM voip.cdr_rollup_hourly
+            WHERE bucket >= $1 AND bucket < $2
+            UNION ALL
+            SELECT date_trunc('hour', start_time),
+                   COALESCE(domain_id, 0), COALESCE(trunk_id, 0),
+                   COALESCE(direction::text, ''), COALESCE(hangup_cause, ''),
+                   1, (billsec > 0)::int, duration, billsec
+            FROM voip.cdr
+            WHERE start_time >= $3 AND start_time <= $4
+              AND leg = 'A'
+        )
+        SELECT ` + keyExpr + ` AS key,
+               SUM(calls)::bigint, SUM(answered)::bigint,
+               SUM(duration_sum)::bigint, SUM(billsec_sum)::bigint
+        FROM src
+        ` + whereSQL + `
+        GROUP BY 1
+        ORDER BY 1
+    `
+
+    rows, err := pool.Query(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Row
+    for rows.Next() {
+        var r Row
+        if err := rows.Scan(&r.Key, &r.Calls, &r.Answered, &r.DurationSum, &r.BillSecSum); err != nil {
+            return nil, err
+        }
+        if r.Calls > 0 {
+            r.ASR = float64(r.Answered) * 100 / float64(r.Calls)
+        }
+        if r.Answered > 0 {
+            r.ACD = float64(r.BillSecSum) / float64(r.Answered)
+        }
+        out = append(out, r)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    if timeKey && len(where) == 0 {
+        if err := attachPeaks(ctx, pool, fmt.Sprintf(keyTmpl, "$3"), p.TZ, from, p.To, out); err != nil {
+            return nil, err
+        }
+    }
+    return out, nil
+}
+
+func attachPeaks(ctx context.Context, pool *pgxpool.Pool, keyExpr, tz string, from, to time.Time, out []Row) error {
+    rows, err := pool.Query(ctx, `
+        SELECT `+keyExpr+` AS key, MAX(peak)::bigint
+        FROM voip.cdr_concurrency_hourly
+        WHERE bucket >= $1 AND bucket <= $2
+        GROUP BY 1
+    `, from, to, tz)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    peaks := make(map[string]int64)
+    for rows.Next() {
+        var (
+            key  string
+            peak int64
+        )
+        if err := rows.Scan(&key, &peak); err != nil {
+            return err
+        }
+        peaks[key] = peak
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for i := range out {
+        if v, ok := peaks[out[i].Key]; ok {
+            v := v
+            out[i].PeakConcurrency = &v
+        }
+    }
+    return nil
+}
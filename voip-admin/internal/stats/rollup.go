@@ -0,0 +1,151 @@
+package stats
+
+import (
+    "context"
+    "errors"
+    "log"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Roller tổng hợp voip.cdr theo giờ vào voip.cdr_rollup_hourly và
+// voip.cdr_concurrency_hourly. Chỉ các giờ đã kết thúc được roll up; mỗi
+// cuộc gọi được đếm một lần theo CDR A-leg.
+type Roller struct {
+    Pool *pgxpool.Pool
+    // Lookback là khoảng giờ đã roll up được tính lại mỗi lần chạy để
+    // bao gồm CDR đến muộn.
+    Lookback time.Duration
+}
+
+const rollupChunk = 24 * time.Hour
+
+// Run gọi Refresh định kỳ cho tới khi ctx bị hủy.
+func (r *Roller) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        if err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+            log.Printf("stats rollup: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Refresh roll up các giờ từ watermark (trừ Lookback) tới đầu giờ hiện tại.
+func (r *Roller) Refresh(ctx context.Context) error {
+    var end time.Time
+    if err := r.Pool.QueryRow(ctx, `SELECT date_trunc('hour', now())`).Scan(&end); err != nil {
+        return err
+    }
+
+    var start time.Time
+    err := r.Pool.QueryRow(ctx, `SELECT rolled_until FROM voip.cdr_rollup_state WHERE id=1`).Scan(&start)
+    switch {
+    case errors.Is(err, pgx.ErrNoRows):
+        var first *time.Time
+        if err := r.Pool.QueryRow(ctx, `SELECT date_trunc('hour', MIN(start_time)) FROM voip.cdr`).Scan(&first); err != nil {
+            return err
+        }
+        if first == nil {
+            return r.setWatermark(ctx, end)
+        }
+        start = *first
+    case err != nil:
+        return err
+    default:
+        start = start.Add(-r.Lookback)
+    }
+
+    for a := start; a.Before(end); a = a.Add(rollupChunk) {
+        b := a.Add(rollupChunk)
+        if b.After(end) {
+            b = end
+        }
+        if err := r.rollupRange(ctx, a, b); err != nil {
+            return err
+        }
+    }
+    return r.setWatermark(ctx, end)
+}
+
+func (r *Roller) setWatermark(ctx context.Context, t time.Time) error {
+    _, err := r.Pool.Exec(ctx, `
+        INSERT INTO voip.cdr_rollup_state (id, rolled_until) VALUES (1, $1)
+        ON CONFLICT (id) DO UPDATE SET rolled_until = EXCLUDED.rolled_until
+    `, t)
+    return err
+}
+
+// rollupRange tính lại rollup cho các giờ trong [a, b).
+func (r *Roller) rollupRange(ctx context.Context, a, b time.Time) error {
+    tx, err := r.Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, `
+        DELETE FROM voip.cdr_rollup_hourly WHERE bucket >= $1 AND bucket < $2
+    `, a, b); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(ctx, `
+        INSERT INTO voip.cdr_rollup_hourly (
+            bucket, domain_id, trunk_id, direction, hangup_cause,
+            calls, answered, duration_sum, billsec_sum
+        )
+        SELECT date_trunc('hour', start_time),
+               COALESCE(domain_id, 0), COALESCE(trunk_id, 0),
+               COALESCE(direction::text, ''), COALESCE(hangup_cause, ''),
+               COUNT(*), COUNT(*) FILTER (WHERE billsec > 0),
+               COALESCE(SUM(duration), 0), COALESCE(SUM(billsec), 0)
+        FROM voip.cdr
+        WHERE start_time >= $1 AND start_time < $2
+          AND leg = 'A'
+        GROUP BY 1, 2, 3, 4, 5
+    `, a, b); err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(ctx, `
+        DELETE FROM voip.cdr_concurrency_hourly WHERE bucket >= $1 AND bucket < $2
+    `, a, b); err != nil {
+        return err
+    }
+    // Đỉnh cuộc gọi đồng thời: quét sự kiện bắt đầu (+1)/kết thúc (-1) theo thời gian.
+    // Cuộc gọi bắt đầu trước a được tính từ a; mốc 0 ở đầu mỗi giờ giúp giờ
+    // không có sự kiện vẫn ghi nhận số cuộc đang diễn ra.
+    if _, err := tx.Exec(ctx, `
+        WITH calls AS (
+            SELECT GREATEST(start_time, $1) AS s, end_time AS e
+            FROM voip.cdr
+            WHERE start_time < $2 AND end_time > $1 AND end_time >= start_time
+              AND leg = 'A'
+        ), ev AS (
+            SELECT s AS t, 1 AS d FROM calls
+            UNION ALL
+            SELECT e, -1 FROM calls WHERE e < $2
+            UNION ALL
+            SELECT h, 0 FROM generate_series($1::timestamptz, $2::timestamptz - interval '1 hour', interval '1 hour') h
+        ), run AS (
+            SELECT t, SUM(d) OVER (ORDER BY t, d ROWS UNBOUNDED PRECEDING) AS c
+            FROM ev
+        )
+        INSERT INTO voip.cdr_concurrency_hourly (bucket, peak)
+        SELECT date_trunc('hour', t), MAX(c)
+        FROM run
+        GROUP BY 1
+    `, a, b); err != nil {
+        return err
+    }
+
+    return tx.Commit(ctx)
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "syscall"
+    "time"
+)
+
+// ErrBlockedAddress được trả về khi URL của subscription trỏ tới địa chỉ nội bộ.
+var ErrBlockedAddress = errors.New("webhook target address not allowed")
+
+// BlockedIP cho biết ip thuộc dải không được gửi webhook tới: loopback,
+// link-local, mạng riêng (RFC 1918, fc00::/7), unspecified và multicast.
+func BlockedIP(ip net.IP) bool {
+    return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+        ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// NewClient trả HTTP client gửi webhook. Địa chỉ được kiểm tra sau khi phân giải
+// DNS, ở mỗi kết nối (kể cả khi redirect), nên hostname trỏ về mạng nội bộ
+// cũng bị chặn. Client không dùng proxy từ biến môi trường.
+func NewClient(timeout time.Duration) *http.Client {
+    dialer := &net.Dialer{
+        Timeout: 10 * time.Second,
+        Control: func(network, address string, _ syscall.RawConn) error {
+            host, _, err := net.SplitHostPort(address)
+            if err != nil {
+                return err
+            }
+            if ip := net.ParseIP(host); ip == nil || BlockedIP(ip) {
+                return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+            }
+            return nil
+        },
+    }
+    return &http.Client{
+        Timeout: timeout,
+        Transport: &http.Transport{
+            DialContext:         dialer.DialContext,
+            TLSHandshakeTimeout: 10 * time.Second,
+            MaxIdleConns:        100,
+            IdleConnTimeout:     90 * time.Second,
+        },
+    }
+}
@@ -0,0 +1,46 @@
+package webhook
+
+import (
+    "errors"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestBlockedIP(t *testing.T) {
+    tests := []struct {
+        ip      string
+        blocked bool
+    }{
+        {"127.0.0.1", true},
+        {"::1", true},
+        {"10.1.2.3", true},
+        {"172.16.0.1", true},
+        {"172.32.0.1", false},
+        {"192.168.1.10", true},
+        {"169.254.169.254", true},
+        {"fe80::1", true},
+        {"fd00::1", true},
+        {"0.0.0.0", true},
+        {"::ffff:127.0.0.1", true},
+        {"8.8.8.8", false},
+        {"2001:4860:4860::8888", false},
+    }
+    for _, tt := range tests {
+        if got := BlockedIP(net.ParseIP(tt.ip)); got != tt.blocked {
+            t.Errorf("BlockedIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+        }
+    }
+}
+
+func TestNewClientBlocksLoopback(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer srv.Close()
+
+    _, err := NewClient(time.Second).Post(srv.URL, "application/json", nil)
+    if !errors.Is(err, ErrBlockedAddress) {
+        t.Fatalf("err = %v, want ErrBlockedAddress", err)
+    }
+}
@@ -0,0 +1,248 @@
+package webhook
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "math/rand"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Dispatcher đọc voip.webhook_outbox và gửi event tới subscriber,
+// retry theo exponential backoff cho tới MaxAttempts.
+type Dispatcher struct {
+    Pool        *pgxpool.Pool
+    Client      *http.Client
+    MaxAttempts int
+    BaseBackoff time.Duration
+    MaxBackoff  time.Duration
+    BatchSize   int
+    // Redact (nếu có) áp data policy của consumer lên payload trước khi gửi.
+    Redact func(consumer, event string, data json.RawMessage) (json.RawMessage, error)
+}
+
+type outboxItem struct {
+    ID        int64
+    SubID     int64
+    Event     string
+    Payload   json.RawMessage
+    Attempts  int
+    CreatedAt time.Time
+    URL       string
+    Secret    string
+    Consumer  string
+}
+
+// envelope là body JSON gửi tới subscriber.
+type envelope struct {
+    ID        int64           `json:"id"`
+    Event     string          `json:"event"`
+    CreatedAt time.Time       `json:"created_at"`
+    Data      json.RawMessage `json:"data"`
+}
+
+// leaseDuration giữ item đã claim khỏi các worker khác trong lúc gửi.
+const leaseDuration = 2 * time.Minute
+
+// Run xử lý outbox định kỳ cho tới khi ctx bị hủy.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        for {
+            n, err := d.DispatchBatch(ctx)
+            if err != nil && ctx.Err() == nil {
+                log.Printf("webhook dispatch: %v", err)
+            }
+            if err != nil || n < d.batchSize() {
+                break
+            }
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (d *Dispatcher) batchSize() int {
+    if d.BatchSize <= 0 {
+        return 50
+    }
+    return d.BatchSize
+}
+
+// DispatchBatch claim một lô item đến hạn (FOR UPDATE SKIP LOCKED để nhiều
+// instance chạy song song an toàn) rồi gửi từng item.
+func (d *Dispatcher) DispatchBatch(ctx context.Context) (int, error) {
+    rows, err := d.Pool.Query(ctx, `
+        WITH due AS (
+            SELECT id
+            FROM voip.webhook_outbox
+            WHERE status = 'pending' AND next_attempt_at <= now()
+            ORDER BY next_attempt_at
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        UPDATE voip.webhook_outbox o
+        SET next_attempt_at = now() + $2::interval
+        FROM due, voip.webhook_subscriptions s
+        WHERE o.id = due.id AND s.id = o.subscription_id
+        RETURNING o.id, o.subscription_id, o.event, o.payload, o.attempts, o.created_at, s.url, s.secret, s.consumer
+    `, d.batchSize(), leaseDuration.String())
+    if err != nil {
+        return 0, err
+    }
+
+    var items []outboxItem
+    for rows.Next() {
+        var it outboxItem
+        if err := rows.Scan(&it.ID, &it.SubID, &it.Event, &it.Payload, &it.Attempts, &it.CreatedAt, &it.URL, &it.Secret, &it.Consumer); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        items = append(items, it)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    for _, it := range items {
+        if err := d.deliver(ctx, it); err != nil {
+            return len(items), err
+        }
+    }
+    return len(items), nil
+}
+
+// Sign trả chữ ký HMAC-SHA256 (hex) của "timestamp.body" với secret của subscription.
+// Subscriber kiểm tra header X-Webhook-Signature bằng cùng công thức.
+func Sign(secret, timestamp string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(timestamp))
+    mac.Write([]byte("."))
+    mac.Write(body)
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, it outboxItem) error {
+    data := it.Payload
+    if d.Redact != nil {
+        var err error
+        if data, err = d.Redact(it.Consumer, it.Event, data); err != nil {
+            return err
+        }
+    }
+    body, err := json.Marshal(envelope{ID: it.ID, Event: it.Event, CreatedAt: it.CreatedAt, Data: data})
+    if err != nil {
+        return err
+    }
+
+    attempt := it.Attempts + 1
+    started := time.Now()
+    statusCode, sendErr := d.send(ctx, it, body)
+    elapsed := time.Since(started)
+
+    var errText *string
+    if sendErr != nil {
+        s := sendErr.Error()
+        errText = &s
+    }
+    var code *int
+    if statusCode != 0 {
+        code = &statusCode
+    }
+
+    tx, err := d.Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, `
+        INSERT INTO voip.webhook_deliveries (outbox_id, subscription_id, attempt, status_code, error, duration_ms)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `, it.ID, it.SubID, attempt, code, errText, int(elapsed.Milliseconds())); err != nil {
+        return err
+    }
+
+    switch {
+    case sendErr == nil:
+        _, err = tx.Exec(ctx, `
+            UPDATE voip.webhook_outbox
+            SET status = 'delivered', attempts = $2, delivered_at = now(), last_error = NULL
+            WHERE id = $1
+        `, it.ID, attempt)
+    case attempt >= d.MaxAttempts:
+        _, err = tx.Exec(ctx, `
+            UPDATE voip.webhook_outbox
+            SET status = 'failed', attempts = $2, last_error = $3
+            WHERE id = $1
+        `, it.ID, attempt, errText)
+    default:
+        _, err = tx.Exec(ctx, `
+            UPDATE voip.webhook_outbox
+            SET attempts = $2, last_error = $3, next_attempt_at = now() + $4::interval
+            WHERE id = $1
+        `, it.ID, attempt, errText, d.backoff(attempt).String())
+    }
+    if err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+func (d *Dispatcher) send(ctx context.Context, it outboxItem, body []byte) (int, error) {
+    ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, it.URL, bytes.NewReader(body))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "voip-admin-webhook/1.0")
+    req.Header.Set("X-Webhook-Id", strconv.FormatInt(it.ID, 10))
+    req.Header.Set("X-Webhook-Event", it.Event)
+    req.Header.Set("X-Webhook-Timestamp", ts)
+    req.Header.Set("X-Webhook-Signature", "sha256="+Sign(it.Secret, ts, body))
+
+    resp, err := d.Client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    _, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+    return resp.StatusCode, nil
+}
+
+// backoff = BaseBackoff * 2^(attempt-1), tối đa MaxBackoff, cộng jitter tới 10%.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+    b := d.BaseBackoff
+    for i := 1; i < attempt && b < d.MaxBackoff; i++ {
+        b *= 2
+    }
+    if b > d.MaxBackoff {
+        b = d.MaxBackoff
+    }
+    if b > 0 {
+        b += time.Duration(rand.Int63n(int64(b)/10 + 1))
+    }
+    return b
+}
@@ -0,0 +1,164 @@
+package webhook
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Các loại event có thể đăng ký.
+const (
+    EventCallEnded          = "call.ended"
+    EventRecordingAvailable = "recording.available"
+    EventDeadLetterAlert    = "dead_letter.alert"
+    EventFraudAlert         = "fraud.alert"
+)
+
+var knownEvents = map[string]bool{
+    EventCallEnded:          true,
+    EventRecordingAvailable: true,
+    EventDeadLetterAlert:    true,
+    EventFraudAlert:         true,
+}
+
+// ValidEvent cho biết event có được hỗ trợ không.
+func ValidEvent(name string) bool {
+    return knownEvents[name]
+}
+
+// ErrNotFound được trả về khi subscription không tồn tại hoặc không thuộc consumer.
+var ErrNotFound = errors.New("subscription not found")
+
+type Subscription struct {
+    ID        int64     `json:"id"`
+    Consumer  string    `json:"consumer"`
+    URL       string    `json:"url"`
+    Secret    string    `json:"secret,omitempty"`
+    Events    []string  `json:"events"`
+    IsActive  bool      `json:"is_active"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Delivery struct {
+    ID             int64     `json:"id"`
+    OutboxID       int64     `json:"outbox_id"`
+    SubscriptionID int64     `json:"subscription_id"`
+    Event          string    `json:"event"`
+    Attempt        int       `json:"attempt"`
+    StatusCode     *int      `json:"status_code,omitempty"`
+    Error          *string   `json:"error,omitempty"`
+    DurationMS     int       `json:"duration_ms"`
+    AttemptedAt    time.Time `json:"attempted_at"`
+    OutboxStatus   string    `json:"outbox_status"`
+}
+
+// GenerateSecret tạo secret ngẫu nhiên dùng để ký HMAC.
+func GenerateSecret() (string, error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+// CreateSubscription tạo subscription; secret chỉ được trả về ở bước này.
+func CreateSubscription(ctx context.Context, pool *pgxpool.Pool, s *Subscription) error {
+    return pool.QueryRow(ctx, `
+        INSERT INTO voip.webhook_subscriptions (consumer, url, secret, events, is_active)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at, updated_at
+    `, s.Consumer, s.URL, s.Secret, s.Events, s.IsActive).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+}
+
+// ListSubscriptions trả các subscription của consumer (không kèm secret).
+func ListSubscriptions(ctx context.Context, pool *pgxpool.Pool, consumer string) ([]Subscription, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT id, consumer, url, events, is_active, created_at, updated_at
+        FROM voip.webhook_subscriptions
+        WHERE consumer=$1
+        ORDER BY id
+    `, consumer)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Subscription
+    for rows.Next() {
+        var s Subscription
+        if err := rows.Scan(&s.ID, &s.Consumer, &s.URL, &s.Events, &s.IsActive, &s.CreatedAt, &s.UpdatedAt); err != nil {
+            return nil, err
+        }
+        out = append(out, s)
+    }
+    return out, rows.Err()
+}
+
+// DeleteSubscription xóa subscription của consumer cùng outbox/log liên quan.
+func DeleteSubscription(ctx context.Context, pool *pgxpool.Pool, consumer string, id int64) error {
+    tag, err := pool.Exec(ctx, `
+        DELETE FROM voip.webhook_subscriptions WHERE id=$1 AND consumer=$2
+    `, id, consumer)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// ListDeliveries trả log gửi gần nhất của consumer, tùy chọn theo subscription.
+func ListDeliveries(ctx context.Context, pool *pgxpool.Pool, consumer string, subscriptionID *int64, limit int) ([]Delivery, error) {
+    rows, err := pool.Query(ctx, `
+        SELECT d.id, d.outbox_id, d.subscription_id, o.event, d.attempt,
+               d.status_code, d.error, d.duration_ms, d.attempted_at, o.status
+        FROM voip.webhook_deliveries d
+        JOIN voip.webhook_outbox o ON o.id = d.outbox_id
+        JOIN voip.webhook_subscriptions s ON s.id = d.subscription_id
+        WHERE s.consumer = $1
+          AND ($2::bigint IS NULL OR d.subscription_id = $2)
+        ORDER BY d.attempted_at DESC, d.id DESC
+        LIMIT $3
+    `, consumer, subscriptionID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []Delivery
+    for rows.Next() {
+        var d Delivery
+        if err := rows.Scan(
+            &d.ID, &d.OutboxID, &d.SubscriptionID, &d.Event, &d.Attempt,
+            &d.StatusCode, &d.Error, &d.DurationMS, &d.AttemptedAt, &d.OutboxStatus,
+        ); err != nil {
+            return nil, err
+        }
+        out = append(out, d)
+    }
+    return out, rows.Err()
+}
+
+// Execer là phần chung của *pgxpool.Pool và pgx.Tx dùng để ghi outbox.
+type Execer interface {
+    Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// Enqueue ghi event vào outbox cho mọi subscription đang bật có đăng ký event.
+// payload được lưu dạng JSON và gửi đi bởi Dispatcher.
+func Enqueue(ctx context.Context, db Execer, event string, payload interface{}) error {
+    _, err := db.Exec(ctx, `
+        INSERT INTO voip.webhook_outbox (subscription_id, event, payload)
+        SELECT id, $1::text, $2::jsonb
+        FROM voip.webhook_subscriptions
+        WHERE is_active AND $1::text = ANY(events)
+    `, event, payload)
+    return err
+}